@@ -6,9 +6,14 @@ import (
 	"strconv"
 )
 
+// MaxDepth limits how deeply lists and dictionaries may nest, so hostile
+// input cannot exhaust the stack
+const MaxDepth = 256
+
 type BencodeDecoder struct {
-	Data []byte
-	Pos  int
+	Data  []byte
+	Pos   int
+	depth int
 }
 
 func NewDecoder(Data []byte) *BencodeDecoder {
@@ -37,7 +42,7 @@ func (d *BencodeDecoder) Decode() (interface{}, error) {
 
 // DecodeInt Decodes an integer (i<number>e)
 func (d *BencodeDecoder) DecodeInt() (int64, error) {
-	if d.Data[d.Pos] != 'i' {
+	if d.Pos >= len(d.Data) || d.Data[d.Pos] != 'i' {
 		return 0, errors.New("expected 'i' at start of integer")
 	}
 	d.Pos++
@@ -73,10 +78,14 @@ func (d *BencodeDecoder) DecodeString() (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("invalid string length: %v", err)
 	}
+	if length < 0 {
+		return "", fmt.Errorf("negative string length: %d", length)
+	}
 
 	d.Pos++ // skip ':'
 
-	if d.Pos+length > len(d.Data) {
+	// Compare against the remaining bytes to avoid overflowing d.Pos+length
+	if length > len(d.Data)-d.Pos {
 		return "", errors.New("string length exceeds Data")
 	}
 
@@ -88,9 +97,14 @@ func (d *BencodeDecoder) DecodeString() (string, error) {
 
 // DecodeList Decodes a list (l<elements>e)
 func (d *BencodeDecoder) DecodeList() ([]interface{}, error) {
-	if d.Data[d.Pos] != 'l' {
+	if d.Pos >= len(d.Data) || d.Data[d.Pos] != 'l' {
 		return nil, errors.New("expected 'l' at start of list")
 	}
+	if d.depth >= MaxDepth {
+		return nil, errors.New("maximum nesting depth exceeded")
+	}
+	d.depth++
+	defer func() { d.depth-- }()
 	d.Pos++
 
 	var result []interface{}
@@ -113,9 +127,14 @@ func (d *BencodeDecoder) DecodeList() ([]interface{}, error) {
 
 // DecodeDict Decodes a dictionary (d<key-value pairs>e)
 func (d *BencodeDecoder) DecodeDict() (map[string]interface{}, error) {
-	if d.Data[d.Pos] != 'd' {
+	if d.Pos >= len(d.Data) || d.Data[d.Pos] != 'd' {
 		return nil, errors.New("expected 'd' at start of dictionary")
 	}
+	if d.depth >= MaxDepth {
+		return nil, errors.New("maximum nesting depth exceeded")
+	}
+	d.depth++
+	defer func() { d.depth-- }()
 	d.Pos++
 
 	result := make(map[string]interface{})
@@ -10,6 +10,11 @@ import (
 // BencodeEncoder handles encoding data to bencode format
 type BencodeEncoder struct{}
 
+// Raw is an already bencoded value, written out byte for byte. It keeps a
+// value whose exact bytes matter, such as an info dictionary, intact when
+// it is re-encoded inside another.
+type Raw []byte
+
 // NewEncoder creates a new bencode encoder
 func NewEncoder() *BencodeEncoder {
 	return &BencodeEncoder{}
@@ -28,6 +33,8 @@ func (e *BencodeEncoder) Encode(value interface{}) ([]byte, error) {
 		return e.encodeList(v)
 	case map[string]interface{}:
 		return e.encodeDict(v)
+	case Raw:
+		return v, nil
 	default:
 		// Use reflection for other types
 		return e.encodeReflect(reflect.ValueOf(value))
@@ -0,0 +1,34 @@
+package bencode
+
+import (
+	"testing"
+)
+
+// FuzzDecode checks that anything the decoder accepts can be encoded again.
+// Run with: go test -fuzz FuzzDecode ./internal/bencode
+func FuzzDecode(f *testing.F) {
+	for _, seed := range []string{
+		"i42e",
+		"i-7e",
+		"4:spam",
+		"0:",
+		"l4:spami42ee",
+		"d3:cow3:moo4:spaml1:a1:bee",
+		"d8:announce20:http://tracker/a4:infod6:lengthi1024e4:name4:file12:piece lengthi16384e6:pieces0:ee",
+		"i03e",
+		"5:abc",
+		"d1:a",
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decoded, err := Decode(data)
+		if err != nil {
+			return
+		}
+		if _, err := Encode(decoded); err != nil {
+			t.Fatalf("decoded %q but can't encode it again: %v", data, err)
+		}
+	})
+}
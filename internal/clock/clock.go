@@ -0,0 +1,192 @@
+// Package clock abstracts the current time so timeout, keep-alive and speed
+// computations can be driven by a fake clock instead of real sleeps.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the current time and waits on it
+type Clock interface {
+	Now() time.Time
+	// After returns a channel that receives the time once d has passed
+	After(d time.Duration) <-chan time.Time
+	// Sleep blocks until d has passed
+	Sleep(d time.Duration)
+	// NewTicker returns a ticker sending the time every d
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker sends the time on C every period until stopped, dropping ticks a
+// slow receiver misses, like time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the wall clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// Since returns the time elapsed on c since t
+func Since(c Clock, t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// Fake is a clock that only moves when told to. Its timers fire as
+// Advance or Set move it past them.
+type Fake struct {
+	mu      sync.RWMutex
+	now     time.Time
+	waiters []waiter
+	tickers map[*fakeTicker]bool
+}
+
+// waiter is a pending Fake.After
+type waiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// NewFake creates a fake clock set to start
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+// Now returns the fake clock's current time
+func (f *Fake) Now() time.Time {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.now
+}
+
+// After returns a channel that receives the fake time once the clock has
+// been moved d past now
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- f.now
+		return c
+	}
+	f.waiters = append(f.waiters, waiter{at: f.now.Add(d), c: c})
+	return c
+}
+
+// Sleep blocks until the clock has been moved d past now
+func (f *Fake) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+// NewTicker returns a ticker that ticks each time the clock is moved past
+// another period, at most once per move
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTicker{f: f, period: d, next: f.now.Add(d), c: make(chan time.Time, 1)}
+	if f.tickers == nil {
+		f.tickers = make(map[*fakeTicker]bool)
+	}
+	f.tickers[t] = true
+	return t
+}
+
+// fakeTicker is a Fake.NewTicker
+type fakeTicker struct {
+	f      *Fake
+	period time.Duration
+	next   time.Time // Guarded by f.mu
+	c      chan time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	delete(t.f.tickers, t)
+}
+
+// Waiters returns how many After and Sleep calls are waiting on the clock,
+// so a test can tell a goroutine has blocked before advancing it
+func (f *Fake) Waiters() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.waiters)
+}
+
+// Advance moves the clock forward by d
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	f.fire()
+}
+
+// Set moves the clock to t
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = t
+	f.fire()
+}
+
+// fire wakes the waiters whose time has come. Caller must hold f.mu.
+func (f *Fake) fire() {
+	pending := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.at.After(f.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.c <- f.now
+	}
+	f.waiters = pending
+
+	for t := range f.tickers {
+		if t.next.After(f.now) {
+			continue
+		}
+		select {
+		case t.c <- f.now:
+		default: // The receiver hasn't taken the last tick yet
+		}
+		for !t.next.After(f.now) {
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeAfter(t *testing.T) {
+	start := time.Unix(1000, 0)
+	f := NewFake(start)
+
+	c := f.After(2 * time.Second)
+	if f.Waiters() != 1 {
+		t.Fatalf("Waiters = %d, want 1", f.Waiters())
+	}
+
+	f.Advance(time.Second)
+	select {
+	case <-c:
+		t.Fatal("After fired a second early")
+	default:
+	}
+
+	f.Advance(time.Second)
+	select {
+	case now := <-c:
+		if !now.Equal(start.Add(2 * time.Second)) {
+			t.Fatalf("After sent %v, want %v", now, start.Add(2*time.Second))
+		}
+	default:
+		t.Fatal("After didn't fire once its time came")
+	}
+	if f.Waiters() != 0 {
+		t.Fatalf("Waiters = %d after firing, want 0", f.Waiters())
+	}
+
+	select {
+	case <-f.After(0):
+	default:
+		t.Fatal("After(0) didn't fire at once")
+	}
+}
+
+func TestFakeSleep(t *testing.T) {
+	f := NewFake(time.Unix(1000, 0))
+	woke := make(chan struct{})
+	go func() {
+		f.Sleep(time.Minute)
+		close(woke)
+	}()
+
+	for f.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	f.Set(f.Now().Add(time.Hour))
+	select {
+	case <-woke:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Sleep didn't return once the clock was set past it")
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	f := NewFake(time.Unix(1000, 0))
+	ticker := f.NewTicker(10 * time.Second)
+
+	f.Advance(9 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticked early")
+	default:
+	}
+
+	// A move past several periods ticks once, like a slow receiver
+	// missing ticks of a time.Ticker
+	f.Advance(25 * time.Second)
+	select {
+	case <-ticker.C():
+	default:
+		t.Fatal("didn't tick")
+	}
+	select {
+	case <-ticker.C():
+		t.Fatal("ticked twice for one move")
+	default:
+	}
+
+	// The next tick is at 40s, on the period
+	f.Advance(5 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticked off the period")
+	default:
+	}
+	f.Advance(time.Second)
+	select {
+	case <-ticker.C():
+	default:
+		t.Fatal("didn't tick on the period")
+	}
+
+	ticker.Stop()
+	f.Advance(time.Minute)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticked after Stop")
+	default:
+	}
+}
@@ -0,0 +1,97 @@
+// Package fdbudget shares a fixed allowance of file descriptors between the
+// parts of a session that open them (file handles, peer sockets,
+// listeners), so a large session degrades by keeping fewer files open and
+// refusing new peers instead of failing with "too many open files".
+package fdbudget
+
+import (
+	"errors"
+	"sync"
+)
+
+// Headroom is how many descriptors of the process limit are left out of the
+// default budget, for stdio, resume and journal files, the session socket
+// and the like
+const Headroom = 64
+
+// ErrExhausted is returned when opening a socket would go over the budget
+var ErrExhausted = errors.New("file descriptor budget exhausted")
+
+// Budget counts descriptors in use against a limit. A nil *Budget is
+// unlimited, so callers without one need no checks.
+type Budget struct {
+	mu    sync.Mutex
+	limit int // 0 means unlimited
+	used  int
+}
+
+// New creates a budget of limit descriptors; 0 means unlimited
+func New(limit int) *Budget {
+	return &Budget{limit: limit}
+}
+
+// Default creates a budget of the process's open file limit less Headroom,
+// or an unlimited one where there is no such limit
+func Default() *Budget {
+	limit, ok := processLimit()
+	if !ok {
+		return New(0)
+	}
+	return New(max(limit-Headroom, Headroom))
+}
+
+// TryAcquire takes one descriptor if the budget has one left
+func (b *Budget) TryAcquire() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limit > 0 && b.used >= b.limit {
+		return false
+	}
+	b.used++
+	return true
+}
+
+// Acquire takes one descriptor even if that goes over the budget, for
+// descriptors that can't be done without (e.g. the only handle to a file
+// being written). Headroom absorbs the overshoot.
+func (b *Budget) Acquire() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used++
+}
+
+// Release returns a descriptor taken with TryAcquire or Acquire
+func (b *Budget) Release() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used > 0 {
+		b.used--
+	}
+}
+
+// Limit returns the budget's size; 0 means unlimited
+func (b *Budget) Limit() int {
+	if b == nil {
+		return 0
+	}
+	return b.limit
+}
+
+// InUse returns how many descriptors are taken
+func (b *Budget) InUse() int {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
@@ -0,0 +1,8 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package fdbudget
+
+// processLimit reports no limit: this platform has no RLIMIT_NOFILE
+func processLimit() (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,21 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package fdbudget
+
+import (
+	"math"
+	"syscall"
+)
+
+// processLimit returns the soft RLIMIT_NOFILE, which the Go runtime has
+// already raised to the hard limit at startup
+func processLimit() (int, bool) {
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+		return 0, false
+	}
+	if rlimit.Cur > math.MaxInt32 { // Unlimited, or as good as
+		return 0, false
+	}
+	return int(rlimit.Cur), true
+}
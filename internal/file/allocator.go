@@ -17,28 +17,108 @@ const (
 	FullAllocation
 	// CompactAllocation allocates only as needed during writing
 	CompactAllocation
+	// AutoAllocation picks one of the above from the target filesystem and
+	// the torrent size, see Allocator.Resolve
+	AutoAllocation
 )
 
+// FullAllocationLimit is the largest torrent AutoAllocation fully
+// preallocates on a local filesystem with sparse file support. Writing out
+// a small torrent up front is cheap and avoids fragmentation; a large one
+// would delay the download too long.
+const FullAllocationLimit = 256 << 20
+
+// String returns the strategy's name as accepted by ParseAllocationStrategy
+func (s AllocationStrategy) String() string {
+	switch s {
+	case SparseAllocation:
+		return "sparse"
+	case FullAllocation:
+		return "full"
+	case CompactAllocation:
+		return "compact"
+	case AutoAllocation:
+		return "auto"
+	default:
+		return fmt.Sprintf("AllocationStrategy(%d)", int(s))
+	}
+}
+
+// ParseAllocationStrategy parses "auto", "sparse", "full" or "compact"
+func ParseAllocationStrategy(name string) (AllocationStrategy, error) {
+	for _, s := range []AllocationStrategy{AutoAllocation, SparseAllocation, FullAllocation, CompactAllocation} {
+		if s.String() == name {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown allocation strategy %q", name)
+}
+
 // Allocator handles file allocation strategies
 type Allocator struct {
 	outputDir string
-	strategy  AllocationStrategy
+	strategy  AllocationStrategy // As requested; may be AutoAllocation
+	effective AllocationStrategy // What AllocateFile uses, see Resolve
 }
 
 // NewAllocator creates a new file allocator
 func NewAllocator(outputDir string) *Allocator {
 	return &Allocator{
 		outputDir: outputDir,
-		strategy:  SparseAllocation, // Default to sparse allocation
+		strategy:  AutoAllocation,
+		effective: SparseAllocation, // Until Resolve looks at the filesystem
 	}
 }
 
-// SetStrategy sets the allocation strategy
+// SetStrategy sets the allocation strategy. An explicit strategy is used
+// as-is; AutoAllocation is resolved by the next Resolve.
 func (a *Allocator) SetStrategy(strategy AllocationStrategy) {
 	a.strategy = strategy
+	if strategy != AutoAllocation {
+		a.effective = strategy
+	}
+}
+
+// Strategy returns the strategy AllocateFile currently uses
+func (a *Allocator) Strategy() AllocationStrategy {
+	return a.effective
 }
 
-// AllocateFile allocates space for a file according to the strategy
+// Resolve settles AutoAllocation for a torrent of totalSize bytes:
+//   - network mounts grow files as they are written (compact), as every
+//     byte preallocated is a byte sent over the network
+//   - copy-on-write filesystems (btrfs, ZFS) use sparse files, since a
+//     rewrite lands in a new extent and preallocation buys nothing
+//   - filesystems without sparse files (FAT, exFAT) zero-fill anyway on a
+//     write past the end, so they are fully allocated up front
+//   - anything else is fully allocated up to FullAllocationLimit, and
+//     sparse beyond it
+//
+// It returns the chosen strategy; an explicit SetStrategy is left alone.
+func (a *Allocator) Resolve(totalSize int64) AllocationStrategy {
+	if a.strategy != AutoAllocation {
+		return a.effective
+	}
+
+	fs := detectFilesystem(a.outputDir)
+	switch {
+	case fs.network:
+		a.effective = CompactAllocation
+	case fs.copyOnWrite:
+		a.effective = SparseAllocation
+	case fs.noSparse:
+		a.effective = FullAllocation
+	case totalSize <= FullAllocationLimit:
+		a.effective = FullAllocation
+	default:
+		a.effective = SparseAllocation
+	}
+	return a.effective
+}
+
+// AllocateFile allocates space for a file according to the strategy.
+// A file that already exists with the expected size is left untouched so
+// previously downloaded data survives a restart.
 func (a *Allocator) AllocateFile(filePath string, size int64) error {
 	// Ensure directory exists
 	dir := filepath.Dir(filePath)
@@ -46,7 +126,11 @@ func (a *Allocator) AllocateFile(filePath string, size int64) error {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
-	switch a.strategy {
+	if stat, err := os.Stat(filePath); err == nil && stat.Size() == size {
+		return nil
+	}
+
+	switch a.effective {
 	case SparseAllocation:
 		return a.allocateSparse(filePath, size)
 	case FullAllocation:
@@ -54,7 +138,7 @@ func (a *Allocator) AllocateFile(filePath string, size int64) error {
 	case CompactAllocation:
 		return a.allocateCompact(filePath)
 	default:
-		return fmt.Errorf("unknown allocation strategy: %d", a.strategy)
+		return fmt.Errorf("unknown allocation strategy: %d", a.effective)
 	}
 }
 
@@ -75,6 +159,11 @@ func (a *Allocator) allocateSparse(filePath string, size int64) error {
 		}
 	}()
 
+	// There is no last byte to write for an empty file
+	if size == 0 {
+		return nil
+	}
+
 	// Use Seek + Write for better sparse file support
 	if _, err := file.Seek(size-1, 0); err != nil {
 		return fmt.Errorf("failed to seek: %w", err)
@@ -223,7 +312,11 @@ func (a *Allocator) GetDiskSpaceInfo() (total, free, used int64, err error) {
 // ValidateAllocation checks if all files are properly allocated
 func (a *Allocator) ValidateAllocation(files []FileInfo) error {
 	for _, file := range files {
-		fullPath := filepath.Join(a.outputDir, file.Path)
+		if file.Priority == PrioritySkip {
+			continue
+		}
+
+		fullPath := filepath.Join(a.outputDir, file.StoredPath())
 
 		stat, err := os.Stat(fullPath)
 		if err != nil {
@@ -242,7 +335,11 @@ func (a *Allocator) ValidateAllocation(files []FileInfo) error {
 // CleanupIncompleteFiles removes files that haven't been properly allocated
 func (a *Allocator) CleanupIncompleteFiles(files []FileInfo) error {
 	for _, file := range files {
-		fullPath := filepath.Join(a.outputDir, file.Path)
+		if file.Priority == PrioritySkip {
+			continue
+		}
+
+		fullPath := filepath.Join(a.outputDir, file.StoredPath())
 
 		stat, err := os.Stat(fullPath)
 		if err != nil {
@@ -263,7 +360,7 @@ func (a *Allocator) CleanupIncompleteFiles(files []FileInfo) error {
 
 // EstimateAllocationTime estimates how long allocation will take
 func (a *Allocator) EstimateAllocationTime(totalSize int64) (seconds int, err error) {
-	switch a.strategy {
+	switch a.effective {
 	case SparseAllocation:
 		// Sparse allocation is very fast
 		return 1, nil
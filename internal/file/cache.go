@@ -0,0 +1,224 @@
+package file
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultWriteCacheSize is the default limit on verified piece data
+	// held in memory before it is written out
+	DefaultWriteCacheSize = 16 << 20
+	// WriteCacheMaxAge is how long a verified piece may wait in the write
+	// cache, so a stalled download still gets its data onto disk
+	WriteCacheMaxAge = 5 * time.Second
+)
+
+// FlushFunc is called with the pieces whose data was just written and synced
+type FlushFunc func(pieces []int)
+
+// writeCache holds verified pieces until enough have built up to write
+// them out as a few large, sorted writes instead of one small random write
+// plus sync per piece
+type writeCache struct {
+	limit  int64          // Dirty bytes that trigger a flush
+	size   int64          // Dirty bytes held
+	pieces map[int][]byte // piece index -> data
+	since  time.Time      // When the oldest held piece was added
+}
+
+// segment is a run of bytes to write at an offset in one file
+type segment struct {
+	fileIndex int
+	offset    int64
+	data      []byte
+}
+
+// SetWriteCache enables write-behind with a dirty-bytes limit. A limit of 0
+// disables it (writing anything held first), so every piece is written and
+// synced as it is verified.
+func (w *Writer) SetWriteCache(limit int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if limit <= 0 {
+		if err := w.flush(); err != nil {
+			return err
+		}
+		w.cache = nil
+		return nil
+	}
+
+	if w.cache == nil {
+		w.cache = &writeCache{pieces: make(map[int][]byte)}
+	}
+	w.cache.limit = limit
+	if w.cache.size >= limit {
+		return w.flush()
+	}
+	return nil
+}
+
+// SetFlushHook sets a callback fired once pieces are durably on disk
+func (w *Writer) SetFlushHook(fn FlushFunc) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onFlushed = fn
+}
+
+// cachePiece holds a verified piece for a later flush. Caller must hold w.mu.
+func (w *Writer) cachePiece(pieceIndex int, data []byte) error {
+	if len(w.cache.pieces) == 0 {
+		w.cache.since = time.Now()
+	}
+	if old, exists := w.cache.pieces[pieceIndex]; exists {
+		w.cache.size -= int64(len(old))
+	}
+
+	// The caller may reuse its buffer once we return
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	w.cache.pieces[pieceIndex] = buf
+	w.cache.size += int64(len(buf))
+
+	if w.cache.size >= w.cache.limit {
+		if err := w.flush(); err != nil {
+			// Leave the older pieces for the next attempt, but report this
+			// one as not written so it is downloaded again
+			delete(w.cache.pieces, pieceIndex)
+			w.cache.size -= int64(len(buf))
+			return err
+		}
+	}
+	return nil
+}
+
+// cachedPiece returns a copy of a piece waiting in the write cache
+func (w *Writer) cachedPiece(pieceIndex int) ([]byte, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.cache == nil {
+		return nil, false
+	}
+	data, ok := w.cache.pieces[pieceIndex]
+	if !ok {
+		return nil, false
+	}
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	return buf, true
+}
+
+// Flush writes out everything in the write cache
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flush()
+}
+
+// FlushExpired flushes the write cache if its oldest piece has waited
+// longer than maxAge
+func (w *Writer) FlushExpired(maxAge time.Duration) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.cache == nil || len(w.cache.pieces) == 0 || time.Since(w.cache.since) < maxAge {
+		return nil
+	}
+	return w.flush()
+}
+
+// flush writes the cached pieces sorted by file and offset, merging
+// adjacent ranges, then syncs each touched file once. If a write fails the
+// pieces stay cached for the next attempt. Caller must hold w.mu.
+func (w *Writer) flush() error {
+	if w.cache == nil || len(w.cache.pieces) == 0 {
+		return nil
+	}
+
+	indices := make([]int, 0, len(w.cache.pieces))
+	for index := range w.cache.pieces {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+
+	var segments []segment
+	for _, index := range indices {
+		mapping, err := w.mapper.GetPieceMapping(index)
+		if err != nil {
+			return fmt.Errorf("failed to get piece mapping: %w", err)
+		}
+
+		data := w.cache.pieces[index]
+		dataOffset := int64(0)
+		for _, fileRange := range mapping.FileRanges {
+			if !fileRange.Discard {
+				segments = append(segments, segment{
+					fileIndex: fileRange.FileIndex,
+					offset:    fileRange.Offset,
+					data:      data[dataOffset : dataOffset+fileRange.Length],
+				})
+			}
+			dataOffset += fileRange.Length
+		}
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		if segments[i].fileIndex != segments[j].fileIndex {
+			return segments[i].fileIndex < segments[j].fileIndex
+		}
+		return segments[i].offset < segments[j].offset
+	})
+
+	// Segments are grouped by file, so each file is synced right after its
+	// last write, before the handle cache could close it
+	written := make(map[int]int64)
+	writes := 0
+	for i := 0; i < len(segments); {
+		// Merge the run of segments that continue one another
+		run := segments[i]
+		j := i + 1
+		for j < len(segments) && segments[j].fileIndex == run.fileIndex &&
+			segments[j].offset == run.offset+int64(len(run.data)) {
+			if j == i+1 {
+				run.data = append([]byte(nil), run.data...)
+			}
+			run.data = append(run.data, segments[j].data...)
+			j++
+		}
+		i = j
+
+		fullPath := w.filePath(run.fileIndex)
+		file, err := w.getFileHandle(fullPath)
+		if err != nil {
+			return fmt.Errorf("failed to get file handle for %s: %w", fullPath, err)
+		}
+		if _, err := file.WriteAt(run.data, run.offset); err != nil {
+			return fmt.Errorf("failed to write to file %s: %w", fullPath, err)
+		}
+		written[run.fileIndex] += int64(len(run.data))
+		writes++
+
+		if i == len(segments) || segments[i].fileIndex != run.fileIndex {
+			if err := file.Sync(); err != nil {
+				return fmt.Errorf("failed to sync %s: %w", fullPath, err)
+			}
+			w.recordModTime(run.fileIndex)
+		}
+	}
+
+	for fileIndex, bytes := range written {
+		w.progress.AddWrittenBytes(fileIndex, bytes)
+	}
+
+	fmt.Printf("Flushed %d pieces (%d bytes) in %d writes\n", len(indices), w.cache.size, writes)
+	w.cache.pieces = make(map[int][]byte)
+	w.cache.size = 0
+
+	if w.onFlushed != nil {
+		w.onFlushed(indices)
+	}
+	return nil
+}
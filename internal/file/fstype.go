@@ -0,0 +1,28 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// filesystemInfo holds the filesystem traits AutoAllocation cares about.
+// The zero value describes a local filesystem with sparse file support.
+type filesystemInfo struct {
+	network     bool // NFS, SMB, FUSE and the like
+	copyOnWrite bool // btrfs, ZFS
+	noSparse    bool // FAT, exFAT
+}
+
+// existingParent returns dir, or its nearest ancestor that exists
+func existingParent(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
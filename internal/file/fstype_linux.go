@@ -0,0 +1,37 @@
+package file
+
+import "syscall"
+
+// Filesystem magic numbers from statfs(2)
+const (
+	btrfsMagic = 0x9123683e
+	zfsMagic   = 0x2fc12fc1
+	nfsMagic   = 0x6969
+	smbMagic   = 0x517b
+	cifsMagic  = 0xff534d42
+	smb2Magic  = 0xfe534d42
+	fuseMagic  = 0x65735546
+	cephMagic  = 0x00c36400
+	msdosMagic = 0x4d44
+	exfatMagic = 0x2011bab0
+)
+
+// detectFilesystem looks up the type of the filesystem holding dir, or its
+// nearest existing parent since the output directory may not exist yet
+func detectFilesystem(dir string) filesystemInfo {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(existingParent(dir), &st); err != nil {
+		return filesystemInfo{}
+	}
+
+	switch uint32(st.Type) {
+	case btrfsMagic, zfsMagic:
+		return filesystemInfo{copyOnWrite: true}
+	case nfsMagic, smbMagic, cifsMagic, smb2Magic, fuseMagic, cephMagic:
+		return filesystemInfo{network: true}
+	case msdosMagic, exfatMagic:
+		return filesystemInfo{noSparse: true}
+	default:
+		return filesystemInfo{}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package file
+
+// detectFilesystem can't tell filesystems apart on this platform, so
+// AutoAllocation falls back to the torrent size alone
+func detectFilesystem(dir string) filesystemInfo {
+	return filesystemInfo{}
+}
@@ -0,0 +1,122 @@
+package file
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// journalRecordSize is the size of one journal record:
+// 4 bytes piece index + 4 bytes CRC32 of the index bytes
+const journalRecordSize = 8
+
+// Journal is an append-only log of pieces that have been verified and
+// written to disk. Each record is synced before the piece is acknowledged,
+// so after a crash the journal can be replayed to rebuild completion state
+// without re-downloading or re-hashing those pieces.
+type Journal struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// OpenJournal opens (or creates) the journal at path
+func OpenJournal(path string) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	return &Journal{
+		path: path,
+		file: file,
+	}, nil
+}
+
+// Append records verified pieces and syncs them to disk, once for the
+// whole batch
+func (j *Journal) Append(pieceIndices ...int) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	records := make([]byte, journalRecordSize*len(pieceIndices))
+	for i, pieceIndex := range pieceIndices {
+		record := records[i*journalRecordSize : (i+1)*journalRecordSize]
+		binary.BigEndian.PutUint32(record[0:4], uint32(pieceIndex))
+		binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(record[0:4]))
+	}
+
+	if _, err := j.file.Write(records); err != nil {
+		return fmt.Errorf("failed to append to journal: %w", err)
+	}
+
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+
+	return nil
+}
+
+// Replay returns the piece indices recorded in the journal. Torn or corrupt
+// records (e.g. from a crash mid-write) and indices outside [0, numPieces)
+// are skipped.
+func (j *Journal) Replay(numPieces int) ([]int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek journal: %w", err)
+	}
+
+	data, err := io.ReadAll(j.file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	seen := make(map[int]bool)
+	var pieces []int
+
+	for offset := 0; offset+journalRecordSize <= len(data); offset += journalRecordSize {
+		record := data[offset : offset+journalRecordSize]
+		if binary.BigEndian.Uint32(record[4:8]) != crc32.ChecksumIEEE(record[0:4]) {
+			continue
+		}
+
+		index := int(binary.BigEndian.Uint32(record[0:4]))
+		if index >= numPieces || seen[index] {
+			continue
+		}
+
+		seen[index] = true
+		pieces = append(pieces, index)
+	}
+
+	return pieces, nil
+}
+
+// Reset discards all journal records
+func (j *Journal) Reset() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate journal: %w", err)
+	}
+	return j.file.Sync()
+}
+
+// GetPath returns the journal file path
+func (j *Journal) GetPath() string {
+	return j.path
+}
+
+// Close closes the journal file
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.file.Close()
+}
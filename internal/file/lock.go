@@ -0,0 +1,122 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrInUse is returned when another download, in this process or another
+// one, holds the lock on the same data
+var ErrInUse = errors.New("already in use by another download")
+
+// heldLocks tracks the lock files this process holds. OS locks on network
+// shares don't always conflict within one process (NFS falls back to
+// per-process POSIX locks), so the same torrent added twice is caught here.
+var heldLocks = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: make(map[string]bool)}
+
+// Lock is an exclusive lock on a download's data, held through a lock file
+// (flock on Unix, LockFileEx on Windows)
+type Lock struct {
+	path      string
+	file      *os.File
+	abandoned bool // The lock file was there already, see Abandoned
+}
+
+// AcquireLock takes the lock file at path without waiting. It fails with
+// ErrInUse if another download holds it.
+func AcquireLock(path string) (*Lock, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	heldLocks.Lock()
+	defer heldLocks.Unlock()
+	if heldLocks.paths[abs] {
+		return nil, fmt.Errorf("%s: %w in this process", abs, ErrInUse)
+	}
+
+	for {
+		before, statErr := os.Stat(abs)
+		file, err := os.OpenFile(abs, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open lock file: %w", err)
+		}
+
+		if err := lockFile(file); err != nil {
+			file.Close()
+			if errors.Is(err, ErrInUse) {
+				return nil, fmt.Errorf("%s: %w%s", abs, ErrInUse, lockOwner(abs))
+			}
+			return nil, fmt.Errorf("failed to lock %s: %w", abs, err)
+		}
+
+		// The previous holder removes the file when it lets go, so we may
+		// have locked a file that is no longer at path; try again if so
+		opened, err1 := file.Stat()
+		current, err2 := os.Stat(abs)
+		if err1 != nil || err2 != nil || !os.SameFile(opened, current) {
+			unlockFile(file)
+			file.Close()
+			continue
+		}
+
+		// Record who holds it for the error other instances report
+		file.Truncate(0)
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+
+		heldLocks.paths[abs] = true
+		return &Lock{path: abs, file: file, abandoned: statErr == nil && os.SameFile(before, opened)}, nil
+	}
+}
+
+// lockOwner describes the process holding the lock file, if it says
+func lockOwner(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	if pid := strings.TrimSpace(string(data)); pid != "" {
+		return " (pid " + pid + ")"
+	}
+	return ""
+}
+
+// Abandoned returns true if the previous holder never released the lock,
+// leaving the lock file behind: it crashed, or the machine did, so data it
+// was writing may not have reached the disk intact
+func (l *Lock) Abandoned() bool {
+	return l.abandoned
+}
+
+// Release removes the lock file and lets go of the lock
+func (l *Lock) Release() error {
+	heldLocks.Lock()
+	defer heldLocks.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+
+	// Remove while still locked, so no one locks the file we are deleting
+	// without noticing; Windows can't delete an open file, so it tries again
+	// after closing
+	removeErr := os.Remove(l.path)
+	unlockFile(l.file)
+	err := l.file.Close()
+	if removeErr != nil {
+		os.Remove(l.path)
+	}
+
+	delete(heldLocks.paths, l.path)
+	l.file = nil
+	return err
+}
@@ -1,15 +1,22 @@
 package file
 
 import (
+	"container/list"
 	"fmt"
+	"sort"
+	"sync"
 )
 
+// DefaultMappingCacheSize is how many piece mappings the mapper keeps
+const DefaultMappingCacheSize = 64
+
 // FileRange represents a range of bytes within a file
 type FileRange struct {
 	FileIndex int    // Index in the torrent's file list
 	FilePath  string // Full path to the file
 	Offset    int64  // Offset within the file
 	Length    int64  // Number of bytes
+	Discard   bool   // File is skipped; the bytes are verified but not written
 }
 
 // PieceFileMap represents the mapping of a piece to files
@@ -20,39 +27,115 @@ type PieceFileMap struct {
 
 // Mapper handles piece-to-file mapping calculations
 type Mapper struct {
-	files       []FileInfo     // File information from torrent
-	pieceLength int64          // Length of each piece
-	totalLength int64          // Total torrent length
-	pieceMaps   []PieceFileMap // Pre-calculated mappings
+	mu          sync.RWMutex // Guards file priorities and the cache pointer
+	files       []FileInfo   // File information from torrent
+	fileEnds    []int64      // Cumulative end offset of each file, for binary search
+	pieceLength int64        // Length of each piece
+	totalLength int64        // Total torrent length
+	numPieces   int
+
+	cache *mappingCache // Recently used mappings; nil disables caching
+}
+
+// Priority is a file's download priority; higher values are downloaded
+// first
+type Priority int
+
+const (
+	// PrioritySkip means the file is not downloaded or allocated
+	PrioritySkip Priority = -2
+	// PriorityLow files are downloaded after normal ones
+	PriorityLow Priority = -1
+	// PriorityNormal is the default priority
+	PriorityNormal Priority = 0
+	// PriorityHigh files are preferred over normal ones
+	PriorityHigh Priority = 1
+)
+
+// String returns the priority's name as accepted by ParsePriority
+func (p Priority) String() string {
+	switch p {
+	case PrioritySkip:
+		return "skip"
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	default:
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+}
+
+// ParsePriority parses "skip", "low", "normal" or "high"
+func ParsePriority(name string) (Priority, error) {
+	for _, p := range []Priority{PrioritySkip, PriorityLow, PriorityNormal, PriorityHigh} {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q", name)
 }
 
 // FileInfo represents information about a file in the torrent
 type FileInfo struct {
-	Path   string // Relative path from torrent root
-	Length int64  // File length in bytes
-	Offset int64  // Cumulative offset in torrent data
+	Path     string   // Relative path from torrent root
+	Length   int64    // File length in bytes
+	Offset   int64    // Cumulative offset in torrent data
+	Priority Priority // Download priority (PriorityNormal by default)
+	DiskPath string   // Existing on-disk file to use instead of outputDir/Path (cross-seeding)
+	Rename   string   // Path relative to the output directory to store the file under instead of Path
+	Padding  bool     // Pad file (BEP 47): zeros aligning the next file to a piece, never stored
+}
+
+// StoredPath returns the path, relative to the output directory, the file
+// is stored under: its Rename if it has one, else its Path
+func (f FileInfo) StoredPath() string {
+	if f.Rename != "" {
+		return f.Rename
+	}
+	return f.Path
 }
 
 // NewMapper creates a new file mapper
 func NewMapper(files []FileInfo, pieceLength int64, totalLength int64) *Mapper {
-	mapper := &Mapper{
-		files:       files,
+	// Keep our own copy so priority changes don't leak into the caller's slice
+	ownFiles := make([]FileInfo, len(files))
+	copy(ownFiles, files)
+
+	// Pad files are never stored, just like skipped ones
+	for i := range ownFiles {
+		if ownFiles[i].Padding {
+			ownFiles[i].Priority = PrioritySkip
+		}
+	}
+
+	fileEnds := make([]int64, len(ownFiles))
+	for i, file := range ownFiles {
+		fileEnds[i] = file.Offset + file.Length
+	}
+
+	return &Mapper{
+		files:       ownFiles,
+		fileEnds:    fileEnds,
 		pieceLength: pieceLength,
 		totalLength: totalLength,
+		numPieces:   int((totalLength + pieceLength - 1) / pieceLength),
+		cache:       newMappingCache(DefaultMappingCacheSize),
 	}
-
-	mapper.buildFileMappings()
-	return mapper
 }
 
-// buildFileMappings pre-calculates piece-to-file mappings
-func (m *Mapper) buildFileMappings() {
-	totalPieces := int((m.totalLength + m.pieceLength - 1) / m.pieceLength)
-	m.pieceMaps = make([]PieceFileMap, totalPieces)
+// SetCacheSize changes how many piece mappings are cached (0 disables the cache)
+func (m *Mapper) SetCacheSize(size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	for pieceIndex := 0; pieceIndex < totalPieces; pieceIndex++ {
-		m.pieceMaps[pieceIndex] = m.calculatePieceMapping(pieceIndex)
+	if size <= 0 {
+		m.cache = nil
+		return
 	}
+	m.cache = newMappingCache(size)
 }
 
 // calculatePieceMapping calculates which files a piece affects
@@ -67,12 +150,21 @@ func (m *Mapper) calculatePieceMapping(pieceIndex int) PieceFileMap {
 
 	var fileRanges []FileRange
 
-	// Find all files that this piece overlaps
-	for fileIndex, file := range m.files {
+	// Files are laid out back to back, so the first file the piece overlaps
+	// is the first one ending after the piece starts
+	first := sort.Search(len(m.fileEnds), func(i int) bool {
+		return m.fileEnds[i] > pieceStart
+	})
+
+	for fileIndex := first; fileIndex < len(m.files); fileIndex++ {
+		file := m.files[fileIndex]
 		fileStart := file.Offset
-		fileEnd := file.Offset + file.Length
+		fileEnd := m.fileEnds[fileIndex]
+		if fileStart >= pieceEnd {
+			break
+		}
 
-		// Check if piece overlaps with this file
+		// Check if piece overlaps with this file (zero-length files never do)
 		if pieceStart < fileEnd && pieceEnd > fileStart {
 			// Calculate overlap
 			overlapStart := max(pieceStart, fileStart)
@@ -95,18 +187,173 @@ func (m *Mapper) calculatePieceMapping(pieceIndex int) PieceFileMap {
 	}
 }
 
-// GetPieceMapping returns the file mapping for a specific piece
+// GetPieceMapping returns the file mapping for a specific piece. Ranges
+// that fall in skipped files are marked Discard.
 func (m *Mapper) GetPieceMapping(pieceIndex int) (PieceFileMap, error) {
-	if pieceIndex < 0 || pieceIndex >= len(m.pieceMaps) {
+	if pieceIndex < 0 || pieceIndex >= m.numPieces {
 		return PieceFileMap{}, fmt.Errorf("invalid piece index: %d", pieceIndex)
 	}
 
-	return m.pieceMaps[pieceIndex], nil
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	mapping, ok := m.cache.get(pieceIndex)
+	if !ok {
+		mapping = m.calculatePieceMapping(pieceIndex)
+		m.cache.put(mapping)
+	}
+
+	ranges := make([]FileRange, len(mapping.FileRanges))
+	copy(ranges, mapping.FileRanges)
+	for i := range ranges {
+		ranges[i].Discard = m.files[ranges[i].FileIndex].Priority == PrioritySkip
+	}
+
+	return PieceFileMap{PieceIndex: mapping.PieceIndex, FileRanges: ranges}, nil
+}
+
+// IsBoundaryPiece returns true if a piece spans more than one file
+func (m *Mapper) IsBoundaryPiece(pieceIndex int) bool {
+	if pieceIndex < 0 || pieceIndex >= m.numPieces {
+		return false
+	}
+
+	pieceStart := int64(pieceIndex) * m.pieceLength
+	pieceEnd := min(pieceStart+m.pieceLength, m.totalLength)
+
+	// The file holding the piece's first byte; if it ends before the piece
+	// does, the rest of the piece belongs to later files
+	first := sort.Search(len(m.fileEnds), func(i int) bool {
+		return m.fileEnds[i] > pieceStart
+	})
+	return first < len(m.fileEnds) && m.fileEnds[first] < pieceEnd
 }
 
 // GetAllFiles returns all files in the torrent
 func (m *Mapper) GetAllFiles() []FileInfo {
-	return m.files
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	files := make([]FileInfo, len(m.files))
+	copy(files, m.files)
+	return files
+}
+
+// SetFilePriority sets the priority of a file
+func (m *Mapper) SetFilePriority(fileIndex int, priority Priority) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if fileIndex < 0 || fileIndex >= len(m.files) {
+		return fmt.Errorf("invalid file index: %d", fileIndex)
+	}
+	if _, err := ParsePriority(priority.String()); err != nil {
+		return err
+	}
+	if m.files[fileIndex].Padding {
+		return nil // Always skipped
+	}
+
+	m.files[fileIndex].Priority = priority
+	return nil
+}
+
+// GetFilePriority returns the priority of a file
+func (m *Mapper) GetFilePriority(fileIndex int) Priority {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if fileIndex < 0 || fileIndex >= len(m.files) {
+		return PriorityNormal
+	}
+	return m.files[fileIndex].Priority
+}
+
+// GetFilePieces returns the first and last piece a file overlaps; ok is
+// false for a zero-length file, which overlaps none
+func (m *Mapper) GetFilePieces(fileIndex int) (first, last int, ok bool) {
+	if fileIndex < 0 || fileIndex >= len(m.files) || m.files[fileIndex].Length == 0 {
+		return 0, 0, false
+	}
+	f := m.files[fileIndex]
+	return int(f.Offset / m.pieceLength), int((f.Offset + f.Length - 1) / m.pieceLength), true
+}
+
+// GetPiecePriority returns the priority of a piece: the highest priority of
+// the files it overlaps, so PrioritySkip only if every one is skipped
+func (m *Mapper) GetPiecePriority(pieceIndex int) Priority {
+	mapping, err := m.GetPieceMapping(pieceIndex)
+	if err != nil || len(mapping.FileRanges) == 0 {
+		return PriorityNormal
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	priority := PrioritySkip
+	for _, r := range mapping.FileRanges {
+		if p := m.files[r.FileIndex].Priority; p > priority {
+			priority = p
+		}
+	}
+	return priority
+}
+
+// SetFileLocation maps a torrent file to an existing file on disk, e.g. to
+// seed data that was downloaded under a different name. An empty path
+// restores the default location.
+func (m *Mapper) SetFileLocation(fileIndex int, diskPath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if fileIndex < 0 || fileIndex >= len(m.files) {
+		return fmt.Errorf("invalid file index: %d", fileIndex)
+	}
+
+	m.files[fileIndex].DiskPath = diskPath
+	return nil
+}
+
+// SetFileRename stores a torrent file under path, relative to the output
+// directory, instead of its Path. An empty path, or the file's Path,
+// restores its name.
+func (m *Mapper) SetFileRename(fileIndex int, path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if fileIndex < 0 || fileIndex >= len(m.files) {
+		return fmt.Errorf("invalid file index: %d", fileIndex)
+	}
+	if path == m.files[fileIndex].Path {
+		path = ""
+	}
+	m.files[fileIndex].Rename = path
+	return nil
+}
+
+// GetFileRenames returns the renamed files' stored paths keyed by their
+// Path
+func (m *Mapper) GetFileRenames() map[string]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	renames := make(map[string]string)
+	for _, f := range m.files {
+		if f.Rename != "" {
+			renames[f.Path] = f.Rename
+		}
+	}
+	return renames
+}
+
+// IsPadding returns true if a file is a pad file
+func (m *Mapper) IsPadding(fileIndex int) bool {
+	return fileIndex >= 0 && fileIndex < len(m.files) && m.files[fileIndex].Padding
+}
+
+// IsFileSkipped returns true if a file is marked skip
+func (m *Mapper) IsFileSkipped(fileIndex int) bool {
+	return m.GetFilePriority(fileIndex) == PrioritySkip
 }
 
 // GetTotalFiles returns the number of files
@@ -147,3 +394,62 @@ func min(a, b int64) int64 {
 	}
 	return b
 }
+
+// mappingCache is a small LRU of piece mappings, so the pieces currently
+// being downloaded don't need a binary search on every block. It has its
+// own lock since lookups happen under the mapper's read lock.
+type mappingCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List            // Front is most recently used
+	entries map[int]*list.Element // piece index -> element holding a PieceFileMap
+}
+
+// newMappingCache creates a cache holding up to size mappings
+func newMappingCache(size int) *mappingCache {
+	return &mappingCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[int]*list.Element),
+	}
+}
+
+// get returns a cached mapping. A nil cache never hits.
+func (c *mappingCache) get(pieceIndex int) (PieceFileMap, bool) {
+	if c == nil {
+		return PieceFileMap{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[pieceIndex]
+	if !ok {
+		return PieceFileMap{}, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(PieceFileMap), true
+}
+
+// put caches a mapping, evicting the least recently used one if full
+func (c *mappingCache) put(mapping PieceFileMap) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[mapping.PieceIndex]; ok {
+		elem.Value = mapping
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[mapping.PieceIndex] = c.order.PushFront(mapping)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(PieceFileMap).PieceIndex)
+	}
+}
@@ -0,0 +1,54 @@
+package file
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// recordModTime notes a file's modification time as the one our own last
+// write left, so later changes by anyone else stand out. Caller must hold
+// w.mu.
+func (w *Writer) recordModTime(fileIndex int) {
+	stat, err := os.Stat(w.filePath(fileIndex))
+	if err != nil {
+		delete(w.modTimes, fileIndex)
+		return
+	}
+	w.modTimes[fileIndex] = stat.ModTime()
+}
+
+// PieceModified reports whether any file holding a piece's data was
+// modified since we last wrote (or allocated) it, e.g. by the user editing
+// or a disk silently corrupting it. It also returns the newest modification
+// time among those files, identifying the change.
+func (w *Writer) PieceModified(pieceIndex int) (bool, time.Time, error) {
+	mapping, err := w.mapper.GetPieceMapping(pieceIndex)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("failed to get piece mapping: %w", err)
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	modified := false
+	var newest time.Time
+	for _, fileRange := range mapping.FileRanges {
+		if fileRange.Discard {
+			continue
+		}
+		stat, err := os.Stat(w.filePath(fileRange.FileIndex))
+		if err != nil {
+			return false, time.Time{}, err
+		}
+
+		known, ok := w.modTimes[fileRange.FileIndex]
+		if !ok || !stat.ModTime().Equal(known) {
+			modified = true
+		}
+		if stat.ModTime().After(newest) {
+			newest = stat.ModTime()
+		}
+	}
+	return modified, newest, nil
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"sync"
 	"time"
+
+	"bittorrentclient/internal/clock"
 )
 
 // FileProgress tracks progress for a single file
@@ -16,13 +18,22 @@ type FileProgress struct {
 	LastUpdate   time.Time // Last time this file was updated
 }
 
-// Progress tracks overall download progress
+// Progress tracks overall download progress. It is the single source of
+// truth for both the byte-based view (bytes written per file) and the
+// piece-based view (pieces verified), and defines when a torrent is complete.
 type Progress struct {
 	mu           sync.RWMutex
 	files        []FileProgress // Progress for each file
 	totalBytes   int64          // Total torrent size
 	writtenBytes int64          // Total bytes written
 	startTime    time.Time      // When download started
+	clock        clock.Clock
+
+	uploadedBytes int64 // Total bytes served to peers
+
+	totalPieces     int   // Number of pieces in the torrent
+	completedPieces int   // Number of verified pieces
+	verifiedBytes   int64 // Bytes in verified pieces
 }
 
 // NewProgress creates a new progress tracker
@@ -47,9 +58,19 @@ func NewProgress(files []FileInfo) *Progress {
 		totalBytes:   totalBytes,
 		writtenBytes: 0,
 		startTime:    time.Now(),
+		clock:        clock.Real,
 	}
 }
 
+// SetClock replaces the clock used for speeds and timestamps. The start time
+// is reset to the new clock's current time.
+func (p *Progress) SetClock(c clock.Clock) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clock = c
+	p.startTime = c.Now()
+}
+
 // AddWrittenBytes adds bytes written to a specific file
 func (p *Progress) AddWrittenBytes(fileIndex int, bytes int64) {
 	p.mu.Lock()
@@ -61,7 +82,7 @@ func (p *Progress) AddWrittenBytes(fileIndex int, bytes int64) {
 
 	// Update file progress
 	p.files[fileIndex].WrittenBytes += bytes
-	p.files[fileIndex].LastUpdate = time.Now()
+	p.files[fileIndex].LastUpdate = p.clock.Now()
 
 	// Check if file is complete
 	if p.files[fileIndex].WrittenBytes >= p.files[fileIndex].TotalBytes {
@@ -72,6 +93,84 @@ func (p *Progress) AddWrittenBytes(fileIndex int, bytes int64) {
 	p.writtenBytes += bytes
 }
 
+// RemoveWrittenBytes takes back bytes of a file counted as written, e.g.
+// when they turned out to be corrupt
+func (p *Progress) RemoveWrittenBytes(fileIndex int, bytes int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if fileIndex < 0 || fileIndex >= len(p.files) {
+		return
+	}
+
+	p.files[fileIndex].WrittenBytes -= bytes
+	p.files[fileIndex].LastUpdate = p.clock.Now()
+	p.files[fileIndex].IsComplete = false
+	p.writtenBytes -= bytes
+}
+
+// SetTotalPieces sets the number of pieces for the piece-based view
+func (p *Progress) SetTotalPieces(total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.totalPieces = total
+}
+
+// AddCompletedPiece records a verified piece of the given length
+func (p *Progress) AddCompletedPiece(length int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.completedPieces++
+	p.verifiedBytes += length
+}
+
+// RemoveCompletedPiece takes back a verified piece of the given length
+func (p *Progress) RemoveCompletedPiece(length int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.completedPieces--
+	p.verifiedBytes -= length
+}
+
+// GetCompletedPieceCount returns the number of verified pieces
+func (p *Progress) GetCompletedPieceCount() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.completedPieces
+}
+
+// GetTotalPieceCount returns the number of pieces in the torrent
+func (p *Progress) GetTotalPieceCount() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.totalPieces
+}
+
+// GetVerifiedBytes returns the number of bytes in verified pieces
+func (p *Progress) GetVerifiedBytes() int64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.verifiedBytes
+}
+
+// GetPieceProgressPercent returns the percentage of pieces verified (0-100)
+func (p *Progress) GetPieceProgressPercent() float64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.totalPieces == 0 {
+		return 0
+	}
+
+	return float64(p.completedPieces) / float64(p.totalPieces) * 100
+}
+
 // SetFileComplete marks a file as complete
 func (p *Progress) SetFileComplete(fileIndex int, complete bool) {
 	p.mu.Lock()
@@ -82,7 +181,7 @@ func (p *Progress) SetFileComplete(fileIndex int, complete bool) {
 	}
 
 	p.files[fileIndex].IsComplete = complete
-	p.files[fileIndex].LastUpdate = time.Now()
+	p.files[fileIndex].LastUpdate = p.clock.Now()
 
 	if complete {
 		// Ensure written bytes matches total bytes
@@ -129,11 +228,16 @@ func (p *Progress) IsFileComplete(fileIndex int) bool {
 	return p.files[fileIndex].IsComplete
 }
 
-// IsComplete returns true if all files are complete
+// IsComplete returns true if the torrent is complete: every piece verified
+// when the piece-based view is in use, otherwise every file fully written
 func (p *Progress) IsComplete() bool {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
+	if p.totalPieces > 0 {
+		return p.completedPieces == p.totalPieces
+	}
+
 	for _, file := range p.files {
 		if !file.IsComplete {
 			return false
@@ -193,6 +297,47 @@ func (p *Progress) GetWrittenBytes() int64 {
 	return p.writtenBytes
 }
 
+// AddUploadedBytes records bytes served to peers
+func (p *Progress) AddUploadedBytes(bytes int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.uploadedBytes += bytes
+}
+
+// GetUploadedBytes returns total bytes served to peers
+func (p *Progress) GetUploadedBytes() int64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.uploadedBytes
+}
+
+// GetUploadSpeed returns the average upload speed in bytes/second
+func (p *Progress) GetUploadSpeed() float64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	elapsed := clock.Since(p.clock, p.startTime).Seconds()
+	if elapsed == 0 {
+		return 0
+	}
+
+	return float64(p.uploadedBytes) / elapsed
+}
+
+// GetRatio returns the share ratio (uploaded / torrent size)
+func (p *Progress) GetRatio() float64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.totalBytes == 0 {
+		return 0
+	}
+
+	return float64(p.uploadedBytes) / float64(p.totalBytes)
+}
+
 // GetRemainingBytes returns bytes remaining to download
 func (p *Progress) GetRemainingBytes() int64 {
 	p.mu.RLock()
@@ -206,12 +351,23 @@ func (p *Progress) GetDownloadSpeed() float64 {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	elapsed := time.Since(p.startTime).Seconds()
+	return p.downloadSpeed()
+}
+
+// downloadSpeed computes the download speed. Caller must hold p.mu.
+// Verified bytes are used when pieces are tracked, since written bytes
+// exclude data discarded for skipped files.
+func (p *Progress) downloadSpeed() float64 {
+	elapsed := clock.Since(p.clock, p.startTime).Seconds()
 	if elapsed == 0 {
 		return 0
 	}
 
-	return float64(p.writtenBytes) / elapsed
+	downloaded := p.writtenBytes
+	if p.totalPieces > 0 {
+		downloaded = p.verifiedBytes
+	}
+	return float64(downloaded) / elapsed
 }
 
 // GetETA returns estimated time to completion
@@ -219,12 +375,20 @@ func (p *Progress) GetETA() time.Duration {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
+	return p.eta()
+}
+
+// eta computes the estimated time to completion. Caller must hold p.mu.
+func (p *Progress) eta() time.Duration {
 	remaining := p.totalBytes - p.writtenBytes
+	if p.totalPieces > 0 {
+		remaining = p.totalBytes - p.verifiedBytes
+	}
 	if remaining <= 0 {
 		return 0
 	}
 
-	speed := p.GetDownloadSpeed()
+	speed := p.downloadSpeed()
 	if speed <= 0 {
 		return time.Duration(0) // Cannot estimate
 	}
@@ -237,6 +401,11 @@ func (p *Progress) GetCompletedFiles() int {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
+	return p.completedFiles()
+}
+
+// completedFiles counts completed files. Caller must hold p.mu.
+func (p *Progress) completedFiles() int {
 	completed := 0
 	for _, file := range p.files {
 		if file.IsComplete {
@@ -260,11 +429,14 @@ func (p *Progress) GetProgressSummary() string {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	completedFiles := p.GetCompletedFiles()
+	completedFiles := p.completedFiles()
 	totalFiles := len(p.files)
-	percent := p.GetOverallProgressPercent()
-	speed := p.GetDownloadSpeed()
-	eta := p.GetETA()
+	percent := 0.0
+	if p.totalBytes > 0 {
+		percent = float64(p.writtenBytes) / float64(p.totalBytes) * 100
+	}
+	speed := p.downloadSpeed()
+	eta := p.eta()
 
 	return fmt.Sprintf("Progress: %.1f%% (%d/%d files) | Speed: %.2f KB/s | ETA: %v",
 		percent, completedFiles, totalFiles, speed/1024, eta.Truncate(time.Second))
@@ -276,12 +448,14 @@ func (p *Progress) Reset() {
 	defer p.mu.Unlock()
 
 	p.writtenBytes = 0
-	p.startTime = time.Now()
+	p.completedPieces = 0
+	p.verifiedBytes = 0
+	p.startTime = p.clock.Now()
 
 	for i := range p.files {
 		p.files[i].WrittenBytes = 0
 		p.files[i].IsComplete = false
-		p.files[i].LastUpdate = time.Now()
+		p.files[i].LastUpdate = p.clock.Now()
 	}
 }
 
@@ -291,7 +465,7 @@ func (p *Progress) GetRecentlyUpdatedFiles(within time.Duration) []FileProgress
 	defer p.mu.RUnlock()
 
 	var recent []FileProgress
-	cutoff := time.Now().Add(-within)
+	cutoff := p.clock.Now().Add(-within)
 
 	for _, file := range p.files {
 		if file.LastUpdate.After(cutoff) {
@@ -308,7 +482,7 @@ func (p *Progress) GetSlowFiles(threshold time.Duration) []FileProgress {
 	defer p.mu.RUnlock()
 
 	var slow []FileProgress
-	cutoff := time.Now().Add(-threshold)
+	cutoff := p.clock.Now().Add(-threshold)
 
 	for _, file := range p.files {
 		if !file.IsComplete && file.LastUpdate.Before(cutoff) {
@@ -0,0 +1,120 @@
+package file
+
+import (
+	"bittorrentclient/internal/bencode"
+	"fmt"
+	"os"
+)
+
+// ResumeData is the persisted completion state of a torrent
+type ResumeData struct {
+	NumPieces int               // Number of pieces in the torrent
+	Bitfield  []byte            // Completed pieces, high bit first
+	Peers     []string          // Known-good peer addresses ("ip:port"), best first
+	Renames   map[string]string // Stored path of each renamed file, keyed by its torrent path
+
+	// Unverified marks, like Bitfield, the complete pieces seed mode assumed
+	// without hashing them; nil if there are none
+	Unverified []byte
+}
+
+// SaveResumeData writes resume data to path. The file is written to a
+// temporary name and renamed so a crash never leaves a truncated file.
+func SaveResumeData(path string, data *ResumeData) error {
+	peers := make([]interface{}, len(data.Peers))
+	for i, addr := range data.Peers {
+		peers[i] = addr
+	}
+
+	renames := make(map[string]interface{}, len(data.Renames))
+	for path, stored := range data.Renames {
+		renames[path] = stored
+	}
+
+	dict := map[string]interface{}{
+		"pieces":   data.NumPieces,
+		"bitfield": string(data.Bitfield),
+		"peers":    peers,
+		"renames":  renames,
+	}
+	if data.Unverified != nil {
+		dict["unverified"] = string(data.Unverified)
+	}
+	encoded, err := bencode.Encode(dict)
+	if err != nil {
+		return fmt.Errorf("failed to encode resume data: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, encoded, 0644); err != nil {
+		return fmt.Errorf("failed to write resume data: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace resume data: %w", err)
+	}
+
+	return nil
+}
+
+// LoadResumeData reads resume data from path
+func LoadResumeData(path string) (*ResumeData, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read resume data: %w", err)
+	}
+
+	decoded, err := bencode.Decode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode resume data: %w", err)
+	}
+
+	dict, ok := decoded.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("resume data is not a dictionary")
+	}
+
+	numPieces, ok := dict["pieces"].(int64)
+	if !ok {
+		return nil, fmt.Errorf("missing or invalid pieces in resume data")
+	}
+
+	bitfield, ok := dict["bitfield"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing or invalid bitfield in resume data")
+	}
+
+	// Peers are optional; older resume files don't have them
+	var peers []string
+	if list, ok := dict["peers"].([]interface{}); ok {
+		for _, item := range list {
+			if addr, ok := item.(string); ok {
+				peers = append(peers, addr)
+			}
+		}
+	}
+
+	// Renames are optional too
+	renames := make(map[string]string)
+	if dict, ok := dict["renames"].(map[string]interface{}); ok {
+		for path, item := range dict {
+			if stored, ok := item.(string); ok {
+				renames[path] = stored
+			}
+		}
+	}
+
+	// So are pieces seed mode never hashed
+	var unverified []byte
+	if field, ok := dict["unverified"].(string); ok {
+		unverified = []byte(field)
+	}
+
+	return &ResumeData{
+		NumPieces:  int(numPieces),
+		Bitfield:   []byte(bitfield),
+		Peers:      peers,
+		Renames:    renames,
+		Unverified: unverified,
+	}, nil
+}
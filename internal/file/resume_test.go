@@ -0,0 +1,39 @@
+package file
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestResumeDataUnverified(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resume")
+
+	saved := &ResumeData{
+		NumPieces:  10,
+		Bitfield:   []byte{0xff, 0xc0},
+		Unverified: []byte{0x0f, 0x40},
+	}
+	if err := SaveResumeData(path, saved); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadResumeData(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.Unverified, saved.Unverified) {
+		t.Fatalf("Unverified = %x, want %x", loaded.Unverified, saved.Unverified)
+	}
+
+	// Files written without seed mode have none
+	saved.Unverified = nil
+	if err := SaveResumeData(path, saved); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err = LoadResumeData(path); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Unverified != nil {
+		t.Fatalf("Unverified = %x, want nil", loaded.Unverified)
+	}
+}
@@ -2,9 +2,13 @@ package file
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
+
+	"bittorrentclient/internal/fdbudget"
 )
 
 // Writer handles writing piece data to files
@@ -14,8 +18,13 @@ type Writer struct {
 	outputDir    string
 	fileHandles  map[string]*os.File // Cache of open file handles
 	maxOpenFiles int                 // Maximum number of open files
+	fdBudget     *fdbudget.Budget    // Session-wide descriptor budget handles count against; nil is unlimited
 	allocator    *Allocator
 	progress     *Progress
+	initialized  bool              // Initialize has allocated the files
+	cache        *writeCache       // Write-behind cache; nil writes each piece as it arrives
+	onFlushed    FlushFunc         // Optional hook called once pieces are synced to disk
+	modTimes     map[int]time.Time // Per file: modification time our last write left
 }
 
 // NewWriter creates a new file writer
@@ -27,6 +36,7 @@ func NewWriter(mapper *Mapper, outputDir string) *Writer {
 		maxOpenFiles: 100, // Reasonable default
 		allocator:    NewAllocator(outputDir),
 		progress:     NewProgress(mapper.GetAllFiles()),
+		modTimes:     make(map[int]time.Time),
 	}
 }
 
@@ -41,10 +51,39 @@ func (w *Writer) Initialize() error {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
-	// Create file structure and allocate space
+	// Pick the allocation strategy for the bytes we will actually write
 	files := w.mapper.GetAllFiles()
+	var wanted int64
 	for _, file := range files {
-		fullPath := filepath.Join(w.outputDir, file.Path)
+		if file.Priority != PrioritySkip && file.DiskPath == "" {
+			wanted += file.Length
+		}
+	}
+	strategy := w.allocator.Resolve(wanted)
+	fmt.Printf("Using %s allocation for %d bytes\n", strategy, wanted)
+
+	// Create file structure and allocate space
+	for i, file := range files {
+		// Skipped files are neither created nor preallocated
+		if file.Priority == PrioritySkip {
+			continue
+		}
+
+		// Files mapped to existing data are used as-is, never truncated
+		if file.DiskPath != "" {
+			stat, err := os.Stat(file.DiskPath)
+			if err != nil {
+				return fmt.Errorf("mapped file %s not found: %w", file.DiskPath, err)
+			}
+			if stat.Size() != file.Length {
+				return fmt.Errorf("mapped file %s has incorrect size: expected %d, got %d",
+					file.DiskPath, file.Length, stat.Size())
+			}
+			w.modTimes[i] = stat.ModTime()
+			continue
+		}
+
+		fullPath := filepath.Join(w.outputDir, file.StoredPath())
 
 		// Create directory structure
 		dir := filepath.Dir(fullPath)
@@ -58,12 +97,45 @@ func (w *Writer) Initialize() error {
 		if err != nil {
 			return fmt.Errorf("failed to allocate file %s: %w", fullPath, err)
 		}
+		w.recordModTime(i)
 	}
 
+	w.initialized = true
 	fmt.Printf("Initialized file structure in %s\n", w.outputDir)
 	return nil
 }
 
+// SetAllocationStrategy overrides the automatic choice of how files are
+// allocated. It takes effect for files allocated from then on.
+func (w *Writer) SetAllocationStrategy(strategy AllocationStrategy) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.allocator.SetStrategy(strategy)
+}
+
+// SetFilePriority changes a file's priority. A file that stops being skipped
+// after Initialize is allocated at that point.
+func (w *Writer) SetFilePriority(fileIndex int, priority Priority) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	wasSkipped := w.mapper.IsFileSkipped(fileIndex)
+	if err := w.mapper.SetFilePriority(fileIndex, priority); err != nil {
+		return err
+	}
+
+	file := w.mapper.GetAllFiles()[fileIndex]
+	if w.initialized && wasSkipped && priority != PrioritySkip && file.DiskPath == "" {
+		fullPath := filepath.Join(w.outputDir, file.StoredPath())
+		if err := w.allocator.AllocateFile(fullPath, file.Length); err != nil {
+			return fmt.Errorf("failed to allocate file %s: %w", fullPath, err)
+		}
+		w.recordModTime(fileIndex)
+	}
+
+	return nil
+}
+
 // WritePiece writes a completed piece to its corresponding files
 func (w *Writer) WritePiece(pieceIndex int, data []byte) error {
 	err := w.mapper.ValidatePieceData(pieceIndex, data)
@@ -79,10 +151,20 @@ func (w *Writer) WritePiece(pieceIndex int, data []byte) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	if w.cache != nil {
+		return w.cachePiece(pieceIndex, data)
+	}
+
 	dataOffset := int64(0)
 
 	for _, fileRange := range mapping.FileRanges {
-		fullPath := filepath.Join(w.outputDir, fileRange.FilePath)
+		// Bytes belonging to skipped files go nowhere
+		if fileRange.Discard {
+			dataOffset += fileRange.Length
+			continue
+		}
+
+		fullPath := w.filePath(fileRange.FileIndex)
 
 		file, err := w.getFileHandle(fullPath)
 		if err != nil {
@@ -116,13 +198,214 @@ func (w *Writer) WritePiece(pieceIndex int, data []byte) error {
 	}
 
 	for _, fileRange := range mapping.FileRanges {
-		fullPath := filepath.Join(w.outputDir, fileRange.FilePath)
+		fullPath := w.filePath(fileRange.FileIndex)
 		if file, exists := w.fileHandles[fullPath]; exists {
 			file.Sync()
+			w.recordModTime(fileRange.FileIndex)
 		}
 	}
 
 	fmt.Printf("Wrote piece %d to %d files\n", pieceIndex, len(mapping.FileRanges))
+	if w.onFlushed != nil {
+		w.onFlushed([]int{pieceIndex})
+	}
+	return nil
+}
+
+// FilePath returns the on-disk path of a torrent file
+func (w *Writer) FilePath(fileIndex int) string {
+	return w.filePath(fileIndex)
+}
+
+// filePath returns the on-disk path of a torrent file, honoring any
+// location set with SetFileLocation or name set with RenameFile
+func (w *Writer) filePath(fileIndex int) string {
+	file := w.mapper.GetAllFiles()[fileIndex]
+	if file.DiskPath != "" {
+		return file.DiskPath
+	}
+	return filepath.Join(w.outputDir, file.StoredPath())
+}
+
+// SetFileLocation maps a torrent file to an existing file on disk.
+// Must be called before Initialize.
+func (w *Writer) SetFileLocation(fileIndex int, diskPath string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.initialized {
+		return fmt.Errorf("cannot change file locations after initialization")
+	}
+	return w.mapper.SetFileLocation(fileIndex, diskPath)
+}
+
+// RenameFile stores a torrent file under path, relative to the output
+// directory, instead of its torrent path, moving data already stored under
+// its current name. An empty path restores the torrent's name. Must be
+// called before Initialize.
+func (w *Writer) RenameFile(fileIndex int, path string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.initialized {
+		return fmt.Errorf("cannot rename files after initialization")
+	}
+	files := w.mapper.GetAllFiles()
+	if fileIndex < 0 || fileIndex >= len(files) {
+		return fmt.Errorf("invalid file index: %d", fileIndex)
+	}
+	if path == "" {
+		path = files[fileIndex].Path
+	}
+
+	if files[fileIndex].DiskPath == "" {
+		from := filepath.Join(w.outputDir, files[fileIndex].StoredPath())
+		to := filepath.Join(w.outputDir, path)
+		if _, err := os.Stat(from); err == nil && from != to {
+			if _, err := os.Stat(to); err == nil {
+				return fmt.Errorf("%s already exists", to)
+			}
+			if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+				return fmt.Errorf("failed to create directory for %s: %w", to, err)
+			}
+			if err := os.Rename(from, to); err != nil {
+				return fmt.Errorf("failed to move %s: %w", from, err)
+			}
+			removeEmptyDirs(filepath.Dir(from), w.outputDir)
+		}
+	}
+	return w.mapper.SetFileRename(fileIndex, path)
+}
+
+// SetFileRenames stores files under the names in renames, keyed by torrent
+// path, e.g. as recorded in resume data, without moving anything. Files
+// renamed already keep their name. Must be called before Initialize.
+func (w *Writer) SetFileRenames(renames map[string]string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.initialized {
+		return fmt.Errorf("cannot rename files after initialization")
+	}
+	for i, file := range w.mapper.GetAllFiles() {
+		if path, ok := renames[file.Path]; ok && file.Rename == "" {
+			if err := w.mapper.SetFileRename(i, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ReadPiece reads a piece's data back from its files
+func (w *Writer) ReadPiece(pieceIndex int) ([]byte, error) {
+	mapping, err := w.mapper.GetPieceMapping(pieceIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get piece mapping: %w", err)
+	}
+	return w.readRange(mapping, 0, pieceLength(mapping))
+}
+
+// ReadBlock reads length bytes at begin of a piece back from its files,
+// touching only the files and bytes the block covers
+func (w *Writer) ReadBlock(pieceIndex int, begin, length int64) ([]byte, error) {
+	mapping, err := w.mapper.GetPieceMapping(pieceIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get piece mapping: %w", err)
+	}
+	return w.readRange(mapping, begin, length)
+}
+
+// readRange reads length bytes at begin of the piece mapping describes
+func (w *Writer) readRange(mapping PieceFileMap, begin, length int64) ([]byte, error) {
+	if begin < 0 || length < 0 || begin+length > pieceLength(mapping) {
+		return nil, fmt.Errorf("block %d+%d out of range for piece %d", begin, length, mapping.PieceIndex)
+	}
+
+	// Pieces still in the write cache aren't on disk yet
+	if data, ok := w.cachedPiece(mapping.PieceIndex); ok {
+		return data[begin : begin+length], nil
+	}
+
+	data := make([]byte, length)
+	end := begin + length
+	pieceOffset := int64(0) // Where the current file range starts in the piece
+
+	for _, fileRange := range mapping.FileRanges {
+		rangeStart, rangeEnd := pieceOffset, pieceOffset+fileRange.Length
+		pieceOffset = rangeEnd
+
+		// Only the part of the range inside the block is read
+		from, to := max(rangeStart, begin), min(rangeEnd, end)
+		if from >= to {
+			continue
+		}
+
+		// Pad files hold zeros, which data already is
+		if fileRange.Discard && w.mapper.IsPadding(fileRange.FileIndex) {
+			continue
+		}
+		if fileRange.Discard {
+			return nil, fmt.Errorf("piece %d overlaps skipped file %s", mapping.PieceIndex, fileRange.FilePath)
+		}
+
+		fullPath := w.filePath(fileRange.FileIndex)
+		file, err := os.Open(fullPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", fullPath, err)
+		}
+
+		_, err = file.ReadAt(data[from-begin:to-begin], fileRange.Offset+from-rangeStart)
+		file.Close()
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read %s: %w", fullPath, err)
+		}
+	}
+
+	return data, nil
+}
+
+// pieceLength returns the length of the piece mapping describes
+func pieceLength(mapping PieceFileMap) int64 {
+	var length int64
+	for _, fileRange := range mapping.FileRanges {
+		length += fileRange.Length
+	}
+	return length
+}
+
+// MarkPieceWritten updates progress for a piece that is already on disk
+// (e.g. recovered from the journal) without writing it again
+func (w *Writer) MarkPieceWritten(pieceIndex int) error {
+	mapping, err := w.mapper.GetPieceMapping(pieceIndex)
+	if err != nil {
+		return fmt.Errorf("failed to get piece mapping: %w", err)
+	}
+
+	for _, fileRange := range mapping.FileRanges {
+		// Bytes of skipped and pad files were never stored
+		if fileRange.Discard {
+			continue
+		}
+		w.progress.AddWrittenBytes(fileRange.FileIndex, fileRange.Length)
+	}
+	return nil
+}
+
+// UnmarkPieceWritten takes back MarkPieceWritten, e.g. for a piece whose
+// data on disk turned out to be corrupt
+func (w *Writer) UnmarkPieceWritten(pieceIndex int) error {
+	mapping, err := w.mapper.GetPieceMapping(pieceIndex)
+	if err != nil {
+		return fmt.Errorf("failed to get piece mapping: %w", err)
+	}
+
+	for _, fileRange := range mapping.FileRanges {
+		if fileRange.Discard {
+			continue
+		}
+		w.progress.RemoveWrittenBytes(fileRange.FileIndex, fileRange.Length)
+	}
 	return nil
 }
 
@@ -138,9 +421,18 @@ func (w *Writer) getFileHandle(fullPath string) (*os.File, error) {
 		w.closeOldestFile()
 	}
 
+	// When the session is short of descriptors, give up one of ours rather
+	// than take one from the peers. With none to give up, take it anyway:
+	// the piece has to be written.
+	if !w.fdBudget.TryAcquire() {
+		w.closeOldestFile()
+		w.fdBudget.Acquire()
+	}
+
 	// Open the file
 	file, err := os.OpenFile(fullPath, os.O_WRONLY, 0644)
 	if err != nil {
+		w.fdBudget.Release()
 		return nil, err
 	}
 
@@ -152,29 +444,156 @@ func (w *Writer) getFileHandle(fullPath string) (*os.File, error) {
 func (w *Writer) closeOldestFile() {
 	// Simple strategy: close the first file we find
 	// In a more sophisticated implementation, you might track access times
-	for path, file := range w.fileHandles {
-		file.Close()
-		delete(w.fileHandles, path)
+	for path := range w.fileHandles {
+		w.closeFile(path)
 		break
 	}
 }
 
+// closeFile closes a cached file handle and returns its descriptor to the
+// budget
+func (w *Writer) closeFile(path string) error {
+	err := w.fileHandles[path].Close()
+	delete(w.fileHandles, path)
+	w.fdBudget.Release()
+	return err
+}
+
+// SetFDBudget makes the writer's open file handles count against budget,
+// closing handles instead of exceeding it
+func (w *Writer) SetFDBudget(budget *fdbudget.Budget) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Handles already open move to the new budget
+	for range w.fileHandles {
+		w.fdBudget.Release()
+		budget.Acquire()
+	}
+	w.fdBudget = budget
+}
+
 // Close closes all file handles and resources
 func (w *Writer) Close() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	var lastErr error
-	for path, file := range w.fileHandles {
-		if err := file.Close(); err != nil {
+	lastErr := w.flush()
+	for path := range w.fileHandles {
+		if err := w.closeFile(path); err != nil {
 			lastErr = err
 		}
-		delete(w.fileHandles, path)
 	}
 
 	return lastErr
 }
 
+// MoveTo moves the torrent's files under dir and carries on from there,
+// e.g. out of a staging directory once the download is complete. Skipped
+// files and files mapped to existing data stay where they are.
+func (w *Writer) MoveTo(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.flush(); err != nil {
+		return err
+	}
+	for path := range w.fileHandles {
+		w.closeFile(path)
+	}
+
+	for _, file := range w.mapper.GetAllFiles() {
+		if file.Priority == PrioritySkip || file.DiskPath != "" {
+			continue
+		}
+
+		from := filepath.Join(w.outputDir, file.StoredPath())
+		to := filepath.Join(dir, file.StoredPath())
+		if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", to, err)
+		}
+		if err := moveFile(from, to); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to move %s: %w", file.Path, err)
+		}
+		removeEmptyDirs(filepath.Dir(from), w.outputDir)
+	}
+
+	w.outputDir = dir
+	w.allocator.outputDir = dir
+	return nil
+}
+
+// UseDirectory carries on with the torrent's files under dir instead, as
+// they are there, e.g. a copy of the data made elsewhere; nothing is moved.
+// Missing files are created as Initialize would.
+func (w *Writer) UseDirectory(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.flush(); err != nil {
+		return err
+	}
+	for path := range w.fileHandles {
+		w.closeFile(path)
+	}
+
+	w.outputDir = dir
+	w.allocator.outputDir = dir
+	if !w.initialized {
+		return nil
+	}
+	for i, file := range w.mapper.GetAllFiles() {
+		if file.Priority == PrioritySkip || file.DiskPath != "" {
+			continue
+		}
+		fullPath := filepath.Join(dir, file.StoredPath())
+		if err := w.allocator.AllocateFile(fullPath, file.Length); err != nil {
+			return fmt.Errorf("failed to allocate file %s: %w", fullPath, err)
+		}
+		w.recordModTime(i)
+	}
+	return nil
+}
+
+// removeEmptyDirs removes dir and its parents up to, not including, root
+// as long as they are empty, e.g. after moving the files out of them
+func removeEmptyDirs(dir, root string) {
+	for root = filepath.Clean(root); dir != root && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+// moveFile moves a file, copying it when from and to are on different
+// file systems, which a rename can't cross
+func moveFile(from, to string) error {
+	err := os.Rename(from, to)
+	if err == nil || os.IsNotExist(err) {
+		return err
+	}
+
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(to)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(to)
+		return err
+	}
+	return os.Remove(from)
+}
+
 // GetProgress returns the current file writing progress
 func (w *Writer) GetProgress() *Progress {
 	return w.progress
@@ -185,7 +604,11 @@ func (w *Writer) VerifyFiles() ([]FileInfo, error) {
 	files := w.mapper.GetAllFiles()
 
 	for i, file := range files {
-		fullPath := filepath.Join(w.outputDir, file.Path)
+		if file.Priority == PrioritySkip {
+			continue
+		}
+
+		fullPath := w.filePath(i)
 
 		// Check if file exists
 		stat, err := os.Stat(fullPath)
@@ -221,21 +644,21 @@ func (w *Writer) GetCompletedFiles() []string {
 	var completed []string
 	files := w.mapper.GetAllFiles()
 
-	for i, file := range files {
+	for i := range files {
 		if w.progress.IsFileComplete(i) {
-			completed = append(completed, filepath.Join(w.outputDir, file.Path))
+			completed = append(completed, w.filePath(i))
 		}
 	}
 
 	return completed
 }
 
-// FlushAll forces all pending writes to disk
+// FlushAll forces all pending writes, including the write cache, to disk
 func (w *Writer) FlushAll() error {
-	w.mu.RLock()
-	defer w.mu.RUnlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	var lastErr error
+	lastErr := w.flush()
 	for _, file := range w.fileHandles {
 		if err := file.Sync(); err != nil {
 			lastErr = err
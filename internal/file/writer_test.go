@@ -0,0 +1,116 @@
+package file
+
+import (
+	"bytes"
+	"testing"
+)
+
+// testWriter returns an initialized writer for three files in 8-byte
+// pieces: "a" of 6 bytes, a 2-byte pad file and "b" of 10 bytes, so piece
+// 0 ends in padding and piece 1 spans into the short last piece
+func testWriter(t *testing.T) (*Writer, []byte) {
+	t.Helper()
+	files := []FileInfo{
+		{Path: "a", Length: 6, Offset: 0},
+		{Path: ".pad/2", Length: 2, Offset: 6, Padding: true},
+		{Path: "b", Length: 10, Offset: 8},
+	}
+	w := NewWriter(NewMapper(files, 8, 18), t.TempDir())
+	if err := w.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("abcdef\x00\x00ghijklmnop")
+	for i := 0; i < 3; i++ {
+		end := int(min(int64(i+1)*8, int64(len(data))))
+		if err := w.WritePiece(i, data[i*8:end]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.FlushAll(); err != nil {
+		t.Fatal(err)
+	}
+	return w, data
+}
+
+func TestWriterReadBlock(t *testing.T) {
+	w, data := testWriter(t)
+
+	tests := []struct {
+		name          string
+		piece         int
+		begin, length int64
+	}{
+		{"whole first piece", 0, 0, 8},
+		{"inside a file", 0, 1, 3},
+		{"into padding", 0, 4, 4},
+		{"padding only", 0, 6, 2},
+		{"short last piece", 2, 0, 2},
+		{"tail of a piece", 1, 5, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := w.ReadBlock(tt.piece, tt.begin, tt.length)
+			if err != nil {
+				t.Fatal(err)
+			}
+			offset := int64(tt.piece)*8 + tt.begin
+			if want := data[offset : offset+tt.length]; !bytes.Equal(got, want) {
+				t.Fatalf("ReadBlock = %q, want %q", got, want)
+			}
+		})
+	}
+
+	for _, piece := range []int{0, 1, 2} {
+		whole, err := w.ReadPiece(piece)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := data[piece*8 : min(int64(piece+1)*8, int64(len(data)))]; !bytes.Equal(whole, want) {
+			t.Fatalf("ReadPiece(%d) = %q, want %q", piece, whole, want)
+		}
+	}
+}
+
+func TestWriterReadBlockOutOfRange(t *testing.T) {
+	w, _ := testWriter(t)
+	for _, block := range [][2]int64{{-1, 2}, {0, -1}, {7, 2}} {
+		if _, err := w.ReadBlock(0, block[0], block[1]); err == nil {
+			t.Fatalf("block %d+%d read, want an out of range error", block[0], block[1])
+		}
+	}
+	if _, err := w.ReadBlock(2, 1, 2); err == nil {
+		t.Fatal("block past the short last piece read")
+	}
+}
+
+func TestMarkPieceWrittenSkipsDiscarded(t *testing.T) {
+	files := []FileInfo{
+		{Path: "a", Length: 6, Offset: 0},
+		{Path: "skipped", Length: 10, Offset: 6, Priority: PrioritySkip},
+	}
+	w := NewWriter(NewMapper(files, 8, 16), t.TempDir())
+	if err := w.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+
+	for piece := 0; piece < 2; piece++ {
+		if err := w.MarkPieceWritten(piece); err != nil {
+			t.Fatal(err)
+		}
+	}
+	progress := w.GetProgress()
+	if got := progress.GetWrittenBytes(); got != 6 {
+		t.Fatalf("written bytes = %d, want only a's 6", got)
+	}
+	if skipped, _ := progress.GetFileProgress(1); skipped.WrittenBytes != 0 || skipped.IsComplete {
+		t.Fatalf("skipped file progress = %+v, want nothing written", skipped)
+	}
+
+	if err := w.UnmarkPieceWritten(0); err != nil {
+		t.Fatal(err)
+	}
+	if skipped, _ := progress.GetFileProgress(1); skipped.WrittenBytes != 0 {
+		t.Fatalf("skipped file written bytes = %d after unmarking, want 0", skipped.WrittenBytes)
+	}
+}
@@ -0,0 +1,165 @@
+package ipc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"bittorrentclient/internal/file"
+)
+
+const (
+	lockName   = "session.lock"
+	socketName = "session.sock"
+
+	// DialRetryWindow is how long Send keeps retrying while a starting
+	// instance holds the lock but isn't listening yet
+	DialRetryWindow = 2 * time.Second
+	// HandleTimeout bounds how long one request may take to answer,
+	// including the running instance contacting the tracker
+	HandleTimeout = 2 * time.Minute
+)
+
+// ErrRunning is returned by Listen when another instance owns the session
+var ErrRunning = errors.New("another instance is running")
+
+// Request asks the running instance to add a torrent, or to run a command
+// such as toggling turtle mode
+type Request struct {
+	Args []string `json:"args"` // Command-line arguments after the program name
+	Dir  string   `json:"dir"`  // Working directory relative paths in Args are resolved against
+}
+
+// Response is the running instance's answer to a Request
+type Response struct {
+	Message string `json:"message,omitempty"` // Shown to the user of the forwarding invocation
+	Error   string `json:"error,omitempty"`
+}
+
+// HandlerFunc handles a Request forwarded by another invocation, returning
+// a message for its user
+type HandlerFunc func(Request) (string, error)
+
+// Server owns the session directory: it holds the session lock and accepts
+// requests on a local socket
+type Server struct {
+	lock     *file.Lock
+	listener net.Listener
+	handle   HandlerFunc
+}
+
+// DefaultDir returns the per-user directory holding the session lock and
+// socket
+func DefaultDir() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "bittorrentclient")
+	}
+	return filepath.Join(os.TempDir(), "bittorrentclient")
+}
+
+// Listen makes this process the running instance for dir, serving forwarded
+// requests with handle. It returns ErrRunning if another instance already is.
+func Listen(dir string, handle HandlerFunc) (*Server, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+
+	lock, err := file.AcquireLock(filepath.Join(dir, lockName))
+	if errors.Is(err, file.ErrInUse) {
+		return nil, ErrRunning
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Holding the lock means any socket left behind is from a crashed
+	// instance
+	socketPath := filepath.Join(dir, socketName)
+	os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		lock.Release()
+		return nil, fmt.Errorf("failed to listen on %s: %w", socketPath, err)
+	}
+
+	s := &Server{lock: lock, listener: listener, handle: handle}
+	go s.serve()
+	return s, nil
+}
+
+// serve accepts connections until the listener is closed
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn answers one request
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(HandleTimeout))
+
+	var req Request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		fmt.Printf("Ignoring malformed session request: %v\n", err)
+		return
+	}
+
+	message, err := s.handle(req)
+	resp := Response{Message: message}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// Close stops accepting requests and gives up the session
+func (s *Server) Close() error {
+	err := s.listener.Close() // Also removes the socket file
+	if lockErr := s.lock.Release(); err == nil {
+		err = lockErr
+	}
+	return err
+}
+
+// Send forwards req to the instance running for dir and returns the message
+// and error it reported handling it
+func Send(dir string, req Request) (string, error) {
+	socketPath := filepath.Join(dir, socketName)
+
+	var conn net.Conn
+	var err error
+	deadline := time.Now().Add(DialRetryWindow)
+	for {
+		conn, err = net.Dial("unix", socketPath)
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to reach the running instance: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(HandleTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.Error != "" {
+		return resp.Message, errors.New(resp.Error)
+	}
+	return resp.Message, nil
+}
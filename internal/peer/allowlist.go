@@ -0,0 +1,87 @@
+package peer
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Allowlist restricts a torrent's peers to a set of IP addresses and
+// subnets, for private distribution (a LAN deployment, say) where peers
+// from trackers or other sources outside it must be ignored. A nil
+// Allowlist allows every peer.
+type Allowlist struct {
+	specs []string
+	nets  []*net.IPNet
+}
+
+// ParseAllowlist parses IP addresses ("192.168.1.7", "fd00::1") and CIDR
+// subnets ("10.0.0.0/8", "fd00::/64"). It returns nil, allowing everyone,
+// if specs is empty.
+func ParseAllowlist(specs []string) (*Allowlist, error) {
+	a := &Allowlist{}
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		cidr := spec
+		if !strings.Contains(spec, "/") {
+			ip := net.ParseIP(spec)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid peer address %q, expected an IP or a CIDR subnet", spec)
+			}
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer subnet %q: %w", spec, err)
+		}
+		a.specs = append(a.specs, spec)
+		a.nets = append(a.nets, ipNet)
+	}
+	if len(a.nets) == 0 {
+		return nil, nil
+	}
+	return a, nil
+}
+
+// Allows returns true if addr, "ip:port" or a bare IP, is inside the
+// allowlist. Host names are never allowed, since they can resolve anywhere.
+func (a *Allowlist) Allows(addr string) bool {
+	if a == nil {
+		return true
+	}
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range a.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Specs returns the addresses and subnets as given to ParseAllowlist
+func (a *Allowlist) Specs() []string {
+	if a == nil {
+		return nil
+	}
+	return append([]string(nil), a.specs...)
+}
+
+// String returns the addresses and subnets, comma-separated
+func (a *Allowlist) String() string {
+	return strings.Join(a.Specs(), ",")
+}
@@ -0,0 +1,383 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bittorrentclient/internal/clock"
+	"bittorrentclient/internal/fdbudget"
+)
+
+// ErrBindLost is returned by a paused dialer, whose bound interface or
+// address went away
+var ErrBindLost = errors.New("bound interface or address is gone")
+
+// Dialer opens peer connections from the local addresses it is bound to,
+// e.g. to keep peer traffic on a VPN interface
+type Dialer struct {
+	bind  string   // Interface name or IP the dialer was created with
+	local []net.IP // Addresses to dial from; empty means let the OS choose
+
+	mu       sync.RWMutex
+	paused   bool             // Refuse to dial, see SetPaused
+	resolver Resolver         // Looks up host names; nil uses net.DefaultResolver
+	timeouts Timeouts         // Zero fields use DefaultTimeouts
+	budget   *fdbudget.Budget // Descriptors sockets count against; nil is unlimited
+	clock    clock.Clock      // Times happy eyeballs and the accept rate; nil uses clock.Real
+}
+
+// Timeouts bound how long a dialer spends reaching one peer
+type Timeouts struct {
+	Dial      time.Duration // Opening the connection, across every address tried
+	Handshake time.Duration // Exchanging handshakes once connected
+	Connect   time.Duration // Dial and handshake together
+}
+
+// DefaultTimeouts returns the timeouts a dialer uses unless told otherwise
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		Dial:      10 * time.Second,
+		Handshake: DefaultHandshakeTimeout,
+		Connect:   20 * time.Second,
+	}
+}
+
+// tcpKeepAlive is the keep-alive period of TCP connections the dialer
+// opens, matching the tracker client's own dialer
+const tcpKeepAlive = 30 * time.Second
+
+// DefaultDialer dials from whatever address the OS routes through
+var DefaultDialer = &Dialer{}
+
+// NewDialer creates a dialer bound to bind, which is an interface name
+// (e.g. "tun0") or an IP address. An empty bind leaves the choice to the OS.
+func NewDialer(bind string) (*Dialer, error) {
+	if bind == "" {
+		return &Dialer{}, nil
+	}
+
+	if ip := net.ParseIP(bind); ip != nil {
+		return &Dialer{bind: bind, local: []net.IP{ip}}, nil
+	}
+
+	local, err := interfaceIPs(bind)
+	if err != nil {
+		return nil, err
+	}
+	if len(local) == 0 {
+		return nil, fmt.Errorf("bind %q: interface has no usable addresses", bind)
+	}
+	return &Dialer{bind: bind, local: local}, nil
+}
+
+// interfaceIPs returns the usable (non link-local) addresses of an interface
+func interfaceIPs(name string) ([]net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("bind %q: %w", name, err)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return nil, fmt.Errorf("bind %q: interface is down", name)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("bind %q: %w", name, err)
+	}
+
+	var ips []net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		ips = append(ips, ipNet.IP)
+	}
+	return ips, nil
+}
+
+// Check verifies the bound interface still exists, is up and still holds
+// the addresses the dialer was created with. A VPN going down typically
+// fails this, and peer traffic should stop before it leaks onto another
+// route. An unbound dialer always passes.
+func (d *Dialer) Check() error {
+	if !d.IsBound() {
+		return nil
+	}
+
+	var current []net.IP
+	var err error
+	if net.ParseIP(d.bind) != nil {
+		current, err = hostIPs()
+	} else {
+		current, err = interfaceIPs(d.bind)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, want := range d.local {
+		found := false
+		for _, ip := range current {
+			if ip.Equal(want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("bind %q: address %s is gone", d.bind, want)
+		}
+	}
+	return nil
+}
+
+// hostIPs returns every address assigned to the host
+func hostIPs() ([]net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	return ips, nil
+}
+
+// SetPaused stops (or allows again) new connections; dials fail with
+// ErrBindLost while paused
+func (d *Dialer) SetPaused(paused bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.paused = paused
+}
+
+// IsPaused returns true if the dialer refuses to dial
+func (d *Dialer) IsPaused() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.paused
+}
+
+// SetTimeouts changes the dial, handshake and connect timeouts; zero fields
+// use the default
+func (d *Dialer) SetTimeouts(t Timeouts) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.timeouts = t
+}
+
+// SetClock replaces the clock that paces happy eyeballs attempts and the
+// accept rate of listeners started afterwards
+func (d *Dialer) SetClock(c clock.Clock) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clock = c
+}
+
+// Clock returns the clock the dialer paces itself by
+func (d *Dialer) Clock() clock.Clock {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.clock == nil {
+		return clock.Real
+	}
+	return d.clock
+}
+
+// Timeouts returns the timeouts the dialer uses, defaults filled in
+func (d *Dialer) Timeouts() Timeouts {
+	d.mu.RLock()
+	t := d.timeouts
+	d.mu.RUnlock()
+
+	defaults := DefaultTimeouts()
+	if t.Dial <= 0 {
+		t.Dial = defaults.Dial
+	}
+	if t.Handshake <= 0 {
+		t.Handshake = defaults.Handshake
+	}
+	if t.Connect <= 0 {
+		t.Connect = defaults.Connect
+	}
+	return t
+}
+
+// IsBound returns true if the dialer is restricted to specific addresses
+func (d *Dialer) IsBound() bool {
+	return len(d.local) > 0
+}
+
+// localFor returns the bound address of the same family as remote. A bound
+// dialer with no such address fails rather than falling back to the default
+// route, so traffic never leaves the chosen interface.
+func (d *Dialer) localFor(remote net.IP) (net.IP, error) {
+	if !d.IsBound() {
+		return nil, nil
+	}
+	wantV4 := remote.To4() != nil
+	for _, ip := range d.local {
+		if (ip.To4() != nil) == wantV4 {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("no bound address for %s", remote)
+}
+
+// DialContext dials a peer address from the bound local address
+func (d *Dialer) DialContext(ctx context.Context, address string) (net.Conn, error) {
+	return d.DialNetwork(ctx, "tcp", address)
+}
+
+// DialNetwork dials address over network ("tcp" or "udp", optionally with
+// a 4 or 6 suffix) from the bound local address, giving up after the dial
+// timeout or failing at once if the descriptor budget is used up. Host
+// names are looked up with the dialer's resolver; when they resolve to
+// both IPv4 and IPv6 addresses, TCP dials race the families happy eyeballs
+// style. Its signature matches net.Dialer.DialContext, so it can back
+// other clients, e.g. the tracker's.
+func (d *Dialer) DialNetwork(ctx context.Context, network, address string) (net.Conn, error) {
+	if d.IsPaused() {
+		return nil, ErrBindLost
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	budget := d.FDBudget()
+	if !budget.TryAcquire() {
+		return nil, fdbudget.ErrExhausted
+	}
+	conn, err := d.dialResolved(ctx, network, host, port)
+	if err != nil {
+		budget.Release()
+		return nil, err
+	}
+	return withBudget(conn, budget), nil
+}
+
+// dialResolved looks host up and dials port on its addresses, for
+// DialNetwork
+func (d *Dialer) dialResolved(ctx context.Context, network, host, port string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.Timeouts().Dial)
+	defer cancel()
+
+	var candidates []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		candidates = []net.IP{ip}
+	} else {
+		addrs, err := d.Resolver().LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			candidates = append(candidates, addr.IP)
+		}
+	}
+
+	// Keep the addresses the network allows and we have a bound address for
+	usable := candidates[:0]
+	for _, remote := range candidates {
+		isV4 := remote.To4() != nil
+		if (strings.HasSuffix(network, "4") && !isV4) || (strings.HasSuffix(network, "6") && isV4) {
+			continue
+		}
+		if _, err := d.localFor(remote); err != nil {
+			continue
+		}
+		usable = append(usable, remote)
+	}
+
+	switch {
+	case len(usable) == 0 && d.IsBound():
+		return nil, fmt.Errorf("no bound address can reach %s", host)
+	case len(usable) == 0:
+		return nil, fmt.Errorf("no %s address for %s", network, host)
+	case len(usable) == 1 || strings.HasPrefix(network, "udp"):
+		return d.dialFrom(ctx, network, usable[0], port)
+	}
+	return d.dialHappyEyeballs(ctx, network, interleaveFamilies(usable), port)
+}
+
+// dialFrom dials remote:port from the bound address of remote's family
+func (d *Dialer) dialFrom(ctx context.Context, network string, remote net.IP, port string) (net.Conn, error) {
+	local, err := d.localFor(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	nd := net.Dialer{KeepAlive: tcpKeepAlive}
+	if local != nil {
+		if strings.HasPrefix(network, "udp") {
+			nd.LocalAddr = &net.UDPAddr{IP: local}
+		} else {
+			nd.LocalAddr = &net.TCPAddr{IP: local}
+		}
+	}
+	return nd.DialContext(ctx, network, net.JoinHostPort(remote.String(), port))
+}
+
+// ListenAddrs returns the addresses peer listeners should bind on port:
+// one per bound address, or both the IPv4 and IPv6 wildcard when unbound,
+// so a session accepts peers over either family
+func (d *Dialer) ListenAddrs(port int) []string {
+	p := strconv.Itoa(port)
+	if !d.IsBound() {
+		return []string{net.JoinHostPort("0.0.0.0", p), net.JoinHostPort("::", p)}
+	}
+
+	addrs := make([]string, 0, len(d.local))
+	for _, ip := range d.local {
+		addrs = append(addrs, net.JoinHostPort(ip.String(), p))
+	}
+	return addrs
+}
+
+// Connect dials a peer from the bound address and performs the handshake,
+// all within the connect timeout
+func (d *Dialer) Connect(ctx context.Context, address string, infoHash, peerID [20]byte) (*Peer, error) {
+	timeouts := d.Timeouts()
+	ctx, cancel := context.WithTimeout(ctx, timeouts.Connect)
+	defer cancel()
+
+	conn, err := d.DialContext(ctx, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to peer %s: %w", address, err)
+	}
+
+	// Perform handshake, within whatever is left of the connect budget
+	deadline := time.Now().Add(timeouts.Handshake)
+	if budget, ok := ctx.Deadline(); ok && budget.Before(deadline) {
+		deadline = budget
+	}
+	handshake, err := performHandshake(conn, infoHash, peerID, deadline)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("handshake failed with peer %s: %w", address, err)
+	}
+
+	// Drop connections to ourselves
+	if handshake.PeerID == peerID {
+		conn.Close()
+		return nil, fmt.Errorf("peer %s: %w", address, ErrSelfConnection)
+	}
+
+	// Create peer instance
+	peer := NewPeer(conn, infoHash)
+	peer.ID = handshake.PeerID
+	peer.Extended = handshake.SupportsExtended()
+	peer.FastExtension = handshake.SupportsFast()
+
+	return peer, nil
+}
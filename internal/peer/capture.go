@@ -0,0 +1,222 @@
+package peer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"bittorrentclient/internal/clock"
+)
+
+// CaptureMagic starts every capture file
+const CaptureMagic = "BTCAPTURE1\n"
+
+// Capture record kinds
+const (
+	CaptureSent     = '>' // Bytes we wrote to the peer
+	CaptureReceived = '<' // Bytes we read from the peer
+	CaptureNote     = '#' // Annotation, e.g. the handshake that preceded the stream
+)
+
+// CaptureRecord is one chunk of traffic, or a note, in a capture file.
+// On disk it is the kind byte, the time in Unix nanoseconds and the data
+// length (both big endian, 8 and 4 bytes), then the data.
+type CaptureRecord struct {
+	Time time.Time
+	Kind byte
+	Data []byte
+}
+
+// Capture writes the raw peer wire traffic of a connection to a file,
+// chunk by chunk as it crosses the socket, so protocol problems can be
+// replayed against the parser offline (see ReplayMessages)
+type Capture struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	f   io.WriteCloser
+	err error // First write error; the capture stops there
+
+	clock clock.Clock
+}
+
+// NewCapture starts a capture written to f, which Close closes
+func NewCapture(f io.WriteCloser) (*Capture, error) {
+	c := &Capture{w: bufio.NewWriter(f), f: f, clock: clock.Real}
+	if _, err := c.w.WriteString(CaptureMagic); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// CreateCapture starts a capture in a new file at path
+func CreateCapture(path string) (*Capture, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewCapture(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetClock replaces the clock records are timestamped with
+func (c *Capture) SetClock(clk clock.Clock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clock = clk
+}
+
+// Note records an annotation
+func (c *Capture) Note(format string, args ...interface{}) {
+	c.write(CaptureNote, []byte(fmt.Sprintf(format, args...)))
+}
+
+// write appends a record. Safe to call on a nil Capture, which records
+// nothing.
+func (c *Capture) write(kind byte, data []byte) {
+	if c == nil || len(data) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+
+	var header [13]byte
+	header[0] = kind
+	binary.BigEndian.PutUint64(header[1:9], uint64(c.clock.Now().UnixNano()))
+	binary.BigEndian.PutUint32(header[9:13], uint32(len(data)))
+	if _, err := c.w.Write(header[:]); err != nil {
+		c.err = err
+		return
+	}
+	_, c.err = c.w.Write(data)
+}
+
+// Close flushes the capture and closes its file
+func (c *Capture) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.w.Flush()
+	if closeErr := c.f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = c.err
+	}
+	c.err = errors.New("capture closed")
+	return err
+}
+
+// ReadCapture reads every record of a capture file
+func ReadCapture(r io.Reader) ([]CaptureRecord, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(CaptureMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != CaptureMagic {
+		return nil, fmt.Errorf("not a peer capture file")
+	}
+
+	var records []CaptureRecord
+	var header [13]byte
+	for {
+		if _, err := io.ReadFull(br, header[:]); err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return records, fmt.Errorf("truncated capture record header: %w", err)
+		}
+		length := binary.BigEndian.Uint32(header[9:13])
+		if length > MaxMessageLength+4 {
+			return records, fmt.Errorf("capture record of %d bytes is too long", length)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return records, fmt.Errorf("truncated capture record: %w", err)
+		}
+		records = append(records, CaptureRecord{
+			Time: time.Unix(0, int64(binary.BigEndian.Uint64(header[1:9]))),
+			Kind: header[0],
+			Data: data,
+		})
+	}
+}
+
+// CaptureStream joins the data of the records of one kind, e.g.
+// CaptureReceived, back into the byte stream that crossed the socket
+func CaptureStream(records []CaptureRecord, kind byte) []byte {
+	var stream bytes.Buffer
+	for _, record := range records {
+		if record.Kind == kind {
+			stream.Write(record.Data)
+		}
+	}
+	return stream.Bytes()
+}
+
+// ReplayMessages parses the stream of one kind from a capture the way a
+// connection would have, returning the messages (nil for keep-alives) up
+// to the first one that fails to parse, and that error
+func ReplayMessages(records []CaptureRecord, kind byte) ([]*Message, error) {
+	stream := bytes.NewReader(CaptureStream(records, kind))
+	var messages []*Message
+	for stream.Len() > 0 {
+		msg, err := DeserializeMessage(stream)
+		if err != nil {
+			return messages, fmt.Errorf("message %d: %w", len(messages), err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// StartCapture copies the connection's traffic into capture from now on,
+// after noting the handshake that opened it. The connection owns the
+// capture from here and closes it on Stop or StopCapture. Started after
+// Start, the capture may begin in the middle of a message.
+func (c *Connection) StartCapture(capture *Capture) {
+	wire, ok := c.Conn.(*throttledConn)
+	if !ok {
+		capture.Close()
+		return
+	}
+
+	var local, remote string
+	if addr := c.Conn.LocalAddr(); addr != nil {
+		local = addr.String()
+	}
+	if addr := c.Conn.RemoteAddr(); addr != nil {
+		remote = addr.String()
+	}
+	capture.Note("handshake local=%s remote=%s info_hash=%x peer_id=%x extended=%v fast=%v",
+		local, remote, c.InfoHash, c.ID, c.Extended, c.FastExtension)
+	if c.IsConnected() {
+		capture.Note("capture started mid-session; the stream may begin inside a message")
+	}
+
+	if old := wire.capture.Swap(capture); old != nil {
+		old.Close()
+	}
+}
+
+// StopCapture stops copying the connection's traffic and closes the
+// capture, if any
+func (c *Connection) StopCapture() error {
+	wire, ok := c.Conn.(*throttledConn)
+	if !ok {
+		return nil
+	}
+	if capture := wire.capture.Swap(nil); capture != nil {
+		return capture.Close()
+	}
+	return nil
+}
@@ -2,46 +2,42 @@ package peer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"sync"
 	"time"
+
+	"bittorrentclient/internal/clock"
 )
 
+// ErrSelfConnection is returned when the remote peer ID equals our own,
+// i.e. the tracker handed us our own address
+var ErrSelfConnection = errors.New("connected to ourselves")
+
 // ConnectToPeer establishes a connection to a peer and performs handshake
 func ConnectToPeer(ctx context.Context, address string, infoHash, peerID [20]byte) (*Peer, error) {
-	// Use context-aware dialer
-	var d net.Dialer
-	conn, err := d.DialContext(ctx, "tcp", address)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to peer %s: %w", address, err)
-	}
-
-	// Perform handshake
-	handshake, err := PerformHandshake(conn, infoHash, peerID)
-	if err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("handshake failed with peer %s: %w", address, err)
-	}
-
-	// Create peer instance
-	peer := NewPeer(conn, infoHash)
-	peer.ID = handshake.PeerID
-
-	return peer, nil
+	return DefaultDialer.Connect(ctx, address, infoHash, peerID)
 }
 
 // SendMessage sends a message to the peer
 func (p *Peer) SendMessage(msg *Message) error {
 	data := msg.Serialize()
 	_, err := p.Conn.Write(data)
+	if err == nil {
+		p.trace.Load().Record(p, TraceSent, msg)
+	}
 	return err
 }
 
 // ReadMessage reads a message from the peer
 func (p *Peer) ReadMessage() (*Message, error) {
-	return DeserializeMessage(p.Conn)
+	msg, err := DeserializeMessage(p.Conn)
+	if err == nil {
+		p.trace.Load().Record(p, TraceReceived, msg)
+	}
+	return msg, err
 }
 
 // SendKeepAlive sends a keep-alive message
@@ -91,6 +87,21 @@ func (p *Peer) SendRequest(index, begin, length uint32) error {
 	return p.SendMessage(NewRequestMessage(index, begin, length))
 }
 
+// MaxUnrequestedBlocks is how many unsolicited piece messages we tolerate
+// from a peer before dropping the connection
+const MaxUnrequestedBlocks = 10
+
+// MaxUploadQueue is how many block requests we queue per peer (our reqq)
+// before rejecting further ones
+const MaxUploadQueue = 250
+
+const (
+	// PieceQueueSize is the buffer between the message loop and the consumer
+	PieceQueueSize = 100
+	// PieceQueueTimeout is how long a full piece queue may block the reader
+	PieceQueueTimeout = 30 * time.Second
+)
+
 // Connection represents a connection to a peer with download capabilities
 type Connection struct {
 	*Peer
@@ -101,6 +112,46 @@ type Connection struct {
 	connected    bool         // Track connection state
 	stopOnce     sync.Once    // Ensure Stop() is only called once
 	stopped      bool         // Track if connection is stopped
+	handleErr    error        // Why a peer message was refused, stopping the connection
+
+	outstanding       map[blockKey]int64 // Requests sent to the peer, value is the block length
+	cancelled         map[blockKey]bool  // Requests we withdrew whose block may still arrive
+	unrequestedBlocks int                // Piece messages received that we never asked for
+	unrequestedBytes  int64              // Bytes in those unrequested piece messages
+	reassertInterest  bool               // Resend Interested after the peer choked us
+
+	allowedFast     map[uint32]bool // Pieces the peer may request while we choke it
+	peerAllowedFast map[uint32]bool // Pieces we may request while the peer chokes us
+	suggested       []uint32        // Pieces the peer suggested we download, oldest first
+	rejected        []RequestItem   // Our requests the peer rejected, not yet taken by the downloader
+	haves           []byte          // Our bitfield, announced by Start
+	peerReqq        int             // Requests the peer queues (its extended handshake reqq); 0 if unknown
+	peerMessages    map[string]int  // Extension name -> the peer's ID for it, from its extended handshake
+
+	metadata        []byte     // Raw info dictionary we serve over ut_metadata; nil serves none
+	metadataReplies []*Message // ut_metadata replies to send once c.mu is released
+	metadataServed  int        // Metadata pieces sent to the peer
+
+	availability AvailabilityTracker // Told which pieces the peer has; nil tells no one
+
+	uploaded      int64             // Block bytes served to this peer
+	onUpload      func(bytes int64) // Optional hook called after each block is served
+	uploadLimiter Limiter           // Optional cap on the rate we serve blocks at
+	uploadQueue   []RequestItem     // Blocks the peer asked us for, oldest first
+	rejects       []RequestItem     // Rejects to send once c.mu is released
+	serving       *RequestItem      // Request taken by NextUploadRequest and not yet sent
+	servingCancel bool              // The peer cancelled the request being served
+
+	received      rollingRate // Requested block bytes received, for the choker
+	receivedTotal int64       // Lifetime requested block bytes received
+	sent          rollingRate // Block bytes served, for the choker while seeding
+	clock         clock.Clock
+}
+
+// blockKey identifies a requested block within the torrent
+type blockKey struct {
+	PieceIndex int64
+	Begin      int64
 }
 
 // RequestItem represents a piece request
@@ -120,14 +171,17 @@ type PieceData struct {
 // NewConnection creates a new peer connection
 func NewConnection(conn net.Conn, infoHash [20]byte) *Connection {
 	return &Connection{
-		Peer:         NewPeer(conn, infoHash),
+		Peer:         NewPeer(throttle(conn), infoHash),
 		requestQueue: make(chan *RequestItem, 100),
-		pieceQueue:   make(chan *PieceData, 100),
+		pieceQueue:   make(chan *PieceData, PieceQueueSize),
 		done:         make(chan struct{}),
-		connected:    true,
+		outstanding:  make(map[blockKey]int64),
+		clock:        clock.Real,
 	}
 }
 
+// IsConnected returns true once Start has run and until the connection
+// is stopped or the peer disconnects
 func (c *Connection) IsConnected() bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -141,20 +195,335 @@ func (c *Connection) Stop() {
 		c.mu.Lock()
 		c.stopped = true
 		c.connected = false
+		if c.availability != nil && c.Bitfield != nil {
+			c.availability.RemoveBitfield(c.Bitfield)
+		}
+		c.availability = nil
 		c.mu.Unlock()
 
 		close(c.done)
 
-		// CRITICAL FIX: Close the pieceQueue channel to signal downstream listeners
-		// that no more data will be sent.
-		close(c.pieceQueue)
-
 		if c.Conn != nil {
 			c.Conn.Close()
 		}
+		c.StopCapture()
 	})
 }
 
+// Choke chokes the peer (we stop serving its requests). Queued requests are
+// dropped, except for allowed-fast pieces; with the fast extension the peer
+// is told about each one with a reject.
+func (c *Connection) Choke() error {
+	c.mu.Lock()
+	c.Choking = true
+	kept := c.uploadQueue[:0]
+	for _, req := range c.uploadQueue {
+		if c.allowedFast[uint32(req.PieceIndex)] {
+			kept = append(kept, req)
+		} else {
+			c.queueReject(req)
+		}
+	}
+	c.uploadQueue = kept
+	c.mu.Unlock()
+
+	if err := c.SendMessage(NewChokeMessage()); err != nil {
+		return err
+	}
+	return c.sendRejects()
+}
+
+// Unchoke unchokes the peer (we allow it to request blocks from us)
+func (c *Connection) Unchoke() error {
+	c.mu.Lock()
+	c.Choking = false
+	c.mu.Unlock()
+	return c.SendMessage(NewUnchokeMessage())
+}
+
+// SetInterested tells the peer whether we want pieces from it. The message is
+// only sent when our interest changes, or when the peer choked us since we
+// last said we were interested, so calling this every tick is cheap.
+func (c *Connection) SetInterested(interested bool) error {
+	c.mu.Lock()
+	if c.Interesting == interested && !c.reassertInterest {
+		c.mu.Unlock()
+		return nil
+	}
+	c.Interesting = interested
+	c.reassertInterest = false
+	c.mu.Unlock()
+
+	if interested {
+		return c.SendMessage(NewInterestedMessage())
+	}
+	return c.SendMessage(NewNotInterestedMessage())
+}
+
+// IsInteresting returns true if we have told the peer we are interested
+func (c *Connection) IsInteresting() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Interesting
+}
+
+// IsInterested returns true if the peer has told us it is interested
+func (c *Connection) IsInterested() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Interested
+}
+
+// IsChoking returns true if we are currently choking the peer
+func (c *Connection) IsChoking() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Choking
+}
+
+// IsAllowedFast returns true if the peer may request the piece while choked
+func (c *Connection) IsAllowedFast(index uint32) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.allowedFast[index]
+}
+
+// sendAllowedFast computes the peer's allowed-fast set and advertises it.
+// It does nothing unless the fast extension was negotiated.
+func (c *Connection) sendAllowedFast() {
+	if !c.FastExtension || c.Conn == nil || c.NumPieces <= 0 {
+		return
+	}
+
+	tcpAddr, ok := c.Conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return
+	}
+
+	set := AllowedFastSet(tcpAddr.IP, c.InfoHash, c.NumPieces, AllowedFastSetSize)
+
+	c.mu.Lock()
+	c.allowedFast = make(map[uint32]bool, len(set))
+	for _, index := range set {
+		c.allowedFast[index] = true
+	}
+	c.mu.Unlock()
+
+	for _, index := range set {
+		if err := c.SendMessage(NewAllowedFastMessage(index)); err != nil {
+			fmt.Printf("Failed to send allowed fast to peer %x: %v\n", c.ID[:8], err)
+			return
+		}
+	}
+}
+
+// SetHaves sets the pieces Start announces we have, as a wire-format
+// bitfield. Call it before Start.
+func (c *Connection) SetHaves(bitfield []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.haves = bitfield
+}
+
+// sendHaves announces our pieces. With the fast extension the peer expects
+// exactly one of have all, have none or a bitfield; without it an empty
+// bitfield may be left out.
+func (c *Connection) sendHaves() {
+	if c.Conn == nil {
+		return
+	}
+
+	c.mu.RLock()
+	haves := c.haves
+	c.mu.RUnlock()
+	count := bitfieldCount(haves, c.NumPieces)
+
+	var msg *Message
+	switch {
+	case c.FastExtension && count > 0 && count == c.NumPieces:
+		msg = NewHaveAllMessage()
+	case c.FastExtension && count == 0:
+		msg = NewHaveNoneMessage()
+	case count > 0:
+		msg = NewBitfieldMessage(haves)
+	default:
+		return
+	}
+	if err := c.SendMessage(msg); err != nil {
+		fmt.Printf("Failed to send our pieces to peer %x: %v\n", c.ID[:8], err)
+	}
+}
+
+// bitfieldCount returns how many of the first numPieces bits are set
+func bitfieldCount(bitfield []byte, numPieces int) int {
+	count := 0
+	for i := 0; i < numPieces && i/8 < len(bitfield); i++ {
+		if bitfield[i/8]&(1<<(7-i%8)) != 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// IsPeerAllowedFast returns true if the peer lets us request the piece
+// while it chokes us
+func (c *Connection) IsPeerAllowedFast(index uint32) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.peerAllowedFast[index]
+}
+
+// GetAllowedFastBitfield returns the peer's bitfield masked to the pieces
+// it allows us to request while choked, or nil if there are none
+func (c *Connection) GetAllowedFastBitfield() []byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.Bitfield == nil || len(c.peerAllowedFast) == 0 {
+		return nil
+	}
+	var bitfield []byte
+	for index := range c.peerAllowedFast {
+		if !c.HasPiece(int(index)) {
+			continue
+		}
+		if bitfield == nil {
+			bitfield = make([]byte, len(c.Bitfield))
+		}
+		bitfield[index/8] |= 1 << (7 - index%8)
+	}
+	return bitfield
+}
+
+// GetSuggestedPieces returns the pieces the peer suggested, oldest first
+func (c *Connection) GetSuggestedPieces() []uint32 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	suggested := make([]uint32, len(c.suggested))
+	copy(suggested, c.suggested)
+	return suggested
+}
+
+// TakeRejectedRequests returns the requests the peer rejected since the
+// last call, so the caller can free them for other peers
+func (c *Connection) TakeRejectedRequests() []RequestItem {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rejected := c.rejected
+	c.rejected = nil
+	return rejected
+}
+
+// sendExtendedHandshake advertises our reqq. It does nothing unless both
+// sides support the extension protocol.
+func (c *Connection) sendExtendedHandshake() {
+	if !c.Extended || c.Conn == nil {
+		return
+	}
+
+	c.mu.RLock()
+	metadataSize := len(c.metadata)
+	c.mu.RUnlock()
+
+	msg, err := NewExtendedHandshakeMessage(MaxUploadQueue, metadataSize)
+	if err == nil {
+		err = c.SendMessage(msg)
+	}
+	if err != nil {
+		fmt.Printf("Failed to send extended handshake to peer %x: %v\n", c.ID[:8], err)
+	}
+}
+
+// SetMetadata makes the connection serve the torrent's raw info dictionary
+// to the peer over ut_metadata (BEP 9), so magnet users can bootstrap from
+// us. Call it before Start, which advertises it.
+func (c *Connection) SetMetadata(metadata []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.metadata = metadata
+}
+
+// GetMetadataServed returns how many metadata pieces we sent the peer
+func (c *Connection) GetMetadataServed() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.metadataServed
+}
+
+// handleMetadataMessage answers a ut_metadata request with the piece, or
+// a reject if we have no metadata or the piece is out of range. Caller must
+// hold c.mu.
+func (c *Connection) handleMetadataMessage(payload []byte) error {
+	msg, err := ParseMetadataMessage(payload)
+	if err != nil {
+		return err
+	}
+	if msg.Type != metadataRequest {
+		return nil // We never request metadata, so ignore data and rejects
+	}
+
+	peerExtID, ok := c.peerMessages["ut_metadata"]
+	if !ok {
+		return nil // No way to reply
+	}
+
+	data := metadataPiece(c.metadata, msg.Piece)
+	var reply *Message
+	if data == nil {
+		reply, err = NewMetadataMessage(byte(peerExtID), metadataReject, msg.Piece, 0, nil)
+	} else {
+		reply, err = NewMetadataMessage(byte(peerExtID), metadataData, msg.Piece, len(c.metadata), data)
+		c.metadataServed++
+	}
+	if err != nil {
+		return err
+	}
+	c.metadataReplies = append(c.metadataReplies, reply)
+	return nil
+}
+
+// sendMetadataReplies sends the ut_metadata replies queued while c.mu was
+// held
+func (c *Connection) sendMetadataReplies() error {
+	c.mu.Lock()
+	replies := c.metadataReplies
+	c.metadataReplies = nil
+	c.mu.Unlock()
+
+	for _, reply := range replies {
+		if err := c.SendMessage(reply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetPeerReqq returns how many requests the peer said it queues before
+// dropping more, or 0 if it didn't say; our pipeline to it shouldn't exceed
+// it
+func (c *Connection) GetPeerReqq() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.peerReqq
+}
+
+// IsChoked returns true if the peer is currently choking us
+func (c *Connection) IsChoked() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Choked
+}
+
+// HandleErr returns why the connection refused a message from the peer
+// and stopped, or nil if it never did
+func (c *Connection) HandleErr() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.handleErr
+}
+
 // IsStopped returns true if the connection has been stopped
 func (c *Connection) IsStopped() bool {
 	c.mu.RLock()
@@ -162,16 +531,22 @@ func (c *Connection) IsStopped() bool {
 	return c.stopped
 }
 
-// RequestPiece queues a piece request
+// RequestPiece queues a piece request. While the peer chokes us only its
+// allowed-fast pieces may be requested.
 func (c *Connection) RequestPiece(pieceIndex, begin int64, length int64) error {
 	if c.IsStopped() {
 		return fmt.Errorf("connection stopped")
 	}
 
-	if c.Choked {
+	if c.IsChoked() && !c.IsPeerAllowedFast(uint32(pieceIndex)) {
 		return fmt.Errorf("peer is choking us")
 	}
 
+	// Asking again supersedes an earlier cancel of the block
+	c.mu.Lock()
+	delete(c.cancelled, blockKey{PieceIndex: pieceIndex, Begin: begin})
+	c.mu.Unlock()
+
 	select {
 	case c.requestQueue <- &RequestItem{
 		PieceIndex: pieceIndex,
@@ -186,6 +561,218 @@ func (c *Connection) RequestPiece(pieceIndex, begin int64, length int64) error {
 	}
 }
 
+// Limiter throttles a transfer, blocking until n bytes may be sent
+type Limiter interface {
+	Wait(n int64)
+}
+
+// SendPiece serves a block to the peer and accounts for the uploaded bytes.
+// If the peer cancels the block while it is being read or throttled, it is
+// dropped instead of sent.
+func (c *Connection) SendPiece(index, begin uint32, data []byte) error {
+	if c.IsStopped() {
+		return fmt.Errorf("connection stopped")
+	}
+	defer c.finishServing(index, begin)
+
+	if c.IsUploadCancelled(index, begin) {
+		return nil
+	}
+
+	c.mu.RLock()
+	limiter := c.uploadLimiter
+	c.mu.RUnlock()
+	if limiter != nil {
+		limiter.Wait(int64(len(data)))
+		if c.IsUploadCancelled(index, begin) {
+			return nil
+		}
+	}
+
+	if err := c.SendMessage(NewPieceMessage(index, begin, data)); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.uploaded += int64(len(data))
+	c.sent.add(c.clock.Now(), int64(len(data)))
+	onUpload := c.onUpload
+	c.mu.Unlock()
+
+	if onUpload != nil {
+		onUpload(int64(len(data)))
+	}
+	return nil
+}
+
+// NextUploadRequest pops the oldest block request the peer has queued with us
+func (c *Connection) NextUploadRequest() (RequestItem, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.uploadQueue) == 0 {
+		return RequestItem{}, false
+	}
+	req := c.uploadQueue[0]
+	c.uploadQueue = c.uploadQueue[1:]
+	c.serving = &req
+	c.servingCancel = false
+	return req, true
+}
+
+// IsUploadCancelled returns true if the peer cancelled the block taken by
+// NextUploadRequest, so the caller can skip reading or sending it
+func (c *Connection) IsUploadCancelled(index, begin uint32) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.isServing(int64(index), int64(begin)) && c.servingCancel
+}
+
+// isServing returns true if the block is the one currently being served.
+// Caller must hold c.mu.
+func (c *Connection) isServing(index, begin int64) bool {
+	return c.serving != nil && c.serving.PieceIndex == index && c.serving.Begin == begin
+}
+
+// finishServing forgets the in-flight request once it was sent or dropped
+func (c *Connection) finishServing(index, begin uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.isServing(int64(index), int64(begin)) {
+		c.serving = nil
+		c.servingCancel = false
+	}
+}
+
+// GetUploadQueueLen returns how many block requests the peer has queued
+func (c *Connection) GetUploadQueueLen() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.uploadQueue)
+}
+
+// RejectRequest tells the peer we won't serve a block. Without the fast
+// extension there is no reject message and the request is just dropped.
+func (c *Connection) RejectRequest(req RequestItem) error {
+	c.mu.Lock()
+	if !c.isServing(req.PieceIndex, req.Begin) || !c.servingCancel {
+		c.queueReject(req) // A cancelled request was already rejected
+	}
+	c.mu.Unlock()
+	c.finishServing(uint32(req.PieceIndex), uint32(req.Begin))
+	return c.sendRejects()
+}
+
+// queueReject records a reject to send after the lock is released.
+// Caller must hold c.mu.
+func (c *Connection) queueReject(req RequestItem) {
+	if c.FastExtension {
+		c.rejects = append(c.rejects, req)
+	}
+}
+
+// sendRejects sends the rejects queued while c.mu was held
+func (c *Connection) sendRejects() error {
+	c.mu.Lock()
+	rejects := c.rejects
+	c.rejects = nil
+	c.mu.Unlock()
+
+	for _, req := range rejects {
+		msg := NewRejectRequestMessage(uint32(req.PieceIndex), uint32(req.Begin), uint32(req.Length))
+		if err := c.SendMessage(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDownloadRate returns the bytes per second of requested blocks received
+// from the peer over the last RateWindow. This, rather than the lifetime
+// total, is what reciprocation is judged on.
+func (c *Connection) GetDownloadRate() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.received.rate(c.clock.Now())
+}
+
+// GetUploadRate returns the bytes per second of blocks served to the peer
+// over the last RateWindow
+func (c *Connection) GetUploadRate() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sent.rate(c.clock.Now())
+}
+
+// GetDownloaded returns the bytes of requested blocks received from the peer
+func (c *Connection) GetDownloaded() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.receivedTotal
+}
+
+// SetClock replaces the clock used for rate accounting, keep-alives and
+// the piece queue timeout. Set it before Start.
+func (c *Connection) SetClock(clk clock.Clock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clock = clk
+}
+
+func (c *Connection) getClock() clock.Clock {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.clock
+}
+
+// SetUploadLimiter caps the rate SendPiece serves blocks at
+func (c *Connection) SetUploadLimiter(limiter Limiter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.uploadLimiter = limiter
+}
+
+// AvailabilityTracker counts the copies of each piece among peers, from the
+// pieces each one says it has (see piece.Manager)
+type AvailabilityTracker interface {
+	AddBitfield(bitfield []byte)    // A peer has the pieces set in bitfield
+	RemoveBitfield(bitfield []byte) // A peer that had them went away or replaced its bitfield
+	AddPiece(index int)             // A peer announced one more piece
+}
+
+// SetAvailabilityTracker tells tracker which pieces the peer has: those it
+// announced already, then each change until the connection stops, when it
+// takes them back
+func (c *Connection) SetAvailabilityTracker(tracker AvailabilityTracker) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stopped {
+		return
+	}
+	if c.availability != nil && c.Bitfield != nil {
+		c.availability.RemoveBitfield(c.Bitfield)
+	}
+	c.availability = tracker
+	if tracker != nil && c.Bitfield != nil {
+		tracker.AddBitfield(c.Bitfield)
+	}
+}
+
+// SetOnUpload sets a hook called with the size of each block served
+func (c *Connection) SetOnUpload(fn func(bytes int64)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onUpload = fn
+}
+
+// GetUploaded returns the number of block bytes served to this peer
+func (c *Connection) GetUploaded() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.uploaded
+}
+
 // GetPieceData returns a channel for receiving piece data
 func (c *Connection) GetPieceData() <-chan *PieceData {
 	return c.pieceQueue
@@ -198,6 +785,29 @@ func (c *Connection) handleMessage(msg *Message) error {
 		return nil
 	}
 
+	delivery, err := c.processMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	if err := c.sendRejects(); err != nil {
+		return err
+	}
+	if err := c.sendMetadataReplies(); err != nil {
+		return err
+	}
+
+	// Piece data is delivered outside the lock, since it may block until
+	// the consumer catches up
+	if delivery != nil {
+		return c.deliverPiece(delivery)
+	}
+	return nil
+}
+
+// processMessage updates connection state for a message. Piece data that
+// should be handed to the consumer is returned rather than queued here.
+func (c *Connection) processMessage(msg *Message) (*PieceData, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -205,8 +815,17 @@ func (c *Connection) handleMessage(msg *Message) error {
 	case MsgChoke:
 		c.Choked = true
 		fmt.Printf("Peer %x choked us\n", c.ID[:8])
-		// Clear any pending requests since we're now choked
+		// Clear any pending requests since we're now choked. Without the fast
+		// extension the peer discards everything we asked for, so nothing is
+		// outstanding anymore; with it, each request is still answered with
+		// the block or a reject.
 		c.clearPendingRequests()
+		if !c.FastExtension {
+			c.outstanding = make(map[blockKey]int64)
+			c.cancelled = nil
+		}
+		// Some peers forget our interest across a choke, so say it again
+		c.reassertInterest = c.Interesting
 
 	case MsgUnchoke:
 		c.Choked = false
@@ -223,134 +842,239 @@ func (c *Connection) handleMessage(msg *Message) error {
 
 	case MsgHave:
 		if len(msg.Payload) != 4 {
-			return fmt.Errorf("invalid have message payload length: %d", len(msg.Payload))
+			return nil, fmt.Errorf("invalid have message payload length: %d", len(msg.Payload))
 		}
 
 		pieceIndex, err := ParseHaveMessage(msg.Payload)
 		if err != nil {
-			return fmt.Errorf("invalid have message: %w", err)
+			return nil, fmt.Errorf("invalid have message: %w", err)
 		}
 
 		// Validate piece index
-		if pieceIndex < 0 {
-			return fmt.Errorf("invalid piece index: %d", pieceIndex)
+		if c.NumPieces > 0 && int(pieceIndex) >= c.NumPieces {
+			return nil, fmt.Errorf("invalid piece index: %d", pieceIndex)
 		}
 
+		had := c.HasPiece(int(pieceIndex))
 		c.SetPiece(int(pieceIndex))
+		if c.availability != nil && !had && c.HasPiece(int(pieceIndex)) {
+			c.availability.AddPiece(int(pieceIndex))
+		}
 		fmt.Printf("Peer %x has piece %d\n", c.ID[:8], pieceIndex)
 
 	case MsgBitfield:
 		// Validate bitfield length
 		if len(msg.Payload) == 0 {
-			return fmt.Errorf("empty bitfield message")
+			return nil, fmt.Errorf("empty bitfield message")
+		}
+		if c.NumPieces > 0 && len(msg.Payload) != (c.NumPieces+7)/8 {
+			return nil, fmt.Errorf("bitfield of %d bytes for %d pieces", len(msg.Payload), c.NumPieces)
 		}
 
 		// Initialize or update bitfield
+		if c.availability != nil && c.Bitfield != nil {
+			c.availability.RemoveBitfield(c.Bitfield)
+		}
 		c.Bitfield = make([]byte, len(msg.Payload))
 		copy(c.Bitfield, msg.Payload)
+		if c.availability != nil {
+			c.availability.AddBitfield(c.Bitfield)
+		}
 		fmt.Printf("Peer %x sent bitfield of length %d\n", c.ID[:8], len(msg.Payload))
 
+	case MsgHaveAll, MsgHaveNone:
+		if !c.FastExtension {
+			return nil, fmt.Errorf("message %d without the fast extension", msg.ID)
+		}
+		if c.NumPieces <= 0 {
+			return nil, nil // Nothing to size the bitfield with
+		}
+		if c.availability != nil && c.Bitfield != nil {
+			c.availability.RemoveBitfield(c.Bitfield)
+		}
+		c.Bitfield = make([]byte, (c.NumPieces+7)/8)
+		if msg.ID == MsgHaveAll {
+			for i := 0; i < c.NumPieces; i++ {
+				c.SetPiece(i)
+			}
+			fmt.Printf("Peer %x has all pieces\n", c.ID[:8])
+		} else {
+			fmt.Printf("Peer %x has no pieces\n", c.ID[:8])
+		}
+		if c.availability != nil {
+			c.availability.AddBitfield(c.Bitfield)
+		}
+
+	case MsgSuggestPiece, MsgAllowedFast:
+		if !c.FastExtension {
+			return nil, fmt.Errorf("message %d without the fast extension", msg.ID)
+		}
+		index, err := ParseHaveMessage(msg.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid message %d: %w", msg.ID, err)
+		}
+		if c.NumPieces > 0 && int(index) >= c.NumPieces {
+			return nil, fmt.Errorf("invalid piece index: %d", index)
+		}
+
+		if msg.ID == MsgAllowedFast {
+			if c.peerAllowedFast == nil {
+				c.peerAllowedFast = make(map[uint32]bool)
+			}
+			c.peerAllowedFast[index] = true
+			return nil, nil
+		}
+		for _, suggested := range c.suggested {
+			if suggested == index {
+				return nil, nil
+			}
+		}
+		if len(c.suggested) >= AllowedFastSetSize {
+			c.suggested = c.suggested[1:]
+		}
+		c.suggested = append(c.suggested, index)
+
+	case MsgRejectRequest:
+		if !c.FastExtension {
+			return nil, fmt.Errorf("reject request without the fast extension")
+		}
+		index, begin, length, err := ParseRequestMessage(msg.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reject request message: %w", err)
+		}
+
+		// Only rejects of requests still outstanding need freeing; others
+		// answer requests that timed out or were never sent
+		key := blockKey{PieceIndex: int64(index), Begin: int64(begin)}
+		if _, requested := c.outstanding[key]; requested {
+			delete(c.outstanding, key)
+			c.rejected = append(c.rejected, RequestItem{PieceIndex: int64(index), Begin: int64(begin), Length: int64(length)})
+		}
+		delete(c.cancelled, key)
+
 	case MsgPiece:
 		// Validate minimum payload length (4 bytes index + 4 bytes begin + at least 1 byte data)
 		if len(msg.Payload) < 9 {
-			return fmt.Errorf("invalid piece message payload length: %d", len(msg.Payload))
+			return nil, fmt.Errorf("invalid piece message payload length: %d", len(msg.Payload))
 		}
 
 		// Handle incoming piece data
 		index, begin, data, err := ParsePieceMessage(msg.Payload)
 		if err != nil {
-			return fmt.Errorf("invalid piece message: %w", err)
+			return nil, fmt.Errorf("invalid piece message: %w", err)
 		}
 
 		// Validate piece data
 		if index < 0 || begin < 0 || len(data) == 0 {
-			return fmt.Errorf("invalid piece data: index=%d, begin=%d, data_len=%d",
+			return nil, fmt.Errorf("invalid piece data: index=%d, begin=%d, data_len=%d",
 				index, begin, len(data))
 		}
 
+		// Discard data that doesn't match a request we sent
+		key := blockKey{PieceIndex: int64(index), Begin: int64(begin)}
+		length, requested := c.outstanding[key]
+		if !requested && c.cancelled[key] {
+			// Sent before our cancel reached the peer; not its fault
+			delete(c.cancelled, key)
+			c.unrequestedBytes += int64(len(data))
+			fmt.Printf("Discarding cancelled block: piece %d, begin %d from peer %x\n",
+				index, begin, c.ID[:8])
+			return nil, nil
+		}
+		if !requested || length != int64(len(data)) {
+			c.unrequestedBlocks++
+			c.unrequestedBytes += int64(len(data))
+			fmt.Printf("Discarding unrequested block: piece %d, begin %d, length %d from peer %x\n",
+				index, begin, len(data), c.ID[:8])
+			if c.unrequestedBlocks > MaxUnrequestedBlocks {
+				return nil, fmt.Errorf("peer sent %d unrequested blocks", c.unrequestedBlocks)
+			}
+			return nil, nil
+		}
+		delete(c.outstanding, key)
+		c.received.add(c.clock.Now(), int64(len(data)))
+		c.receivedTotal += int64(len(data))
+
 		fmt.Printf("Received piece %d, begin %d, length %d from peer %x\n",
 			index, begin, len(data), c.ID[:8])
 
-		// Send piece data to piece queue
-		select {
-		case c.pieceQueue <- &PieceData{
+		// Hand the piece data to the caller for delivery to the piece queue
+		return &PieceData{
 			PieceIndex: int64(index),
 			Begin:      int64(begin),
 			Data:       data,
-		}:
-		case <-c.done:
-			return fmt.Errorf("connection closed")
-		default:
-			// Queue full - this indicates a flow control issue
-			fmt.Printf("Warning: piece queue full, dropping data for piece %d\n", index)
-		}
+		}, nil
 
 	case MsgRequest:
 		// Handle incoming request from peer (they want a piece from us)
 		if len(msg.Payload) != 12 {
-			return fmt.Errorf("invalid request message payload length: %d", len(msg.Payload))
+			return nil, fmt.Errorf("invalid request message payload length: %d", len(msg.Payload))
 		}
 
 		index, begin, length, err := ParseRequestMessage(msg.Payload)
 		if err != nil {
-			return fmt.Errorf("invalid request message: %w", err)
+			return nil, fmt.Errorf("invalid request message: %w", err)
 		}
 
 		// Validate request parameters
 		if index < 0 || begin < 0 || length <= 0 {
-			return fmt.Errorf("invalid request parameters: index=%d, begin=%d, length=%d",
+			return nil, fmt.Errorf("invalid request parameters: index=%d, begin=%d, length=%d",
 				index, begin, length)
 		}
 
-		// Check if we're choking this peer
-		if c.Choking {
+		req := RequestItem{PieceIndex: int64(index), Begin: int64(begin), Length: int64(length)}
+
+		// Check if we're choking this peer; allowed-fast pieces are
+		// served regardless
+		if c.Choking && !c.allowedFast[index] {
 			fmt.Printf("Ignoring request from choked peer %x\n", c.ID[:8])
-			return nil
+			c.queueReject(req)
+			return nil, nil
 		}
 
-		// Check if we have the requested piece
-		if !c.HasPiece(int(index)) {
-			fmt.Printf("Peer %x requested piece %d that we don't have\n", c.ID[:8], index)
-			return nil
+		// Queue the request for the uploader, which checks we have the
+		// piece and serves peers round-robin
+		if len(c.uploadQueue) >= MaxUploadQueue {
+			fmt.Printf("Upload queue full for peer %x, rejecting piece %d\n", c.ID[:8], index)
+			c.queueReject(req)
+			return nil, nil
 		}
-
-		fmt.Printf("Peer %x requested piece %d, begin %d, length %d\n",
-			c.ID[:8], index, begin, length)
-
-		// TODO: Implement piece serving logic
-		// This would involve:
-		// 1. Reading the piece data from disk
-		// 2. Extracting the requested block
-		// 3. Sending a piece message back to the peer
-		// For now, we'll just log it
-		fmt.Printf("TODO: Serve piece %d to peer %x\n", index, c.ID[:8])
+		c.uploadQueue = append(c.uploadQueue, req)
 
 	case MsgCancel:
 		// Handle cancel request
 		if len(msg.Payload) != 12 {
-			return fmt.Errorf("invalid cancel message payload length: %d", len(msg.Payload))
+			return nil, fmt.Errorf("invalid cancel message payload length: %d", len(msg.Payload))
 		}
 
 		index, begin, length, err := ParseCancelMessage(msg.Payload)
 		if err != nil {
-			return fmt.Errorf("invalid cancel message: %w", err)
+			return nil, fmt.Errorf("invalid cancel message: %w", err)
 		}
 
 		fmt.Printf("Peer %x cancelled request for piece %d, begin %d, length %d\n",
 			c.ID[:8], index, begin, length)
 
-		// TODO: Cancel any pending uploads for this request
-		// This would involve:
-		// 1. Finding the matching request in our upload queue
-		// 2. Removing it from the queue
-		// 3. Freeing any associated resources
-		// For now, we'll just log it
-		fmt.Printf("TODO: Cancel upload for piece %d to peer %x\n", index, c.ID[:8])
+		// Drop it from the upload queue, or stop it being sent if the
+		// uploader already took it. The fast extension wants every
+		// cancelled request answered, so confirm with a reject.
+		req := RequestItem{PieceIndex: int64(index), Begin: int64(begin), Length: int64(length)}
+		for i, queued := range c.uploadQueue {
+			if queued == req {
+				c.uploadQueue = append(c.uploadQueue[:i], c.uploadQueue[i+1:]...)
+				c.queueReject(req)
+				return nil, nil
+			}
+		}
+		if c.isServing(req.PieceIndex, req.Begin) && !c.servingCancel {
+			c.servingCancel = true
+			c.queueReject(req)
+		}
 
 	case MsgPort:
 		// Handle port message (for DHT)
 		if len(msg.Payload) != 2 {
-			return fmt.Errorf("invalid port message payload length: %d", len(msg.Payload))
+			return nil, fmt.Errorf("invalid port message payload length: %d", len(msg.Payload))
 		}
 
 		port := ParsePortMessage(msg.Payload)
@@ -358,6 +1082,28 @@ func (c *Connection) handleMessage(msg *Message) error {
 
 		// TODO: Store DHT port information if implementing DHT support
 
+	case MsgExtended:
+		if len(msg.Payload) == 0 {
+			return nil, fmt.Errorf("empty extended message")
+		}
+		switch msg.Payload[0] {
+		case ExtendedHandshakeID:
+		case UTMetadataID:
+			return nil, c.handleMetadataMessage(msg.Payload[1:])
+		default:
+			return nil, nil // Not an extension we advertised
+		}
+
+		handshake, err := ParseExtendedHandshake(msg.Payload[1:])
+		if err != nil {
+			return nil, err
+		}
+		c.peerMessages = handshake.Messages
+		if handshake.Reqq > 0 {
+			c.peerReqq = handshake.Reqq
+			fmt.Printf("Peer %x queues up to %d requests\n", c.ID[:8], handshake.Reqq)
+		}
+
 	// In your message handling switch statement, add:
 	default:
 		fmt.Printf("Unknown message ID %d from peer %s, payload length: %d\n",
@@ -366,7 +1112,21 @@ func (c *Connection) handleMessage(msg *Message) error {
 
 	}
 
-	return nil
+	return nil, nil
+}
+
+// deliverPiece hands received block data to the consumer. When the queue is
+// full it blocks the read side (backpressure) instead of dropping the block,
+// and gives up only if the consumer stays stalled for PieceQueueTimeout.
+func (c *Connection) deliverPiece(data *PieceData) error {
+	select {
+	case c.pieceQueue <- data:
+		return nil
+	case <-c.done:
+		return fmt.Errorf("connection closed")
+	case <-c.getClock().After(PieceQueueTimeout):
+		return fmt.Errorf("piece queue full for %v, consumer stalled", PieceQueueTimeout)
+	}
 }
 
 // clearPendingRequests clears any pending requests when we get choked
@@ -382,8 +1142,81 @@ func (c *Connection) clearPendingRequests() {
 	}
 }
 
+// ForgetRequest stops expecting a block, e.g. after the request timed out.
+// A late arrival for it is then treated as unrequested.
+func (c *Connection) ForgetRequest(pieceIndex, begin int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.outstanding, blockKey{PieceIndex: pieceIndex, Begin: begin})
+}
+
+// CancelRequest withdraws a request, e.g. because its block was handed to
+// another peer, so this peer doesn't spend upload on a block we'd discard.
+// A request not yet sent is dropped; one already sent is cancelled on the
+// wire, and its block arriving anyway is discarded without counting against
+// the peer.
+func (c *Connection) CancelRequest(pieceIndex, begin, length int64) error {
+	key := blockKey{PieceIndex: pieceIndex, Begin: begin}
+
+	c.mu.Lock()
+	_, sent := c.outstanding[key]
+	delete(c.outstanding, key)
+	// Peers that drop cancelled requests silently never clear their entry,
+	// so start over rather than grow without bound
+	if c.cancelled == nil || len(c.cancelled) >= MaxUploadQueue {
+		c.cancelled = make(map[blockKey]bool)
+	}
+	c.cancelled[key] = true
+	c.mu.Unlock()
+
+	if !sent {
+		return nil
+	}
+	return c.SendMessage(NewCancelMessage(uint32(pieceIndex), uint32(begin), uint32(length)))
+}
+
+// GetOutstandingRequests returns the number of requests sent and not yet answered
+func (c *Connection) GetOutstandingRequests() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.outstanding)
+}
+
+// GetUnrequestedBlocks returns how many unsolicited blocks the peer has sent
+func (c *Connection) GetUnrequestedBlocks() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.unrequestedBlocks
+}
+
+// GetUnrequestedBytes returns the bytes of unsolicited blocks we discarded
+func (c *Connection) GetUnrequestedBytes() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.unrequestedBytes
+}
+
+// IsSeed returns true if the peer has advertised every piece
+func (c *Connection) IsSeed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.NumPieces <= 0 || c.Bitfield == nil {
+		return false
+	}
+	for i := 0; i < c.NumPieces; i++ {
+		if !c.HasPiece(i) {
+			return false
+		}
+	}
+	return true
+}
+
 // IsUseful returns true if this peer has pieces we need
 func (c *Connection) IsUseful(completedPieces map[int]bool, totalPieces int) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	if c.Bitfield == nil {
 		return false
 	}
@@ -396,6 +1229,22 @@ func (c *Connection) IsUseful(completedPieces map[int]bool, totalPieces int) boo
 	return false
 }
 
+// CountPieces adds one to counts[i] for each piece i the peer has, for
+// tallying how many copies of each piece connected peers hold
+func (c *Connection) CountPieces(counts []int) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.Bitfield == nil {
+		return
+	}
+	for i := range counts {
+		if c.HasPiece(i) {
+			counts[i]++
+		}
+	}
+}
+
 // Add this helper struct at the top of the file
 type readResult struct {
 	msg *Message
@@ -404,6 +1253,14 @@ type readResult struct {
 
 // Replace the existing Start function with this one
 func (c *Connection) Start() {
+	c.mu.Lock()
+	c.connected = !c.stopped
+	c.mu.Unlock()
+
+	c.sendHaves()
+	c.sendExtendedHandshake()
+	c.sendAllowedFast()
+
 	// We'll use two goroutines: one for reading, one for the main logic.
 	msgChan := make(chan readResult)
 	go c.readLoop(msgChan)
@@ -417,6 +1274,12 @@ func (c *Connection) readLoop(msgChan chan<- readResult) {
 		// This is now a blocking read with no aggressive timeout.
 		// It will wait as long as needed for a full message to arrive.
 		msg, err := c.ReadMessage()
+		if err != nil {
+			// The connection is unusable from here on
+			c.mu.Lock()
+			c.connected = false
+			c.mu.Unlock()
+		}
 
 		// Send the result back to the main loop.
 		select {
@@ -438,9 +1301,12 @@ func (c *Connection) messageLoop(msgChan <-chan readResult) {
 		if !c.IsStopped() {
 			c.Stop()
 		}
+		// The message loop is the only sender on pieceQueue, so closing it
+		// here signals downstream listeners without racing a send.
+		close(c.pieceQueue)
 	}()
 
-	keepAliveTicker := time.NewTicker(2 * time.Minute)
+	keepAliveTicker := c.getClock().NewTicker(2 * time.Minute)
 	defer keepAliveTicker.Stop()
 
 	for {
@@ -461,6 +1327,9 @@ func (c *Connection) messageLoop(msgChan <-chan readResult) {
 			// We got a message, so handle it.
 			if err := c.handleMessage(result.msg); err != nil {
 				fmt.Printf("ERROR: Failed to handle message from peer %x: %v\n", c.ID[:8], err)
+				c.mu.Lock()
+				c.handleErr = err
+				c.mu.Unlock()
 				return // Stop if handling fails.
 			}
 
@@ -468,6 +1337,24 @@ func (c *Connection) messageLoop(msgChan <-chan readResult) {
 			if c.IsStopped() {
 				return
 			}
+			// A Choke may have arrived after this request was queued; the peer
+			// would discard it anyway, so don't send it unless it's allowed fast.
+			if c.IsChoked() && !c.IsPeerAllowedFast(uint32(req.PieceIndex)) {
+				continue
+			}
+
+			// Record the request before sending it, so a CancelRequest racing
+			// with the send still cancels it on the wire
+			key := blockKey{PieceIndex: req.PieceIndex, Begin: req.Begin}
+			c.mu.Lock()
+			if c.cancelled[key] {
+				delete(c.cancelled, key)
+				c.mu.Unlock()
+				continue
+			}
+			c.outstanding[key] = req.Length
+			c.mu.Unlock()
+
 			err := c.SendMessage(NewRequestMessage(
 				uint32(req.PieceIndex),
 				uint32(req.Begin),
@@ -478,7 +1365,7 @@ func (c *Connection) messageLoop(msgChan <-chan readResult) {
 				return
 			}
 
-		case <-keepAliveTicker.C:
+		case <-keepAliveTicker.C():
 			if c.IsStopped() {
 				return
 			}
@@ -0,0 +1,75 @@
+package peer
+
+import (
+	"fmt"
+
+	"bittorrentclient/internal/bencode"
+)
+
+// The handshake's reserved bit advertising the extension protocol (BEP 10)
+const (
+	extendedReservedByte = 5
+	extendedReservedBit  = 0x10
+)
+
+// ExtendedHandshakeID is the extended message ID of the extended handshake
+const ExtendedHandshakeID = 0
+
+// ExtendedHandshake holds the extended handshake fields we use
+type ExtendedHandshake struct {
+	Messages     map[string]int // Extension name -> ID to send its messages with
+	Reqq         int            // Requests the sender queues before dropping more; 0 if not given
+	MetadataSize int            // Bytes of the info dictionary, if the sender serves it
+}
+
+// NewExtendedHandshakeMessage creates our extended handshake. It advertises
+// reqq so peers size their request pipeline to our upload queue, and
+// ut_metadata (BEP 9) when we have metadataSize > 0 bytes of metadata to
+// serve.
+func NewExtendedHandshakeMessage(reqq, metadataSize int) (*Message, error) {
+	messages := map[string]interface{}{}
+	fields := map[string]interface{}{
+		"m":    messages,
+		"reqq": int64(reqq),
+	}
+	if metadataSize > 0 {
+		messages["ut_metadata"] = int64(UTMetadataID)
+		fields["metadata_size"] = int64(metadataSize)
+	}
+	dict, err := bencode.Encode(fields)
+	if err != nil {
+		return nil, err
+	}
+	payload := append([]byte{ExtendedHandshakeID}, dict...)
+	return NewMessage(MsgExtended, payload), nil
+}
+
+// ParseExtendedHandshake parses the bencoded dictionary of an extended
+// handshake (the payload after the extended message ID)
+func ParseExtendedHandshake(payload []byte) (*ExtendedHandshake, error) {
+	value, err := bencode.Decode(payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid extended handshake: %w", err)
+	}
+	dict, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("extended handshake is not a dictionary")
+	}
+
+	h := ExtendedHandshake{Messages: make(map[string]int)}
+	if messages, ok := dict["m"].(map[string]interface{}); ok {
+		for name, id := range messages {
+			// ID 0 disables an extension; IDs are sent as one byte
+			if id, ok := id.(int64); ok && id > 0 && id <= 255 {
+				h.Messages[name] = int(id)
+			}
+		}
+	}
+	if reqq, ok := dict["reqq"].(int64); ok && reqq > 0 {
+		h.Reqq = int(reqq)
+	}
+	if size, ok := dict["metadata_size"].(int64); ok && size > 0 {
+		h.MetadataSize = int(size)
+	}
+	return &h, nil
+}
@@ -0,0 +1,48 @@
+package peer
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"net"
+)
+
+// AllowedFastSetSize is the number of pieces in each peer's allowed-fast set
+const AllowedFastSetSize = 10
+
+// AllowedFastSet computes the BEP 6 allowed-fast set for a peer: the pieces
+// the peer may request from us even while we are choking it. The set only
+// depends on the peer's IPv4 /24 network and the info hash, so both sides
+// compute the same pieces.
+func AllowedFastSet(ip net.IP, infoHash [20]byte, numPieces, k int) []uint32 {
+	ip4 := ip.To4()
+	if ip4 == nil || numPieces <= 0 {
+		return nil
+	}
+	if k > numPieces {
+		k = numPieces
+	}
+
+	// x = (ip & 0xffffff00) + infohash
+	x := make([]byte, 0, 24)
+	x = append(x, ip4[0], ip4[1], ip4[2], 0)
+	x = append(x, infoHash[:]...)
+
+	var allowed []uint32
+	seen := make(map[uint32]bool)
+
+	for len(allowed) < k {
+		hash := sha1.Sum(x)
+		x = hash[:]
+
+		for i := 0; i < 5 && len(allowed) < k; i++ {
+			y := binary.BigEndian.Uint32(x[i*4 : i*4+4])
+			index := y % uint32(numPieces)
+			if !seen[index] {
+				seen[index] = true
+				allowed = append(allowed, index)
+			}
+		}
+	}
+
+	return allowed
+}
@@ -0,0 +1,47 @@
+package peer
+
+import (
+	"net"
+	"sync"
+
+	"bittorrentclient/internal/fdbudget"
+)
+
+// budgetConn returns its descriptor to the budget when closed
+type budgetConn struct {
+	net.Conn
+	budget *fdbudget.Budget
+	once   sync.Once
+}
+
+// withBudget makes closing conn release one descriptor taken from budget
+func withBudget(conn net.Conn, budget *fdbudget.Budget) net.Conn {
+	if budget == nil {
+		return conn
+	}
+	return &budgetConn{Conn: conn, budget: budget}
+}
+
+// Close closes the connection and releases its descriptor
+func (c *budgetConn) Close() error {
+	c.once.Do(c.budget.Release)
+	return c.Conn.Close()
+}
+
+// SetFDBudget makes the dialer's connections, and the sockets of listeners
+// it starts afterwards, count against budget. Dials fail with
+// fdbudget.ErrExhausted and inbound connections are dropped when it is
+// used up.
+func (d *Dialer) SetFDBudget(budget *fdbudget.Budget) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.budget = budget
+}
+
+// FDBudget returns the descriptor budget the dialer counts against; nil is
+// unlimited
+func (d *Dialer) FDBudget() *fdbudget.Budget {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.budget
+}
@@ -10,22 +10,49 @@ import (
 const (
 	ProtocolString = "BitTorrent protocol"
 	HandshakeSize  = 49 + len(ProtocolString)
+
+	// DefaultHandshakeTimeout is how long PerformHandshake waits for the
+	// peer's handshake
+	DefaultHandshakeTimeout = 10 * time.Second
 )
 
 // Handshake represents the BitTorrent handshake message
 type Handshake struct {
 	Pstr     string
+	Reserved [8]byte // Extension bits
 	InfoHash [20]byte
 	PeerID   [20]byte
 }
 
-// NewHandshake creates a new handshake
+// The handshake's reserved bit advertising the fast extension (BEP 6)
+const (
+	fastReservedByte = 7
+	fastReservedBit  = 0x04
+)
+
+// NewHandshake creates a new handshake advertising the extension protocol
+// and the fast extension
 func NewHandshake(infoHash, peerID [20]byte) *Handshake {
-	return &Handshake{
+	h := &Handshake{
 		Pstr:     ProtocolString,
 		InfoHash: infoHash,
 		PeerID:   peerID,
 	}
+	h.Reserved[extendedReservedByte] |= extendedReservedBit
+	h.Reserved[fastReservedByte] |= fastReservedBit
+	return h
+}
+
+// SupportsExtended returns true if the handshake advertises the extension
+// protocol (BEP 10)
+func (h *Handshake) SupportsExtended() bool {
+	return h.Reserved[extendedReservedByte]&extendedReservedBit != 0
+}
+
+// SupportsFast returns true if the handshake advertises the fast extension
+// (BEP 6)
+func (h *Handshake) SupportsFast() bool {
+	return h.Reserved[fastReservedByte]&fastReservedBit != 0
 }
 
 // Serialize converts handshake to bytes
@@ -41,7 +68,8 @@ func (h *Handshake) Serialize() []byte {
 	copy(buf[curr:], h.Pstr)
 	curr += len(h.Pstr)
 
-	// Reserved bytes (8 zeros)
+	// Reserved bytes
+	copy(buf[curr:], h.Reserved[:])
 	curr += 8
 
 	// Info hash
@@ -78,7 +106,9 @@ func DeserializeHandshake(data []byte) (*Handshake, error) {
 		return nil, fmt.Errorf("invalid protocol string: %s", pstr)
 	}
 
-	// Skip reserved bytes
+	// Reserved bytes
+	var reserved [8]byte
+	copy(reserved[:], data[curr:curr+8])
 	curr += 8
 
 	// Info hash
@@ -92,6 +122,7 @@ func DeserializeHandshake(data []byte) (*Handshake, error) {
 
 	return &Handshake{
 		Pstr:     pstr,
+		Reserved: reserved,
 		InfoHash: infoHash,
 		PeerID:   peerID,
 	}, nil
@@ -99,8 +130,13 @@ func DeserializeHandshake(data []byte) (*Handshake, error) {
 
 // PerformHandshake performs handshake with a peer
 func PerformHandshake(conn net.Conn, infoHash, peerID [20]byte) (*Handshake, error) {
-	// Set deadline for handshake
-	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return performHandshake(conn, infoHash, peerID, time.Now().Add(DefaultHandshakeTimeout))
+}
+
+// performHandshake performs handshake with a peer, failing if it isn't done
+// by deadline
+func performHandshake(conn net.Conn, infoHash, peerID [20]byte, deadline time.Time) (*Handshake, error) {
+	conn.SetDeadline(deadline)
 	defer conn.SetDeadline(time.Time{})
 
 	// Send our handshake
@@ -0,0 +1,262 @@
+package peer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"bittorrentclient/internal/clock"
+	"bittorrentclient/internal/fdbudget"
+)
+
+// InboundLimits protect a Listener from connection floods. Connections over
+// either limit are closed as soon as they are accepted, before we spend a
+// goroutine or buffer on them.
+type InboundLimits struct {
+	AcceptRate  float64 // Connections accepted per second on average; 0 means no limit
+	AcceptBurst int     // Connections that may be accepted at once above AcceptRate
+	MaxPending  int     // Connections still handshaking at once; 0 means no limit
+}
+
+// DefaultInboundLimits returns limits generous for honest swarms but low
+// enough that one hostile host can't exhaust descriptors or goroutines
+func DefaultInboundLimits() InboundLimits {
+	return InboundLimits{
+		AcceptRate:  20,
+		AcceptBurst: 40,
+		MaxPending:  50,
+	}
+}
+
+// InboundHandler receives an inbound peer once handshaken; p.InfoHash names
+// its torrent. It owns the peer from then on and should close it if it's
+// unwanted.
+type InboundHandler func(p *Peer)
+
+// Listener accepts peer connections on the dialer's listen addresses,
+// answering handshakes for the torrents lookup knows
+type Listener struct {
+	listeners []net.Listener
+	limits    InboundLimits
+	peerID    [20]byte
+	lookup    func(infoHash [20]byte) bool // Whether we serve a torrent
+	handle    InboundHandler
+	timeout   time.Duration    // Handshake timeout
+	budget    *fdbudget.Budget // Descriptors accepted connections count against
+
+	pending chan struct{} // Holds a token per connection still handshaking; nil if unlimited
+
+	mu     sync.Mutex
+	tokens float64 // Accept rate token bucket
+	last   time.Time
+	clock  clock.Clock
+
+	dropped atomic.Int64 // Connections closed for going over the limits
+}
+
+// Listen accepts peers on port at every address in ListenAddrs. Handshakes
+// must finish within the dialer's handshake timeout and name an info hash
+// lookup reports we serve; handle gets each peer that gets that far. It
+// fails only if no address could be listened on; addresses that fail while
+// others succeed are reported.
+func (d *Dialer) Listen(port int, limits InboundLimits, peerID [20]byte, lookup func(infoHash [20]byte) bool, handle InboundHandler) (*Listener, error) {
+	l := &Listener{
+		limits:  limits,
+		peerID:  peerID,
+		lookup:  lookup,
+		handle:  handle,
+		timeout: d.Timeouts().Handshake,
+		budget:  d.FDBudget(),
+		tokens:  float64(limits.AcceptBurst),
+		clock:   d.Clock(),
+	}
+	l.last = l.clock.Now()
+	if limits.MaxPending > 0 {
+		l.pending = make(chan struct{}, limits.MaxPending)
+	}
+
+	var errs []error
+	for _, addr := range d.ListenAddrs(port) {
+		ln, err := net.Listen(listenNetwork(addr), addr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to listen on %s: %w", addr, err))
+			continue
+		}
+		l.budget.Acquire() // Listening sockets are needed whatever the budget
+		l.listeners = append(l.listeners, ln)
+	}
+	if len(l.listeners) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	for _, err := range errs {
+		fmt.Printf("⚠️  %v\n", err)
+	}
+
+	for _, ln := range l.listeners {
+		go l.serve(ln)
+	}
+	return l, nil
+}
+
+// listenNetwork returns "tcp4" or "tcp6" for addr's address family. Plain
+// "tcp" would make the IPv6 wildcard dual-stack, clashing on Linux with
+// the IPv4 wildcard listening on the same port.
+func listenNetwork(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "tcp"
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return "tcp"
+	case ip.To4() == nil:
+		return "tcp6"
+	default:
+		return "tcp4"
+	}
+}
+
+// serve accepts connections until the listener is closed
+func (l *Listener) serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// Typically out of descriptors: back off rather than spin
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		if !l.allowAccept() || !l.acquire() {
+			l.dropped.Add(1)
+			conn.Close()
+			continue
+		}
+		if !l.budget.TryAcquire() {
+			l.release()
+			l.dropped.Add(1)
+			conn.Close()
+			continue
+		}
+		go l.serveConn(withBudget(conn, l.budget))
+	}
+}
+
+// allowAccept takes a token from the accept rate bucket
+func (l *Listener) allowAccept() bool {
+	if l.limits.AcceptRate <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.limits.AcceptRate
+	l.last = now
+	if burst := float64(max(l.limits.AcceptBurst, 1)); l.tokens > burst {
+		l.tokens = burst
+	}
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// acquire reserves a pending handshake slot without waiting
+func (l *Listener) acquire() bool {
+	if l.pending == nil {
+		return true
+	}
+	select {
+	case l.pending <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release frees a slot taken by acquire
+func (l *Listener) release() {
+	if l.pending != nil {
+		<-l.pending
+	}
+}
+
+// serveConn answers one inbound handshake and hands the peer on
+func (l *Listener) serveConn(conn net.Conn) {
+	handshake, err := l.receiveHandshake(conn)
+	l.release()
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	p := NewPeer(conn, handshake.InfoHash)
+	p.ID = handshake.PeerID
+	p.Extended = handshake.SupportsExtended()
+	p.FastExtension = handshake.SupportsFast()
+	l.handle(p)
+}
+
+// receiveHandshake reads the remote's handshake first, as the side being
+// connected to, and replies only if we serve its torrent
+func (l *Listener) receiveHandshake(conn net.Conn) (*Handshake, error) {
+	conn.SetDeadline(time.Now().Add(l.timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	buf := make([]byte, HandshakeSize)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, fmt.Errorf("failed to read handshake: %w", err)
+	}
+	handshake, err := DeserializeHandshake(buf)
+	if err != nil {
+		return nil, err
+	}
+	if !l.lookup(handshake.InfoHash) {
+		return nil, fmt.Errorf("not serving info hash %x", handshake.InfoHash[:8])
+	}
+	if handshake.PeerID == l.peerID {
+		return nil, ErrSelfConnection
+	}
+
+	if _, err := conn.Write(NewHandshake(handshake.InfoHash, l.peerID).Serialize()); err != nil {
+		return nil, fmt.Errorf("failed to send handshake: %w", err)
+	}
+	return handshake, nil
+}
+
+// Addrs returns the addresses being listened on
+func (l *Listener) Addrs() []net.Addr {
+	addrs := make([]net.Addr, len(l.listeners))
+	for i, ln := range l.listeners {
+		addrs[i] = ln.Addr()
+	}
+	return addrs
+}
+
+// Dropped returns how many connections were closed for exceeding the
+// accept rate, pending handshake limit or descriptor budget
+func (l *Listener) Dropped() int64 {
+	return l.dropped.Load()
+}
+
+// Close stops accepting connections. Handshakes in progress finish.
+func (l *Listener) Close() error {
+	var errs []error
+	for _, ln := range l.listeners {
+		if err := ln.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		l.budget.Release()
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,50 @@
+package peer
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenNetwork(t *testing.T) {
+	tests := map[string]string{
+		"0.0.0.0:6881":     "tcp4",
+		"[::]:6881":        "tcp6",
+		"192.168.1.7:6881": "tcp4",
+		"[fe80::1]:6881":   "tcp6",
+		"localhost:6881":   "tcp",
+	}
+	for addr, want := range tests {
+		if got := listenNetwork(addr); got != want {
+			t.Errorf("listenNetwork(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestListenBothFamilies(t *testing.T) {
+	// Find a free port both families can use
+	probe, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	d, err := NewDialer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := d.Listen(port, DefaultInboundLimits(), [20]byte{}, func([20]byte) bool { return false }, func(p *Peer) { p.Close() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	want := 1
+	if ln, err := net.Listen("tcp6", "[::1]:0"); err == nil {
+		ln.Close()
+		want = 2 // The host has IPv6
+	}
+	if len(l.listeners) != want {
+		t.Fatalf("listening on %d addresses, want %d", len(l.listeners), want)
+	}
+}
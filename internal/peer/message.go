@@ -18,8 +18,23 @@ const (
 	MsgPiece         = 7
 	MsgCancel        = 8
 	MsgPort          = 9
+
+	// Fast extension (BEP 6)
+	MsgSuggestPiece  = 0x0D
+	MsgHaveAll       = 0x0E
+	MsgHaveNone      = 0x0F
+	MsgRejectRequest = 0x10
+	MsgAllowedFast   = 0x11
+
+	// Extension protocol (BEP 10)
+	MsgExtended = 20
 )
 
+// MaxMessageLength bounds the length prefix we accept from a peer. It leaves
+// room for a 16KB block plus header and for bitfields of very large torrents,
+// while keeping a hostile length prefix from forcing a huge allocation.
+const MaxMessageLength = 1 << 20
+
 // Message represents a peer wire protocol message
 type Message struct {
 	ID      byte
@@ -72,6 +87,10 @@ func DeserializeMessage(r io.Reader) (*Message, error) {
 		return nil, nil
 	}
 
+	if length > MaxMessageLength {
+		return nil, fmt.Errorf("message length %d exceeds maximum %d", length, MaxMessageLength)
+	}
+
 	// Read message ID
 	msgBuf := make([]byte, length)
 	_, err = io.ReadFull(r, msgBuf)
@@ -130,6 +149,59 @@ func NewRequestMessage(index, begin, length uint32) *Message {
 		Payload: payload,
 	}
 }
+
+// NewCancelMessage creates a cancel message withdrawing a request
+func NewCancelMessage(index, begin, length uint32) *Message {
+	msg := NewRequestMessage(index, begin, length)
+	msg.ID = MsgCancel
+	return msg
+}
+
+// NewPieceMessage creates a piece message carrying a block of data
+func NewPieceMessage(index, begin uint32, data []byte) *Message {
+	payload := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint32(payload[0:4], index)
+	binary.BigEndian.PutUint32(payload[4:8], begin)
+	copy(payload[8:], data)
+
+	return NewMessage(MsgPiece, payload)
+}
+
+// NewSuggestPieceMessage creates a suggest piece message (BEP 6), hinting
+// that the peer could download the piece from us cheaply
+func NewSuggestPieceMessage(pieceIndex uint32) *Message {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, pieceIndex)
+	return NewMessage(MsgSuggestPiece, payload)
+}
+
+// NewHaveAllMessage creates a have all message (BEP 6), sent instead of a
+// bitfield with every piece set
+func NewHaveAllMessage() *Message {
+	return NewMessage(MsgHaveAll, nil)
+}
+
+// NewHaveNoneMessage creates a have none message (BEP 6), sent instead of
+// an empty bitfield
+func NewHaveNoneMessage() *Message {
+	return NewMessage(MsgHaveNone, nil)
+}
+
+// NewRejectRequestMessage creates a reject request message (BEP 6), telling
+// the peer we won't serve a block it asked for
+func NewRejectRequestMessage(index, begin, length uint32) *Message {
+	msg := NewRequestMessage(index, begin, length)
+	msg.ID = MsgRejectRequest
+	return msg
+}
+
+// NewAllowedFastMessage creates an allowed fast message (BEP 6)
+func NewAllowedFastMessage(pieceIndex uint32) *Message {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, pieceIndex)
+	return NewMessage(MsgAllowedFast, payload)
+}
+
 func ParseHaveMessage(payload []byte) (uint32, error) {
 	if len(payload) != 4 {
 		return 0, fmt.Errorf("invalid have message length: %d", len(payload))
@@ -0,0 +1,90 @@
+package peer
+
+import (
+	"fmt"
+
+	"bittorrentclient/internal/bencode"
+)
+
+// MetadataPieceSize is the size of every metadata piece but the last (BEP 9)
+const MetadataPieceSize = 16 * 1024
+
+// UTMetadataID is the extended message ID we ask peers to send ut_metadata
+// messages to us with
+const UTMetadataID = 1
+
+// ut_metadata message types
+const (
+	metadataRequest = 0
+	metadataData    = 1
+	metadataReject  = 2
+)
+
+// MetadataMessage is a parsed ut_metadata message
+type MetadataMessage struct {
+	Type      int
+	Piece     int
+	TotalSize int    // Only in data messages
+	Data      []byte // The piece's bytes, following the dictionary in data messages
+}
+
+// ParseMetadataMessage parses a ut_metadata payload (after the extended
+// message ID)
+func ParseMetadataMessage(payload []byte) (*MetadataMessage, error) {
+	decoder := bencode.NewDecoder(payload)
+	dict, err := decoder.DecodeDict()
+	if err != nil {
+		return nil, fmt.Errorf("invalid ut_metadata message: %w", err)
+	}
+
+	msgType, ok := dict["msg_type"].(int64)
+	if !ok {
+		return nil, fmt.Errorf("ut_metadata message without msg_type")
+	}
+	piece, ok := dict["piece"].(int64)
+	if !ok || piece < 0 {
+		return nil, fmt.Errorf("ut_metadata message without a valid piece")
+	}
+
+	msg := &MetadataMessage{Type: int(msgType), Piece: int(piece)}
+	if msg.Type == metadataData {
+		totalSize, _ := dict["total_size"].(int64)
+		msg.TotalSize = int(totalSize)
+		// The piece's bytes follow the dictionary
+		msg.Data = payload[decoder.Pos:]
+	}
+	return msg, nil
+}
+
+// NewMetadataMessage creates a ut_metadata message for the peer's extended
+// message ID. Data messages carry total size and the piece's bytes.
+func NewMetadataMessage(peerExtID byte, msgType, piece, totalSize int, data []byte) (*Message, error) {
+	dict := map[string]interface{}{
+		"msg_type": int64(msgType),
+		"piece":    int64(piece),
+	}
+	if msgType == metadataData {
+		dict["total_size"] = int64(totalSize)
+	}
+	encoded, err := bencode.Encode(dict)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := make([]byte, 0, 1+len(encoded)+len(data))
+	payload = append(payload, peerExtID)
+	payload = append(payload, encoded...)
+	payload = append(payload, data...)
+	return NewMessage(MsgExtended, payload), nil
+}
+
+// metadataPiece returns piece of metadata, or nil if it is out of range
+func metadataPiece(metadata []byte, piece int) []byte {
+	// Check before multiplying, so a huge index can't overflow into range
+	if piece < 0 || piece >= (len(metadata)+MetadataPieceSize-1)/MetadataPieceSize {
+		return nil
+	}
+	begin := piece * MetadataPieceSize
+	end := min(begin+MetadataPieceSize, len(metadata))
+	return metadata[begin:end]
+}
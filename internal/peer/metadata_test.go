@@ -0,0 +1,51 @@
+package peer
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMetadataPiece(t *testing.T) {
+	metadata := bytes.Repeat([]byte{'x'}, 2*MetadataPieceSize+100)
+
+	tests := []struct {
+		name  string
+		piece int
+		want  int // Length of the piece returned; -1 for nil
+	}{
+		{"first", 0, MetadataPieceSize},
+		{"middle", 1, MetadataPieceSize},
+		{"last", 2, 100},
+		{"past the end", 3, -1},
+		{"negative", -1, -1},
+		{"huge", 3 << 48, -1},
+		{"overflowing", int(^uint(0) >> 1), -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := metadataPiece(metadata, tt.piece)
+			if tt.want < 0 {
+				if got != nil {
+					t.Fatalf("metadataPiece(%d) = %d bytes, want nil", tt.piece, len(got))
+				}
+				return
+			}
+			if len(got) != tt.want {
+				t.Fatalf("metadataPiece(%d) = %d bytes, want %d", tt.piece, len(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestMetadataPieceExactMultiple(t *testing.T) {
+	metadata := make([]byte, MetadataPieceSize)
+	if got := metadataPiece(metadata, 0); len(got) != MetadataPieceSize {
+		t.Fatalf("piece 0 = %d bytes, want %d", len(got), MetadataPieceSize)
+	}
+	if got := metadataPiece(metadata, 1); got != nil {
+		t.Fatalf("piece 1 = %d bytes, want nil", len(got))
+	}
+	if got := metadataPiece(nil, 0); got != nil {
+		t.Fatalf("piece 0 of no metadata = %d bytes, want nil", len(got))
+	}
+}
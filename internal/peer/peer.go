@@ -2,19 +2,25 @@ package peer
 
 import (
 	"net"
+	"sync/atomic"
 	"time"
 )
 
 // Peer represents a connected peer
 type Peer struct {
-	Conn        net.Conn
-	ID          [20]byte
-	InfoHash    [20]byte
-	Choked      bool
-	Choking     bool
-	Interested  bool
-	Interesting bool
-	Bitfield    []byte
+	Conn          net.Conn
+	ID            [20]byte
+	InfoHash      [20]byte
+	Choked        bool
+	Choking       bool
+	Interested    bool
+	Interesting   bool
+	Bitfield      []byte
+	NumPieces     int  // Number of pieces in the torrent, used to size the bitfield
+	Extended      bool // Both handshakes advertised the extension protocol (BEP 10)
+	FastExtension bool // Both handshakes advertised the fast extension (BEP 6)
+
+	trace atomic.Pointer[Trace] // Records every message, see SetTrace
 }
 
 // NewPeer creates a new peer connection
@@ -55,10 +61,14 @@ func (p *Peer) HasPiece(index int) bool {
 	return p.Bitfield[byteIndex]&(1<<(7-bitIndex)) != 0
 }
 
-// SetPiece marks a piece as available in the bitfield
+// SetPiece marks a piece as available in the bitfield. If the peer never
+// sent a bitfield, one is allocated from NumPieces on the first call.
 func (p *Peer) SetPiece(index int) {
 	if p.Bitfield == nil {
-		return
+		if p.NumPieces <= 0 {
+			return
+		}
+		p.Bitfield = make([]byte, (p.NumPieces+7)/8)
 	}
 
 	byteIndex := index / 8
@@ -0,0 +1,329 @@
+package peer
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"bittorrentclient/internal/clock"
+)
+
+// Source is where we learned about a peer address
+type Source int
+
+const (
+	SourceTracker Source = iota
+	SourceIncoming
+	SourcePEX
+	SourceDHT
+	SourceResume
+)
+
+const (
+	// PoolRetryBackoff is the wait after the first failed dial; it doubles
+	// with each consecutive failure up to PoolMaxBackoff
+	PoolRetryBackoff = 30 * time.Second
+	PoolMaxBackoff   = 30 * time.Minute
+	// PoolRecencyWindow is how long a sighting still raises a peer's score
+	PoolRecencyWindow = 30 * time.Minute
+	// PoolResumeBonus lifts peers saved from a previous run above untried
+	// peers, since they are known to have worked
+	PoolResumeBonus = 1.0
+)
+
+// Candidate is a known peer address and what we've learned about it
+type Candidate struct {
+	Addr      string
+	Source    Source
+	FirstSeen time.Time
+	LastSeen  time.Time // Last time any source reported the address
+
+	Attempts       int
+	Successes      int
+	Failures       int // Consecutive failed dials, reset by a success
+	LastAttempt    time.Time
+	LastConnected  time.Time
+	Connected      bool
+	connectedSince time.Time
+	connectedTime  time.Duration // Total time spent connected, excluding the current connection
+
+	Downloaded int64 // Bytes received from this peer over all connections
+}
+
+// Pool keeps every peer address known for a torrent and hands them out
+// for dialing best-first
+type Pool struct {
+	mu    sync.RWMutex
+	peers map[string]*Candidate // key: "ip:port"
+	clock clock.Clock
+
+	allowlist *Allowlist // Addresses outside it are ignored; nil allows all
+}
+
+// NewPool creates an empty peer pool
+func NewPool() *Pool {
+	return &Pool{
+		peers: make(map[string]*Candidate),
+		clock: clock.Real,
+	}
+}
+
+// SetClock replaces the clock used for backoff and recency
+func (p *Pool) SetClock(c clock.Clock) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clock = c
+}
+
+// SetAllowlist ignores addresses outside a from now on, forgetting those
+// already known; nil allows all again
+func (p *Pool) SetAllowlist(a *Allowlist) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.allowlist = a
+	for addr := range p.peers {
+		if !a.Allows(addr) {
+			delete(p.peers, addr)
+		}
+	}
+}
+
+// Add records a sighting of addr. Returns true if the address was new, and
+// false for one the allowlist ignores.
+func (p *Pool) Add(addr string, source Source) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.allowlist.Allows(addr) {
+		return false
+	}
+
+	now := p.clock.Now()
+	if c, exists := p.peers[addr]; exists {
+		c.LastSeen = now
+		return false
+	}
+
+	p.peers[addr] = &Candidate{
+		Addr:      addr,
+		Source:    source,
+		FirstSeen: now,
+		LastSeen:  now,
+	}
+	return true
+}
+
+// Remove forgets an address
+func (p *Pool) Remove(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.peers, addr)
+}
+
+// Len returns the number of known addresses
+func (p *Pool) Len() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.peers)
+}
+
+// RecordAttempt notes that we are dialing addr
+func (p *Pool) RecordAttempt(addr string) {
+	p.update(addr, func(c *Candidate, now time.Time) {
+		c.Attempts++
+		c.LastAttempt = now
+	})
+}
+
+// RecordFailure notes that dialing addr failed
+func (p *Pool) RecordFailure(addr string) {
+	p.update(addr, func(c *Candidate, now time.Time) {
+		c.Failures++
+	})
+}
+
+// RecordConnected notes that addr is now connected
+func (p *Pool) RecordConnected(addr string) {
+	p.update(addr, func(c *Candidate, now time.Time) {
+		c.Successes++
+		c.Failures = 0
+		c.Connected = true
+		c.connectedSince = now
+		c.LastConnected = now
+	})
+}
+
+// RecordDisconnected notes that the connection to addr closed
+func (p *Pool) RecordDisconnected(addr string) {
+	p.update(addr, func(c *Candidate, now time.Time) {
+		if c.Connected {
+			c.connectedTime += now.Sub(c.connectedSince)
+		}
+		c.Connected = false
+		c.LastConnected = now
+	})
+}
+
+// RecordDownload adds bytes received from addr
+func (p *Pool) RecordDownload(addr string, bytes int64) {
+	p.update(addr, func(c *Candidate, now time.Time) {
+		c.Downloaded += bytes
+	})
+}
+
+// update applies fn to a candidate, adding it first if unknown
+func (p *Pool) update(addr string, fn func(c *Candidate, now time.Time)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.clock.Now()
+	c, exists := p.peers[addr]
+	if !exists {
+		c = &Candidate{Addr: addr, Source: SourceIncoming, FirstSeen: now, LastSeen: now}
+		p.peers[addr] = c
+	}
+	fn(c, now)
+}
+
+// Get returns a copy of the candidate for addr
+func (p *Pool) Get(addr string) (Candidate, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	c, exists := p.peers[addr]
+	if !exists {
+		return Candidate{}, false
+	}
+	return *c, true
+}
+
+// Dialable returns how many addresses Next could hand out right now
+func (p *Pool) Dialable() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	now := p.clock.Now()
+	n := 0
+	for _, c := range p.peers {
+		if !c.Connected && !now.Before(c.retryAt()) {
+			n++
+		}
+	}
+	return n
+}
+
+// Next returns up to n addresses to dial, best score first. Connected
+// peers and peers still backing off after failed dials are skipped.
+func (p *Pool) Next(n int) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	now := p.clock.Now()
+	type scored struct {
+		addr  string
+		score float64
+	}
+	var ready []scored
+	for addr, c := range p.peers {
+		if c.Connected || now.Before(c.retryAt()) {
+			continue
+		}
+		ready = append(ready, scored{addr, c.score(now)})
+	}
+
+	sort.Slice(ready, func(i, j int) bool {
+		if ready[i].score != ready[j].score {
+			return ready[i].score > ready[j].score
+		}
+		return ready[i].addr < ready[j].addr
+	})
+
+	if n > len(ready) {
+		n = len(ready)
+	}
+	addrs := make([]string, n)
+	for i := range addrs {
+		addrs[i] = ready[i].addr
+	}
+	return addrs
+}
+
+// Best returns up to n addresses we have connected to before, fastest first.
+// These are the peers worth remembering across restarts.
+func (p *Pool) Best(n int) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	now := p.clock.Now()
+	var good []*Candidate
+	for _, c := range p.peers {
+		if c.Successes > 0 {
+			good = append(good, c)
+		}
+	}
+
+	sort.Slice(good, func(i, j int) bool {
+		ri, rj := good[i].DownloadRate(now), good[j].DownloadRate(now)
+		if ri != rj {
+			return ri > rj
+		}
+		return good[i].score(now) > good[j].score(now)
+	})
+
+	if n > len(good) {
+		n = len(good)
+	}
+	addrs := make([]string, n)
+	for i := range addrs {
+		addrs[i] = good[i].Addr
+	}
+	return addrs
+}
+
+// retryAt returns when the candidate may be dialed again
+func (c *Candidate) retryAt() time.Time {
+	if c.Failures == 0 {
+		return time.Time{}
+	}
+
+	backoff := PoolRetryBackoff << (c.Failures - 1)
+	if backoff > PoolMaxBackoff || backoff <= 0 {
+		backoff = PoolMaxBackoff
+	}
+	return c.LastAttempt.Add(backoff)
+}
+
+// DownloadRate returns the average bytes/second received while connected
+func (c *Candidate) DownloadRate(now time.Time) float64 {
+	connected := c.connectedTime
+	if c.Connected {
+		connected += now.Sub(c.connectedSince)
+	}
+	if connected <= 0 {
+		return 0
+	}
+	return float64(c.Downloaded) / connected.Seconds()
+}
+
+// score ranks a candidate: peers that connected before and sent data fast
+// come first, then recently seen untried peers, then peers that keep failing
+func (c *Candidate) score(now time.Time) float64 {
+	// Smoothed success ratio, so untried peers start at 0.5
+	score := float64(c.Successes+1) / float64(c.Attempts+2)
+
+	// Speed in KB/s on a log scale, so one fast peer doesn't dwarf the rest
+	score += math.Log10(1 + c.DownloadRate(now)/1024)
+
+	if c.Source == SourceResume {
+		score += PoolResumeBonus
+	}
+
+	// Recently reported addresses are more likely to still be there
+	if age := now.Sub(c.LastSeen); age < PoolRecencyWindow {
+		score += 0.5 * (1 - float64(age)/float64(PoolRecencyWindow))
+	}
+
+	return score
+}
@@ -0,0 +1,50 @@
+package peer
+
+import "time"
+
+// RateWindow is the span a connection's transfer rate is averaged over
+const RateWindow = 10 * time.Second
+
+// rateBuckets is the number of one-second buckets covering RateWindow
+const rateBuckets = int(RateWindow / time.Second)
+
+// rollingRate counts bytes in one-second buckets so the rate over the last
+// RateWindow can be read cheaply, without keeping every sample
+type rollingRate struct {
+	buckets [rateBuckets]int64
+	last    int64 // Unix second of the newest bucket
+}
+
+// add records n bytes transferred at now
+func (r *rollingRate) add(now time.Time, n int64) {
+	r.advance(now)
+	r.buckets[r.last%int64(rateBuckets)] += n
+}
+
+// rate returns the average bytes per second over the last RateWindow
+func (r *rollingRate) rate(now time.Time) float64 {
+	r.advance(now)
+
+	var total int64
+	for _, n := range r.buckets {
+		total += n
+	}
+	return float64(total) / RateWindow.Seconds()
+}
+
+// advance clears the buckets of the seconds that passed since the last call
+func (r *rollingRate) advance(now time.Time) {
+	sec := now.Unix()
+	if sec <= r.last {
+		return
+	}
+
+	if sec-r.last >= int64(rateBuckets) {
+		r.buckets = [rateBuckets]int64{}
+	} else {
+		for s := r.last + 1; s <= sec; s++ {
+			r.buckets[s%int64(rateBuckets)] = 0
+		}
+	}
+	r.last = sec
+}
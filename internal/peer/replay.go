@@ -0,0 +1,190 @@
+package peer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// replayAddr is the address a ReplayConn reports for both ends
+type replayAddr struct{}
+
+func (replayAddr) Network() string { return "replay" }
+func (replayAddr) String() string  { return "replay" }
+
+// ReplayConn is a net.Conn that plays back a recorded or handcrafted byte
+// stream as if a peer sent it, then reports EOF, and keeps everything
+// written to it. It lets a Connection's message handling be driven and
+// checked without a live peer.
+type ReplayConn struct {
+	mu      sync.Mutex
+	stream  *bytes.Reader
+	written bytes.Buffer
+	closed  bool
+}
+
+// NewReplayConn plays back stream, the bytes the peer sent after the
+// handshake
+func NewReplayConn(stream []byte) *ReplayConn {
+	return &ReplayConn{stream: bytes.NewReader(stream)}
+}
+
+// ReplayStream serializes messages into a stream for NewReplayConn; a nil
+// message is a keep-alive
+func ReplayStream(messages ...*Message) []byte {
+	var stream bytes.Buffer
+	for _, msg := range messages {
+		if msg == nil {
+			stream.Write(make([]byte, 4))
+			continue
+		}
+		stream.Write(msg.Serialize())
+	}
+	return stream.Bytes()
+}
+
+// Read returns the next bytes of the stream, io.EOF once it is exhausted
+func (c *ReplayConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	return c.stream.Read(b)
+}
+
+// Write keeps b, see Written
+func (c *ReplayConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	return c.written.Write(b)
+}
+
+// Close makes further reads and writes fail
+func (c *ReplayConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+// Unread returns how many bytes of the stream were never read, e.g.
+// because a message before them failed to parse
+func (c *ReplayConn) Unread() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stream.Len()
+}
+
+// Written returns the bytes written to the connection so far
+func (c *ReplayConn) Written() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]byte(nil), c.written.Bytes()...)
+}
+
+func (c *ReplayConn) LocalAddr() net.Addr                { return replayAddr{} }
+func (c *ReplayConn) RemoteAddr() net.Addr               { return replayAddr{} }
+func (c *ReplayConn) SetDeadline(t time.Time) error      { return nil }
+func (c *ReplayConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *ReplayConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// ReplayResult is what a Connection did with a replayed stream
+type ReplayResult struct {
+	Conn   *Connection  // The stopped connection, for inspecting its state
+	Pieces []*PieceData // Blocks it delivered to the consumer, in order
+	Sent   []*Message   // Messages it wrote back (nil for keep-alives)
+	Unread int          // Stream bytes left when it stopped; 0 if all were read
+	Err    error        // Why the stream failed to parse or was refused, if it did
+}
+
+// Replay runs a Connection over stream, the bytes a peer sent after the
+// handshake, until the stream ends or the connection gives up on it, and
+// reports what it did. setup, if not nil, prepares the connection before
+// it starts, as the downloader would (peer ID, extension bits, our haves,
+// metadata, requests to expect blocks for).
+func Replay(stream []byte, infoHash [20]byte, setup func(c *Connection)) *ReplayResult {
+	wire := NewReplayConn(stream)
+	conn := NewConnection(wire, infoHash)
+	if setup != nil {
+		setup(conn)
+	}
+	conn.Start()
+
+	result := &ReplayResult{Conn: conn}
+	for data := range conn.GetPieceData() {
+		result.Pieces = append(result.Pieces, data)
+	}
+	result.Unread = wire.Unread()
+	_, result.Err = ReplayMessages([]CaptureRecord{{Kind: CaptureReceived, Data: stream}}, CaptureReceived)
+	if err := conn.HandleErr(); err != nil {
+		result.Err = err
+	}
+	result.Sent, _ = ReplayMessages([]CaptureRecord{{Kind: CaptureSent, Data: wire.Written()}}, CaptureSent)
+	return result
+}
+
+// ReplayCapture replays what the peer sent in a capture (see ReadCapture)
+// through a new Connection, set up with the info hash, peer ID and
+// extension bits of the capture's handshake note before setup runs. It
+// fails if the note's info hash or peer ID is malformed.
+func ReplayCapture(records []CaptureRecord, setup func(c *Connection)) (*ReplayResult, error) {
+	handshake := captureHandshake(records)
+	var infoHash, peerID [20]byte
+	if err := decodeHandshakeID(handshake, "info_hash", infoHash[:]); err != nil {
+		return nil, err
+	}
+	if err := decodeHandshakeID(handshake, "peer_id", peerID[:]); err != nil {
+		return nil, err
+	}
+
+	return Replay(CaptureStream(records, CaptureReceived), infoHash, func(c *Connection) {
+		c.ID = peerID
+		c.Extended = handshake["extended"] == "true"
+		c.FastExtension = handshake["fast"] == "true"
+		if setup != nil {
+			setup(c)
+		}
+	}), nil
+}
+
+// decodeHandshakeID decodes the 20-byte hex field key of a handshake note
+// into dst, leaving it zero if the capture has no handshake note
+func decodeHandshakeID(handshake map[string]string, key string, dst []byte) error {
+	value, ok := handshake[key]
+	if !ok {
+		return nil
+	}
+	if len(value) != hex.EncodedLen(len(dst)) {
+		return fmt.Errorf("capture handshake: %s %q is not %d bytes", key, value, len(dst))
+	}
+	if _, err := hex.Decode(dst, []byte(value)); err != nil {
+		return fmt.Errorf("capture handshake: invalid %s %q: %w", key, value, err)
+	}
+	return nil
+}
+
+// captureHandshake returns the key=value fields of a capture's handshake
+// note, see Connection.StartCapture
+func captureHandshake(records []CaptureRecord) map[string]string {
+	fields := make(map[string]string)
+	for _, record := range records {
+		if record.Kind != CaptureNote || !bytes.HasPrefix(record.Data, []byte("handshake ")) {
+			continue
+		}
+		for _, field := range strings.Fields(string(record.Data)) {
+			if key, value, ok := strings.Cut(field, "="); ok {
+				fields[key] = value
+			}
+		}
+		break
+	}
+	return fields
+}
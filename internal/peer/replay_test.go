@@ -0,0 +1,137 @@
+package peer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReplayBitfieldLength(t *testing.T) {
+	tests := []struct {
+		name     string
+		bitfield []byte
+		wantErr  bool
+	}{
+		{"exact", []byte{0xff, 0xc0}, false},
+		{"short", []byte{0xff}, true},
+		{"long", []byte{0xff, 0xc0, 0x00}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := ReplayStream(NewBitfieldMessage(tt.bitfield), NewHaveMessage(3))
+			result := Replay(stream, [20]byte{}, func(c *Connection) {
+				c.NumPieces = 10
+			})
+
+			if tt.wantErr {
+				if result.Err == nil || !strings.Contains(result.Err.Error(), "bitfield") {
+					t.Fatalf("Err = %v, want a bitfield length error", result.Err)
+				}
+				return
+			}
+			if result.Err != nil {
+				t.Fatalf("Err = %v, want nil", result.Err)
+			}
+			if result.Unread != 0 {
+				t.Fatalf("Unread = %d, want 0", result.Unread)
+			}
+		})
+	}
+}
+
+func TestReplayRejectWithoutFastExtension(t *testing.T) {
+	stream := ReplayStream(NewRejectRequestMessage(0, 0, 16384))
+
+	result := Replay(stream, [20]byte{}, nil)
+	if result.Err == nil || !strings.Contains(result.Err.Error(), "fast extension") {
+		t.Fatalf("Err = %v, want a fast extension error", result.Err)
+	}
+
+	result = Replay(stream, [20]byte{}, func(c *Connection) {
+		c.FastExtension = true
+	})
+	if result.Err != nil {
+		t.Fatalf("with the fast extension: Err = %v, want nil", result.Err)
+	}
+}
+
+func TestReplayMetadataRequestOutOfRange(t *testing.T) {
+	// The peer says which ID to send it ut_metadata messages with
+	handshake := NewMessage(MsgExtended, append([]byte{ExtendedHandshakeID}, "d1:md11:ut_metadatai3eee"...))
+
+	tests := []struct {
+		name     string
+		piece    int
+		wantType int
+	}{
+		{"in range", 1, metadataData},
+		{"past the end", 2, metadataReject},
+		{"huge", 1 << 40, metadataReject},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, err := NewMetadataMessage(UTMetadataID, metadataRequest, tt.piece, 0, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := Replay(ReplayStream(handshake, request), [20]byte{}, func(c *Connection) {
+				c.Extended = true
+				c.SetMetadata(make([]byte, MetadataPieceSize+10))
+			})
+			if result.Err != nil {
+				t.Fatalf("Err = %v, want nil", result.Err)
+			}
+
+			var replies []*MetadataMessage
+			for _, msg := range result.Sent {
+				if msg == nil || msg.ID != MsgExtended || len(msg.Payload) == 0 || msg.Payload[0] != 3 {
+					continue
+				}
+				reply, err := ParseMetadataMessage(msg.Payload[1:])
+				if err != nil {
+					t.Fatal(err)
+				}
+				replies = append(replies, reply)
+			}
+			if len(replies) != 1 {
+				t.Fatalf("sent %d ut_metadata replies, want 1", len(replies))
+			}
+			if replies[0].Type != tt.wantType || replies[0].Piece != tt.piece {
+				t.Fatalf("reply type %d for piece %d, want type %d for piece %d",
+					replies[0].Type, replies[0].Piece, tt.wantType, tt.piece)
+			}
+		})
+	}
+}
+
+func TestReplayCaptureHandshake(t *testing.T) {
+	hash := strings.Repeat("ab", 20)
+	tests := []struct {
+		name    string
+		note    string
+		wantErr bool
+	}{
+		{"valid", "handshake info_hash=" + hash + " peer_id=" + hash + " extended=true fast=false", false},
+		{"no handshake note", "something else", false},
+		{"not hex", "handshake info_hash=" + strings.Repeat("zz", 20), true},
+		{"too long", "handshake info_hash=" + hash + "abab", true},
+		{"too short", "handshake peer_id=abab", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := []CaptureRecord{{Kind: CaptureNote, Data: []byte(tt.note)}}
+			result, err := ReplayCapture(records, nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("err = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.name == "valid" && (result.Conn.InfoHash[0] != 0xab || result.Conn.ID[19] != 0xab || !result.Conn.Extended) {
+				t.Fatalf("connection not set up from the handshake note")
+			}
+		})
+	}
+}
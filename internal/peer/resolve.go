@@ -0,0 +1,188 @@
+package peer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// HappyEyeballsDelay is how long a dial attempt runs alone before the next
+// address, alternating address families, is tried alongside it (RFC 8305)
+const HappyEyeballsDelay = 250 * time.Millisecond
+
+// Resolver looks up the addresses of peer and tracker host names.
+// net.DefaultResolver is one; others can use DNS over HTTPS, fixed host
+// tables and so on.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// StaticResolver answers from a fixed table of host names, handing names it
+// doesn't know to Fallback (none if nil)
+type StaticResolver struct {
+	Hosts    map[string][]net.IP // Lowercased host name -> addresses
+	Fallback Resolver
+}
+
+// LookupIPAddr returns the table's addresses for host, or asks Fallback
+func (r *StaticResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if ips, ok := r.Hosts[strings.ToLower(strings.TrimSuffix(host, "."))]; ok {
+		addrs := make([]net.IPAddr, len(ips))
+		for i, ip := range ips {
+			addrs[i] = net.IPAddr{IP: ip}
+		}
+		return addrs, nil
+	}
+	if r.Fallback == nil {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return r.Fallback.LookupIPAddr(ctx, host)
+}
+
+// SetResolver replaces how the dialer looks up host names; nil restores
+// net.DefaultResolver
+func (d *Dialer) SetResolver(r Resolver) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.resolver = r
+}
+
+// Resolver returns the resolver the dialer looks up host names with
+func (d *Dialer) Resolver() Resolver {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.resolver == nil {
+		return net.DefaultResolver
+	}
+	return d.resolver
+}
+
+// LoadHostsFile reads a hosts file ("address name [alias...]" per line, #
+// comments) into a StaticResolver falling back to fallback
+func LoadHostsFile(path string, fallback Resolver) (*StaticResolver, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := &StaticResolver{Hosts: make(map[string][]net.IP), Fallback: fallback}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil || len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected an address followed by host names", path, line)
+		}
+		for _, name := range fields[1:] {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			r.Hosts[name] = append(r.Hosts[name], ip)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// interleaveFamilies orders addresses for happy eyeballs: alternating
+// between IPv6 and IPv4, starting with the family of the first address
+func interleaveFamilies(ips []net.IP) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	first, second := v6, v4
+	if len(ips) > 0 && ips[0].To4() != nil {
+		first, second = v4, v6
+	}
+
+	ordered := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			ordered = append(ordered, first[i])
+		}
+		if i < len(second) {
+			ordered = append(ordered, second[i])
+		}
+	}
+	return ordered
+}
+
+// dialResult is the outcome of one happy eyeballs attempt
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// dialHappyEyeballs dials candidates in order, starting the next attempt
+// when the previous fails or after HappyEyeballsDelay, and returns the
+// first connection made. Connections that lose the race are closed.
+func (d *Dialer) dialHappyEyeballs(ctx context.Context, network string, candidates []net.IP, port string) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, len(candidates))
+	started, failed := 0, 0
+	start := func() {
+		remote := candidates[started]
+		started++
+		go func() {
+			conn, err := d.dialFrom(ctx, network, remote, port)
+			results <- dialResult{conn, err}
+		}()
+	}
+
+	clk := d.Clock()
+	start()
+	next := clk.After(HappyEyeballsDelay)
+
+	var firstErr error
+	for {
+		select {
+		case <-next:
+			if started < len(candidates) {
+				start()
+				next = clk.After(HappyEyeballsDelay)
+			}
+
+		case r := <-results:
+			if r.err == nil {
+				// Close whatever the attempts still running produce
+				go func(remaining int) {
+					for i := 0; i < remaining; i++ {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(started - failed - 1)
+				return r.conn, nil
+			}
+
+			failed++
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if failed == len(candidates) {
+				return nil, firstErr
+			}
+			// Nothing in flight: don't wait out the delay
+			if failed == started {
+				start()
+				next = clk.After(HappyEyeballsDelay)
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package peer
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// throttledConn caps the rate of one peer's connection at the socket:
+// reads wait on the download limiter for the bytes just read, so a peer
+// sending too fast is slowed by TCP backpressure, and writes wait on the
+// upload limiter before they go out. It also copies the traffic to a
+// capture, if one is started.
+type throttledConn struct {
+	net.Conn
+	mu       sync.RWMutex
+	download Limiter
+	upload   Limiter
+	capture  atomic.Pointer[Capture] // See Connection.StartCapture
+}
+
+// Read reads from the connection, then waits until the bytes read are
+// within the download cap
+func (c *throttledConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.capture.Load().write(CaptureReceived, b[:n])
+	c.mu.RLock()
+	download := c.download
+	c.mu.RUnlock()
+	if download != nil && n > 0 {
+		download.Wait(int64(n))
+	}
+	return n, err
+}
+
+// Write waits until b is within the upload cap, then writes it
+func (c *throttledConn) Write(b []byte) (int, error) {
+	c.mu.RLock()
+	upload := c.upload
+	c.mu.RUnlock()
+	if upload != nil && len(b) > 0 {
+		upload.Wait(int64(len(b)))
+	}
+	n, err := c.Conn.Write(b)
+	c.capture.Load().write(CaptureSent, b[:n])
+	return n, err
+}
+
+// throttle wraps conn so its rate can be capped later, see
+// Connection.SetPeerRateLimiters
+func throttle(conn net.Conn) net.Conn {
+	if conn == nil {
+		return nil
+	}
+	return &throttledConn{Conn: conn}
+}
+
+// SetPeerRateLimiters caps this peer's connection, every message in either
+// direction, on top of any torrent or session limits; nil leaves a
+// direction uncapped. Limits can be changed or lifted at any time.
+func (c *Connection) SetPeerRateLimiters(download, upload Limiter) {
+	throttled, ok := c.Conn.(*throttledConn)
+	if !ok {
+		return
+	}
+	throttled.mu.Lock()
+	throttled.download = download
+	throttled.upload = upload
+	throttled.mu.Unlock()
+}
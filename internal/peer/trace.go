@@ -0,0 +1,209 @@
+package peer
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"bittorrentclient/internal/clock"
+)
+
+// Trace directions
+const (
+	TraceSent     = "send"
+	TraceReceived = "recv"
+)
+
+// MessageName returns the name of a message ID, for logs and traces
+func MessageName(id byte) string {
+	switch id {
+	case MsgChoke:
+		return "choke"
+	case MsgUnchoke:
+		return "unchoke"
+	case MsgInterested:
+		return "interested"
+	case MsgNotInterested:
+		return "not_interested"
+	case MsgHave:
+		return "have"
+	case MsgBitfield:
+		return "bitfield"
+	case MsgRequest:
+		return "request"
+	case MsgPiece:
+		return "piece"
+	case MsgCancel:
+		return "cancel"
+	case MsgPort:
+		return "port"
+	case MsgSuggestPiece:
+		return "suggest_piece"
+	case MsgHaveAll:
+		return "have_all"
+	case MsgHaveNone:
+		return "have_none"
+	case MsgRejectRequest:
+		return "reject_request"
+	case MsgAllowedFast:
+		return "allowed_fast"
+	case MsgExtended:
+		return "extended"
+	default:
+		return fmt.Sprintf("unknown(%d)", id)
+	}
+}
+
+// TraceEvent is one message sent to or received from a peer
+type TraceEvent struct {
+	Time      time.Time `json:"time"`
+	Peer      string    `json:"peer"`              // Remote address
+	PeerID    string    `json:"peer_id,omitempty"` // Hex, once the handshake told us
+	Direction string    `json:"dir"`               // TraceSent or TraceReceived
+	Type      string    `json:"type"`              // MessageName, or "keep_alive"
+	Size      int       `json:"size"`              // Payload bytes
+	Index     *uint32   `json:"index,omitempty"`   // Piece index, for messages about a piece
+	Begin     *uint32   `json:"begin,omitempty"`   // Block offset, for messages about a block
+	Length    *uint32   `json:"length,omitempty"`  // Block length, for messages about a block
+	Extension *byte     `json:"ext,omitempty"`     // Extended message ID (BEP 10)
+}
+
+// Trace records every message sent and received on the connections it is
+// set on, for debugging interop problems with specific clients. The most
+// recent events are kept in a ring buffer for Export; each event can also
+// be written to a log as a JSON line as it happens.
+type Trace struct {
+	mu     sync.Mutex
+	events []TraceEvent // Ring buffer, oldest at next once full
+	next   int
+	full   bool
+	out    io.Writer // Every event as a JSON line; nil writes none
+	err    error     // First error writing to out, after which it is dropped
+	clock  clock.Clock
+}
+
+// NewTrace creates a trace keeping the last size events (0 keeps none) and
+// writing every event to out, if not nil
+func NewTrace(size int, out io.Writer) *Trace {
+	return &Trace{
+		events: make([]TraceEvent, size),
+		out:    out,
+		clock:  clock.Real,
+	}
+}
+
+// SetClock replaces the clock events are timestamped with
+func (t *Trace) SetClock(c clock.Clock) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clock = c
+}
+
+// Record traces msg, sent to or received from p in direction dir. A nil
+// msg is a keep-alive. Safe to call on a nil Trace, which records nothing.
+func (t *Trace) Record(p *Peer, dir string, msg *Message) {
+	if t == nil {
+		return
+	}
+
+	event := TraceEvent{Direction: dir, Type: "keep_alive"}
+	if p.Conn != nil && p.Conn.RemoteAddr() != nil {
+		event.Peer = p.Conn.RemoteAddr().String()
+	}
+	if p.ID != ([20]byte{}) {
+		event.PeerID = hex.EncodeToString(p.ID[:])
+	}
+	if msg != nil {
+		event.Type = MessageName(msg.ID)
+		event.Size = len(msg.Payload)
+		describePayload(&event, msg)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	event.Time = t.clock.Now()
+	if len(t.events) > 0 {
+		t.events[t.next] = event
+		t.next = (t.next + 1) % len(t.events)
+		t.full = t.full || t.next == 0
+	}
+	if t.out != nil {
+		line, _ := json.Marshal(event)
+		if _, err := t.out.Write(append(line, '\n')); err != nil {
+			t.err = err
+			t.out = nil
+		}
+	}
+}
+
+// describePayload fills in the piece index, block and extension fields of
+// the messages that carry them
+func describePayload(event *TraceEvent, msg *Message) {
+	field := func(i int) *uint32 {
+		if len(msg.Payload) < 4*(i+1) {
+			return nil
+		}
+		v := binary.BigEndian.Uint32(msg.Payload[4*i:])
+		return &v
+	}
+
+	switch msg.ID {
+	case MsgHave, MsgSuggestPiece, MsgAllowedFast:
+		event.Index = field(0)
+	case MsgRequest, MsgCancel, MsgRejectRequest:
+		event.Index, event.Begin, event.Length = field(0), field(1), field(2)
+	case MsgPiece:
+		event.Index, event.Begin = field(0), field(1)
+		if len(msg.Payload) >= 8 {
+			length := uint32(len(msg.Payload) - 8)
+			event.Length = &length
+		}
+	case MsgExtended:
+		if len(msg.Payload) > 0 {
+			ext := msg.Payload[0]
+			event.Extension = &ext
+		}
+	}
+}
+
+// Events returns the buffered events, oldest first
+func (t *Trace) Events() []TraceEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.full {
+		return append([]TraceEvent(nil), t.events[:t.next]...)
+	}
+	events := make([]TraceEvent, 0, len(t.events))
+	events = append(events, t.events[t.next:]...)
+	return append(events, t.events[:t.next]...)
+}
+
+// Export writes the buffered events to w as JSON lines, oldest first
+func (t *Trace) Export(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	for _, event := range t.Events() {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Err returns the error that stopped events being written to the log, if
+// any
+func (t *Trace) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// SetTrace records every message sent and received on the connection in
+// trace; nil stops tracing
+func (p *Peer) SetTrace(trace *Trace) {
+	p.trace.Store(trace)
+}
@@ -0,0 +1,175 @@
+// Package peerid generates the client's peer ID in Azureus style
+// ("-XXVVVV-" followed by 12 random bytes) and holds the identity that
+// the handshake and tracker announces share.
+package peerid
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	DefaultClientCode    = "BC"   // Two-character client code
+	DefaultClientVersion = "0100" // Four-character client version
+)
+
+// Policy describes how peer IDs are generated
+type Policy struct {
+	ClientCode string
+	Version    string
+}
+
+// DefaultPolicy returns the policy for this client
+func DefaultPolicy() Policy {
+	return Policy{ClientCode: DefaultClientCode, Version: DefaultClientVersion}
+}
+
+// Validate checks that the policy produces a well-formed prefix
+func (p Policy) Validate() error {
+	if len(p.ClientCode) != 2 {
+		return fmt.Errorf("client code must be 2 characters, got %q", p.ClientCode)
+	}
+	if len(p.Version) != 4 {
+		return fmt.Errorf("client version must be 4 characters, got %q", p.Version)
+	}
+	for _, c := range []byte(p.ClientCode + p.Version) {
+		if c < 0x21 || c > 0x7e || c == '-' {
+			return fmt.Errorf("invalid character %q in client prefix", c)
+		}
+	}
+	return nil
+}
+
+// Prefix returns the "-XXVVVV-" prefix of generated IDs
+func (p Policy) Prefix() string {
+	return "-" + p.ClientCode + p.Version + "-"
+}
+
+// Generate creates a new peer ID
+func (p Policy) Generate() ([20]byte, error) {
+	var id [20]byte
+	if err := p.Validate(); err != nil {
+		return id, err
+	}
+
+	prefix := p.Prefix()
+	copy(id[:], prefix)
+
+	randomMu.Lock()
+	defer randomMu.Unlock()
+	if _, err := io.ReadFull(random, id[len(prefix):]); err != nil {
+		return id, fmt.Errorf("failed to generate peer ID: %w", err)
+	}
+	return id, nil
+}
+
+var (
+	randomMu sync.Mutex
+	random   io.Reader = rand.Reader // Source of the random part of generated IDs
+)
+
+// SetSeed makes generated peer IDs repeat for the same seed instead of
+// coming from crypto/rand, for reproducing a run exactly. Never use it for
+// real swarms. It must be called before the first call to Default.
+func SetSeed(seed int64) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if identity != nil {
+		return fmt.Errorf("peer ID already generated")
+	}
+
+	randomMu.Lock()
+	defer randomMu.Unlock()
+	random = mathrand.New(mathrand.NewSource(seed))
+	return nil
+}
+
+var (
+	mu        sync.Mutex
+	policy    = DefaultPolicy()
+	identity  *[20]byte
+	persisted string // File the identity was loaded from or saved to; empty if it rotates per run
+)
+
+// SetPolicy changes the policy used for the shared identity. It must be
+// called before the first call to Default.
+func SetPolicy(p Policy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if identity != nil {
+		return fmt.Errorf("peer ID already generated")
+	}
+	policy = p
+	return nil
+}
+
+// Persist makes the shared identity stable across restarts by keeping it
+// in the file at path, which is created on first use. Some private
+// trackers expect a stable peer ID; without Persist a new one is generated
+// every run. The random part is kept when the client prefix changes (e.g.
+// after an upgrade), so the ID still reports the running version. It must
+// be called before the first call to Default.
+func Persist(path string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if identity != nil {
+		if persisted == path {
+			return nil
+		}
+		return fmt.Errorf("peer ID already generated")
+	}
+
+	id, err := policy.Generate()
+	if err != nil {
+		return err
+	}
+
+	// Keep the stored random part; the prefix follows the running version
+	prefix := policy.Prefix()
+	stored, err := os.ReadFile(path)
+	if err == nil && len(stored) == len(id) {
+		copy(id[len(prefix):], stored[len(prefix):])
+	}
+
+	if !bytes.Equal(stored, id[:]) {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return fmt.Errorf("failed to create peer ID directory: %w", err)
+		}
+		if err := os.WriteFile(path, id[:], 0600); err != nil {
+			return fmt.Errorf("failed to save peer ID: %w", err)
+		}
+	}
+
+	identity = &id
+	persisted = path
+	return nil
+}
+
+// Default returns the process-wide peer ID, generating it on first use
+func Default() [20]byte {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if identity == nil {
+		id, err := policy.Generate()
+		if err != nil {
+			// The policy was validated, so only the random source can fail
+			panic(err)
+		}
+		identity = &id
+	}
+	return *identity
+}
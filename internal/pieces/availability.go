@@ -0,0 +1,49 @@
+package piece
+
+// AddBitfield counts a peer's pieces, those set in bitfield, towards their
+// availability. Together with RemoveBitfield and AddPiece it makes the
+// manager a peer.AvailabilityTracker, which connections keep up to date
+// from the Bitfield, Have All/None and Have messages their peers send.
+func (m *Manager) AddBitfield(bitfield []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.countBitfield(bitfield, 1)
+}
+
+// RemoveBitfield takes back AddBitfield, when the peer goes away or sends
+// another bitfield
+func (m *Manager) RemoveBitfield(bitfield []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.countBitfield(bitfield, -1)
+}
+
+// AddPiece counts one more peer having a piece, announced with a Have
+func (m *Manager) AddPiece(index int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if index >= 0 && index < len(m.availability) {
+		m.availability[index]++
+	}
+}
+
+// GetAvailability returns how many connected peers have each piece
+func (m *Manager) GetAvailability() []int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]int(nil), m.availability...)
+}
+
+// countBitfield adds delta to the availability of every piece set in
+// bitfield. Caller must hold m.mu.
+func (m *Manager) countBitfield(bitfield []byte, delta int) {
+	for i := range m.availability {
+		if !m.peerHasPiece(i, bitfield) {
+			continue
+		}
+		m.availability[i] += delta
+		if m.availability[i] < 0 {
+			m.availability[i] = 0
+		}
+	}
+}
@@ -1,14 +1,33 @@
 package piece
 
 import (
+	"bittorrentclient/internal/clock"
+	"bittorrentclient/internal/fdbudget"
 	"bittorrentclient/internal/file"
 	"fmt"
-	"math/rand"
+	"runtime"
 	"strings"
 	"sync"
 	"time"
 )
 
+// PieceVerifiedFunc is called when a piece passes hash verification, before
+// it is written to its files. data holds the verified bytes and must not be
+// modified or retained after the call returns; copy it if needed. The hook
+// runs with the manager locked, so it must not call back into the Manager.
+type PieceVerifiedFunc func(pieceIndex int, data []byte)
+
+// FileCompleteEvent describes a file whose pieces are all verified and on disk
+type FileCompleteEvent struct {
+	FileIndex int
+	Path      string // Final on-disk path
+	Length    int64
+}
+
+// FileCompleteFunc is called when the last piece of a file is verified and
+// written. Like PieceVerifiedFunc it runs with the manager locked.
+type FileCompleteFunc func(event FileCompleteEvent)
+
 // Manager manages all pieces for a torrent
 type Manager struct {
 	mu             sync.RWMutex
@@ -16,19 +35,42 @@ type Manager struct {
 	totalPieces    int
 	pieceLength    int64
 	totalLength    int64
-	downloaded     int
 	pendingPieces  map[int]*Piece      // Pieces currently being downloaded
 	completePieces map[int]bool        // Completed pieces
 	requests       map[string]*Request // Outstanding requests (key: "pieceIndex:begin")
+	availability   []int               // Per piece: connected peers that have it, see AddBitfield
 
 	// File system integration - Add these fields
-	fileWriter *file.Writer
-	fileMapper *file.Mapper
-	resumeData map[int]bool // For resume capability
-
-	// Statistics
-	downloadedBytes int64
-	startTime       time.Time
+	fileWriter  *file.Writer
+	fileMapper  *file.Mapper
+	resumePath  string          // Where to persist completion state; empty disables resume
+	resumePeers func() []string // Supplies peers to persist with the resume data
+	loadedPeers []string        // Peers read from the resume data
+	renamed     bool            // Files were renamed since the resume data was last saved
+	onVerified  PieceVerifiedFunc
+	onFileDone  FileCompleteFunc
+	fileMissing []int             // Per file: overlapping pieces not yet complete
+	wanted      []bool            // Pieces to download; nil means all of them
+	priorities  []file.Priority   // Per piece, from its files' priorities; nil means all normal
+	verifyRate  int64             // Read limit for VerifyExistingData in bytes/second; 0 is unlimited
+	unverified  map[int]bool      // Pieces assumed complete by seed mode, hashed on first read
+	readCheck   bool              // Re-hash pieces whose files changed on disk before serving them
+	readChecked map[int]time.Time // Per piece: the file change it was last re-hashed against
+	corrupt     map[int]bool      // Pieces that no longer match on disk; never served
+	journal     *file.Journal
+	journalPath string // Where to keep the journal of verified pieces; empty disables it
+
+	pieceStarted  map[int]time.Time // When each in-progress piece's first block arrived
+	completionLog CompletionLog     // Downloaded pieces in completion order
+
+	// Statistics - piece and byte counts live in progress, shared with the writer
+	progress      *file.Progress
+	hashFailures  int   // Total failed piece verifications
+	hashFailBytes int64 // Bytes thrown away because their piece failed verification
+	wastedBytes   int64 // Bytes of duplicate blocks or blocks for completed pieces
+	err           error // Set when the torrent can't make progress (e.g. poisoned piece)
+
+	clock clock.Clock
 }
 
 func (m *Manager) GetTotalPieces() int {
@@ -36,7 +78,17 @@ func (m *Manager) GetTotalPieces() int {
 }
 
 func (m *Manager) GetDownloaded() int {
-	return m.downloaded
+	return m.progress.GetCompletedPieceCount()
+}
+
+// GetDownloadedBytes returns the number of verified bytes downloaded
+func (m *Manager) GetDownloadedBytes() int64 {
+	return m.progress.GetVerifiedBytes()
+}
+
+// GetTotalLength returns the total torrent size in bytes
+func (m *Manager) GetTotalLength() int64 {
+	return m.totalLength
 }
 
 func (m *Manager) GetPieces() []*Piece {
@@ -58,12 +110,16 @@ func NewManager(pieces [][20]byte, pieceLength int64, totalLength int64, fileInf
 		pieces:         make([]*Piece, len(pieces)),
 		pendingPieces:  make(map[int]*Piece),
 		completePieces: make(map[int]bool),
+		pieceStarted:   make(map[int]time.Time),
 		requests:       make(map[string]*Request),
+		availability:   make([]int, len(pieces)),
 		fileWriter:     writer,
 		fileMapper:     mapper,
-		resumeData:     make(map[int]bool),
-		startTime:      time.Now(),
+		progress:       writer.GetProgress(),
+		clock:          clock.Real,
 	}
+	manager.progress.SetTotalPieces(len(pieces))
+	writer.SetFlushHook(manager.journalPieces)
 
 	// Initialize pieces
 	for i, hash := range pieces {
@@ -77,9 +133,32 @@ func NewManager(pieces [][20]byte, pieceLength int64, totalLength int64, fileInf
 		manager.pieces[i] = NewPiece(i, hash, length)
 	}
 
+	// Count the pieces each file overlaps; zero-length files overlap none
+	manager.fileMissing = make([]int, len(fileInfos))
+	for i, info := range fileInfos {
+		if info.Length > 0 {
+			first := info.Offset / pieceLength
+			last := (info.Offset + info.Length - 1) / pieceLength
+			manager.fileMissing[i] = int(last - first + 1)
+		}
+	}
+
 	return manager
 }
 
+// SetMerkleHashes makes pieces verify against SHA-256 merkle hashes, as a
+// BitTorrent v2 torrent's do, instead of SHA-1. hashes has one entry per
+// piece. Must be called before Initialize.
+func (m *Manager) SetMerkleHashes(hashes []MerkleHash) error {
+	if len(hashes) != len(m.pieces) {
+		return fmt.Errorf("%d merkle hashes for %d pieces", len(hashes), len(m.pieces))
+	}
+	for i := range hashes {
+		m.pieces[i].V2 = &hashes[i]
+	}
+	return nil
+}
+
 // Initialize sets up the file system
 func (m *Manager) Initialize() error {
 	m.mu.Lock()
@@ -94,64 +173,174 @@ func (m *Manager) Initialize() error {
 	// Check for existing files and resume data
 	err = m.loadResumeData()
 	if err != nil {
-		fmt.Printf("No resume data found, starting fresh download\n")
+		fmt.Printf("No resume data found, starting fresh download (%v)\n", err)
+	}
+
+	// Record new names right away, so they survive a crash
+	if m.renamed {
+		m.saveResumeData()
+		m.renamed = false
+	}
+
+	// Rebuild completion state from the journal of verified pieces
+	if m.journalPath != "" {
+		if err := m.replayJournal(); err != nil {
+			fmt.Printf("Failed to replay journal, starting without it: %v\n", err)
+		}
 	}
 
 	return nil
 }
 
-// GetPieceToRequest returns the next piece that should be requested
-func (m *Manager) GetPieceToRequest(peerBitfield []byte) *Piece {
+// SetPieceVerifiedHook sets a callback fired for every verified piece
+func (m *Manager) SetPieceVerifiedHook(fn PieceVerifiedFunc) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.onVerified = fn
+}
 
-	// If this is our first piece, pick randomly for faster start
-	if m.downloaded == 0 {
-		return m.getRandomAvailablePiece(peerBitfield)
-	}
+// SetFileCompleteHook sets a function called as each file completes during
+// the download. Files already complete when restored from resume data or
+// the journal don't fire it.
+func (m *Manager) SetFileCompleteHook(fn FileCompleteFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onFileDone = fn
+}
 
-	// Use rarest first strategy
-	return m.getRarestPiece(peerBitfield)
+// IsFileComplete returns true if every piece overlapping a file is complete
+func (m *Manager) IsFileComplete(fileIndex int) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if fileIndex < 0 || fileIndex >= len(m.fileMissing) {
+		return false
+	}
+	return m.fileMissing[fileIndex] == 0
 }
 
-// getRandomAvailablePiece gets a random piece for first download
-func (m *Manager) getRandomAvailablePiece(peerBitfield []byte) *Piece {
-	var available []int
+// countFilePieces records that a piece is complete in every file it
+// overlaps, firing the file-complete hook for files it finishes when notify
+// is set. Caller must hold m.mu.
+func (m *Manager) countFilePieces(pieceIndex int, notify bool) {
+	mapping, err := m.fileMapper.GetPieceMapping(pieceIndex)
+	if err != nil {
+		return
+	}
 
-	for i := 0; i < m.totalPieces; i++ {
-		if m.isPieceAvailable(i, peerBitfield) {
-			available = append(available, i)
+	for _, fileRange := range mapping.FileRanges {
+		i := fileRange.FileIndex
+		if m.fileMissing[i] == 0 {
+			continue
+		}
+		m.fileMissing[i]--
+		if m.fileMissing[i] > 0 || !notify || fileRange.Discard {
+			continue
+		}
+
+		// The event promises the file is written, not just in the cache
+		if err := m.fileWriter.Flush(); err != nil {
+			fmt.Printf("⚠️  Failed to flush completed file: %v\n", err)
+		}
+
+		event := FileCompleteEvent{
+			FileIndex: i,
+			Path:      m.fileWriter.FilePath(i),
+			Length:    m.fileMapper.GetAllFiles()[i].Length,
+		}
+		fmt.Printf("📁 File complete: %s\n", event.Path)
+		if m.onFileDone != nil {
+			m.onFileDone(event)
 		}
 	}
+}
 
-	if len(available) == 0 {
-		return nil
+// SetJournalPath enables the piece journal at path. Must be called before Initialize.
+func (m *Manager) SetJournalPath(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.journalPath = path
+}
+
+// journalPieces records pieces the writer has synced to disk, so a crash
+// after this point doesn't cost a re-download. It runs as the writer's
+// flush hook; every flush happens with m.mu held.
+func (m *Manager) journalPieces(pieces []int) {
+	if m.journal == nil {
+		return
+	}
+	if err := m.journal.Append(pieces...); err != nil {
+		fmt.Printf("⚠️  Failed to journal pieces %v: %v\n", pieces, err)
 	}
+}
 
-	index := available[rand.Intn(len(available))]
-	piece := m.pieces[index]
-	m.pendingPieces[index] = piece
-	return piece
+// SetAllocationStrategy overrides the automatic choice of how the
+// torrent's files are allocated; call it before Initialize
+func (m *Manager) SetAllocationStrategy(strategy file.AllocationStrategy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fileWriter.SetAllocationStrategy(strategy)
 }
 
-// getRarestPiece implements rarest first strategy (simplified)
-func (m *Manager) getRarestPiece(peerBitfield []byte) *Piece {
-	// For now, just return the first available piece
-	// In a full implementation, you'd track piece rarity across all peers
-	for i := 0; i < m.totalPieces; i++ {
-		if m.isPieceAvailable(i, peerBitfield) {
-			piece := m.pieces[i]
-			m.pendingPieces[i] = piece
-			return piece
+// SetWriteCache buffers up to limit bytes of verified pieces in memory and
+// writes them in sorted batches (0 writes each piece as it is verified)
+func (m *Manager) SetWriteCache(limit int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.fileWriter.SetWriteCache(limit)
+}
+
+// SetFDBudget makes the manager's open file handles count against a
+// session-wide descriptor budget
+func (m *Manager) SetFDBudget(budget *fdbudget.Budget) {
+	m.fileWriter.SetFDBudget(budget)
+}
+
+// Flush writes out everything in the write cache
+func (m *Manager) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.fileWriter.Flush()
+}
+
+// FlushStale writes out the write cache if it has held data for longer
+// than file.WriteCacheMaxAge
+func (m *Manager) FlushStale() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.fileWriter.FlushExpired(file.WriteCacheMaxAge)
+}
+
+// replayJournal opens the journal and marks every recorded piece complete.
+// Caller must hold m.mu.
+func (m *Manager) replayJournal() error {
+	journal, err := file.OpenJournal(m.journalPath)
+	if err != nil {
+		return err
+	}
+	m.journal = journal
+
+	indices, err := journal.Replay(m.totalPieces)
+	if err != nil {
+		return err
+	}
+
+	for _, index := range indices {
+		if err := m.markComplete(index); err != nil {
+			return err
 		}
 	}
+
+	if len(indices) > 0 {
+		fmt.Printf("Recovered %d pieces from journal\n", len(indices))
+	}
 	return nil
 }
 
 // isPieceAvailable checks if a piece can be requested
 func (m *Manager) isPieceAvailable(index int, peerBitfield []byte) bool {
-	// Check if we already have this piece
-	if m.completePieces[index] {
+	// Check if we already have this piece, or don't want it at all
+	if m.completePieces[index] || !m.isWanted(index) {
 		return false
 	}
 
@@ -168,6 +357,14 @@ func (m *Manager) isPieceAvailable(index int, peerBitfield []byte) bool {
 	return true
 }
 
+// isPieceAvailableFor checks if a piece can be requested from a specific peer
+func (m *Manager) isPieceAvailableFor(index int, peerBitfield []byte, peerID [20]byte) bool {
+	if !m.isPieceAvailable(index, peerBitfield) {
+		return false
+	}
+	return !m.pieces[index].ShouldAvoidPeer(peerID)
+}
+
 // peerHasPiece checks if peer has a specific piece
 func (m *Manager) peerHasPiece(index int, bitfield []byte) bool {
 	if bitfield == nil {
@@ -194,7 +391,7 @@ func (m *Manager) AddRequest(pieceIndex, begin, length int, peerID [20]byte) {
 		PieceIndex: int64(pieceIndex),
 		Begin:      int64(begin),
 		Length:     int64(length),
-		Requested:  time.Now(),
+		Requested:  m.clock.Now(),
 		PeerID:     peerID,
 	}
 }
@@ -208,21 +405,22 @@ func (m *Manager) RemoveRequest(pieceIndex, begin int) {
 	delete(m.requests, key)
 }
 
-// HandlePieceMessage processes incoming piece data
-func (m *Manager) HandlePieceMessage(pieceIndex int, begin int64, data []byte) error {
+// HandlePieceMessage processes incoming piece data from a peer
+func (m *Manager) HandlePieceMessage(peerID [20]byte, pieceIndex int, begin int64, data []byte) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	key := fmt.Sprintf("%d:%d", pieceIndex, begin)
 	delete(m.requests, key)
 
-	if pieceIndex >= len(m.pieces) {
+	if pieceIndex < 0 || pieceIndex >= len(m.pieces) {
 		return fmt.Errorf("invalid piece index: %d", pieceIndex)
 	}
 	piece := m.pieces[pieceIndex]
 
 	// Don't process blocks for already completed pieces
-	if piece.IsComplete() {
+	if piece.IsComplete() || piece.HasBlock(begin) {
+		m.wastedBytes += int64(len(data))
 		return nil
 	}
 
@@ -230,38 +428,59 @@ func (m *Manager) HandlePieceMessage(pieceIndex int, begin int64, data []byte) e
 	if err != nil {
 		return fmt.Errorf("failed to set block: %w", err)
 	}
+	piece.AddContributor(begin, peerID)
+	m.recordBlockStart(pieceIndex)
 
 	// Check if the piece is now fully downloaded (all blocks received)
 	if piece.IsComplete() {
 		// Validate the piece hash
 		if piece.Validate() {
 			fmt.Printf("✅ Piece %d validated successfully!\n", pieceIndex)
+			if m.onVerified != nil {
+				m.onVerified(pieceIndex, piece.Data)
+			}
 			err := m.fileWriter.WritePiece(pieceIndex, piece.Data)
 			if err != nil {
 				fmt.Printf("❌ Failed to write piece %d to file: %v\n", pieceIndex, err)
 				piece.Reset() // Reset piece to re-download
 				delete(m.pendingPieces, pieceIndex)
+				m.forgetBlockStart(pieceIndex)
 				return fmt.Errorf("failed to write piece to file: %w", err)
 			}
 
+			// The piece is journaled by journalPieces once it is synced,
+			// which with the write cache may be a little later
+
 			// Mark as complete and update stats
 			m.completePieces[pieceIndex] = true
-			m.downloaded++
-			m.downloadedBytes += piece.Length
+			m.progress.AddCompletedPiece(piece.Length)
 			delete(m.pendingPieces, pieceIndex)
+			m.countFilePieces(pieceIndex, true)
+			m.recordCompletion(piece)
 
+			completed := m.progress.GetCompletedPieceCount()
 			fmt.Printf("Piece %d completed! Progress: %d/%d (%.1f%%)\n",
-				pieceIndex, m.downloaded, m.totalPieces, m.GetProgress())
+				pieceIndex, completed, m.totalPieces, m.progress.GetPieceProgressPercent())
 
-			if m.downloaded%10 == 0 {
+			if completed%10 == 0 {
 				m.saveResumeData()
 			}
 		} else {
-			// If validation fails, reset the piece so it can be downloaded again.
-			fmt.Printf("Piece %d failed validation, retrying...\n", pieceIndex)
-			piece.Reset()
+			// If validation fails, drop the suspect blocks so they can be
+			// downloaded again; blocks from other peers are kept
+			discarded := piece.RecordHashFailure()
+			m.hashFailures++
+			m.hashFailBytes += discarded
+			fmt.Printf("Piece %d failed validation (%d/%d failures), retrying %d of %d bytes...\n",
+				pieceIndex, piece.HashFailures, MaxPieceFailures, discarded, piece.Length)
 			m.cleanupPieceRequests(pieceIndex)
 			delete(m.pendingPieces, pieceIndex)
+			m.forgetBlockStart(pieceIndex)
+
+			if piece.HashFailures >= MaxPieceFailures && m.err == nil {
+				m.err = fmt.Errorf("piece %d failed hash verification %d times", pieceIndex, piece.HashFailures)
+				fmt.Printf("❌ Torrent errored: %v\n", m.err)
+			}
 		}
 	}
 
@@ -274,7 +493,7 @@ func (m *Manager) GetTimeoutRequests() []*Request {
 	defer m.mu.RUnlock()
 
 	var timeouts []*Request
-	now := time.Now()
+	now := m.clock.Now()
 
 	for _, req := range m.requests {
 		if now.Sub(req.Requested) > RequestTimeout {
@@ -290,31 +509,68 @@ func (m *Manager) GetProgress() float64 {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	if m.totalPieces == 0 {
-		return 0
+	if m.wanted == nil && m.priorities == nil {
+		return m.progress.GetPieceProgressPercent()
 	}
 
-	return float64(m.downloaded) / float64(m.totalPieces) * 100
+	wanted, done := m.wantedCounts()
+	if wanted == 0 {
+		return 100
+	}
+	return float64(done) / float64(wanted) * 100
 }
 
-// IsComplete returns true if all pieces are downloaded
+// IsComplete returns true if all pieces are downloaded, or with a restricted
+// piece set or skipped files, all wanted pieces
 func (m *Manager) IsComplete() bool {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	return m.downloaded == m.totalPieces
+	if m.wanted == nil && m.priorities == nil {
+		return m.progress.IsComplete()
+	}
+
+	wanted, done := m.wantedCounts()
+	return done == wanted
 }
 
-// GetDownloadSpeed returns current download speed in bytes/second
-func (m *Manager) GetDownloadSpeed() float64 {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+// SetWantedPieces restricts the download to the pieces set in wanted; the
+// rest are never requested. A nil slice wants every piece again.
+func (m *Manager) SetWantedPieces(wanted []bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if wanted != nil && len(wanted) != m.totalPieces {
+		return fmt.Errorf("wanted set has %d pieces, torrent has %d", len(wanted), m.totalPieces)
+	}
+	m.wanted = wanted
+	return nil
+}
+
+// isWanted returns true if a piece should be downloaded: it is in the
+// wanted set and overlaps a file that isn't skipped. Caller must hold m.mu.
+func (m *Manager) isWanted(index int) bool {
+	return (m.wanted == nil || m.wanted[index]) && m.piecePriority(index) != file.PrioritySkip
+}
 
-	elapsed := time.Since(m.startTime).Seconds()
-	if elapsed == 0 {
-		return 0
+// wantedCounts returns how many pieces are wanted and how many of those are
+// complete. Caller must hold m.mu.
+func (m *Manager) wantedCounts() (wanted, done int) {
+	for i := 0; i < m.totalPieces; i++ {
+		if !m.isWanted(i) {
+			continue
+		}
+		wanted++
+		if m.completePieces[i] {
+			done++
+		}
 	}
-	return float64(m.downloadedBytes) / elapsed
+	return wanted, done
+}
+
+// GetDownloadSpeed returns current download speed in bytes/second
+func (m *Manager) GetDownloadSpeed() float64 {
+	return m.progress.GetDownloadSpeed()
 }
 
 // GetCompletedPieces returns a copy of completed pieces map
@@ -329,20 +585,456 @@ func (m *Manager) GetCompletedPieces() map[int]bool {
 	return completed
 }
 
-// saveResumeData saves current progress for resume capability
+// GetNeededPieces returns the indices of wanted pieces not yet complete
+func (m *Manager) GetNeededPieces() []int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var needed []int
+	for i := 0; i < m.totalPieces; i++ {
+		if m.isWanted(i) && !m.completePieces[i] {
+			needed = append(needed, i)
+		}
+	}
+	return needed
+}
+
+// HasPiece returns true if a piece is verified and on disk
+func (m *Manager) HasPiece(index int) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.completePieces[index]
+}
+
+// ReadBlock reads a block of a verified piece back from disk, for serving
+// it to a peer
+func (m *Manager) ReadBlock(index int, begin, length int64) ([]byte, error) {
+	if !m.HasPiece(index) {
+		return nil, fmt.Errorf("piece %d is not complete", index)
+	}
+
+	piece := m.pieces[index]
+	if begin < 0 || length <= 0 || begin+length > piece.Length {
+		return nil, fmt.Errorf("block %d+%d out of range for piece %d", begin, length, index)
+	}
+
+	m.mu.RLock()
+	unverified := m.unverified[index]
+	readCheck := m.readCheck
+	m.mu.RUnlock()
+	if unverified {
+		// Verifying needs the whole piece; it's served from that read
+		data, err := m.fileWriter.ReadPiece(index)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.verifySeeded(index, data); err != nil {
+			return nil, err
+		}
+		return data[begin : begin+length], nil
+	}
+	if readCheck {
+		if err := m.checkOnRead(index); err != nil {
+			return nil, err
+		}
+	}
+
+	// Only the block is read, not the whole piece
+	return m.fileWriter.ReadBlock(index, begin, length)
+}
+
+// SetVerifyOnRead makes ReadBlock re-hash a piece before serving it if one
+// of its files was modified since we last wrote it, so data the user
+// edited or the disk silently corrupted isn't uploaded to the swarm
+func (m *Manager) SetVerifyOnRead(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.readCheck = enabled
+	if m.readChecked == nil {
+		m.readChecked = make(map[int]time.Time)
+		m.corrupt = make(map[int]bool)
+	}
+}
+
+// checkOnRead re-reads and re-hashes a piece once per change to its files,
+// refusing it if it no longer matches the torrent
+func (m *Manager) checkOnRead(index int) error {
+	modified, changed, err := m.fileWriter.PieceModified(index)
+	if err != nil {
+		return err
+	}
+	if !modified {
+		return nil
+	}
+
+	m.mu.RLock()
+	checked, ok := m.readChecked[index]
+	corrupt := m.corrupt[index]
+	m.mu.RUnlock()
+	if ok && checked.Equal(changed) {
+		if corrupt {
+			return fmt.Errorf("piece %d no longer matches the torrent on disk", index)
+		}
+		return nil
+	}
+
+	data, err := m.fileWriter.ReadPiece(index)
+	if err != nil {
+		return err
+	}
+	matches := m.pieces[index].Matches(data)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.readChecked[index] = changed
+	if !matches {
+		if !m.corrupt[index] {
+			m.corrupt[index] = true
+			m.hashFailures++
+			fmt.Printf("⚠️  Piece %d changed on disk and no longer matches the torrent, not serving it\n", index)
+		}
+		return fmt.Errorf("piece %d no longer matches the torrent on disk", index)
+	}
+	delete(m.corrupt, index)
+	return nil
+}
+
+// SetSeedMode marks every piece complete without hashing, for data known to
+// be complete. Each piece is verified the first time a peer asks for it
+// instead; one that turns out not to match is marked missing again, to be
+// downloaded. Must be called after Initialize.
+func (m *Manager) SetSeedMode() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.unverified == nil {
+		m.unverified = make(map[int]bool)
+	}
+	for i := 0; i < m.totalPieces; i++ {
+		if m.completePieces[i] {
+			continue // Already verified or restored from resume data
+		}
+		if err := m.markComplete(i); err != nil {
+			return err
+		}
+		m.unverified[i] = true
+	}
+	return nil
+}
+
+// verifySeeded hashes a piece seed mode assumed complete. One that doesn't
+// match is marked missing, so the download picks it up again.
+func (m *Manager) verifySeeded(index int, data []byte) error {
+	matches := m.pieces[index].Matches(data)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.unverified[index] {
+		return nil // Verified by a concurrent read
+	}
+	if !matches {
+		m.hashFailures++
+		fmt.Printf("⚠️  Seed mode: piece %d does not match the torrent, downloading it again\n", index)
+		if err := m.unmarkComplete(index); err != nil {
+			return err
+		}
+		return fmt.Errorf("seed mode: piece %d does not match the torrent", index)
+	}
+	delete(m.unverified, index)
+	return nil
+}
+
+// markComplete marks a piece that is already on disk as complete, updating
+// the piece, the completion map and progress. Caller must hold m.mu.
+func (m *Manager) markComplete(index int) error {
+	if index < 0 || index >= m.totalPieces {
+		return fmt.Errorf("invalid piece index: %d", index)
+	}
+	if m.completePieces[index] {
+		return nil
+	}
+
+	if err := m.fileWriter.MarkPieceWritten(index); err != nil {
+		return err
+	}
+
+	piece := m.pieces[index]
+	piece.Complete = true
+	for i := range piece.Downloaded {
+		piece.Downloaded[i] = true
+	}
+
+	m.completePieces[index] = true
+	delete(m.pendingPieces, index)
+	m.progress.AddCompletedPiece(piece.Length)
+	m.countFilePieces(index, false)
+	return nil
+}
+
+// SetCompleted marks every piece set in bitfield as complete, e.g. when
+// restoring resume state. Pieces are assumed to be on disk already.
+func (m *Manager) SetCompleted(bitfield []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.setCompleted(bitfield)
+}
+
+// setCompleted implements SetCompleted. Caller must hold m.mu.
+func (m *Manager) setCompleted(bitfield []byte) error {
+	if len(bitfield) != (m.totalPieces+7)/8 {
+		return fmt.Errorf("bitfield length %d does not match %d pieces", len(bitfield), m.totalPieces)
+	}
+
+	for i := 0; i < m.totalPieces; i++ {
+		if !m.peerHasPiece(i, bitfield) {
+			continue
+		}
+		if err := m.markComplete(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetBitfield returns our completed pieces as a wire-format bitfield
+func (m *Manager) GetBitfield() []byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.bitfield()
+}
+
+// bitfield builds the completed-pieces bitfield. Caller must hold m.mu.
+func (m *Manager) bitfield() []byte {
+	bitfield := make([]byte, (m.totalPieces+7)/8)
+	for index := range m.completePieces {
+		bitfield[index/8] |= 1 << (7 - index%8)
+	}
+	return bitfield
+}
+
+// SetResumePath enables persisting completion state at path, and stores
+// files renamed in an earlier run under their new names. Must be called
+// before Initialize.
+func (m *Manager) SetResumePath(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resumePath = path
+
+	if data, err := file.LoadResumeData(path); err == nil && len(data.Renames) > 0 {
+		if err := m.fileWriter.SetFileRenames(data.Renames); err != nil {
+			fmt.Printf("⚠️  Failed to restore file names: %v\n", err)
+		}
+	}
+}
+
+// SetResumePeersFunc sets the function asked for peer addresses to store
+// in the resume data, so they can be tried first on restart
+func (m *Manager) SetResumePeersFunc(fn func() []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resumePeers = fn
+}
+
+// GetResumePeers returns the peer addresses loaded from the resume data
+func (m *Manager) GetResumePeers() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	peers := make([]string, len(m.loadedPeers))
+	copy(peers, m.loadedPeers)
+	return peers
+}
+
+// saveResumeData saves current progress for resume capability.
+// Caller must hold m.mu.
 func (m *Manager) saveResumeData() {
-	// Simple implementation - in production you'd save to a file
-	m.resumeData = make(map[int]bool)
-	for k, v := range m.completePieces {
-		m.resumeData[k] = v
+	if m.resumePath == "" {
+		return
+	}
+
+	// The bitfield claims every complete piece is on disk
+	if err := m.fileWriter.Flush(); err != nil {
+		fmt.Printf("⚠️  Failed to flush before saving resume data: %v\n", err)
+		return
+	}
+
+	var peers []string
+	if m.resumePeers != nil {
+		peers = m.resumePeers()
+	}
+
+	var unverified []byte
+	if len(m.unverified) > 0 {
+		unverified = make([]byte, (m.totalPieces+7)/8)
+		for index := range m.unverified {
+			unverified[index/8] |= 1 << (7 - index%8)
+		}
+	}
+
+	err := file.SaveResumeData(m.resumePath, &file.ResumeData{
+		NumPieces:  m.totalPieces,
+		Bitfield:   m.bitfield(),
+		Peers:      peers,
+		Renames:    m.fileMapper.GetFileRenames(),
+		Unverified: unverified,
+	})
+	if err != nil {
+		fmt.Printf("⚠️  Failed to save resume data: %v\n", err)
+		return
+	}
+
+	// Everything in the journal is now covered by the resume file
+	if m.journal != nil {
+		if err := m.journal.Reset(); err != nil {
+			fmt.Printf("⚠️  Failed to reset journal: %v\n", err)
+		}
 	}
 }
 
-// loadResumeData loads previous progress
+// loadResumeData loads previous progress into the manager.
+// Caller must hold m.mu.
 func (m *Manager) loadResumeData() error {
-	// Simply return an error to indicate no resume data
-	// This will force a fresh download
-	return fmt.Errorf("no resume data available")
+	if m.resumePath == "" {
+		return fmt.Errorf("no resume data available")
+	}
+
+	data, err := file.LoadResumeData(m.resumePath)
+	if err != nil {
+		return err
+	}
+
+	if data.NumPieces != m.totalPieces {
+		return fmt.Errorf("resume data has %d pieces, torrent has %d", data.NumPieces, m.totalPieces)
+	}
+
+	if err := m.setCompleted(data.Bitfield); err != nil {
+		return err
+	}
+	m.loadedPeers = data.Peers
+
+	// Pieces seed mode assumed last time still get hashed on first read
+	if len(data.Unverified) == len(data.Bitfield) {
+		for i := 0; i < m.totalPieces; i++ {
+			if m.completePieces[i] && m.peerHasPiece(i, data.Unverified) {
+				if m.unverified == nil {
+					m.unverified = make(map[int]bool)
+				}
+				m.unverified[i] = true
+			}
+		}
+	}
+
+	fmt.Printf("Restored %d completed pieces from resume data\n", len(m.completePieces))
+	return nil
+}
+
+// MoveData moves the torrent's files to dir and keeps the resume state at
+// resumePath from then on. The journal is dropped, since the resume data
+// saved here covers every piece.
+func (m *Manager) MoveData(dir, resumePath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fileWriter.MoveTo(dir); err != nil {
+		return err
+	}
+
+	if m.journal != nil {
+		if err := m.journal.Close(); err != nil {
+			fmt.Printf("Error closing journal: %v\n", err)
+		}
+		m.journal = nil
+	}
+	m.journalPath = ""
+
+	m.resumePath = resumePath
+	m.saveResumeData()
+	return nil
+}
+
+// Relocate carries on with the torrent's data in dir, keeping the resume
+// data at resumePath and the journal at journalPath from then on (empty
+// disables either). With move set, the files are moved there first.
+// Otherwise dir is taken to hold the data already, e.g. a copy made
+// elsewhere, and every piece is verified against it afresh, so pieces it
+// lacks are downloaded again. It returns the number of complete pieces.
+func (m *Manager) Relocate(dir, resumePath, journalPath string, move bool) (int, error) {
+	m.mu.Lock()
+	var err error
+	if move {
+		err = m.fileWriter.MoveTo(dir)
+	} else {
+		err = m.fileWriter.UseDirectory(dir)
+	}
+	if err != nil {
+		m.mu.Unlock()
+		return 0, err
+	}
+
+	if m.journal != nil {
+		if err := m.journal.Close(); err != nil {
+			fmt.Printf("Error closing journal: %v\n", err)
+		}
+		m.journal = nil
+	}
+	m.resumePath = resumePath
+	m.journalPath = journalPath
+	if journalPath != "" {
+		// Whatever a journal there recorded, the resume data saved below covers
+		if journal, err := file.OpenJournal(journalPath); err != nil {
+			fmt.Printf("⚠️  Failed to open journal: %v\n", err)
+		} else if err := journal.Reset(); err != nil {
+			fmt.Printf("⚠️  Failed to reset journal: %v\n", err)
+			journal.Close()
+		} else {
+			m.journal = journal
+		}
+	}
+
+	if move {
+		m.saveResumeData()
+		complete := len(m.completePieces)
+		m.mu.Unlock()
+		return complete, nil
+	}
+	m.clearCompleted()
+	m.mu.Unlock()
+
+	if _, err := m.VerifyExistingData(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.saveResumeData()
+	return len(m.completePieces), nil
+}
+
+// clearCompleted forgets which pieces are complete, e.g. before verifying
+// other data. Pieces being downloaded are kept. Caller must hold m.mu.
+func (m *Manager) clearCompleted() {
+	for index := range m.completePieces {
+		m.pieces[index].Reset()
+	}
+	m.completePieces = make(map[int]bool)
+	m.unverified = nil
+	if m.readChecked != nil {
+		m.readChecked = make(map[int]time.Time)
+		m.corrupt = make(map[int]bool)
+	}
+	m.progress.Reset()
+
+	for i, info := range m.fileMapper.GetAllFiles() {
+		m.fileMissing[i] = 0
+		if info.Length > 0 {
+			first := info.Offset / m.pieceLength
+			last := (info.Offset + info.Length - 1) / m.pieceLength
+			m.fileMissing[i] = int(last - first + 1)
+		}
+	}
 }
 
 // Close closes the file writer
@@ -350,19 +1042,251 @@ func (m *Manager) Close() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	// Persist completion state on shutdown
+	m.saveResumeData()
+
+	if m.journal != nil {
+		if err := m.journal.Close(); err != nil {
+			fmt.Printf("Error closing journal: %v\n", err)
+		}
+		m.journal = nil
+	}
+
 	if m.fileWriter != nil {
 		return m.fileWriter.Close()
 	}
 	return nil
 }
 
-// GetFileProgress returns file writing progress
-func (m *Manager) GetFileProgress() *file.Progress {
-	if m.fileWriter != nil {
-		return m.fileWriter.GetProgress()
+// SetFilePriority sets a file's priority; skipped files are not allocated
+// and their bytes are discarded when pieces are written. Pieces that only
+// overlap skipped files are never requested, and the rest are requested
+// highest priority first (see PiecePriority).
+func (m *Manager) SetFilePriority(fileIndex int, priority file.Priority) error {
+	if err := m.fileWriter.SetFilePriority(fileIndex, priority); err != nil {
+		return err
 	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.updatePiecePriorities(fileIndex)
 	return nil
 }
+
+// GetFilePriority returns a file's priority
+func (m *Manager) GetFilePriority(fileIndex int) file.Priority {
+	return m.fileMapper.GetFilePriority(fileIndex)
+}
+
+// PiecePriority returns a piece's priority: the highest of the files it
+// overlaps
+func (m *Manager) PiecePriority(index int) file.Priority {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.piecePriority(index)
+}
+
+// piecePriority is PiecePriority for a caller holding m.mu
+func (m *Manager) piecePriority(index int) file.Priority {
+	if m.priorities == nil {
+		return file.PriorityNormal
+	}
+	return m.priorities[index]
+}
+
+// updatePiecePriorities recomputes the priorities of the pieces a file
+// overlaps after its priority changed. Caller must hold m.mu.
+func (m *Manager) updatePiecePriorities(fileIndex int) {
+	first, last, ok := m.fileMapper.GetFilePieces(fileIndex)
+	if !ok {
+		return
+	}
+	if m.priorities == nil {
+		if m.fileMapper.GetFilePriority(fileIndex) == file.PriorityNormal {
+			return // Still all normal
+		}
+		m.priorities = make([]file.Priority, m.totalPieces)
+		for i := range m.priorities {
+			m.priorities[i] = file.PriorityNormal
+		}
+	}
+	for i := first; i <= last && i < m.totalPieces; i++ {
+		m.priorities[i] = m.fileMapper.GetPiecePriority(i)
+	}
+}
+
+// RenameFile stores a file under path, relative to the output directory,
+// instead of its torrent path, moving data already stored; an empty path
+// restores its name. The name is kept in the resume data. Must be called
+// before Initialize.
+func (m *Manager) RenameFile(fileIndex int, path string) error {
+	if err := m.fileWriter.RenameFile(fileIndex, path); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.renamed = true
+	m.mu.Unlock()
+	return nil
+}
+
+// GetFiles returns the torrent's files, with the names they are stored under
+func (m *Manager) GetFiles() []file.FileInfo {
+	return m.fileMapper.GetAllFiles()
+}
+
+// SetFileLocation maps a torrent file to existing data on disk (cross-seeding).
+// Must be called before Initialize; follow up with VerifyExistingData.
+func (m *Manager) SetFileLocation(fileIndex int, diskPath string) error {
+	return m.fileWriter.SetFileLocation(fileIndex, diskPath)
+}
+
+// VerifyExistingData hashes the data already on disk and marks every piece
+// that matches as complete. It returns the number of pieces newly verified.
+//
+// The manager is only locked while a verified piece is recorded, so the
+// download can carry on meanwhile, and reads are throttled to the rate set
+// with SetVerifyRate.
+func (m *Manager) VerifyExistingData() (int, error) {
+	m.mu.RLock()
+	rate := m.verifyRate
+	clk := m.clock
+	m.mu.RUnlock()
+
+	start := clk.Now()
+	var read int64
+	verified := 0
+
+	for i, piece := range m.pieces {
+		m.mu.RLock()
+		done := m.completePieces[i]
+		m.mu.RUnlock()
+		if done {
+			continue
+		}
+
+		data, err := m.fileWriter.ReadPiece(i)
+		if err != nil {
+			continue // Not on disk (e.g. skipped file), needs downloading
+		}
+		read += int64(len(data))
+
+		if piece.Matches(data) {
+			m.mu.Lock()
+			err := m.markComplete(i)
+			m.mu.Unlock()
+			if err != nil {
+				return verified, err
+			}
+			verified++
+		}
+
+		throttle(clk, start, read, rate)
+	}
+
+	if verified > 0 {
+		m.mu.Lock()
+		m.saveResumeData()
+		m.mu.Unlock()
+	}
+	return verified, nil
+}
+
+// Recheck hashes every complete piece on disk again, reading at most rate
+// bytes per second (0 means unlimited) so seeding carries on meanwhile.
+// Pieces that no longer match, e.g. silently corrupted by the disk, are
+// no longer complete, so they are downloaded again. It returns them.
+func (m *Manager) Recheck(rate int64) ([]int, error) {
+	m.mu.RLock()
+	clk := m.clock
+	m.mu.RUnlock()
+
+	start := clk.Now()
+	var read int64
+	var bad []int
+
+	for i, piece := range m.pieces {
+		m.mu.RLock()
+		done := m.completePieces[i]
+		m.mu.RUnlock()
+		if !done {
+			continue
+		}
+
+		data, err := m.fileWriter.ReadPiece(i)
+		if err == nil {
+			read += int64(len(data))
+		}
+		if err != nil || !piece.Matches(data) {
+			m.mu.Lock()
+			err := m.unmarkComplete(i)
+			m.mu.Unlock()
+			if err != nil {
+				return bad, err
+			}
+			bad = append(bad, i)
+		}
+
+		throttle(clk, start, read, rate)
+	}
+
+	if len(bad) > 0 {
+		m.mu.Lock()
+		m.hashFailures += len(bad)
+		m.saveResumeData()
+		m.mu.Unlock()
+	}
+	return bad, nil
+}
+
+// unmarkComplete takes back markComplete for a piece whose data on disk
+// is no longer good. Caller must hold m.mu.
+func (m *Manager) unmarkComplete(index int) error {
+	if !m.completePieces[index] {
+		return nil
+	}
+	if err := m.fileWriter.UnmarkPieceWritten(index); err != nil {
+		return err
+	}
+
+	piece := m.pieces[index]
+	piece.Reset()
+	delete(m.completePieces, index)
+	delete(m.unverified, index)
+	m.progress.RemoveCompletedPiece(piece.Length)
+
+	if mapping, err := m.fileMapper.GetPieceMapping(index); err == nil {
+		for _, fileRange := range mapping.FileRanges {
+			m.fileMissing[fileRange.FileIndex]++
+		}
+	}
+	return nil
+}
+
+// SetVerifyRate limits how fast VerifyExistingData reads from disk, in
+// bytes per second (0 means unlimited)
+func (m *Manager) SetVerifyRate(bytesPerSecond int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.verifyRate = bytesPerSecond
+}
+
+// throttle sleeps on clk until reading read bytes since start fits within
+// rate, and otherwise just yields so other goroutines get the disk and CPU
+func throttle(clk clock.Clock, start time.Time, read, rate int64) {
+	if rate > 0 {
+		due := start.Add(time.Duration(float64(read) / float64(rate) * float64(time.Second)))
+		if wait := due.Sub(clk.Now()); wait > 0 {
+			clk.Sleep(wait)
+			return
+		}
+	}
+	runtime.Gosched()
+}
+
+// GetFileProgress returns the torrent's progress tracker
+func (m *Manager) GetFileProgress() *file.Progress {
+	return m.progress
+}
 func (m *Manager) cleanupPieceRequests(pieceIndex int) {
 	for key := range m.requests {
 		if strings.HasPrefix(key, fmt.Sprintf("%d:", pieceIndex)) {
@@ -390,3 +1314,65 @@ func (m *Manager) MarkPieceAsPending(piece *Piece) {
 		m.pendingPieces[piece.Index] = piece
 	}
 }
+
+// ReleasePiece returns a pending piece to the pool of pieces that can be
+// selected, e.g. when the peer downloading it goes away. Blocks already
+// received are kept.
+func (m *Manager) ReleasePiece(pieceIndex int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.pendingPieces, pieceIndex)
+	m.cleanupPieceRequests(pieceIndex)
+}
+
+// GetHashFailures returns the total number of failed piece verifications
+func (m *Manager) GetHashFailures() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.hashFailures
+}
+
+// GetFailingPieces returns the indices of pieces that have failed
+// verification at least PreferOtherPeersAfter times
+func (m *Manager) GetFailingPieces() []int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var failing []int
+	for _, piece := range m.pieces {
+		if piece.HashFailures >= PreferOtherPeersAfter && !m.completePieces[piece.Index] {
+			failing = append(failing, piece.Index)
+		}
+	}
+	return failing
+}
+
+// Err returns the error that stopped the torrent, or nil
+func (m *Manager) Err() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.err
+}
+
+// GetHashFailBytes returns bytes discarded because their piece failed verification
+func (m *Manager) GetHashFailBytes() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.hashFailBytes
+}
+
+// GetWastedBytes returns bytes received for blocks we already had
+func (m *Manager) GetWastedBytes() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.wastedBytes
+}
+
+// SetClock replaces the clock used for request timeouts and speed tracking
+func (m *Manager) SetClock(c clock.Clock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clock = c
+	m.progress.SetClock(c)
+}
@@ -0,0 +1,68 @@
+package piece
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// MerkleHash describes how a piece of a BitTorrent v2 torrent (BEP 52) is
+// verified: the SHA-256 merkle tree of its 16 KiB blocks must have Root
+type MerkleHash struct {
+	Root   [32]byte // The piece's entry in its file's piece layer, or the file's pieces root for a file of one piece
+	Length int64    // Bytes of file data in the piece; any rest is padding
+	Leaves int      // Blocks the tree covers, rounded up to a power of two; missing ones hash as zero
+}
+
+// Matches returns true if the piece data hashes to the root
+func (h *MerkleHash) Matches(data []byte) bool {
+	if int64(len(data)) < h.Length {
+		return false
+	}
+	root := MerkleRoot(data[:h.Length], h.Leaves)
+	return bytes.Equal(root[:], h.Root[:])
+}
+
+// MerkleRoot returns the root of the merkle tree over data's 16 KiB blocks,
+// padded with zero hashes to leaves leaves (or the next power of two above
+// the block count, if that's more)
+func MerkleRoot(data []byte, leaves int) [32]byte {
+	blocks := (len(data) + BlockSize - 1) / BlockSize
+	for leaves < blocks || leaves&(leaves-1) != 0 {
+		leaves++
+	}
+	if leaves == 0 {
+		leaves = 1
+	}
+
+	layer := make([][32]byte, leaves)
+	for i := 0; i < blocks; i++ {
+		end := min((i+1)*BlockSize, len(data))
+		layer[i] = sha256.Sum256(data[i*BlockSize : end])
+	}
+	return MerkleLayerRoot(layer, [32]byte{})
+}
+
+// MerkleLayerRoot returns the root above a layer of hashes, padding it to a
+// power of two with pad, the hash of an empty subtree at that layer
+func MerkleLayerRoot(layer [][32]byte, pad [32]byte) [32]byte {
+	if len(layer) == 0 {
+		return pad
+	}
+	for len(layer) > 1 || len(layer)&(len(layer)-1) != 0 {
+		if len(layer)%2 == 1 {
+			layer = append(layer, pad)
+		}
+		next := make([][32]byte, len(layer)/2)
+		var pair [64]byte
+		for i := range next {
+			copy(pair[:32], layer[2*i][:])
+			copy(pair[32:], layer[2*i+1][:])
+			next[i] = sha256.Sum256(pair[:])
+		}
+		copy(pair[:32], pad[:])
+		copy(pair[32:], pad[:])
+		pad = sha256.Sum256(pair[:])
+		layer = next
+	}
+	return layer[0]
+}
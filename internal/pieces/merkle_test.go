@@ -0,0 +1,104 @@
+package piece
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// testData returns n bytes of a fixed, non-repeating-per-block pattern
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+func mustHash(t *testing.T, s string) [32]byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 32 {
+		t.Fatalf("bad test hash %q", s)
+	}
+	var h [32]byte
+	copy(h[:], b)
+	return h
+}
+
+// The expected roots follow BEP 52: SHA-256 of each 16 KiB block, the last
+// one short, with missing leaves up to a power of two hashing as zero
+func TestMerkleRoot(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		leaves int
+		want   string
+	}{
+		{"empty", nil, 1, "0000000000000000000000000000000000000000000000000000000000000000"},
+		{"short block", []byte("abc"), 1, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{"one block", testData(BlockSize), 1, "4348e3b98e8a327b34ced39c1da9e67cdb4cd5e48e4d7960607a3ae403d35f0c"},
+		{"partial second block", testData(BlockSize + 100), 1, "9736c9e81a78092a146406419b482c8e184f7bf9b6d6d4d34bf15b4832fb1821"},
+		{"three blocks padded to four", testData(3 * BlockSize), 1, "c23d35ec942288a7d9b58d1d0446a76104660b7c72e5cf39f38bddb028ff8ca0"},
+		{"short piece of four leaves", testData(20000), 4, "073e3b6951b7e2bb1766deb71b026b77ee1531ac4c5be6ae4a5b6d6df965a6bb"},
+		{"zero piece of two leaves", nil, 2, "f5a5fd42d16a20302798ef6ed309979b43003d2320d9f0e8ea9831a92759fb4b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MerkleRoot(tt.data, tt.leaves); got != mustHash(t, tt.want) {
+				t.Fatalf("MerkleRoot = %x, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// A 70000-byte file in 32 KiB pieces: its piece layer holds three piece
+// roots, and its pieces root pads that layer with the root of a zero piece
+func TestMerkleLayerRoot(t *testing.T) {
+	file := testData(70000)
+	pieceLength := 2 * BlockSize
+	wantLayer := []string{
+		"d9e13d0b676ad681164ef0b7b5910d1328ea83a047cad57e619d76bbe3a08525",
+		"e28097eaaa55956702cf8195d1a551dbabb63e3d679b294cf33d506a6b5ef479",
+		"94cbfbca59afe74836d2e7c6f1e12f31e47ed0d068f78a4e78b4197c4709099f",
+	}
+
+	var layer [][32]byte
+	for i, begin := 0, 0; begin < len(file); i, begin = i+1, begin+pieceLength {
+		root := MerkleRoot(file[begin:min(begin+pieceLength, len(file))], 2)
+		if root != mustHash(t, wantLayer[i]) {
+			t.Fatalf("piece %d root = %x, want %s", i, root, wantLayer[i])
+		}
+		layer = append(layer, root)
+	}
+
+	zeroPiece := MerkleRoot(nil, 2)
+	want := mustHash(t, "83deabd1fe1301daff7f0f151ac57676bb0de039ffc91faa63b02a3e0105bcd8")
+	if got := MerkleLayerRoot(layer, zeroPiece); got != want {
+		t.Fatalf("pieces root = %x, want %x", got, want)
+	}
+	if got := MerkleLayerRoot(nil, zeroPiece); got != zeroPiece {
+		t.Fatalf("empty layer root = %x, want the pad %x", got, zeroPiece)
+	}
+}
+
+func TestMerkleHashMatches(t *testing.T) {
+	data := testData(20000)
+	h := MerkleHash{
+		Root:   mustHash(t, "073e3b6951b7e2bb1766deb71b026b77ee1531ac4c5be6ae4a5b6d6df965a6bb"),
+		Length: int64(len(data)),
+		Leaves: 4,
+	}
+
+	// The piece buffer may run past the file into padding
+	padded := append(append([]byte(nil), data...), make([]byte, 4*BlockSize-len(data))...)
+	if !h.Matches(padded) {
+		t.Fatal("padded piece does not match")
+	}
+	if h.Matches(data[:len(data)-1]) {
+		t.Fatal("truncated piece matches")
+	}
+	data[100] ^= 1
+	if h.Matches(data) {
+		t.Fatal("corrupt piece matches")
+	}
+}
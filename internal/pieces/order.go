@@ -0,0 +1,105 @@
+package piece
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+// CompletionRecord describes one downloaded piece, in the order pieces
+// completed
+type CompletionRecord struct {
+	Order        int       `json:"order"` // 0 for the first piece completed
+	Piece        int       `json:"piece"`
+	Started      time.Time `json:"started"`   // First block of the piece arrived
+	Completed    time.Time `json:"completed"` // Piece verified and written
+	Peers        int       `json:"peers"`     // Peers that sent blocks for the successful attempt
+	HashFailures int       `json:"hash_failures"`
+}
+
+// Duration returns how long the piece took from first block to completion
+func (r CompletionRecord) Duration() time.Duration {
+	return r.Completed.Sub(r.Started)
+}
+
+// CompletionLog is the order and timing of piece completion, for studying
+// how the selector behaves. Pieces restored from resume data or verified
+// from disk aren't in it.
+type CompletionLog []CompletionRecord
+
+// WriteJSON writes the log as a JSON array
+func (l CompletionLog) WriteJSON(w io.Writer) error {
+	if l == nil {
+		l = CompletionLog{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(l)
+}
+
+// WriteCSV writes the log as CSV with a header row. Times are RFC 3339 with
+// nanoseconds; duration_ms is from first block to completion.
+func (l CompletionLog) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"order", "piece", "started", "completed", "duration_ms", "peers", "hash_failures"})
+	for _, r := range l {
+		cw.Write([]string{
+			strconv.Itoa(r.Order),
+			strconv.Itoa(r.Piece),
+			r.Started.Format(time.RFC3339Nano),
+			r.Completed.Format(time.RFC3339Nano),
+			strconv.FormatInt(r.Duration().Milliseconds(), 10),
+			strconv.Itoa(r.Peers),
+			strconv.Itoa(r.HashFailures),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// GetCompletionLog returns the pieces downloaded so far in completion order
+func (m *Manager) GetCompletionLog() CompletionLog {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	log := make(CompletionLog, len(m.completionLog))
+	copy(log, m.completionLog)
+	return log
+}
+
+// recordBlockStart notes when the first block of a piece arrived. Caller
+// must hold m.mu.
+func (m *Manager) recordBlockStart(index int) {
+	if _, ok := m.pieceStarted[index]; !ok {
+		m.pieceStarted[index] = m.clock.Now()
+	}
+}
+
+// forgetBlockStart drops when the first block of a piece arrived, for an
+// attempt that failed, so the next attempt is timed from its own first
+// block. Caller must hold m.mu.
+func (m *Manager) forgetBlockStart(index int) {
+	delete(m.pieceStarted, index)
+}
+
+// recordCompletion appends a downloaded piece to the completion log.
+// Caller must hold m.mu.
+func (m *Manager) recordCompletion(piece *Piece) {
+	now := m.clock.Now()
+	started, ok := m.pieceStarted[piece.Index]
+	if !ok {
+		started = now
+	}
+	delete(m.pieceStarted, piece.Index)
+
+	m.completionLog = append(m.completionLog, CompletionRecord{
+		Order:        len(m.completionLog),
+		Piece:        piece.Index,
+		Started:      started,
+		Completed:    now,
+		Peers:        len(piece.contributors()),
+		HashFailures: piece.HashFailures,
+	})
+}
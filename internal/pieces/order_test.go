@@ -0,0 +1,59 @@
+package piece
+
+import (
+	"crypto/sha1"
+	"testing"
+	"time"
+
+	"bittorrentclient/internal/clock"
+	"bittorrentclient/internal/file"
+)
+
+// A piece that fails its hash is timed, when it completes, from the first
+// block of the attempt that succeeded
+func TestCompletionLogAfterHashFailure(t *testing.T) {
+	data := testData(2 * BlockSize)
+	files := []file.FileInfo{{Path: "f", Length: int64(len(data))}}
+	m := NewManager([][20]byte{sha1.Sum(data)}, int64(len(data)), int64(len(data)), files, t.TempDir())
+	if err := m.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	start := time.Unix(1000, 0)
+	fake := clock.NewFake(start)
+	m.SetClock(fake)
+
+	peerID := [20]byte{1}
+	send := func(begin int, block []byte) {
+		t.Helper()
+		if err := m.HandlePieceMessage(peerID, 0, int64(begin), block); err != nil {
+			t.Fatal(err)
+		}
+		fake.Advance(time.Second)
+	}
+
+	corrupt := append([]byte(nil), data[:BlockSize]...)
+	corrupt[0] ^= 1
+	send(0, corrupt)
+	send(BlockSize, data[BlockSize:])
+	if m.HasPiece(0) {
+		t.Fatal("corrupt piece verified")
+	}
+
+	fake.Advance(time.Minute)
+	retried := fake.Now()
+	send(0, data[:BlockSize])
+	send(BlockSize, data[BlockSize:])
+
+	log := m.GetCompletionLog()
+	if len(log) != 1 {
+		t.Fatalf("completion log has %d records, want 1", len(log))
+	}
+	if !log[0].Started.Equal(retried) {
+		t.Fatalf("piece started at %v, want the retry's first block at %v", log[0].Started, retried)
+	}
+	if log[0].HashFailures != 1 {
+		t.Fatalf("HashFailures = %d, want 1", log[0].HashFailures)
+	}
+}
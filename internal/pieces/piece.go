@@ -10,7 +10,20 @@ import (
 const (
 	BlockSize          = 16384 // 16KB blocks
 	MaxRequestsPerPeer = 5
-	RequestTimeout     = 30 * time.Second
+	RequestTimeout     = 30 * time.Second // Until a peer has a measured timeout, and the most it may reach
+
+	// MinRequestTimeout is the least a peer's adaptive request timeout can be
+	MinRequestTimeout = 2 * time.Second
+	// MinTimeoutSamples is how many delivered blocks are measured before a
+	// peer's adaptive timeout replaces RequestTimeout
+	MinTimeoutSamples = 4
+
+	// PreferOtherPeersAfter is the number of hash failures after which a piece
+	// is no longer requested from peers that contributed to a failed attempt
+	PreferOtherPeersAfter = 2
+	// MaxPieceFailures is the number of hash failures after which the piece is
+	// considered poisoned and the torrent is marked errored
+	MaxPieceFailures = 5
 )
 
 // Piece represents a single piece of the torrent
@@ -22,6 +35,11 @@ type Piece struct {
 	Downloaded []bool // Track which blocks are downloaded
 	Complete   bool
 	Data       []byte
+	V2         *MerkleHash // SHA-256 merkle hash of a v2 piece; nil checks Hash instead
+
+	HashFailures int               // Number of failed hash verifications
+	origins      [][20]byte        // Peer each downloaded block came from, kept until the piece verifies
+	failedPeers  map[[20]byte]bool // Peers that sent blocks for a failed attempt
 }
 
 // Block represents a block within a piece
@@ -71,6 +89,7 @@ func NewPiece(index int, hash [20]byte, length int64) *Piece {
 		Downloaded: downloaded,
 		Complete:   false,
 		Data:       make([]byte, length),
+		origins:    make([][20]byte, numBlocks),
 	}
 }
 
@@ -112,6 +131,93 @@ func (p *Piece) SetBlock(begin int64, data []byte) error {
 	return nil
 }
 
+// AddContributor records that a peer sent the block starting at begin
+func (p *Piece) AddContributor(begin int64, peerID [20]byte) {
+	blockIndex := begin / BlockSize
+	if begin < 0 || int(blockIndex) >= len(p.origins) {
+		return
+	}
+	p.origins[blockIndex] = peerID
+}
+
+// contributors returns the peers that sent the blocks held for the current
+// attempt
+func (p *Piece) contributors() map[[20]byte]bool {
+	contributors := make(map[[20]byte]bool)
+	for i, downloaded := range p.Downloaded {
+		if downloaded && p.origins[i] != ([20]byte{}) {
+			contributors[p.origins[i]] = true
+		}
+	}
+	return contributors
+}
+
+// RecordHashFailure counts a failed verification, remembers the peers that
+// contributed to it and discards the blocks from peers now suspect, keeping
+// the rest for the retry. It returns the bytes discarded.
+//
+// A contributor is suspect if it was implicated in an earlier failure of
+// the piece, or sent every block. Until one is, the bad block can't be
+// pinned on anyone and every block is discarded.
+func (p *Piece) RecordHashFailure() int64 {
+	p.HashFailures++
+
+	contributors := p.contributors()
+	suspects := make(map[[20]byte]bool)
+	for peerID := range contributors {
+		if p.failedPeers[peerID] || len(contributors) == 1 {
+			suspects[peerID] = true
+		}
+	}
+
+	if p.failedPeers == nil {
+		p.failedPeers = make(map[[20]byte]bool)
+	}
+	for peerID := range contributors {
+		p.failedPeers[peerID] = true
+	}
+
+	var discarded int64
+	for i, downloaded := range p.Downloaded {
+		if !downloaded {
+			continue
+		}
+		// Blocks of unknown origin can't be vouched for either
+		origin := p.origins[i]
+		if len(suspects) > 0 && origin != ([20]byte{}) && !suspects[origin] {
+			continue
+		}
+		discarded += p.Blocks[i].Length
+		p.clearBlock(i)
+	}
+	p.Complete = false
+	return discarded
+}
+
+// clearBlock forgets a downloaded block so it is requested again
+func (p *Piece) clearBlock(i int) {
+	begin := p.Blocks[i].Begin
+	clear(p.Data[begin : begin+p.Blocks[i].Length])
+	p.Downloaded[i] = false
+	p.Blocks[i].Data = nil
+	p.origins[i] = [20]byte{}
+}
+
+// ShouldAvoidPeer returns true if the piece has failed often enough that it
+// should be fetched from peers other than those involved in the failures
+func (p *Piece) ShouldAvoidPeer(peerID [20]byte) bool {
+	return p.HashFailures >= PreferOtherPeersAfter && p.failedPeers[peerID]
+}
+
+// HasBlock returns true if the block starting at begin was already received
+func (p *Piece) HasBlock(begin int64) bool {
+	blockIndex := begin / BlockSize
+	if begin < 0 || int(blockIndex) >= len(p.Downloaded) {
+		return false
+	}
+	return p.Downloaded[blockIndex]
+}
+
 // checkComplete checks if all blocks are downloaded
 func (p *Piece) checkComplete() {
 	for _, downloaded := range p.Downloaded {
@@ -133,11 +239,7 @@ func (p *Piece) Validate() bool {
 		return false
 	}
 
-	hash := sha1.Sum(p.Data[:p.Length])
-
-	fmt.Printf("Piece %d validation - Expected: %x, Got: %x\n", p.Index, p.Hash, hash)
-
-	isValid := bytes.Equal(hash[:], p.Hash[:])
+	isValid := p.Matches(p.Data[:p.Length])
 	if isValid {
 		fmt.Printf("✅ Piece %d validated successfully!\n", p.Index)
 	} else {
@@ -146,6 +248,21 @@ func (p *Piece) Validate() bool {
 	return isValid
 }
 
+// Matches returns true if data, the whole piece, hashes to what the
+// torrent says it should: the SHA-1 hash, or for v2 pieces the merkle root.
+// A hybrid torrent's pieces must match both; v2-only pieces have no SHA-1
+// hash (it's zero).
+func (p *Piece) Matches(data []byte) bool {
+	if p.V2 != nil && !p.V2.Matches(data) {
+		return false
+	}
+	if p.V2 != nil && p.Hash == ([20]byte{}) {
+		return true
+	}
+	hash := sha1.Sum(data)
+	return bytes.Equal(hash[:], p.Hash[:])
+}
+
 // GetMissingBlocks returns list of blocks that haven't been downloaded
 func (p *Piece) GetMissingBlocks() []Block {
 	var missing []Block
@@ -172,6 +289,7 @@ func (p *Piece) Reset() {
 	for i := range p.Downloaded {
 		p.Downloaded[i] = false
 		p.Blocks[i].Data = nil
+		p.origins[i] = [20]byte{}
 	}
 	p.Complete = false
 	p.Data = make([]byte, p.Length)
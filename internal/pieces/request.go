@@ -4,14 +4,19 @@ import (
 	"fmt"
 	"sync"
 	"time"
+
+	"bittorrentclient/internal/clock"
 )
 
 // RequestManager manages piece requests to peers
 type RequestManager struct {
 	mu             sync.RWMutex
-	activeRequests map[string]*Request // key: "peerID:pieceIndex:begin"
-	peerRequests   map[string]int      // track requests per peer
+	activeRequests map[string]*Request  // key: "peerID:pieceIndex:begin"
+	peerRequests   map[string]int       // track requests per peer
+	peerLimits     map[string]int       // Lower caps for peers that queue fewer requests than maxRequests
+	peerRTT        map[string]*rttStats // Block delivery times per peer, for adaptive timeouts
 	maxRequests    int
+	clock          clock.Clock
 }
 
 // NewRequestManager creates a new request manager
@@ -19,17 +24,50 @@ func NewRequestManager(maxRequestsPerPeer int) *RequestManager {
 	return &RequestManager{
 		activeRequests: make(map[string]*Request),
 		peerRequests:   make(map[string]int),
+		peerLimits:     make(map[string]int),
+		peerRTT:        make(map[string]*rttStats),
 		maxRequests:    maxRequestsPerPeer,
+		clock:          clock.Real,
 	}
 }
 
+// SetClock replaces the clock used to timestamp and time out requests
+func (rm *RequestManager) SetClock(c clock.Clock) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.clock = c
+}
+
+// SetPeerLimit caps the requests outstanding to a peer below the usual
+// maximum, e.g. at the reqq it advertised. A limit of 0 removes the cap.
+func (rm *RequestManager) SetPeerLimit(peerID [20]byte, limit int) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	peerKey := string(peerID[:])
+	if limit <= 0 || limit >= rm.maxRequests {
+		delete(rm.peerLimits, peerKey)
+		return
+	}
+	rm.peerLimits[peerKey] = limit
+}
+
+// limitFor returns how many requests may be outstanding to a peer. Caller
+// must hold rm.mu.
+func (rm *RequestManager) limitFor(peerKey string) int {
+	if limit, ok := rm.peerLimits[peerKey]; ok {
+		return limit
+	}
+	return rm.maxRequests
+}
+
 // CanRequestFromPeer checks if we can make more requests to a peer
 func (rm *RequestManager) CanRequestFromPeer(peerID [20]byte) bool {
 	rm.mu.RLock()
 	defer rm.mu.RUnlock()
 
 	peerKey := string(peerID[:])
-	return rm.peerRequests[peerKey] < rm.maxRequests
+	return rm.peerRequests[peerKey] < rm.limitFor(peerKey)
 }
 
 // AddRequest adds a new request
@@ -40,7 +78,7 @@ func (rm *RequestManager) AddRequest(peerID [20]byte, pieceIndex, begin int64, l
 	peerKey := string(peerID[:])
 
 	// Check if peer has capacity
-	if rm.peerRequests[peerKey] >= rm.maxRequests {
+	if rm.peerRequests[peerKey] >= rm.limitFor(peerKey) {
 		return fmt.Errorf("peer has too many active requests")
 	}
 
@@ -49,7 +87,7 @@ func (rm *RequestManager) AddRequest(peerID [20]byte, pieceIndex, begin int64, l
 		PieceIndex: pieceIndex,
 		Begin:      begin,
 		Length:     length,
-		Requested:  time.Now(),
+		Requested:  rm.clock.Now(),
 		PeerID:     peerID,
 	}
 
@@ -80,16 +118,37 @@ func (rm *RequestManager) RemoveRequest(peerID [20]byte, pieceIndex, begin int64
 	}
 }
 
-// GetTimeoutRequests returns requests that have timed out
+// CompleteRequest removes a request whose block arrived, and measures how
+// long it took towards the peer's adaptive timeout
+func (rm *RequestManager) CompleteRequest(peerID [20]byte, pieceIndex, begin int64) {
+	rm.mu.Lock()
+	peerKey := string(peerID[:])
+	key := fmt.Sprintf("%s:%d:%d", peerKey, pieceIndex, begin)
+	if req, exists := rm.activeRequests[key]; exists {
+		stats := rm.peerRTT[peerKey]
+		if stats == nil {
+			stats = &rttStats{}
+			rm.peerRTT[peerKey] = stats
+		}
+		stats.add(rm.clock.Now().Sub(req.Requested))
+	}
+	rm.mu.Unlock()
+
+	rm.RemoveRequest(peerID, pieceIndex, begin)
+}
+
+// GetTimeoutRequests returns requests that have timed out. Each peer's
+// requests time out after its adaptive timeout, see TimeoutFor, which
+// never exceeds timeout.
 func (rm *RequestManager) GetTimeoutRequests(timeout time.Duration) []*Request {
 	rm.mu.RLock()
 	defer rm.mu.RUnlock()
 
 	var timeouts []*Request
-	now := time.Now()
+	now := rm.clock.Now()
 
 	for _, req := range rm.activeRequests {
-		if now.Sub(req.Requested) > timeout {
+		if now.Sub(req.Requested) > rm.timeoutFor(string(req.PeerID[:]), timeout) {
 			timeouts = append(timeouts, req)
 		}
 	}
@@ -97,20 +156,97 @@ func (rm *RequestManager) GetTimeoutRequests(timeout time.Duration) []*Request {
 	return timeouts
 }
 
+// TimeoutFor returns how long a request to the peer may go unanswered: its
+// mean block delivery time plus four deviations, like TCP's retransmission
+// timeout, so fast peers are retried quickly while slow but working ones
+// aren't timed out. Peers with too few samples get RequestTimeout.
+func (rm *RequestManager) TimeoutFor(peerID [20]byte) time.Duration {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	return rm.timeoutFor(string(peerID[:]), RequestTimeout)
+}
+
+// timeoutFor returns a peer's adaptive timeout, capped at limit. Caller
+// must hold rm.mu.
+func (rm *RequestManager) timeoutFor(peerKey string, limit time.Duration) time.Duration {
+	stats := rm.peerRTT[peerKey]
+	if stats == nil || stats.samples < MinTimeoutSamples {
+		return limit
+	}
+	timeout := stats.mean + 4*stats.deviation
+	if timeout < MinRequestTimeout {
+		timeout = MinRequestTimeout
+	}
+	if timeout > limit {
+		timeout = limit
+	}
+	return timeout
+}
+
+// rttStats smooths a peer's block delivery times the way TCP smooths round
+// trip times (RFC 6298)
+type rttStats struct {
+	mean      time.Duration
+	deviation time.Duration
+	samples   int
+}
+
+// add folds a delivery time into the averages
+func (s *rttStats) add(rtt time.Duration) {
+	s.samples++
+	if s.samples == 1 {
+		s.mean = rtt
+		s.deviation = rtt / 2
+		return
+	}
+	diff := s.mean - rtt
+	if diff < 0 {
+		diff = -diff
+	}
+	s.deviation = (3*s.deviation + diff) / 4
+	s.mean = (7*s.mean + rtt) / 8
+}
+
 // ClearPeerRequests removes all requests for a peer (when peer disconnects)
-func (rm *RequestManager) ClearPeerRequests(peerID [20]byte) {
+// and returns them so the blocks can be handed to other peers
+func (rm *RequestManager) ClearPeerRequests(peerID [20]byte) []*Request {
 	rm.mu.Lock()
 	defer rm.mu.Unlock()
 
 	peerKey := string(peerID[:])
 
 	// Remove all requests for this peer
+	var cleared []*Request
 	for key, req := range rm.activeRequests {
 		if string(req.PeerID[:]) == peerKey {
+			cleared = append(cleared, req)
 			delete(rm.activeRequests, key)
 		}
 	}
 
 	// Clear peer request count
 	delete(rm.peerRequests, peerKey)
+	delete(rm.peerLimits, peerKey)
+	return cleared
+}
+
+// PieceRequested returns true if any peer has a request outstanding for a
+// block of the piece
+func (rm *RequestManager) PieceRequested(pieceIndex int64) bool {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	for _, req := range rm.activeRequests {
+		if req.PieceIndex == pieceIndex {
+			return true
+		}
+	}
+	return false
+}
+
+// ForgetPeer drops the delivery times measured for a peer that went away
+func (rm *RequestManager) ForgetPeer(peerID [20]byte) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	delete(rm.peerRTT, string(peerID[:]))
 }
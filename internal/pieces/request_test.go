@@ -0,0 +1,98 @@
+package piece
+
+import (
+	"testing"
+	"time"
+
+	"bittorrentclient/internal/clock"
+)
+
+func TestPieceRequested(t *testing.T) {
+	rm := NewRequestManager(10)
+	choked, other := [20]byte{1}, [20]byte{2}
+
+	for _, req := range []struct {
+		peer  [20]byte
+		begin int64
+	}{{choked, 0}, {choked, BlockSize}, {other, 2 * BlockSize}} {
+		if err := rm.AddRequest(req.peer, 7, req.begin, BlockSize); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if cleared := rm.ClearPeerRequests(choked); len(cleared) != 2 {
+		t.Fatalf("cleared %d requests, want the choked peer's 2", len(cleared))
+	}
+	if !rm.PieceRequested(7) {
+		t.Fatal("piece with another peer's request outstanding reported free")
+	}
+
+	rm.CompleteRequest(other, 7, 2*BlockSize)
+	if rm.PieceRequested(7) {
+		t.Fatal("piece reported requested after its last request completed")
+	}
+	if rm.PieceRequested(8) {
+		t.Fatal("never requested piece reported requested")
+	}
+}
+
+// deliver has the peer answer one request after rtt, n times
+func deliver(t *testing.T, rm *RequestManager, fake *clock.Fake, peerID [20]byte, rtt time.Duration, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := rm.AddRequest(peerID, int64(i), 0, BlockSize); err != nil {
+			t.Fatal(err)
+		}
+		fake.Advance(rtt)
+		rm.CompleteRequest(peerID, int64(i), 0)
+	}
+}
+
+func TestTimeoutFor(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1000, 0))
+	rm := NewRequestManager(10)
+	rm.SetClock(fake)
+	fast, steady, slow, unknown := [20]byte{1}, [20]byte{2}, [20]byte{3}, [20]byte{4}
+
+	deliver(t, rm, fake, fast, 100*time.Millisecond, MinTimeoutSamples-1)
+	if got := rm.TimeoutFor(fast); got != RequestTimeout {
+		t.Fatalf("timeout with %d samples = %v, want RequestTimeout", MinTimeoutSamples-1, got)
+	}
+	deliver(t, rm, fake, fast, 100*time.Millisecond, 1)
+	if got := rm.TimeoutFor(fast); got != MinRequestTimeout {
+		t.Fatalf("fast peer's timeout = %v, want MinRequestTimeout", got)
+	}
+
+	deliver(t, rm, fake, slow, 20*time.Second, MinTimeoutSamples)
+	if got := rm.TimeoutFor(slow); got != RequestTimeout {
+		t.Fatalf("slow peer's timeout = %v, want it capped at RequestTimeout", got)
+	}
+
+	deliver(t, rm, fake, steady, 3*time.Second, MinTimeoutSamples)
+	steadyTimeout := rm.TimeoutFor(steady)
+	if steadyTimeout <= 3*time.Second || steadyTimeout >= RequestTimeout {
+		t.Fatalf("steady peer's timeout = %v, want between its 3s deliveries and RequestTimeout", steadyTimeout)
+	}
+	if got := rm.TimeoutFor(unknown); got != RequestTimeout {
+		t.Fatalf("unknown peer's timeout = %v, want RequestTimeout", got)
+	}
+
+	// Each peer's requests time out after its own timeout
+	for _, peerID := range [][20]byte{fast, steady, unknown} {
+		if err := rm.AddRequest(peerID, 100, 0, BlockSize); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake.Advance(MinRequestTimeout + time.Second)
+	timeouts := rm.GetTimeoutRequests(RequestTimeout)
+	if len(timeouts) != 1 || timeouts[0].PeerID != fast {
+		t.Fatalf("timed out %v, want only the fast peer's request", timeouts)
+	}
+	fake.Advance(steadyTimeout)
+	if timeouts := rm.GetTimeoutRequests(RequestTimeout); len(timeouts) != 2 {
+		t.Fatalf("timed out %d requests, want the fast and steady peers'", len(timeouts))
+	}
+	if timeouts := rm.GetTimeoutRequests(time.Second); len(timeouts) != 3 {
+		t.Fatalf("timed out %d requests under a 1s limit, want all 3", len(timeouts))
+	}
+}
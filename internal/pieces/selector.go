@@ -3,8 +3,15 @@ package piece
 import (
 	"math/rand"
 	"time"
+
+	"bittorrentclient/internal/file"
 )
 
+// Selector picks the next piece to download from a peer
+type Selector interface {
+	SelectPiece(manager *Manager, peerID [20]byte, peerBitfield []byte, isFirstPiece bool) *Piece
+}
+
 // PieceSelector handles piece selection strategies
 type PieceSelector struct {
 	rng *rand.Rand
@@ -12,25 +19,43 @@ type PieceSelector struct {
 
 // NewPieceSelector creates a new piece selector
 func NewPieceSelector() *PieceSelector {
+	return NewSeededPieceSelector(time.Now().UnixNano())
+}
+
+// NewSeededPieceSelector creates a piece selector whose random choices are
+// the same every run for the same seed and inputs, for reproducing a
+// selection exactly
+func NewSeededPieceSelector(seed int64) *PieceSelector {
 	return &PieceSelector{
-		rng: rand.New(rand.NewSource(time.Now().UnixNano())),
+		rng: rand.New(rand.NewSource(seed)),
 	}
 }
 
-// SelectPiece selects the next piece to download based on strategy
-func (ps *PieceSelector) SelectPiece(manager *Manager, peerBitfield []byte, isFirstPiece bool) *Piece {
+// SelectPiece selects the next piece to download from a peer based on strategy
+func (ps *PieceSelector) SelectPiece(manager *Manager, peerID [20]byte, peerBitfield []byte, isFirstPiece bool) *Piece {
 	if isFirstPiece {
-		return ps.selectRandomPiece(manager, peerBitfield)
+		return ps.selectRandomPiece(manager, peerID, peerBitfield)
 	}
-	return ps.selectRarestFirst(manager, peerBitfield)
+	return ps.selectRarestFirst(manager, peerID, peerBitfield)
 }
 
-// selectRandomPiece selects a random available piece
-func (ps *PieceSelector) selectRandomPiece(manager *Manager, peerBitfield []byte) *Piece {
-	var available []*Piece
+// selectRandomPiece selects a random available piece among those of the
+// highest file priority
+func (ps *PieceSelector) selectRandomPiece(manager *Manager, peerID [20]byte, peerBitfield []byte) *Piece {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
 
+	var available []*Piece
+	var priority file.Priority
 	for i, piece := range manager.pieces {
-		if manager.isPieceAvailable(i, peerBitfield) {
+		if !manager.isPieceAvailableFor(i, peerBitfield, peerID) {
+			continue
+		}
+		switch p := manager.piecePriority(i); {
+		case len(available) == 0 || p > priority:
+			available = append(available[:0], piece)
+			priority = p
+		case p == priority:
 			available = append(available, piece)
 		}
 	}
@@ -42,43 +67,47 @@ func (ps *PieceSelector) selectRandomPiece(manager *Manager, peerBitfield []byte
 	return available[ps.rng.Intn(len(available))]
 }
 
-// selectRarestFirst implements rarest first strategy
-func (ps *PieceSelector) selectRarestFirst(manager *Manager, peerBitfield []byte) *Piece {
+// selectRarestFirst implements rarest first strategy within the highest
+// file priority
+func (ps *PieceSelector) selectRarestFirst(manager *Manager, peerID [20]byte, peerBitfield []byte) *Piece {
 	manager.mu.RLock()
 	defer manager.mu.RUnlock()
 
-	// Track piece availability counts
-	pieceAvailability := make(map[int]int)
-	var availablePieces []int
-
-	// Count availability across all peers
+	// Collect the missing pieces this peer can give us of the highest
+	// priority, and among those the ones the fewest connected peers have,
+	// going by the bitfields and haves they sent
+	var rarestPieces []int
+	var priority file.Priority
+	minAvailability := 0
 	for i := 0; i < manager.totalPieces; i++ {
-		if manager.isPieceAvailable(i, peerBitfield) {
-			availablePieces = append(availablePieces, i)
-			// In a real implementation, you'd track this across all connected peers
-			// For now, we'll simulate rarity by using piece index as a proxy
-			pieceAvailability[i] = 1 + (i % 3) // Simulate varying availability
+		if !manager.isPieceAvailableFor(i, peerBitfield, peerID) {
+			continue
+		}
+		p, count := manager.piecePriority(i), manager.availability[i]
+		switch {
+		case len(rarestPieces) == 0 || p > priority || (p == priority && count < minAvailability):
+			rarestPieces = append(rarestPieces[:0], i)
+			priority, minAvailability = p, count
+		case p == priority && count == minAvailability:
+			rarestPieces = append(rarestPieces, i)
 		}
 	}
 
-	if len(availablePieces) == 0 {
+	if len(rarestPieces) == 0 {
 		return nil
 	}
 
-	// Find the rarest pieces (lowest availability count)
-	minAvailability := int(^uint(0) >> 1) // Max int
-	for _, pieceIndex := range availablePieces {
-		if pieceAvailability[pieceIndex] < minAvailability {
-			minAvailability = pieceAvailability[pieceIndex]
+	// Among equally rare pieces, prefer ones spanning a file boundary. Every
+	// file touching them needs them, so fetching them early lets files
+	// complete one by one instead of all sitting just short of done.
+	var boundaryPieces []int
+	for _, pieceIndex := range rarestPieces {
+		if manager.fileMapper.IsBoundaryPiece(pieceIndex) {
+			boundaryPieces = append(boundaryPieces, pieceIndex)
 		}
 	}
-
-	// Collect all pieces with minimum availability
-	var rarestPieces []int
-	for _, pieceIndex := range availablePieces {
-		if pieceAvailability[pieceIndex] == minAvailability {
-			rarestPieces = append(rarestPieces, pieceIndex)
-		}
+	if len(boundaryPieces) > 0 {
+		rarestPieces = boundaryPieces
 	}
 
 	// Randomly select from the rarest pieces to break ties
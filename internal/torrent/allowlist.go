@@ -0,0 +1,43 @@
+package torrent
+
+import (
+	"bittorrentclient/internal/peer"
+)
+
+// WithPeerAllowlist restricts the torrent to peers inside allowlist:
+// addresses outside it from trackers or any other source are ignored and
+// inbound connections from them refused. nil allows every peer.
+func WithPeerAllowlist(allowlist *peer.Allowlist) Option {
+	return func(d *Downloader) {
+		d.allowlist = allowlist
+		d.peerPool.SetAllowlist(allowlist)
+	}
+}
+
+// SetPeerAllowlist restricts the torrent to peers inside allowlist from
+// now on, disconnecting connected peers outside it. nil allows every peer.
+func (d *Downloader) SetPeerAllowlist(allowlist *peer.Allowlist) {
+	d.mu.Lock()
+	d.allowlist = allowlist
+	d.peerPool.SetAllowlist(allowlist)
+	var outside [][20]byte
+	for _, conn := range d.connections {
+		if !allowlist.Allows(remoteAddrOf(conn)) {
+			outside = append(outside, conn.ID)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, peerID := range outside {
+		d.logger.Printf("Disconnecting peer %x outside the peer allowlist\n", peerID[:8])
+		d.RemovePeer(peerID)
+	}
+}
+
+// GetPeerAllowlist returns the peers the torrent is restricted to; nil if
+// it allows every peer
+func (d *Downloader) GetPeerAllowlist() *peer.Allowlist {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.allowlist
+}
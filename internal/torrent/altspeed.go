@@ -0,0 +1,64 @@
+package torrent
+
+// RateLimits are session-wide download and upload caps in bytes per second
+// (0 means unlimited)
+type RateLimits struct {
+	Download int64
+	Upload   int64
+}
+
+// DefaultAltRateLimits are the turtle mode limits unless configured otherwise
+var DefaultAltRateLimits = RateLimits{
+	Download: 50 * 1024, // 50 KB/s
+	Upload:   50 * 1024,
+}
+
+// SetAltSpeed switches between the normal SessionConfig.RateLimits and the
+// alternative SessionConfig.AltRateLimits ("turtle mode"). It applies to
+// every torrent in the session at once.
+func (s *Session) SetAltSpeed(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.altSpeed = on
+	s.applyRateLimits()
+}
+
+// ToggleAltSpeed flips turtle mode and returns whether it is now on
+func (s *Session) ToggleAltSpeed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.altSpeed = !s.altSpeed
+	s.applyRateLimits()
+	return s.altSpeed
+}
+
+// AltSpeed returns true if the alternative rate limits are in effect
+func (s *Session) AltSpeed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.altSpeed
+}
+
+// ActiveRateLimits returns the session-wide limits currently in effect
+func (s *Session) ActiveRateLimits() RateLimits {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.activeRateLimits()
+}
+
+// activeRateLimits returns the limits selected by s.altSpeed. Caller must
+// hold s.mu.
+func (s *Session) activeRateLimits() RateLimits {
+	if s.altSpeed {
+		return s.config.AltRateLimits
+	}
+	return s.config.RateLimits
+}
+
+// applyRateLimits sets the session limiters to the active limits. Caller
+// must hold s.mu.
+func (s *Session) applyRateLimits() {
+	limits := s.activeRateLimits()
+	s.downloadLimit.SetRate(limits.Download)
+	s.uploadLimit.SetRate(limits.Upload)
+}
@@ -0,0 +1,72 @@
+package torrent
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"bittorrentclient/internal/peer"
+)
+
+// WithCapture writes the raw wire traffic of every peer connection of the
+// torrent into dir, one capture file per connection (see peer.Capture)
+func WithCapture(dir string) Option {
+	return func(d *Downloader) {
+		d.captureDir = dir
+	}
+}
+
+// CapturePeer writes the raw wire traffic of one peer into a capture file
+// at path: its current connection from now on, or else its next connection
+// from the start. peerKey is the peer ID in hex.
+func (d *Downloader) CapturePeer(peerKey, path string) error {
+	if id, err := hex.DecodeString(peerKey); err != nil || len(id) != 20 {
+		return fmt.Errorf("invalid peer ID %q", peerKey)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	conn, exists := d.connections[peerKey]
+	if !exists {
+		d.capturePeers[peerKey] = path
+		return nil
+	}
+	capture, err := peer.CreateCapture(path)
+	if err != nil {
+		return err
+	}
+	conn.StartCapture(capture)
+	return nil
+}
+
+// startCapture starts capturing a new connection, before it is started, if
+// WithCapture or CapturePeer asked for it
+func (d *Downloader) startCapture(conn *peer.Connection) {
+	peerKey := peerKeyFor(conn.ID)
+
+	d.mu.Lock()
+	path, wanted := d.capturePeers[peerKey]
+	delete(d.capturePeers, peerKey)
+	d.mu.Unlock()
+
+	if !wanted && d.captureDir != "" {
+		if err := os.MkdirAll(d.captureDir, 0755); err != nil {
+			d.logger.Printf("Failed to create capture directory: %v\n", err)
+			return
+		}
+		name := fmt.Sprintf("%s-%s-%d.btcap", d.torrent.InfoHash, peerKey, d.clock.Now().UnixNano())
+		path, wanted = filepath.Join(d.captureDir, name), true
+	}
+	if !wanted {
+		return
+	}
+
+	capture, err := peer.CreateCapture(path)
+	if err != nil {
+		d.logger.Printf("Failed to capture peer %x: %v\n", conn.ID[:8], err)
+		return
+	}
+	conn.StartCapture(capture)
+}
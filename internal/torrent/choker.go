@@ -0,0 +1,204 @@
+package torrent
+
+import (
+	"sort"
+	"time"
+
+	"bittorrentclient/internal/peer"
+)
+
+const (
+	// ChokeInterval is how often the choker re-ranks peers
+	ChokeInterval = 10 * time.Second
+	// OptimisticUnchokeInterval is how long the optimistic unchoke stays
+	// with one peer before moving on to another
+	OptimisticUnchokeInterval = 30 * time.Second
+)
+
+// chokeLoop re-ranks peers every ChokeInterval until the torrent stops,
+// while downloading and while seeding
+func (d *Downloader) chokeLoop() {
+	ticker := d.clock.NewTicker(ChokeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C():
+			d.rechoke()
+		}
+	}
+}
+
+// rechoke unchokes the peers that reciprocate best and chokes the rest
+// (tit-for-tat). Peers are ranked by the rate they send to us while we
+// download and by the rate we send to them once we seed, since then that
+// is what makes an unchoke worthwhile. Going down the ranking, peers are
+// unchoked until GetUploadSlots interested ones are; uninterested peers
+// ranked above that are unchoked too, so they can start at once if they
+// become interested. New peers keep their bootstrap unchoke for
+// NewPeerUnchokePeriod whatever their rank, each using up one of the slots.
+//
+// With more than one slot, one of them is the optimistic unchoke: a random
+// choked, interested peer, replaced every OptimisticUnchokeInterval, so
+// peers outside the ranking get a chance to show they are faster.
+func (d *Downloader) rechoke() {
+	if !d.uploading() {
+		d.chokeAll()
+		return
+	}
+
+	now := d.clock.Now()
+	seeding := d.IsComplete()
+
+	d.mu.Lock()
+	ranked := make([]*peer.Connection, 0, len(d.connections))
+	bootstrapping := 0
+	for key, conn := range d.connections {
+		if until, exists := d.newPeerUnchokes[key]; exists {
+			if now.Before(until) {
+				bootstrapping++
+				continue
+			}
+			delete(d.newPeerUnchokes, key)
+		}
+		ranked = append(ranked, conn)
+	}
+	d.mu.Unlock()
+
+	slots := max(d.GetUploadSlots()-bootstrapping, 0)
+	optimistic := d.rotateOptimisticUnchoke(ranked, now, slots > 1)
+	if optimistic != nil {
+		slots--
+		if optimistic.IsChoking() {
+			if err := optimistic.Unchoke(); err != nil {
+				d.logger.Printf("Failed to unchoke peer %x: %v\n", optimistic.ID[:8], err)
+			}
+		}
+	}
+
+	rates := make(map[*peer.Connection]float64, len(ranked))
+	for _, conn := range ranked {
+		if seeding {
+			rates[conn] = conn.GetUploadRate()
+		} else {
+			rates[conn] = conn.GetDownloadRate()
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if rates[ranked[i]] != rates[ranked[j]] {
+			return rates[ranked[i]] > rates[ranked[j]]
+		}
+		return peerKeyFor(ranked[i].ID) < peerKeyFor(ranked[j].ID)
+	})
+
+	for _, conn := range ranked {
+		if conn == optimistic || !conn.IsConnected() {
+			continue
+		}
+		if slots > 0 {
+			if conn.IsInterested() {
+				slots--
+			}
+			if conn.IsChoking() {
+				if err := conn.Unchoke(); err != nil {
+					d.logger.Printf("Failed to unchoke peer %x: %v\n", conn.ID[:8], err)
+				}
+			}
+			continue
+		}
+		if !conn.IsChoking() {
+			if err := conn.Choke(); err != nil {
+				d.logger.Printf("Failed to choke peer %x: %v\n", conn.ID[:8], err)
+			}
+		}
+	}
+}
+
+// reserveNewPeerUnchoke records a bootstrap unchoke for a newly connected
+// peer and returns true, unless new peers still in theirs already take up
+// all of GetUploadSlots
+func (d *Downloader) reserveNewPeerUnchoke(id [20]byte) bool {
+	slots := d.GetUploadSlots()
+	now := d.clock.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	bootstrapping := 0
+	for _, until := range d.newPeerUnchokes {
+		if now.Before(until) {
+			bootstrapping++
+		}
+	}
+	if bootstrapping >= slots {
+		return false
+	}
+	d.newPeerUnchokes[peerKeyFor(id)] = now.Add(NewPeerUnchokePeriod)
+	return true
+}
+
+// chokeAll chokes every peer, for a torrent that no longer uploads
+func (d *Downloader) chokeAll() {
+	d.mu.Lock()
+	d.optimistic = ""
+	d.mu.Unlock()
+
+	for _, conn := range d.connectionList() {
+		if conn.IsConnected() && !conn.IsChoking() {
+			if err := conn.Choke(); err != nil {
+				d.logger.Printf("Failed to choke peer %x: %v\n", conn.ID[:8], err)
+			}
+		}
+	}
+}
+
+// rotateOptimisticUnchoke returns the peer holding the optimistic unchoke
+// among candidates, first moving it to a random choked, interested one if
+// its OptimisticUnchokeInterval is over or it went away. It returns nil if
+// enabled is false or no peer qualifies.
+func (d *Downloader) rotateOptimisticUnchoke(candidates []*peer.Connection, now time.Time, enabled bool) *peer.Connection {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !enabled {
+		d.optimistic = ""
+		return nil
+	}
+
+	var current *peer.Connection
+	var eligible []*peer.Connection
+	for _, conn := range candidates {
+		if !conn.IsConnected() {
+			continue
+		}
+		if peerKeyFor(conn.ID) == d.optimistic {
+			current = conn
+		} else if conn.IsChoking() && conn.IsInterested() {
+			eligible = append(eligible, conn)
+		}
+	}
+	if current != nil && now.Before(d.optimisticUntil) {
+		return current
+	}
+	if len(eligible) == 0 {
+		// Nobody else is waiting; the current peer, if any, keeps it
+		if current != nil {
+			d.optimisticUntil = now.Add(OptimisticUnchokeInterval)
+		} else {
+			d.optimistic = ""
+		}
+		return current
+	}
+
+	// Keep the order the choice is made from stable, so a seeded
+	// chokeRand repeats it
+	sort.Slice(eligible, func(i, j int) bool {
+		return peerKeyFor(eligible[i].ID) < peerKeyFor(eligible[j].ID)
+	})
+	next := eligible[d.chokeRand.Intn(len(eligible))]
+	d.optimistic = peerKeyFor(next.ID)
+	d.optimisticUntil = now.Add(OptimisticUnchokeInterval)
+	d.logger.Printf("Optimistically unchoking peer %x\n", next.ID[:8])
+	return next
+}
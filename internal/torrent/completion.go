@@ -0,0 +1,298 @@
+package torrent
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// CompletionAction is what a torrent does once its download completes
+type CompletionAction int
+
+const (
+	// CompleteStop stops the torrent, keeping it in the session
+	CompleteStop CompletionAction = iota
+	// CompleteSeed seeds until CompletionPolicy.SeedRatio or SeedTime is
+	// reached, then stops; with neither set it seeds forever
+	CompleteSeed
+	// CompleteSeedForever seeds until the torrent is stopped by hand
+	CompleteSeedForever
+	// CompleteRunHook runs CompletionPolicy.Hook, then stops
+	CompleteRunHook
+	// CompleteRemove removes the torrent from its session, keeping its data
+	CompleteRemove
+	// CompleteShutdown stops the torrent and asks for the machine to be
+	// shut down once nothing else in the session is running (see
+	// Downloader.Finished)
+	CompleteShutdown
+)
+
+// String returns the action's name as accepted by ParseCompletionAction
+func (a CompletionAction) String() string {
+	switch a {
+	case CompleteStop:
+		return "stop"
+	case CompleteSeed:
+		return "seed"
+	case CompleteSeedForever:
+		return "seed-forever"
+	case CompleteRunHook:
+		return "hook"
+	case CompleteRemove:
+		return "remove"
+	case CompleteShutdown:
+		return "shutdown"
+	default:
+		return fmt.Sprintf("CompletionAction(%d)", int(a))
+	}
+}
+
+// ParseCompletionAction parses "stop", "seed", "seed-forever", "hook",
+// "remove" or "shutdown"
+func ParseCompletionAction(name string) (CompletionAction, error) {
+	for _, a := range []CompletionAction{CompleteStop, CompleteSeed, CompleteSeedForever, CompleteRunHook, CompleteRemove, CompleteShutdown} {
+		if a.String() == name {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown completion action %q", name)
+}
+
+// CompletionPolicy says what a torrent does once its download completes
+type CompletionPolicy struct {
+	Action    CompletionAction
+	SeedRatio float64       // CompleteSeed stops at this upload ratio; 0 means no ratio limit
+	SeedTime  time.Duration // CompleteSeed stops after seeding this long; 0 means no time limit
+	Hook      string        // Shell command CompleteRunHook runs, see runHook
+}
+
+// DefaultCompletionPolicy stops a torrent once its download completes
+func DefaultCompletionPolicy() CompletionPolicy {
+	return CompletionPolicy{Action: CompleteStop}
+}
+
+// Validate checks that the policy can be carried out
+func (p CompletionPolicy) Validate() error {
+	if _, err := ParseCompletionAction(p.Action.String()); err != nil {
+		return err
+	}
+	if p.SeedRatio < 0 || p.SeedTime < 0 {
+		return fmt.Errorf("seed ratio and seed time can't be negative")
+	}
+	if p.Action == CompleteRunHook && p.Hook == "" {
+		return fmt.Errorf("the hook action needs a hook command")
+	}
+	return nil
+}
+
+// WithCompletion sets what the torrent does once its download completes.
+// Defaults to DefaultCompletionPolicy.
+func WithCompletion(policy CompletionPolicy) Option {
+	return func(d *Downloader) {
+		d.completion = policy
+	}
+}
+
+// SetCompletion changes what the torrent does once its download completes.
+// A torrent already seeding applies it at once, e.g. stopping if the new
+// policy doesn't seed or its limits are already reached.
+func (d *Downloader) SetCompletion(policy CompletionPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.completion = policy
+	return nil
+}
+
+// GetCompletion returns what the torrent does once its download completes
+func (d *Downloader) GetCompletion() CompletionPolicy {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.completion
+}
+
+// Finished returns a channel closed once the torrent has carried out its
+// completion action and is no longer running. It never closes for a torrent
+// stopped some other way, or one seeding forever.
+func (d *Downloader) Finished() <-chan struct{} {
+	return d.finished
+}
+
+// FinishedWith returns the completion action the torrent carried out, once
+// Finished is closed
+func (d *Downloader) FinishedWith() CompletionAction {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.finishedWith
+}
+
+// IsSeeding returns true if the download completed and the torrent is
+// seeding until its completion policy says to stop
+func (d *Downloader) IsSeeding() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return !d.completedAt.IsZero() && !d.isFinished() && !d.isStopped()
+}
+
+// isStopped returns true once Stop was called
+func (d *Downloader) isStopped() bool {
+	select {
+	case <-d.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// isFinished returns true once Finished is closed
+func (d *Downloader) isFinished() bool {
+	select {
+	case <-d.finished:
+		return true
+	default:
+		return false
+	}
+}
+
+// completionLoop waits for the download to complete, then seeds for as long
+// as the completion policy (re-read every tick, so it can change) says and
+// carries out its action
+func (d *Downloader) completionLoop() {
+	select {
+	case <-d.downloadDone:
+	case <-d.done:
+		return
+	}
+	// Its download slot is free for the next torrent in the queue
+	if d.session != nil {
+		d.session.updateQueue()
+	}
+	if !d.downloadCompleted() {
+		return
+	}
+
+	d.mu.Lock()
+	if d.completedAt.IsZero() { // Else restored by Session.LoadAll
+		d.completedAt = d.clock.Now()
+	}
+	d.mu.Unlock()
+
+	ticker := d.clock.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		// A piece seed mode assumed complete failed its hash when read
+		if !d.pieceManager.IsComplete() {
+			d.repair()
+		}
+
+		policy := d.GetCompletion()
+		if d.doneSeeding(policy) {
+			d.finish(policy)
+			return
+		}
+
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// doneSeeding returns true once the policy no longer wants the completed
+// torrent seeding. The transfer mode overrides it: ModeNoSeed never seeds
+// and ModeSeedOnly always does.
+func (d *Downloader) doneSeeding(policy CompletionPolicy) bool {
+	switch d.GetTransferMode() {
+	case ModeNoSeed:
+		return true
+	case ModeSeedOnly:
+		return false
+	}
+
+	switch policy.Action {
+	case CompleteSeedForever:
+		return false
+	case CompleteSeed:
+	default:
+		return true
+	}
+
+	if policy.SeedRatio > 0 && d.ratio() >= policy.SeedRatio {
+		return true
+	}
+	if policy.SeedTime > 0 {
+		d.mu.RLock()
+		completedAt := d.completedAt
+		d.mu.RUnlock()
+		if d.clock.Now().Sub(completedAt) >= policy.SeedTime {
+			return true
+		}
+	}
+	return false
+}
+
+// finish carries out the policy's action and closes Finished
+func (d *Downloader) finish(policy CompletionPolicy) {
+	d.logger.Printf("Completion action: %s\n", policy.Action)
+
+	switch policy.Action {
+	case CompleteRunHook:
+		if err := d.runHook(policy.Hook); err != nil {
+			d.logger.Printf("Completion hook failed: %v\n", err)
+		}
+		d.Stop()
+	case CompleteRemove:
+		if d.session != nil {
+			d.session.RemoveTorrent(d)
+		} else {
+			d.Stop()
+		}
+	default:
+		d.Stop()
+	}
+
+	d.mu.Lock()
+	d.finishedWith = policy.Action
+	d.mu.Unlock()
+	close(d.finished)
+}
+
+// runHook runs command through the shell, telling it which torrent
+// completed in the TORRENT_NAME, TORRENT_INFOHASH and TORRENT_DIR
+// environment variables, and waits for it to exit
+func (d *Downloader) runHook(command string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(),
+		"TORRENT_NAME="+d.torrent.Info.Name,
+		"TORRENT_INFOHASH="+d.torrent.InfoHash.String(),
+		"TORRENT_DIR="+d.GetOutputDir(),
+	)
+
+	output, err := cmd.CombinedOutput()
+	if len(output) > 0 {
+		d.logger.Printf("Completion hook output:\n%s", output)
+	}
+	return err
+}
+
+// ShutdownCommand returns the command that powers off the machine on this
+// platform
+func ShutdownCommand() *exec.Cmd {
+	switch runtime.GOOS {
+	case "windows":
+		return exec.Command("shutdown", "/s", "/t", "0")
+	default:
+		return exec.Command("shutdown", "-h", "now")
+	}
+}
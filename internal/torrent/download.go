@@ -2,73 +2,421 @@ package torrent
 
 import (
 	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
+	"bittorrentclient/internal/clock"
 	"bittorrentclient/internal/file"
 	"bittorrentclient/internal/peer"
 	piece "bittorrentclient/internal/pieces"
+	"bittorrentclient/internal/tracker"
 )
 
+// MaxResumePeers is how many known-good peers are saved in the resume data
+const MaxResumePeers = 30
+
+const (
+	// TargetPeers is how many connections NumWant aims for when no peer
+	// limit is set
+	TargetPeers = 50
+	// MaxNumWant caps how many peers we ask a tracker for in one announce
+	MaxNumWant = 200
+)
+
+// NewPeerUnchokePeriod is how long a newly connected peer stays unchoked
+// before the choker ranks it, giving it a reason to unchoke us back
+const NewPeerUnchokePeriod = 30 * time.Second
+
 // Downloader manages the download process for a torrent
 type Downloader struct {
 	torrent      *Torrent
 	pieceManager *piece.Manager
 	requestMgr   *piece.RequestManager
-	selector     *piece.PieceSelector
+	selector     piece.Selector
 	connections  map[string]*peer.Connection
+	peerAddrs    map[string]string   // remote address -> peer key, for duplicate detection
+	peerPool     *peer.Pool          // Every address known for this torrent, for dialing
+	swarmOf      map[string]InfoHash // Address -> swarm it was found in, if not the torrent's InfoHash
 	mu           sync.RWMutex
 	done         chan struct{}
+	stopOnce     sync.Once
 	downloadDone chan struct{}
+
+	completion   CompletionPolicy
+	completedAt  time.Time        // When the download completed; zero until then
+	finished     chan struct{}    // Closed once the completion action was carried out
+	finishedWith CompletionAction // The action carried out, once finished is closed
+	mode         TransferMode     // Whether the torrent downloads, uploads or both
+	queued       bool             // Waiting for a download slot, see Session.SetMaxActive
+
+	recheck      RecheckPolicy
+	lastRecheck  time.Time // When the data was last rechecked; zero if never
+	crashRecheck bool      // The last run crashed and the policy wants a recheck
+	repairing    bool      // repair is downloading pieces lost after completion
+
+	newPeerUnchokes map[string]time.Time       // peer key -> end of its bootstrap unchoke
+	optimistic      string                     // Peer key holding the optimistic unchoke; empty if none
+	optimisticUntil time.Time                  // When the optimistic unchoke moves on
+	chokeRand       *rand.Rand                 // Picks the optimistic unchoke
+	peerLimits      map[string]*peerRateLimits // peer key -> its rate caps, see SetPeerRateLimits
+	capturePeers    map[string]string          // peer key -> capture file for its next connection
+	captureDir      string                     // Capture every connection here; empty captures none
+
+	session     *Session          // Owning session, if any
+	uploadSlots *UploadSlotConfig // Per-torrent override of the session's upload slots
+
+	discardedBytes  int64       // Unrequested bytes discarded by peers that have since gone away
+	uploadedBefore  int64       // Bytes uploaded in earlier runs, restored by Session.LoadAll
+	selections      []Selection // As passed to Select, for Session.SaveAll
+	trackerSeeds    int
+	trackerLeechers int
+
+	smoothedRate    float64 // EMA of the verified download rate, see sampleRate
+	lastSample      time.Time
+	lastSampleBytes int64
+
+	lastDataAt   time.Time     // When verified data last arrived, for stall detection
+	stalled      bool          // No data for stallTimeout; cleared when data arrives
+	stallTimeout time.Duration // 0 disables stall detection
+	onStall      StallFunc
+
+	maxPeers      int             // 0 means unlimited
+	allowlist     *peer.Allowlist // Only peers inside it; nil allows all
+	resume        bool
+	downloadLimit *RateLimiter
+	uploadLimit   *RateLimiter
+	logger        *log.Logger
+	verifyRate    int64
+	seedMode      bool
+	verifyOnRead  bool
+	writeCache    int64
+	allocation    file.AllocationStrategy
+	incomplete    bool       // Stage data in IncompletePath until complete
+	outputDir     string     // Where the finished files belong
+	stagingDir    string     // Where in-progress data lives; empty if not staged
+	locationMu    sync.Mutex // Serializes moving the data, see SetLocation
+	lock          *file.Lock // Held from Start until Stop; nil if never acquired
+	orderLog      string     // Export the piece completion order here when done or stopped
+	clock         clock.Clock
+	deterministic bool  // Seeded randomness and fixed peer order, see WithDeterministic
+	randSeed      int64 // Seed for the choker's random choices when deterministic
 }
 
 // NewDownloader creates a new downloader
-func NewDownloader(t *Torrent, outputDir string) *Downloader {
-	return &Downloader{
+func NewDownloader(t *Torrent, outputDir string, opts ...Option) *Downloader {
+	d := &Downloader{
 		torrent:      t,
-		pieceManager: GetPieceManager(t, outputDir),
 		requestMgr:   piece.NewRequestManager(piece.MaxRequestsPerPeer),
 		selector:     piece.NewPieceSelector(),
 		connections:  make(map[string]*peer.Connection),
+		peerAddrs:    make(map[string]string),
+		peerPool:     peer.NewPool(),
+		swarmOf:      make(map[string]InfoHash),
 		done:         make(chan struct{}),
 		downloadDone: make(chan struct{}),
+		finished:     make(chan struct{}),
+		completion:   DefaultCompletionPolicy(),
+
+		newPeerUnchokes: make(map[string]time.Time),
+		peerLimits:      make(map[string]*peerRateLimits),
+		capturePeers:    make(map[string]string),
+
+		resume:     true,
+		logger:     log.New(os.Stdout, "", 0),
+		writeCache: file.DefaultWriteCacheSize,
+		allocation: file.AutoAllocation,
+		clock:      clock.Real,
+
+		stallTimeout: DefaultStallTimeout,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	// Stage in-progress data unless resume state in outputDir shows the
+	// data already lives there (e.g. it was moved there on completion)
+	d.outputDir = outputDir
+	dataDir := outputDir
+	if d.incomplete {
+		if _, err := os.Stat(ResumePath(t, outputDir)); err != nil {
+			d.stagingDir = IncompletePath(t, outputDir)
+			dataDir = d.stagingDir
+		}
+	}
+
+	if d.pieceManager == nil {
+		if d.resume {
+			d.pieceManager = GetPieceManager(t, dataDir)
+		} else {
+			d.pieceManager = newPieceManager(t, dataDir)
+		}
+	}
+
+	d.pieceManager.SetVerifyRate(d.verifyRate)
+	d.pieceManager.SetAllocationStrategy(d.allocation)
+	d.pieceManager.SetVerifyOnRead(d.verifyOnRead)
+	if err := d.pieceManager.SetWriteCache(d.writeCache); err != nil {
+		d.logger.Printf("Failed to set write cache: %v\n", err)
+	}
+	d.pieceManager.SetResumePeersFunc(func() []string {
+		return d.peerPool.Best(MaxResumePeers)
+	})
+
+	d.requestMgr.SetClock(d.clock)
+	d.peerPool.SetClock(d.clock)
+	d.pieceManager.SetClock(d.clock)
+	if d.deterministic {
+		d.chokeRand = rand.New(rand.NewSource(d.randSeed))
+	} else {
+		d.chokeRand = rand.New(rand.NewSource(time.Now().UnixNano()))
 	}
+	d.downloadLimit.SetClock(d.clock)
+	d.uploadLimit.SetClock(d.clock)
+	return d
+}
+
+// GetTorrent returns the torrent being downloaded
+func (d *Downloader) GetTorrent() *Torrent {
+	return d.torrent
+}
+
+// Clock returns the clock the downloader's timers run on
+func (d *Downloader) Clock() clock.Clock {
+	return d.clock
+}
+
+// GetOutputDir returns where the torrent's files are (or will be, once a
+// staged download completes)
+func (d *Downloader) GetOutputDir() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.outputDir
 }
+
 func (d *Downloader) GetPieceMgr() *piece.Manager {
 	return d.pieceManager
 }
+
+// GetPeerPool returns the pool of known peer addresses for this torrent
+func (d *Downloader) GetPeerPool() *peer.Pool {
+	return d.peerPool
+}
+
+// AddSwarmPeer adds a peer address found in the swarm of infoHash, one of
+// the torrent's SwarmHashes, to the pool. HandshakeHash then returns the
+// hash to connect to it with.
+func (d *Downloader) AddSwarmPeer(addr string, infoHash InfoHash, source peer.Source) {
+	if !d.peerPool.Add(addr, source) || infoHash == d.torrent.InfoHash || !d.torrent.InSwarm(infoHash) {
+		return // Peers seen in both swarms get the v1 hash
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.swarmOf[addr] = infoHash
+}
+
+// HandshakeHash returns the info hash to send in the handshake with addr:
+// that of the swarm it was found in
+func (d *Downloader) HandshakeHash(addr string) InfoHash {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if infoHash, ok := d.swarmOf[addr]; ok {
+		return infoHash
+	}
+	return d.torrent.InfoHash
+}
 func GetPieceManager(t *Torrent, outputDir string) *piece.Manager {
+	manager := newPieceManager(t, outputDir)
+	manager.SetJournalPath(JournalPath(t, outputDir))
+	manager.SetResumePath(ResumePath(t, outputDir))
+	return manager
+}
+
+// newPieceManager creates a piece manager without resume state
+func newPieceManager(t *Torrent, outputDir string) *piece.Manager {
 	// t.Info.Pieces is already [][20]byte, so use it directly
 	pieceHashes := t.Info.Pieces
+	if len(pieceHashes) == 0 && t.Info.IsV2() {
+		pieceHashes = make([][20]byte, t.Info.NumPieces())
+	}
 
 	// Create file info from torrent
 	fileInfos := createFileInfoFromTorrent(t)
 
-	return piece.NewManager(pieceHashes, t.Info.PieceLength, t.Info.GetTotalLength(), fileInfos, outputDir)
+	// v2 and hybrid pieces are checked against the merkle hashes too
+	manager := piece.NewManager(pieceHashes, t.Info.PieceLength, t.Info.GetTotalLength(), fileInfos, outputDir)
+	if t.Info.IsV2() {
+		if err := manager.SetMerkleHashes(t.merkleHashes()); err != nil {
+			fmt.Printf("⚠️  Failed to set v2 piece hashes: %v\n", err)
+		}
+	}
+	return manager
+}
+
+// ResumePath returns where the resume state is kept for a torrent
+func ResumePath(t *Torrent, outputDir string) string {
+	return filepath.Join(outputDir, "."+t.InfoHash.String()+".resume")
 }
 
-// Start starts the download process
-func (d *Downloader) Start() {
-	// Initialize file system before starting download
-	err := d.pieceManager.Initialize()
-	if err != nil {
-		fmt.Printf("Failed to initialize file system: %v\n", err)
+// JournalPath returns where the journal of verified pieces is kept for a torrent
+func JournalPath(t *Torrent, outputDir string) string {
+	return filepath.Join(outputDir, "."+t.InfoHash.String()+".journal")
+}
+
+// LockPath returns the lock file that keeps two downloads of a torrent from
+// writing to the same output directory at once
+func LockPath(t *Torrent, outputDir string) string {
+	return filepath.Join(outputDir, "."+t.InfoHash.String()+".lock")
+}
+
+// IncompleteDirName is the directory under the output directory holding
+// staged downloads, see WithIncompleteDir
+const IncompleteDirName = ".incomplete"
+
+// IncompletePath returns where a staged torrent's in-progress data is kept
+func IncompletePath(t *Torrent, outputDir string) string {
+	return filepath.Join(outputDir, IncompleteDirName, t.InfoHash.String())
+}
+
+// moveIntoPlace moves a completed staged download into the output
+// directory and removes its staging directory
+func (d *Downloader) moveIntoPlace() {
+	d.locationMu.Lock()
+	defer d.locationMu.Unlock()
+
+	if d.stagingDir == "" {
 		return
 	}
 
+	if err := d.pieceManager.MoveData(d.outputDir, ResumePath(d.torrent, d.outputDir)); err != nil {
+		d.logger.Printf("Failed to move download into %s: %v\n", d.outputDir, err)
+		return
+	}
+	if err := os.RemoveAll(d.stagingDir); err != nil {
+		d.logger.Printf("Failed to remove %s: %v\n", d.stagingDir, err)
+	}
+	os.Remove(filepath.Dir(d.stagingDir)) // Only succeeds once no other staged downloads are left
+	d.stagingDir = ""
+	d.logger.Printf("Moved completed download into %s\n", d.outputDir)
+}
+
+// Start starts the download process. It fails with file.ErrInUse if another
+// download holds the torrent's data in the same output directory.
+func (d *Downloader) Start() error {
+	// Lock the data before touching it, so a second instance (or the same
+	// torrent added twice) can't interleave its writes with ours
+	if err := os.MkdirAll(d.outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	lock, err := file.AcquireLock(LockPath(d.torrent, d.outputDir))
+	if err != nil {
+		return err
+	}
+	d.lock = lock
+	if lock.Abandoned() {
+		d.logger.Printf("⚠️  The last run didn't shut down cleanly\n")
+		d.mu.Lock()
+		d.crashRecheck = d.recheck.AfterCrash
+		d.mu.Unlock()
+	}
+
+	// Initialize file system before starting download
+	if err := d.pieceManager.Initialize(); err != nil {
+		return fmt.Errorf("failed to initialize file system: %w", err)
+	}
+
+	if d.seedMode {
+		if err := d.pieceManager.SetSeedMode(); err != nil {
+			return fmt.Errorf("failed to enter seed mode: %w", err)
+		}
+		d.logger.Printf("Seed mode: skipping hash check, pieces are verified when first requested\n")
+	}
+
+	// Peers that worked last time are dialed before the tracker's
+	for _, addr := range d.pieceManager.GetResumePeers() {
+		d.peerPool.Add(addr, peer.SourceResume)
+	}
+
 	go d.downloadLoop()
+	go d.uploadLoop()
+	go d.chokeLoop()
+	go d.completionLoop()
+	if d.recheck.Enabled() {
+		go d.recheckLoop()
+	}
+	return nil
+}
+
+// NewConnection sets up a connection, not yet started, for a peer that
+// completed the handshake for this torrent, under whichever of its
+// SwarmHashes the handshake used. Unless the torrent is private,
+// the connection serves its metadata to magnet users.
+func (d *Downloader) NewConnection(p *peer.Peer) *peer.Connection {
+	conn := peer.NewConnection(p.Conn, p.InfoHash)
+	conn.ID = p.ID
+	conn.Extended = p.Extended
+	conn.FastExtension = p.FastExtension
+	conn.NumPieces = d.torrent.Info.NumPieces()
+	conn.SetHaves(d.pieceManager.GetBitfield())
+	conn.SetClock(d.clock)
+	if d.session != nil {
+		conn.SetTrace(d.session.Trace())
+	}
+	d.startCapture(conn)
+	if !d.torrent.Info.IsPrivate() {
+		conn.SetMetadata(d.torrent.RawInfo())
+	}
+	return conn
 }
 
-// AddPeer adds a peer connection to the downloader
-func (d *Downloader) AddPeer(conn *peer.Connection) {
+// AddPeer adds a peer connection to the downloader. It returns an error,
+// and leaves the connection untouched, if we are already connected to the
+// same peer ID or the same remote address, or the address is outside the
+// peer allowlist.
+func (d *Downloader) AddPeer(conn *peer.Connection) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	peerKey := fmt.Sprintf("%x", conn.ID[:8])
+	peerKey := peerKeyFor(conn.ID)
+	if _, exists := d.connections[peerKey]; exists {
+		return fmt.Errorf("duplicate connection to peer %x", conn.ID[:8])
+	}
+
+	if d.maxPeers > 0 && len(d.connections) >= d.maxPeers {
+		return fmt.Errorf("peer limit of %d reached", d.maxPeers)
+	}
+
+	addr := remoteAddrOf(conn)
+	if !d.allowlist.Allows(addr) {
+		return fmt.Errorf("peer %s is outside the peer allowlist", addr)
+	}
+	if addr != "" {
+		if _, exists := d.peerAddrs[addr]; exists {
+			return fmt.Errorf("duplicate connection to address %s", addr)
+		}
+		d.peerAddrs[addr] = peerKey
+		d.peerPool.RecordConnected(addr)
+	}
+
 	d.connections[peerKey] = conn
+	conn.SetAvailabilityTracker(d.pieceManager)
+	d.applyPeerRateLimits(peerKey)
+
+	// Count blocks served to this peer towards the torrent's upload total
+	if progress := d.pieceManager.GetFileProgress(); progress != nil {
+		conn.SetOnUpload(progress.AddUploadedBytes)
+	}
+	if d.uploadLimit != nil {
+		conn.SetUploadLimiter(d.uploadLimit)
+	}
 
 	// Start handling this peer
 	go d.handlePeer(conn)
+	return nil
 }
 
 // RemovePeer removes a peer connection
@@ -76,16 +424,96 @@ func (d *Downloader) RemovePeer(peerID [20]byte) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	peerKey := fmt.Sprintf("%x", peerID[:8])
+	peerKey := peerKeyFor(peerID)
 	if conn, exists := d.connections[peerKey]; exists {
 		conn.Stop()
 		delete(d.connections, peerKey)
-		d.requestMgr.ClearPeerRequests(peerID)
+		delete(d.newPeerUnchokes, peerKey)
+		d.discardedBytes += conn.GetUnrequestedBytes()
+		if addr := remoteAddrOf(conn); addr != "" {
+			delete(d.peerAddrs, addr)
+			d.peerPool.RecordDisconnected(addr)
+		}
+		d.releasePeerRequests(peerID)
+		d.requestMgr.ForgetPeer(peerID)
+	}
+}
+
+// releasePeerRequests drops a peer's outstanding requests and makes the
+// pieces they belonged to selectable again, so other peers can pick them up
+// right away instead of waiting for the requests to time out. A piece other
+// peers still have requests out for stays theirs, see releasePiece.
+func (d *Downloader) releasePeerRequests(peerID [20]byte) []*piece.Request {
+	requests := d.requestMgr.ClearPeerRequests(peerID)
+	released := make(map[int64]bool)
+	for _, req := range requests {
+		if released[req.PieceIndex] {
+			continue
+		}
+		released[req.PieceIndex] = true
+		d.releasePiece(req.PieceIndex)
+	}
+	return requests
+}
+
+// releasePiece makes a piece selectable again once no peer has requests
+// out for it. While some do, it stays with them: they request the blocks
+// given up along with the rest of the piece, rather than a second peer
+// picking the whole piece up again.
+func (d *Downloader) releasePiece(pieceIndex int64) {
+	if !d.requestMgr.PieceRequested(pieceIndex) {
+		d.pieceManager.ReleasePiece(int(pieceIndex))
+	}
+}
+
+// releaseAllRequests releases every connected peer's requests, see
+// releasePeerRequests, and returns them by peer for cancelRequests. Caller
+// must hold d.mu.
+func (d *Downloader) releaseAllRequests() map[*peer.Connection][]*piece.Request {
+	released := make(map[*peer.Connection][]*piece.Request)
+	for _, conn := range d.connections {
+		if requests := d.releasePeerRequests(conn.ID); len(requests) > 0 {
+			released[conn] = requests
+		}
+	}
+	return released
+}
+
+// cancelRequests cancels released requests on the wire, so peers stop
+// sending blocks we would only discard. Called without d.mu, since sending
+// may wait on the upload limit.
+func (d *Downloader) cancelRequests(released map[*peer.Connection][]*piece.Request) {
+	for conn, requests := range released {
+		for _, req := range requests {
+			if err := conn.CancelRequest(req.PieceIndex, req.Begin, req.Length); err != nil {
+				d.logger.Printf("Failed to cancel request to peer %x: %v\n", conn.ID[:8], err)
+				break
+			}
+		}
+	}
+}
+
+// peerKeyFor returns the connections map key for a peer ID. The full ID is
+// used because the first bytes are usually just the client prefix.
+func peerKeyFor(peerID [20]byte) string {
+	return fmt.Sprintf("%x", peerID[:])
+}
+
+// remoteAddrOf returns the remote IP:port of a connection, if known
+func remoteAddrOf(conn *peer.Connection) string {
+	if conn.Conn == nil || conn.Conn.RemoteAddr() == nil {
+		return ""
 	}
+	return conn.Conn.RemoteAddr().String()
 }
 
-// Stop stops the download process
+// Stop stops the download process. It is safe to call more than once.
 func (d *Downloader) Stop() {
+	d.stopOnce.Do(d.stop)
+}
+
+// stop does the work of Stop, which only lets it run once
+func (d *Downloader) stop() {
 	close(d.done)
 
 	// Stop all connections
@@ -95,9 +523,39 @@ func (d *Downloader) Stop() {
 	}
 	d.mu.Unlock()
 
+	d.writeOrderLog()
+
+	d.locationMu.Lock()
+	defer d.locationMu.Unlock()
+
+	// Without the lock the data belongs to whoever holds it, and closing
+	// would overwrite their resume state with ours
+	if d.lock == nil {
+		return
+	}
+
 	// Close file writer
 	if err := d.pieceManager.Close(); err != nil {
-		fmt.Printf("Error closing file writer: %v\n", err)
+		d.logger.Printf("Error closing file writer: %v\n", err)
+	}
+	if err := d.lock.Release(); err != nil {
+		d.logger.Printf("Error releasing lock: %v\n", err)
+	}
+}
+
+// DisconnectPeers drops every peer connection. Peers can be added again
+// afterwards.
+func (d *Downloader) DisconnectPeers() {
+	d.mu.RLock()
+	conns := make([]*peer.Connection, 0, len(d.connections))
+	for _, conn := range d.connections {
+		conns = append(conns, conn)
+	}
+	d.mu.RUnlock()
+
+	// Each peer's handler removes it once its connection stops
+	for _, conn := range conns {
+		conn.Stop()
 	}
 }
 
@@ -106,11 +564,194 @@ func (d *Downloader) IsComplete() bool {
 	return d.pieceManager.IsComplete()
 }
 
+// SetFilePriority sets the priority of a file in the torrent by index, see
+// SetFilePriorityByPath
+func (d *Downloader) SetFilePriority(fileIndex int, priority file.Priority) error {
+	return d.pieceManager.SetFilePriority(fileIndex, priority)
+}
+
+// SetFileLocation maps a torrent file to an existing file on disk, so data
+// saved under another name or path can be seeded. Call before Start.
+func (d *Downloader) SetFileLocation(fileIndex int, diskPath string) error {
+	return d.pieceManager.SetFileLocation(fileIndex, diskPath)
+}
+
+// VerifyExistingData checks the data already on disk against the piece
+// hashes and marks matching pieces complete. Call after Start.
+func (d *Downloader) VerifyExistingData() (int, error) {
+	return d.pieceManager.VerifyExistingData()
+}
+
+// OnPieceVerified registers a callback that receives each piece's verified
+// bytes before they are written to disk (see piece.PieceVerifiedFunc)
+func (d *Downloader) OnPieceVerified(fn piece.PieceVerifiedFunc) {
+	d.pieceManager.SetPieceVerifiedHook(fn)
+}
+
+// OnFileComplete registers a function called as each file finishes downloading
+func (d *Downloader) OnFileComplete(fn piece.FileCompleteFunc) {
+	d.pieceManager.SetFileCompleteHook(fn)
+}
+
+// Err returns the error that stopped the download, or nil
+func (d *Downloader) Err() error {
+	return d.pieceManager.Err()
+}
+
 // GetProgress returns download progress
 func (d *Downloader) GetProgress() float64 {
 	return d.pieceManager.GetProgress()
 }
 
+// GetUploaded returns the total bytes uploaded for this torrent
+func (d *Downloader) GetUploaded() int64 {
+	progress := d.pieceManager.GetFileProgress()
+	if progress == nil {
+		return 0
+	}
+	return progress.GetUploadedBytes()
+}
+
+// GetTotalUploaded returns the bytes uploaded for this torrent including
+// earlier runs restored by Session.LoadAll, which is what its share ratio
+// counts
+func (d *Downloader) GetTotalUploaded() int64 {
+	d.mu.RLock()
+	before := d.uploadedBefore
+	d.mu.RUnlock()
+	return before + d.GetUploaded()
+}
+
+// ratio returns the share ratio: total uploaded bytes over the torrent size
+func (d *Downloader) ratio() float64 {
+	total := d.pieceManager.GetTotalLength()
+	if total == 0 {
+		return 0
+	}
+	return float64(d.GetTotalUploaded()) / float64(total)
+}
+
+// GetPeerUploaded returns bytes uploaded to each connected peer, keyed by peer ID (hex)
+func (d *Downloader) GetPeerUploaded() map[string]int64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	uploaded := make(map[string]int64, len(d.connections))
+	for key, conn := range d.connections {
+		uploaded[key] = conn.GetUploaded()
+	}
+	return uploaded
+}
+
+// GetPeerDownloadRates returns each connected peer's download rate over the
+// last peer.RateWindow, keyed by peer ID (hex). The choker ranks peers by it.
+func (d *Downloader) GetPeerDownloadRates() map[string]float64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	rates := make(map[string]float64, len(d.connections))
+	for key, conn := range d.connections {
+		rates[key] = conn.GetDownloadRate()
+	}
+	return rates
+}
+
+// NumWant returns how many peers to ask the tracker for: plenty when the
+// pool is starved, and tracker.NoPeers once we have enough to connect to,
+// including when seeding with every upload slot taken
+func (d *Downloader) NumWant() int {
+	d.mu.RLock()
+	connected := len(d.connections)
+	d.mu.RUnlock()
+
+	target := TargetPeers
+	if d.maxPeers > 0 {
+		target = d.maxPeers
+	}
+	if d.IsComplete() {
+		target = d.GetUploadSlots()
+	}
+
+	need := target - connected - d.peerPool.Dialable()
+	if need <= 0 {
+		return tracker.NoPeers
+	}
+
+	// Many addresses fail to connect, so ask for more than we're short
+	return min(2*need, MaxNumWant)
+}
+
+// AnnounceStats returns the uploaded/downloaded/left values for a tracker announce
+func (d *Downloader) AnnounceStats() (uploaded, downloaded, left int64) {
+	downloaded = d.pieceManager.GetDownloadedBytes()
+	left = d.pieceManager.GetTotalLength() - downloaded
+	if left < 0 {
+		left = 0
+	}
+	return d.GetUploaded(), downloaded, left
+}
+
+// SetUploadSlots overrides the upload slot configuration for this torrent
+func (d *Downloader) SetUploadSlots(cfg UploadSlotConfig) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.uploadSlots = &cfg
+}
+
+// ClearUploadSlots removes the per-torrent override, falling back to the session
+func (d *Downloader) ClearUploadSlots() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.uploadSlots = nil
+}
+
+// GetUploadSlotConfig returns the effective upload slot configuration
+func (d *Downloader) GetUploadSlotConfig() UploadSlotConfig {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.uploadSlots != nil {
+		return *d.uploadSlots
+	}
+	if d.session != nil {
+		return d.session.GetConfig().UploadSlots
+	}
+	return DefaultUploadSlotConfig()
+}
+
+// GetUploadSlots returns how many peers may currently be unchoked
+func (d *Downloader) GetUploadSlots() int {
+	var uploadRate float64
+	if progress := d.pieceManager.GetFileProgress(); progress != nil {
+		uploadRate = progress.GetUploadSpeed()
+	}
+	return d.GetUploadSlotConfig().SlotsFor(uploadRate)
+}
+
+// SetRatioLimit sets the upload ratio at which seeding should stop (0
+// disables), the completion policy's SeedRatio. A limit only applies to
+// CompleteSeed, so a torrent set to stop or seed forever switches to it;
+// the actions that don't seed can't take a limit.
+func (d *Downloader) SetRatioLimit(ratio float64) error {
+	if ratio < 0 {
+		return fmt.Errorf("ratio limit can't be negative")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if ratio > 0 {
+		switch d.completion.Action {
+		case CompleteSeed:
+		case CompleteStop, CompleteSeedForever:
+			d.completion.Action = CompleteSeed
+		default:
+			return fmt.Errorf("the %s completion action doesn't seed, so a ratio limit can't apply", d.completion.Action)
+		}
+	}
+	d.completion.SeedRatio = ratio
+	return nil
+}
+
 // WaitForCompletion waits until download is complete
 func (d *Downloader) WaitForCompletion() {
 	<-d.downloadDone
@@ -120,7 +761,7 @@ func (d *Downloader) WaitForCompletion() {
 func (d *Downloader) downloadLoop() {
 	defer close(d.downloadDone)
 
-	ticker := time.NewTicker(1 * time.Second)
+	ticker := d.clock.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
 	for {
@@ -128,59 +769,124 @@ func (d *Downloader) downloadLoop() {
 		case <-d.done:
 			return
 
-		case <-ticker.C:
+		case <-ticker.C():
 			if d.pieceManager.IsComplete() {
-				fmt.Printf("Download complete! 🎉\n")
+				if err := d.pieceManager.Flush(); err != nil {
+					d.logger.Printf("Failed to flush write cache: %v\n", err)
+				}
+				d.moveIntoPlace()
+				d.writeOrderLog()
+				d.logger.Printf("Download complete! 🎉\n")
 				return
 			}
 
-			// Handle timeout requests
-			d.handleTimeouts()
+			// Stop instead of looping forever on a poisoned piece
+			if err := d.pieceManager.Err(); err != nil {
+				d.logger.Printf("Download stopped: %v\n", err)
+				return
+			}
+			d.downloadStep()
+		}
+	}
+}
+
+// downloadCompleted returns true if downloadLoop, once it has returned,
+// got all the wanted pieces, which with a selection or skipped files
+// aren't all of them, rather than stopping on an error or with the torrent
+func (d *Downloader) downloadCompleted() bool {
+	select {
+	case <-d.done:
+		return false
+	default:
+	}
+	return d.pieceManager.Err() == nil
+}
 
-			// Try to make new requests
-			d.makeRequests()
+// downloadStep does one tick of downloadLoop's work towards completing the
+// download. In ModeSeedOnly it only keeps the peers tidy.
+func (d *Downloader) downloadStep() {
+	downloading := d.downloading()
 
-			// Print progress - Update this section
-			fmt.Printf("Progress: %.1f%% - Speed: %.2f KB/s - Files: %s\n",
-				d.pieceManager.GetProgress(),
-				d.pieceManager.GetDownloadSpeed()/1024,
-				d.getFileProgressSummary())
-		}
+	// Update the smoothed rate used for the ETA
+	d.sampleRate()
+
+	// Drop peers and ask for new ones if data stopped arriving; nothing
+	// arrives when we ask for nothing
+	if downloading {
+		d.checkStall()
 	}
+
+	// Get pieces that sat in the write cache too long onto disk
+	if err := d.pieceManager.FlushStale(); err != nil {
+		d.logger.Printf("Failed to flush write cache: %v\n", err)
+	}
+
+	// Drop peers whose connection has gone away
+	d.pruneDisconnected()
+
+	// Tell peers whether we still want anything from them
+	d.updateAllInterest()
+
+	// Handle timeout requests
+	d.handleTimeouts()
+
+	// Try to make new requests
+	if downloading {
+		d.makeRequests()
+	}
+
+	// Print progress - Update this section
+	d.logger.Printf("Progress: %.1f%% - Speed: %.2f KB/s - Files: %s\n",
+		d.pieceManager.GetProgress(),
+		d.pieceManager.GetDownloadSpeed()/1024,
+		d.getFileProgressSummary())
 }
 
 // handlePeer handles a single peer connection
 // Replace this function in internal/torrent/download.go
 func (d *Downloader) handlePeer(conn *peer.Connection) {
 	defer d.RemovePeer(conn.ID)
-	fmt.Printf("Handling peer %x\n", conn.ID[:8])
+	d.logger.Printf("Handling peer %x\n", conn.ID[:8])
 
-	if conn.IsUseful(d.pieceManager.GetCompletedPieces(), d.pieceManager.GetTotalPieces()) {
-		fmt.Printf("Peer %x is useful, sending interested\n", conn.ID[:8])
-		conn.SendInterested()
+	// Unchoke new peers even with no history so they have a reason to
+	// unchoke us back, unless we serve nothing or the upload slots are
+	// taken by other new peers
+	if d.uploading() && d.reserveNewPeerUnchoke(conn.ID) {
+		if err := conn.Unchoke(); err != nil {
+			d.logger.Printf("Failed to unchoke peer %x: %v\n", conn.ID[:8], err)
+			d.mu.Lock()
+			delete(d.newPeerUnchokes, peerKeyFor(conn.ID))
+			d.mu.Unlock()
+		}
 	}
 
+	d.updateInterest(conn, d.pieceManager.GetCompletedPieces())
+
 	// Use a for...range loop over the piece data channel.
 	// This loop will automatically terminate when conn.GetPieceData() is closed
-	// by the connection's Stop() method, preventing a goroutine leak.
+	// by the connection's message loop on exit, preventing a goroutine leak.
 	for {
 		select {
 		case pieceData, ok := <-conn.GetPieceData():
 			if !ok {
 				// Channel has been closed, exit the goroutine.
-				fmt.Printf("Peer %x disconnected. Exiting handler.\n", conn.ID[:8])
+				d.logger.Printf("Peer %x disconnected. Exiting handler.\n", conn.ID[:8])
 				return
 			}
 
-			d.requestMgr.RemoveRequest(conn.ID, pieceData.PieceIndex, pieceData.Begin)
+			d.requestMgr.CompleteRequest(conn.ID, pieceData.PieceIndex, pieceData.Begin)
+			if addr := remoteAddrOf(conn); addr != "" {
+				d.peerPool.RecordDownload(addr, int64(len(pieceData.Data)))
+			}
 
 			err := d.pieceManager.HandlePieceMessage(
+				conn.ID,
 				int(pieceData.PieceIndex),
 				pieceData.Begin,
 				pieceData.Data,
 			)
 			if err != nil {
-				fmt.Printf("Error handling piece data from peer %x: %v\n", conn.ID[:8], err)
+				d.logger.Printf("Error handling piece data from peer %x: %v\n", conn.ID[:8], err)
 				// Optionally, you could disconnect from a peer that sends bad data.
 				continue
 			}
@@ -190,28 +896,75 @@ func (d *Downloader) handlePeer(conn *peer.Connection) {
 
 		case <-d.done:
 			// The entire downloader is shutting down.
-			fmt.Printf("Downloader shutting down. Exiting handler for peer %x.\n", conn.ID[:8])
+			d.logger.Printf("Downloader shutting down. Exiting handler for peer %x.\n", conn.ID[:8])
 			return
 		}
 	}
 }
 
+// requestOrder returns the connected peers in the order makeRequests visits
+// them: map order normally, sorted by peer key when deterministic. Caller
+// must hold d.mu.
+func (d *Downloader) requestOrder() []*peer.Connection {
+	keys := make([]string, 0, len(d.connections))
+	for key := range d.connections {
+		keys = append(keys, key)
+	}
+	if d.deterministic {
+		sort.Strings(keys)
+	}
+
+	conns := make([]*peer.Connection, len(keys))
+	for i, key := range keys {
+		conns[i] = d.connections[key]
+	}
+	return conns
+}
+
 // Replace the existing makeRequests function in internal/torrent/download.go
 
 func (d *Downloader) makeRequests() {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
 
-	for _, conn := range d.connections {
-		// A peer must be connected, not choking us, and have capacity for more requests.
-		if !conn.IsConnected() || conn.Choked || !d.requestMgr.CanRequestFromPeer(conn.ID) {
+	for _, conn := range d.requestOrder() {
+		// Blocks the peer rejected can go to any peer
+		for _, req := range conn.TakeRejectedRequests() {
+			d.requestMgr.RemoveRequest(conn.ID, req.PieceIndex, req.Begin)
+			d.releasePiece(req.PieceIndex)
+		}
+
+		// A choked peer discards our outstanding requests, so free its slots.
+		// A fast extension peer rejects them instead, and still serves its
+		// allowed-fast pieces.
+		bitfield := conn.Bitfield
+		if conn.IsChoked() {
+			if !conn.FastExtension {
+				d.releasePeerRequests(conn.ID)
+				continue
+			}
+			if bitfield = conn.GetAllowedFastBitfield(); bitfield == nil {
+				continue
+			}
+		}
+
+		// Hold off on new pieces while over the download rate limit
+		if !d.downloadLimit.Ready() {
+			return
+		}
+
+		// A peer must be connected and have capacity for more requests,
+		// never more than it said it queues
+		d.requestMgr.SetPeerLimit(conn.ID, conn.GetPeerReqq())
+		if !conn.IsConnected() || !d.requestMgr.CanRequestFromPeer(conn.ID) {
 			continue // Skip this peer if it's not ready
 		}
 
 		// Select a piece that the peer has, which we need, and is not already pending.
 		piece := d.selector.SelectPiece(
 			d.pieceManager,
-			conn.Bitfield,
+			conn.ID,
+			bitfield,
 			d.pieceManager.GetDownloaded() == 0,
 		)
 
@@ -221,7 +974,7 @@ func (d *Downloader) makeRequests() {
 			d.pieceManager.MarkPieceAsPending(piece)
 
 			// This log is helpful to see which piece is being worked on
-			fmt.Printf("INFO: Requesting piece %d from peer %x\n", piece.Index, conn.ID[:8])
+			d.logger.Printf("INFO: Requesting piece %d from peer %x\n", piece.Index, conn.ID[:8])
 			d.requestBlocksFromPiece(conn, piece)
 		}
 	}
@@ -241,19 +994,20 @@ func (d *Downloader) requestBlocksFromPiece(conn *peer.Connection, piece *piece.
 		if err != nil {
 			continue
 		}
+		d.downloadLimit.Take(block.Length)
 
 		// Send request to peer
 		err = conn.RequestPiece(int64(piece.Index), block.Begin, block.Length)
 		if err != nil {
 			d.requestMgr.RemoveRequest(conn.ID, int64(piece.Index), block.Begin)
-			fmt.Printf("Failed to request block: %v\n", err)
+			d.logger.Printf("Failed to request block: %v\n", err)
 		}
 	}
 }
 
 // requestMoreBlocks requests more blocks from a piece that's being downloaded
 func (d *Downloader) requestMoreBlocks(conn *peer.Connection, pieceIndex int) {
-	if pieceIndex >= len(d.pieceManager.GetPieces()) {
+	if pieceIndex >= len(d.pieceManager.GetPieces()) || !d.downloading() {
 		return
 	}
 
@@ -265,7 +1019,7 @@ func (d *Downloader) requestMoreBlocks(conn *peer.Connection, pieceIndex int) {
 	// Request more blocks if we have capacity
 	missingBlocks := piece.GetMissingBlocks()
 	for _, block := range missingBlocks {
-		if !d.requestMgr.CanRequestFromPeer(conn.ID) {
+		if !d.requestMgr.CanRequestFromPeer(conn.ID) || !d.downloadLimit.Ready() {
 			break
 		}
 
@@ -273,6 +1027,7 @@ func (d *Downloader) requestMoreBlocks(conn *peer.Connection, pieceIndex int) {
 		if err != nil {
 			continue
 		}
+		d.downloadLimit.Take(block.Length)
 
 		err = conn.RequestPiece(int64(piece.Index), block.Begin, block.Length)
 		if err != nil {
@@ -281,15 +1036,69 @@ func (d *Downloader) requestMoreBlocks(conn *peer.Connection, pieceIndex int) {
 	}
 }
 
+// pruneDisconnected removes peers whose connection is no longer alive
+func (d *Downloader) pruneDisconnected() {
+	d.mu.RLock()
+	var dead [][20]byte
+	for _, conn := range d.connections {
+		if !conn.IsConnected() {
+			dead = append(dead, conn.ID)
+		}
+	}
+	d.mu.RUnlock()
+
+	for _, peerID := range dead {
+		d.logger.Printf("Pruning disconnected peer %x\n", peerID[:8])
+		d.RemovePeer(peerID)
+	}
+}
+
+// updateAllInterest re-evaluates our interest in every connected peer
+func (d *Downloader) updateAllInterest() {
+	completed := d.pieceManager.GetCompletedPieces()
+
+	d.mu.RLock()
+	conns := make([]*peer.Connection, 0, len(d.connections))
+	for _, conn := range d.connections {
+		conns = append(conns, conn)
+	}
+	d.mu.RUnlock()
+
+	for _, conn := range conns {
+		d.updateInterest(conn, completed)
+	}
+}
+
+// updateInterest sends Interested if the peer has pieces we need, and
+// NotInterested once it doesn't (or we download nothing) so the peer can
+// give the upload slot to someone else
+func (d *Downloader) updateInterest(conn *peer.Connection, completed map[int]bool) {
+	interested := d.downloading() && conn.IsUseful(completed, d.pieceManager.GetTotalPieces())
+	if err := conn.SetInterested(interested); err != nil {
+		d.logger.Printf("Failed to update interest in peer %x: %v\n", conn.ID[:8], err)
+	}
+}
+
 // handleTimeouts handles request timeouts
 func (d *Downloader) handleTimeouts() {
 	timeouts := d.requestMgr.GetTimeoutRequests(piece.RequestTimeout)
 
 	for _, req := range timeouts {
-		fmt.Printf("Request timeout: piece %d, begin %d\n", req.PieceIndex, req.Begin)
+		d.logger.Printf("Request timeout: piece %d, begin %d\n", req.PieceIndex, req.Begin)
 		d.requestMgr.RemoveRequest(req.PeerID, req.PieceIndex, req.Begin)
 
-		// TODO: Could re-request from different peer
+		// Tell the slow peer not to bother, then hand the piece back once
+		// no other block of it is in flight. A disconnected peer needs no
+		// cancel; its requests were already released.
+		d.mu.RLock()
+		conn, exists := d.connections[peerKeyFor(req.PeerID)]
+		d.mu.RUnlock()
+		if exists {
+			if err := conn.CancelRequest(req.PieceIndex, req.Begin, req.Length); err != nil {
+				d.logger.Printf("Failed to cancel request to peer %x: %v\n", req.PeerID[:8], err)
+			}
+		}
+		d.releasePiece(req.PieceIndex)
 	}
 }
 
@@ -317,9 +1126,10 @@ func createFileInfoFromTorrent(t *Torrent) []file.FileInfo {
 		}
 
 		files = append(files, file.FileInfo{
-			Path:   path,
-			Length: f.Length,
-			Offset: offset,
+			Path:    path,
+			Length:  f.Length,
+			Offset:  offset,
+			Padding: f.IsPadding(),
 		})
 
 		offset += f.Length
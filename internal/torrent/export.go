@@ -0,0 +1,88 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"bittorrentclient/internal/bencode"
+	"bittorrentclient/internal/tracker"
+)
+
+// EncodeTorrentFile builds a .torrent file around rawInfo, the bencoded
+// info dictionary exactly as received (e.g. fetched from peers over BEP 9),
+// so the file keeps its info hash. The trackers from every source are
+// merged into its announce and announce-list, see tracker.MergeTiers.
+func EncodeTorrentFile(rawInfo []byte, trackers ...[][]string) ([]byte, error) {
+	return encodeTorrentFile(rawInfo, nil, trackers...)
+}
+
+// encodeTorrentFile is EncodeTorrentFile, adding the piece layers of a v2
+// torrent when there are any
+func encodeTorrentFile(rawInfo []byte, pieceLayers map[[32]byte][]byte, trackers ...[][]string) ([]byte, error) {
+	if _, err := bencode.NewDecoder(rawInfo).DecodeDict(); err != nil {
+		return nil, fmt.Errorf("info is not a bencoded dictionary: %w", err)
+	}
+
+	outer := map[string]interface{}{"info": bencode.Raw(rawInfo)}
+	tiers := tracker.MergeTiers(trackers...)
+	if len(tiers) > 0 {
+		outer["announce"] = tiers[0][0]
+		list := make([]interface{}, len(tiers))
+		for i, tier := range tiers {
+			urls := make([]interface{}, len(tier))
+			for j, url := range tier {
+				urls[j] = url
+			}
+			list[i] = urls
+		}
+		outer["announce-list"] = list
+	}
+	if len(pieceLayers) > 0 {
+		layers := make(map[string]interface{}, len(pieceLayers))
+		for root, layer := range pieceLayers {
+			layers[string(root[:])] = string(layer)
+		}
+		outer["piece layers"] = layers
+	}
+	return bencode.Encode(outer)
+}
+
+// ExportTorrentFile writes a .torrent file for rawInfo into dir, named
+// after its info hash, and returns the path. rawInfo must hash to
+// infoHash, so a corrupted fetch is never kept; for a v2-only torrent that
+// is its SHA-256 hash truncated to 20 bytes.
+func ExportTorrentFile(dir string, infoHash InfoHash, rawInfo []byte, trackers ...[][]string) (string, error) {
+	return exportTorrentFile(dir, infoHash, rawInfo, nil, trackers...)
+}
+
+func exportTorrentFile(dir string, infoHash InfoHash, rawInfo []byte, pieceLayers map[[32]byte][]byte, trackers ...[][]string) (string, error) {
+	v2Hash := sha256.Sum256(rawInfo)
+	if InfoHash(sha1.Sum(rawInfo)) != infoHash && !bytes.Equal(v2Hash[:20], infoHash[:]) {
+		return "", fmt.Errorf("info dictionary does not match info hash %s", infoHash)
+	}
+	data, err := encodeTorrentFile(rawInfo, pieceLayers, trackers...)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, infoHash.String()+".torrent")
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return "", err
+	}
+	return path, os.Rename(path+".tmp", path)
+}
+
+// Export writes the torrent, with its info dictionary byte for byte, its
+// piece layers and its trackers plus any extra ones, into dir as
+// ExportTorrentFile does
+func (t *Torrent) Export(dir string, extraTrackers ...[][]string) (string, error) {
+	sources := append([][][]string{t.AnnounceTiers()}, extraTrackers...)
+	return exportTorrentFile(dir, t.InfoHash, t.RawInfo(), t.PieceLayers, sources...)
+}
@@ -11,6 +11,13 @@ type File struct {
 	Length int64    `bencode:"length"`
 	Path   []string `bencode:"path"`
 	MD5Sum *string  `bencode:"md5sum,omitempty"`
+	Attr   *string  `bencode:"attr,omitempty"` // File attributes (BEP 47); "p" marks a pad file
+}
+
+// IsPadding returns true if the file is a pad file, zeros aligning the next
+// file to a piece boundary that are never stored
+func (f *File) IsPadding() bool {
+	return f.Attr != nil && strings.Contains(*f.Attr, "p")
 }
 
 // ValidatePath checks if the file path is safe and valid
@@ -0,0 +1,146 @@
+package torrent
+
+import (
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Orphan is resume state or staged data left in an output directory by a
+// torrent the session isn't running, e.g. one that was removed or abandoned
+type Orphan struct {
+	Path     string    // Resume/journal file or .incomplete/<infohash> directory
+	InfoHash string    // Hex info hash the data belongs to
+	Size     int64     // Bytes on disk, summed over a directory's files
+	ModTime  time.Time // Last write to the file, or to anything in the directory
+}
+
+// FindOrphans lists the resume files, journals and .incomplete directories
+// in outputDir that belong to no torrent running in this session for that
+// directory
+func (s *Session) FindOrphans(outputDir string) ([]Orphan, error) {
+	active := s.activeInfoHashes(outputDir)
+
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []Orphan
+	for _, entry := range entries {
+		hash, ok := stateInfoHash(entry.Name())
+		if !ok || entry.IsDir() || active[hash] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // Removed since ReadDir
+		}
+		orphans = append(orphans, Orphan{
+			Path:     filepath.Join(outputDir, entry.Name()),
+			InfoHash: hash,
+			Size:     info.Size(),
+			ModTime:  info.ModTime(),
+		})
+	}
+
+	incompleteDir := filepath.Join(outputDir, IncompleteDirName)
+	entries, err = os.ReadDir(incompleteDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		hash := entry.Name()
+		if !entry.IsDir() || !isInfoHash(hash) || active[hash] {
+			continue
+		}
+		orphan := Orphan{Path: filepath.Join(incompleteDir, hash), InfoHash: hash}
+		if err := dirUsage(&orphan); err != nil {
+			return nil, err
+		}
+		orphans = append(orphans, orphan)
+	}
+	return orphans, nil
+}
+
+// CollectGarbage deletes the orphans in outputDir that haven't been written
+// to for at least retention, returning what was removed. The retention
+// period gives a torrent that is only briefly stopped a chance to come back
+// before its partial data is lost.
+func (s *Session) CollectGarbage(outputDir string, retention time.Duration) ([]Orphan, error) {
+	orphans, err := s.FindOrphans(outputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []Orphan
+	for _, orphan := range orphans {
+		if time.Since(orphan.ModTime) < retention {
+			continue
+		}
+		if err := os.RemoveAll(orphan.Path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, orphan)
+	}
+	os.Remove(filepath.Join(outputDir, IncompleteDirName)) // Only succeeds once empty
+	return removed, nil
+}
+
+// activeInfoHashes returns the info hashes of the session's torrents that
+// keep their data in outputDir
+func (s *Session) activeInfoHashes(outputDir string) map[string]bool {
+	active := make(map[string]bool)
+	for _, d := range s.GetDownloaders() {
+		if filepath.Clean(d.GetOutputDir()) == filepath.Clean(outputDir) {
+			active[d.torrent.InfoHash.String()] = true
+		}
+	}
+	return active
+}
+
+// stateInfoHash extracts the info hash from a resume or journal file name,
+// see ResumePath and JournalPath
+func stateInfoHash(name string) (string, bool) {
+	if !strings.HasPrefix(name, ".") {
+		return "", false
+	}
+	for _, ext := range []string{".resume", ".journal"} {
+		if hash, ok := strings.CutSuffix(name[1:], ext); ok && isInfoHash(hash) {
+			return hash, true
+		}
+	}
+	return "", false
+}
+
+// isInfoHash returns true if s is a hex-encoded info hash
+func isInfoHash(s string) bool {
+	if len(s) != 2*len(InfoHash{}) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// dirUsage fills in an orphan directory's size and latest modification time
+func dirUsage(orphan *Orphan) error {
+	return filepath.WalkDir(orphan.Path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			orphan.Size += info.Size()
+		}
+		if info.ModTime().After(orphan.ModTime) {
+			orphan.ModTime = info.ModTime()
+		}
+		return nil
+	})
+}
@@ -0,0 +1,201 @@
+package torrent
+
+import (
+	"sort"
+	"time"
+
+	"bittorrentclient/internal/peer"
+)
+
+const (
+	// DefaultStallTimeout is how long no data may arrive despite connected
+	// peers before the download counts as stalled
+	DefaultStallTimeout = 5 * time.Minute
+	// StallChurnFraction is the share of peers dropped when a download
+	// stalls, least useful first, to make room for fresh ones
+	StallChurnFraction = 0.5
+)
+
+// HealthStatus summarizes how well a torrent's swarm is serving it
+type HealthStatus int
+
+const (
+	// Healthy means data is arriving and every needed piece is available
+	Healthy HealthStatus = iota
+	// Degraded means no data right now, no peers, or some needed piece
+	// isn't held by any connected peer
+	Degraded
+	// Stalled means no data has arrived for the stall timeout despite
+	// connected peers
+	Stalled
+)
+
+// String returns the status name
+func (s HealthStatus) String() string {
+	switch s {
+	case Healthy:
+		return "healthy"
+	case Degraded:
+		return "degraded"
+	case Stalled:
+		return "stalled"
+	default:
+		return "unknown"
+	}
+}
+
+// Health is a snapshot of a torrent's swarm health
+type Health struct {
+	Status             HealthStatus
+	RarestAvailability int       // Connected peers holding the rarest needed piece (all pieces once complete)
+	ActivePeers        int       // Connected peers
+	DownloadRate       float64   // Smoothed download rate in bytes/second
+	LastData           time.Time // When verified data last arrived; zero if never
+}
+
+// StallEvent describes a download that stopped receiving data
+type StallEvent struct {
+	Duration time.Duration // How long no data has arrived
+	Peers    int           // Peers connected when the stall was detected
+	Dropped  int           // Peers disconnected to make room for new ones
+}
+
+// StallFunc is called when a download stalls, after the least useful peers
+// were dropped. It runs on its own goroutine, so it may re-announce and
+// connect new peers.
+type StallFunc func(event StallEvent)
+
+// OnStall registers a function called each time the download stalls
+func (d *Downloader) OnStall(fn StallFunc) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onStall = fn
+}
+
+// GetHealth returns the torrent's current swarm health
+func (d *Downloader) GetHealth() Health {
+	conns := d.connectionList()
+	counts := d.pieceCounts(conns)
+
+	health := Health{
+		ActivePeers:        len(conns),
+		RarestAvailability: rarest(counts, d.pieceManager.GetNeededPieces()),
+	}
+
+	d.mu.RLock()
+	health.DownloadRate = d.smoothedRate
+	health.LastData = d.lastDataAt
+	stalled := d.stalled
+	d.mu.RUnlock()
+
+	switch {
+	case stalled:
+		health.Status = Stalled
+	case health.ActivePeers == 0 || health.RarestAvailability == 0 || health.DownloadRate == 0:
+		health.Status = Degraded
+	default:
+		health.Status = Healthy
+	}
+	if d.IsComplete() && health.Status == Degraded && health.ActivePeers > 0 {
+		health.Status = Healthy // Nothing left to receive, so no rate is fine
+	}
+	return health
+}
+
+// rarest returns the lowest copy count among pieces, or among all pieces
+// if pieces is empty
+func rarest(counts []int, pieces []int) int {
+	if len(counts) == 0 {
+		return 0
+	}
+	if len(pieces) == 0 {
+		pieces = make([]int, len(counts))
+		for i := range pieces {
+			pieces[i] = i
+		}
+	}
+
+	lowest := counts[pieces[0]]
+	for _, i := range pieces[1:] {
+		if counts[i] < lowest {
+			lowest = counts[i]
+		}
+	}
+	return lowest
+}
+
+// checkStall emits a stall event, drops the least useful peers and resets
+// the timer when no data has arrived for the stall timeout despite
+// connected peers. Called once per download loop tick, after sampleRate.
+func (d *Downloader) checkStall() {
+	now := d.clock.Now()
+	conns := d.connectionList()
+
+	d.mu.Lock()
+	// Time without peers isn't a stall; only count from when they connect
+	if d.lastDataAt.IsZero() || len(conns) == 0 {
+		d.lastDataAt = now
+	}
+	idle := now.Sub(d.lastDataAt)
+	if d.stallTimeout <= 0 || idle < d.stallTimeout {
+		d.mu.Unlock()
+		return
+	}
+	d.stalled = true
+	d.lastDataAt = now // Give the replacement peers a full timeout
+	onStall := d.onStall
+	d.mu.Unlock()
+
+	dropped := d.churnPeers(conns)
+	d.logger.Printf("⚠️  Download stalled: no data for %s from %d peers, dropped %d\n",
+		idle.Round(time.Second), len(conns), dropped)
+
+	if onStall != nil {
+		go onStall(StallEvent{Duration: idle, Peers: len(conns), Dropped: dropped})
+	}
+}
+
+// churnPeers disconnects StallChurnFraction of conns, preferring peers
+// with nothing we need, then the slowest. It returns how many it dropped.
+func (d *Downloader) churnPeers(conns []*peer.Connection) int {
+	completed := d.pieceManager.GetCompletedPieces()
+	totalPieces := d.pieceManager.GetTotalPieces()
+
+	useful := make(map[*peer.Connection]bool, len(conns))
+	for _, conn := range conns {
+		useful[conn] = conn.IsUseful(completed, totalPieces)
+	}
+	sort.SliceStable(conns, func(i, j int) bool {
+		if useful[conns[i]] != useful[conns[j]] {
+			return !useful[conns[i]]
+		}
+		return conns[i].GetDownloadRate() < conns[j].GetDownloadRate()
+	})
+
+	drop := max(1, int(float64(len(conns))*StallChurnFraction))
+	for _, conn := range conns[:drop] {
+		conn.Stop() // Its handler removes it
+	}
+	return drop
+}
+
+// pieceCounts returns how many of conns hold each piece
+func (d *Downloader) pieceCounts(conns []*peer.Connection) []int {
+	counts := make([]int, d.pieceManager.GetTotalPieces())
+	for _, conn := range conns {
+		conn.CountPieces(counts)
+	}
+	return counts
+}
+
+// connectionList returns the connected peers
+func (d *Downloader) connectionList() []*peer.Connection {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	conns := make([]*peer.Connection, 0, len(d.connections))
+	for _, conn := range d.connections {
+		conns = append(conns, conn)
+	}
+	return conns
+}
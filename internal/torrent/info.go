@@ -2,6 +2,9 @@ package torrent
 
 import (
 	"errors"
+	"fmt"
+
+	piece "bittorrentclient/internal/pieces"
 )
 
 // Info represents the info dictionary of a torrent
@@ -14,6 +17,38 @@ type Info struct {
 	MD5Sum *string `bencode:"md5sum,omitempty"`
 
 	Files []File `bencode:"files,omitempty"`
+
+	Private *int64  `bencode:"private,omitempty"` // 1 restricts peers to the tracker's (BEP 27)
+	Source  *string `bencode:"source,omitempty"`  // Tag private trackers add so their copy has its own info hash
+
+	MetaVersion *int64   `bencode:"meta version,omitempty"` // 2 for BitTorrent v2 metadata (BEP 52)
+	FileTree    []FileV2 `bencode:"-"`                      // The v2 "file tree", flattened in path order
+}
+
+// IsV2 returns true if the info dictionary has BitTorrent v2 metadata. A
+// v2 torrent that also has piece hashes is a hybrid.
+func (i *Info) IsV2() bool {
+	return i.MetaVersion != nil && *i.MetaVersion == MetaVersion2 && len(i.FileTree) > 0
+}
+
+// IsHybrid returns true if the torrent has both v1 and v2 metadata, and so
+// can join either swarm
+func (i *Info) IsHybrid() bool {
+	return i.IsV2() && len(i.Pieces) > 0
+}
+
+// NumPieces returns how many pieces the torrent has
+func (i *Info) NumPieces() int {
+	if len(i.Pieces) > 0 || i.PieceLength <= 0 {
+		return len(i.Pieces)
+	}
+	return int((i.GetTotalLength() + i.PieceLength - 1) / i.PieceLength)
+}
+
+// IsPrivate returns true if the torrent is private: peers may only come
+// from its trackers
+func (i *Info) IsPrivate() bool {
+	return i.Private != nil && *i.Private == 1
 }
 
 // IsSingleFile returns true if this is a single-file torrent
@@ -35,10 +70,15 @@ func (i *Info) Validate() error {
 		return errors.New("piece length must be positive")
 	}
 
-	if len(i.Pieces) == 0 {
+	if len(i.Pieces) == 0 && !i.IsV2() {
 		return errors.New("no piece hashes provided")
 	}
 
+	// v2 pieces are merkle subtrees of 16 KiB blocks
+	if i.IsV2() && (i.PieceLength < piece.BlockSize || i.PieceLength&(i.PieceLength-1) != 0) {
+		return fmt.Errorf("v2 piece length %d is not a power of two of at least 16 KiB", i.PieceLength)
+	}
+
 	// Validate single vs multi-file consistency
 	if i.IsSingleFile() && i.IsMultiFile() {
 		return errors.New("torrent cannot be both single-file and multi-file")
@@ -15,3 +15,9 @@ func (t *Torrent) GenerateInfoHash(rawInfoDict []byte) InfoHash {
 	hash := sha1.Sum(rawInfoDict)
 	return InfoHash(hash)
 }
+
+// RawInfo returns the info dictionary exactly as bencoded in the .torrent
+// file, which is what peers fetching metadata (BEP 9) must receive
+func (t *Torrent) RawInfo() []byte {
+	return t.rawInfoDict
+}
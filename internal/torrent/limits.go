@@ -0,0 +1,93 @@
+package torrent
+
+import (
+	"fmt"
+)
+
+const (
+	// MaxPieceLength is the largest piece length accepted by default
+	MaxPieceLength = 64 << 20 // 64 MiB
+	// DefaultMaxPieceCount caps the number of pieces, since per-piece state
+	// is allocated up front for the whole torrent
+	DefaultMaxPieceCount = 1 << 20
+)
+
+// ParseLimits bounds the metadata we accept, protecting the piece and
+// mapper arrays from memory blowups on malicious torrents
+type ParseLimits struct {
+	MaxPieceLength   int64 // Reject larger piece lengths
+	MaxPieceCount    int   // Reject torrents with more pieces
+	StrictPowerOfTwo bool  // Reject, rather than warn about, piece lengths that aren't a power of two
+	StrictPaths      bool  // Reject, rather than rename, file paths that collide (including by case)
+}
+
+// DefaultParseLimits returns the limits used by Open and ParseTorrent
+func DefaultParseLimits() ParseLimits {
+	return ParseLimits{
+		MaxPieceLength: MaxPieceLength,
+		MaxPieceCount:  DefaultMaxPieceCount,
+	}
+}
+
+// checkPieces validates the piece length and count before piece state is
+// allocated
+func (l ParseLimits) checkPieces(pieceLength int64, numPieces int) error {
+	if pieceLength <= 0 {
+		return fmt.Errorf("piece length must be positive, got %d", pieceLength)
+	}
+	if l.MaxPieceLength > 0 && pieceLength > l.MaxPieceLength {
+		return fmt.Errorf("piece length %d exceeds limit of %d", pieceLength, l.MaxPieceLength)
+	}
+	if l.MaxPieceCount > 0 && numPieces > l.MaxPieceCount {
+		return fmt.Errorf("piece count %d exceeds limit of %d", numPieces, l.MaxPieceCount)
+	}
+	if l.StrictPowerOfTwo && !isPowerOfTwo(pieceLength) {
+		return fmt.Errorf("piece length %d is not a power of two", pieceLength)
+	}
+	return nil
+}
+
+// checkInfo validates a parsed info dictionary against the limits and
+// returns warnings for metadata that is unusual but usable
+func (l ParseLimits) checkInfo(info *Info) ([]string, error) {
+	var warnings []string
+
+	if !isPowerOfTwo(info.PieceLength) {
+		warnings = append(warnings, fmt.Sprintf("piece length %d is not a power of two", info.PieceLength))
+	}
+
+	var total int64
+	if info.IsSingleFile() {
+		total = *info.Length
+		if total < 0 {
+			return nil, fmt.Errorf("negative length %d", total)
+		}
+	}
+	for _, f := range info.Files {
+		if f.Length < 0 {
+			return nil, fmt.Errorf("negative length %d for file %v", f.Length, f.Path)
+		}
+		if total+f.Length < total {
+			return nil, fmt.Errorf("total length overflows")
+		}
+		total += f.Length
+	}
+
+	expected := (total + info.PieceLength - 1) / info.PieceLength
+	if int64(info.NumPieces()) != expected {
+		return nil, fmt.Errorf("torrent has %d piece hashes, %d bytes need %d", info.NumPieces(), total, expected)
+	}
+
+	renamed, err := resolvePathConflicts(info.Files, l.StrictPaths)
+	if err != nil {
+		return nil, err
+	}
+	warnings = append(warnings, renamed...)
+
+	return warnings, nil
+}
+
+// isPowerOfTwo returns true if n is a positive power of two
+func isPowerOfTwo(n int64) bool {
+	return n > 0 && n&(n-1) == 0
+}
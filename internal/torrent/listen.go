@@ -0,0 +1,92 @@
+package torrent
+
+import (
+	"fmt"
+	"net"
+
+	"bittorrentclient/internal/peer"
+)
+
+// DefaultListenPort is the port peers are accepted on unless another is
+// asked for
+const DefaultListenPort = 6881
+
+// Listen accepts inbound peers on port for every torrent in the session,
+// within config.Inbound, and adds them to the matching downloader.
+// It runs until Close.
+func (s *Session) Listen(port int, peerID [20]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener != nil {
+		return fmt.Errorf("session is already listening")
+	}
+	s.listenPort = port
+
+	lookup := func(infoHash [20]byte) bool {
+		return s.downloaderFor(infoHash) != nil
+	}
+	listener, err := s.dialer.Listen(port, s.config.Inbound, peerID, lookup, s.acceptPeer)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	return nil
+}
+
+// ListenPort returns the port the session accepts peers on, which is what
+// announces tell trackers: the port Listen bound (the one the system chose
+// if it was asked for 0), else the one it was asked for, else
+// DefaultListenPort
+func (s *Session) ListenPort() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.listener != nil {
+		for _, addr := range s.listener.Addrs() {
+			if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+				return tcpAddr.Port
+			}
+		}
+	}
+	if s.listenPort != 0 {
+		return s.listenPort
+	}
+	return DefaultListenPort
+}
+
+// InboundDropped returns how many inbound connections were closed for going
+// over config.Inbound
+func (s *Session) InboundDropped() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.listener == nil {
+		return 0
+	}
+	return s.listener.Dropped()
+}
+
+// downloaderFor returns the session's downloader for a torrent with
+// infoHash among its swarm hashes, or nil
+func (s *Session) downloaderFor(infoHash [20]byte) *Downloader {
+	for _, d := range s.GetDownloaders() {
+		if d.GetTorrent().InSwarm(infoHash) {
+			return d
+		}
+	}
+	return nil
+}
+
+// acceptPeer starts an inbound peer's connection and hands it to its
+// torrent's downloader
+func (s *Session) acceptPeer(p *peer.Peer) {
+	d := s.downloaderFor(p.InfoHash)
+	if d == nil || s.dialer.IsPaused() {
+		p.Close()
+		return
+	}
+
+	conn := d.NewConnection(p)
+	conn.Start()
+	if err := d.AddPeer(conn); err != nil {
+		conn.Stop()
+	}
+}
@@ -0,0 +1,107 @@
+package torrent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"bittorrentclient/internal/bencode"
+)
+
+// locationsFile is the file in SessionConfig.StateDir recording where each
+// torrent was downloaded to
+const locationsFile = "locations"
+
+// OutputDirFor returns the directory a torrent should be downloaded to:
+// outputDir if given, else where it went the last time it was added, else
+// the session's default SessionConfig.OutputDir
+func (s *Session) OutputDirFor(t *Torrent, outputDir string) string {
+	if outputDir != "" {
+		return outputDir
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if dir, ok := s.locations[t.InfoHash.String()]; ok {
+		return dir
+	}
+	return s.config.OutputDir
+}
+
+// recordLocation remembers where a torrent is downloaded to, so adding it
+// again without an output directory, e.g. after a restart, resumes there
+func (s *Session) recordLocation(t *Torrent, outputDir string) {
+	if abs, err := filepath.Abs(outputDir); err == nil {
+		outputDir = abs
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := t.InfoHash.String()
+	if s.locations[key] == outputDir {
+		return
+	}
+	s.locations[key] = outputDir
+	if err := s.saveLocations(); err != nil {
+		fmt.Printf("⚠️  Failed to record download location: %v\n", err)
+	}
+}
+
+// loadLocations reads the recorded download locations. A missing file just
+// means nothing was recorded yet.
+func (s *Session) loadLocations() error {
+	s.locations = make(map[string]string)
+	if s.config.StateDir == "" {
+		return nil
+	}
+
+	raw, err := os.ReadFile(filepath.Join(s.config.StateDir, locationsFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read download locations: %w", err)
+	}
+
+	decoded, err := bencode.Decode(raw)
+	if err != nil {
+		return fmt.Errorf("failed to decode download locations: %w", err)
+	}
+	dict, ok := decoded.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("download locations are not a dictionary")
+	}
+	for hash, value := range dict {
+		if dir, ok := value.(string); ok {
+			s.locations[hash] = dir
+		}
+	}
+	return nil
+}
+
+// saveLocations writes the recorded download locations, replacing the file
+// atomically. Caller must hold s.mu.
+func (s *Session) saveLocations() error {
+	if s.config.StateDir == "" {
+		return nil
+	}
+
+	dict := make(map[string]interface{}, len(s.locations))
+	for hash, dir := range s.locations {
+		dict[hash] = dir
+	}
+	encoded, err := bencode.Encode(dict)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.config.StateDir, 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(s.config.StateDir, locationsFile)
+	if err := os.WriteFile(path+".tmp", encoded, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
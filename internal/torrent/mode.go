@@ -0,0 +1,108 @@
+package torrent
+
+import (
+	"fmt"
+
+	"bittorrentclient/internal/peer"
+	piece "bittorrentclient/internal/pieces"
+)
+
+// TransferMode says which ways a torrent transfers data
+type TransferMode int
+
+const (
+	// ModeNormal downloads what is missing and uploads to peers, seeding
+	// once complete as the completion policy says
+	ModeNormal TransferMode = iota
+	// ModeNoSeed uploads while downloading but stops at 100%: the
+	// completion action is carried out at once, without seeding first
+	ModeNoSeed
+	// ModeSeedOnly never requests pieces and only serves the data it
+	// already has, until stopped by hand; the completion policy doesn't
+	// apply
+	ModeSeedOnly
+)
+
+// String returns the mode's name as accepted by ParseTransferMode
+func (m TransferMode) String() string {
+	switch m {
+	case ModeNormal:
+		return "normal"
+	case ModeNoSeed:
+		return "no-seed"
+	case ModeSeedOnly:
+		return "seed-only"
+	default:
+		return fmt.Sprintf("TransferMode(%d)", int(m))
+	}
+}
+
+// ParseTransferMode parses "normal", "no-seed" or "seed-only"
+func ParseTransferMode(name string) (TransferMode, error) {
+	for _, m := range []TransferMode{ModeNormal, ModeNoSeed, ModeSeedOnly} {
+		if m.String() == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown transfer mode %q", name)
+}
+
+// WithTransferMode sets which ways the torrent transfers data. Defaults to
+// ModeNormal.
+func WithTransferMode(mode TransferMode) Option {
+	return func(d *Downloader) {
+		d.mode = mode
+	}
+}
+
+// SetTransferMode changes which ways the torrent transfers data. Switching
+// to ModeSeedOnly gives up and cancels the blocks requested so far;
+// switching a seeding torrent to ModeNoSeed carries out its completion
+// action.
+func (d *Downloader) SetTransferMode(mode TransferMode) error {
+	if _, err := ParseTransferMode(mode.String()); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	if mode == d.mode {
+		d.mu.Unlock()
+		return nil
+	}
+	d.mode = mode
+	d.logger.Printf("Transfer mode: %s\n", mode)
+
+	var released map[*peer.Connection][]*piece.Request
+	if mode == ModeSeedOnly {
+		released = d.releaseAllRequests()
+	}
+	d.mu.Unlock()
+	d.cancelRequests(released)
+
+	// A seed-only torrent takes no download slot
+	if d.session != nil {
+		d.session.updateQueue()
+	}
+	return nil
+}
+
+// GetTransferMode returns which ways the torrent transfers data
+func (d *Downloader) GetTransferMode() TransferMode {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.mode
+}
+
+// downloading returns false if the mode forbids requesting pieces, or the
+// torrent is queued
+func (d *Downloader) downloading() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.mode != ModeSeedOnly && !d.queued
+}
+
+// uploading returns false if the mode forbids serving pieces now: a
+// no-seed torrent stops once complete
+func (d *Downloader) uploading() bool {
+	return d.GetTransferMode() != ModeNoSeed || !d.pieceManager.IsComplete()
+}
@@ -0,0 +1,156 @@
+package torrent
+
+import (
+	"log"
+	"time"
+
+	"bittorrentclient/internal/clock"
+	"bittorrentclient/internal/file"
+	piece "bittorrentclient/internal/pieces"
+)
+
+// Option configures a Downloader
+type Option func(*Downloader)
+
+// WithMaxPeers limits how many peers AddPeer accepts (0 means unlimited)
+func WithMaxPeers(n int) Option {
+	return func(d *Downloader) {
+		d.maxPeers = n
+	}
+}
+
+// WithSelector replaces the default rarest-first piece selector
+func WithSelector(selector piece.Selector) Option {
+	return func(d *Downloader) {
+		d.selector = selector
+	}
+}
+
+// WithStorage uses an existing piece manager, and the files behind it,
+// instead of creating one for the output directory
+func WithStorage(manager *piece.Manager) Option {
+	return func(d *Downloader) {
+		d.pieceManager = manager
+	}
+}
+
+// WithRateLimits caps download and upload rates in bytes per second
+// (0 means unlimited)
+func WithRateLimits(download, upload int64) Option {
+	return func(d *Downloader) {
+		d.downloadLimit = NewRateLimiter(download)
+		d.uploadLimit = NewRateLimiter(upload)
+	}
+}
+
+// WithResume enables or disables the resume file and journal. It has no
+// effect together with WithStorage.
+func WithResume(enabled bool) Option {
+	return func(d *Downloader) {
+		d.resume = enabled
+	}
+}
+
+// WithLogger sends the downloader's log output to logger
+func WithLogger(logger *log.Logger) Option {
+	return func(d *Downloader) {
+		d.logger = logger
+	}
+}
+
+// WithVerifyRate limits disk reads while verifying existing data, in bytes
+// per second (0 means unlimited)
+func WithVerifyRate(bytesPerSecond int64) Option {
+	return func(d *Downloader) {
+		d.verifyRate = bytesPerSecond
+	}
+}
+
+// WithWriteCache sets how many bytes of verified pieces are buffered before
+// being written out in sorted batches; 0 writes and syncs every piece as it
+// is verified. Defaults to file.DefaultWriteCacheSize.
+func WithWriteCache(bytes int64) Option {
+	return func(d *Downloader) {
+		d.writeCache = bytes
+	}
+}
+
+// WithVerifyOnRead re-hashes a piece before uploading it if its files were
+// modified on disk since we wrote them, and refuses to serve it if it no
+// longer matches
+func WithVerifyOnRead() Option {
+	return func(d *Downloader) {
+		d.verifyOnRead = true
+	}
+}
+
+// WithAllocation overrides how the torrent's files are allocated. Defaults
+// to file.AutoAllocation, which picks a strategy from the target filesystem
+// and the torrent size.
+func WithAllocation(strategy file.AllocationStrategy) Option {
+	return func(d *Downloader) {
+		d.allocation = strategy
+	}
+}
+
+// WithIncompleteDir keeps in-progress data under
+// <outputDir>/.incomplete/<infohash>/ and moves it into outputDir once the
+// download completes, so abandoned downloads are removed by deleting one
+// directory
+func WithIncompleteDir() Option {
+	return func(d *Downloader) {
+		d.incomplete = true
+	}
+}
+
+// WithSeedMode adds a torrent whose data is known to be complete: pieces are
+// marked complete without hashing, and each is verified when first read for
+// a peer
+func WithSeedMode() Option {
+	return func(d *Downloader) {
+		d.seedMode = true
+	}
+}
+
+// WithStallTimeout sets how long no data may arrive despite connected peers
+// before the download counts as stalled (see Downloader.OnStall). 0 turns
+// stall detection off.
+func WithStallTimeout(timeout time.Duration) Option {
+	return func(d *Downloader) {
+		d.stallTimeout = timeout
+	}
+}
+
+// WithOrderLog exports the order and timing of piece completion to path
+// when the download completes or stops (see ExportCompletionOrder)
+func WithOrderLog(path string) Option {
+	return func(d *Downloader) {
+		d.orderLog = path
+	}
+}
+
+// WithDeterministic seeds the piece selector's and the choker's random
+// choices and visits peers in a fixed order, so that with WithClock driving
+// time the same inputs reproduce the same requests. For debugging only:
+// replacing the selector afterwards with WithSelector drops its seeding.
+func WithDeterministic(seed int64) Option {
+	return func(d *Downloader) {
+		d.deterministic = true
+		d.randSeed = seed
+		d.selector = piece.NewSeededPieceSelector(seed)
+	}
+}
+
+// WithClock drives timeouts, unchoke periods and rate computations from c
+// instead of the wall clock
+func WithClock(c clock.Clock) Option {
+	return func(d *Downloader) {
+		d.clock = c
+	}
+}
+
+// GetRateLimits returns the download and upload limits in bytes per second
+// (0 means unlimited)
+func (d *Downloader) GetRateLimits() (download, upload int64) {
+	return d.downloadLimit.Rate(), d.uploadLimit.Rate()
+}
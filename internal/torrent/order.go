@@ -0,0 +1,45 @@
+package torrent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExportCompletionOrder writes the order and timing in which pieces
+// completed to path, as CSV if it ends in ".csv" and JSON otherwise
+func (d *Downloader) ExportCompletionOrder(path string) error {
+	log := d.pieceManager.GetCompletionLog()
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmp, err)
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		err = log.WriteCSV(f)
+	} else {
+		err = log.WriteJSON(f)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write completion order: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// writeOrderLog exports the completion order to the WithOrderLog path, if
+// one was given
+func (d *Downloader) writeOrderLog() {
+	if d.orderLog == "" {
+		return
+	}
+	if err := d.ExportCompletionOrder(d.orderLog); err != nil {
+		d.logger.Printf("Failed to export completion order: %v\n", err)
+	}
+}
@@ -2,20 +2,31 @@ package torrent
 
 import (
 	"bittorrentclient/internal/bencode"
+	"crypto/sha256"
 	"fmt"
 	"os"
 )
 
 func Open(filename string) (*Torrent, error) {
+	return OpenWithLimits(filename, DefaultParseLimits())
+}
+
+// OpenWithLimits reads and parses a torrent file with custom metadata limits
+func OpenWithLimits(filename string, limits ParseLimits) (*Torrent, error) {
 	Data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read torrent file: %w", err)
 	}
 
-	return ParseTorrent(Data)
+	return ParseTorrentWithLimits(Data, limits)
 }
 
 func ParseTorrent(Data []byte) (*Torrent, error) {
+	return ParseTorrentWithLimits(Data, DefaultParseLimits())
+}
+
+// ParseTorrentWithLimits parses a torrent, rejecting metadata outside limits
+func ParseTorrentWithLimits(Data []byte, limits ParseLimits) (*Torrent, error) {
 	// First, decode the entire torrent file
 	decoded, err := bencode.Decode(Data)
 	if err != nil {
@@ -35,18 +46,42 @@ func ParseTorrent(Data []byte) (*Torrent, error) {
 	}
 
 	// Parse the torrent structure
-	torrent, err := parseTorrentFromMap(torrentMap)
+	torrent, err := parseTorrentFromMap(torrentMap, limits)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse torrent structure: %w", err)
 	}
 
 	// Calculate InfoHash from raw info dictionary
 	torrent.InfoHash = torrent.GenerateInfoHash(rawInfoDict)
+	torrent.rawInfoDict = rawInfoDict
+	if torrent.Info.IsV2() {
+		torrent.InfoHashV2 = sha256.Sum256(rawInfoDict)
+		if len(torrent.Info.Pieces) == 0 {
+			// v2-only swarms go by the v2 hash truncated to 20 bytes
+			copy(torrent.InfoHash[:], torrent.InfoHashV2[:])
+		}
+	}
 
 	// Validate the parsed torrent
 	if err := torrent.Validate(); err != nil {
 		return nil, fmt.Errorf("torrent validation failed: %w", err)
 	}
+	if torrent.Info.IsV2() {
+		if err := torrent.checkPieceLayers(); err != nil {
+			return nil, fmt.Errorf("torrent validation failed: %w", err)
+		}
+	}
+	if torrent.Info.IsHybrid() {
+		if err := torrent.checkHybrid(); err != nil {
+			return nil, fmt.Errorf("torrent validation failed: %w", err)
+		}
+	}
+
+	warnings, err := limits.checkInfo(torrent.Info)
+	if err != nil {
+		return nil, fmt.Errorf("torrent validation failed: %w", err)
+	}
+	torrent.Warnings = warnings
 
 	return torrent, nil
 }
@@ -93,7 +128,7 @@ func extractRawInfoDict(Data []byte) ([]byte, error) {
 }
 
 // parseTorrentFromMap converts the decoded map to a Torrent struct
-func parseTorrentFromMap(torrentMap map[string]interface{}) (*Torrent, error) {
+func parseTorrentFromMap(torrentMap map[string]interface{}, limits ParseLimits) (*Torrent, error) {
 	torrent := &Torrent{}
 
 	// Parse announce
@@ -142,17 +177,25 @@ func parseTorrentFromMap(torrentMap map[string]interface{}) (*Torrent, error) {
 		return nil, fmt.Errorf("info is not a dictionary")
 	}
 
-	info, err := parseInfoFromMap(infoMap)
+	info, err := parseInfoFromMap(infoMap, limits)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse info dictionary: %w", err)
 	}
 
 	torrent.Info = info
+
+	// Parse v2 piece layers, which live outside the info dictionary
+	if layers, ok := torrentMap["piece layers"].(map[string]interface{}); ok {
+		torrent.PieceLayers, err = parsePieceLayers(layers)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return torrent, nil
 }
 
 // parseInfoFromMap converts the info map to an Info struct
-func parseInfoFromMap(infoMap map[string]interface{}) (*Info, error) {
+func parseInfoFromMap(infoMap map[string]interface{}, limits ParseLimits) (*Info, error) {
 	info := &Info{}
 
 	// Parse required fields
@@ -168,8 +211,20 @@ func parseInfoFromMap(infoMap map[string]interface{}) (*Info, error) {
 	}
 	info.PieceLength = pieceLength
 
+	// v2 metadata (BEP 52); a v2-only torrent has no pieces field
+	if metaVersion, ok := infoMap["meta version"].(int64); ok {
+		info.MetaVersion = &metaVersion
+	}
+	if tree, ok := infoMap["file tree"].(map[string]interface{}); ok && info.MetaVersion != nil && *info.MetaVersion == MetaVersion2 {
+		fileTree, err := parseFileTree(tree, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse file tree: %w", err)
+		}
+		info.FileTree = fileTree
+	}
+
 	piecesStr, ok := infoMap["pieces"].(string)
-	if !ok {
+	if !ok && !info.IsV2() {
 		return nil, fmt.Errorf("missing or invalid pieces field")
 	}
 
@@ -179,7 +234,14 @@ func parseInfoFromMap(infoMap map[string]interface{}) (*Info, error) {
 	}
 
 	numPieces := len(piecesStr) / 20
-	info.Pieces = make([][20]byte, numPieces)
+	if ok {
+		if err := limits.checkPieces(pieceLength, numPieces); err != nil {
+			return nil, err
+		}
+	}
+	if numPieces > 0 {
+		info.Pieces = make([][20]byte, numPieces)
+	}
 
 	for i := 0; i < numPieces; i++ {
 		copy(info.Pieces[i][:], piecesStr[i*20:(i+1)*20])
@@ -208,10 +270,27 @@ func parseInfoFromMap(infoMap map[string]interface{}) (*Info, error) {
 
 			info.Files = append(info.Files, *file)
 		}
+	} else if info.IsV2() {
+		// v2-only: the files come from the file tree
+		if pieceLength <= 0 {
+			return nil, fmt.Errorf("missing or invalid piece length field")
+		}
+		layoutV2(info)
+		if err := limits.checkPieces(pieceLength, info.NumPieces()); err != nil {
+			return nil, err
+		}
 	} else {
 		return nil, fmt.Errorf("torrent must have either 'length' or 'files' field")
 	}
 
+	// Parse optional fields
+	if private, ok := infoMap["private"].(int64); ok {
+		info.Private = &private
+	}
+	if source, ok := infoMap["source"].(string); ok {
+		info.Source = &source
+	}
+
 	return info, nil
 }
 
@@ -241,6 +320,9 @@ func parseFileFromMap(fileMap map[string]interface{}) (*File, error) {
 	if md5sum, ok := fileMap["md5sum"].(string); ok {
 		file.MD5Sum = &md5sum
 	}
+	if attr, ok := fileMap["attr"].(string); ok {
+		file.Attr = &attr
+	}
 
 	// Validate the file path
 	if err := file.ValidatePath(); err != nil {
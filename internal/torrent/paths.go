@@ -0,0 +1,92 @@
+package torrent
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// resolvePathConflicts finds files whose path collides with an earlier
+// file's, exactly or only by case (fatal on Windows and macOS), or that
+// need a directory where an earlier file is. Going through the files in
+// torrent order, the colliding component gets a " (n)" suffix, so the
+// result is the same on every run and platform; later files in a renamed
+// directory follow it there. With strict set the first collision is an
+// error instead. It returns a warning per renamed file.
+func resolvePathConflicts(files []File, strict bool) ([]string, error) {
+	taken := make(map[string]bool)      // Lowercased paths of files placed so far
+	dirs := make(map[string]bool)       // Lowercased paths of directories placed so far
+	dirNames := make(map[string]string) // Lowercased original directory path -> name chosen for it
+
+	var warnings []string
+	for i := range files {
+		if files[i].IsPadding() {
+			continue // Never stored, so can't collide
+		}
+		original := files[i].Path
+		placed := make([]string, 0, len(original))
+
+		for k, name := range original {
+			last := k == len(original)-1
+			key := strings.ToLower(path.Join(original[:k+1]...))
+
+			if !last {
+				if chosen, ok := dirNames[key]; ok {
+					placed = append(placed, chosen)
+					continue
+				}
+			}
+
+			conflicts := func(candidate string) bool {
+				p := strings.ToLower(path.Join(append(placed, candidate)...))
+				if last {
+					return taken[p] || dirs[p]
+				}
+				return taken[p]
+			}
+			chosen := name
+			if conflicts(name) {
+				if strict {
+					return nil, fmt.Errorf("file path %s conflicts with an earlier file or directory (paths are compared case-insensitively)",
+						path.Join(original...))
+				}
+				chosen = uniqueName(name, func(candidate string) bool {
+					p := strings.ToLower(path.Join(append(placed, candidate)...))
+					return taken[p] || dirs[p]
+				})
+			}
+
+			placed = append(placed, chosen)
+			if last {
+				taken[strings.ToLower(path.Join(placed...))] = true
+			} else {
+				dirNames[key] = chosen
+				dirs[strings.ToLower(path.Join(placed...))] = true
+			}
+		}
+
+		if path.Join(placed...) != path.Join(original...) {
+			warnings = append(warnings, fmt.Sprintf("file %s renamed to %s: its path conflicts with an earlier file",
+				path.Join(original...), path.Join(placed...)))
+			files[i].Path = placed
+		}
+	}
+	return warnings, nil
+}
+
+// uniqueName returns name with the lowest " (n)" suffix, placed before any
+// extension, that isn't taken
+func uniqueName(name string, taken func(string) bool) string {
+	ext := path.Ext(name)
+	if ext == name {
+		ext = "" // Dotfiles like ".config" are all base
+	}
+	base := strings.TrimSuffix(name, ext)
+
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, n, ext)
+		if !taken(candidate) {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package torrent
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// peerRateLimits caps one peer's connection, see SetPeerRateLimits
+type peerRateLimits struct {
+	download *RateLimiter
+	upload   *RateLimiter
+}
+
+// SetPeerRateLimits caps the rates, in bytes per second, at which a peer
+// may send to us and we send to it (0 means unlimited), e.g. to throttle a
+// peer hammering us with requests. The cap applies on top of the torrent's
+// and the session's, to the peer's current connection and to any later
+// one. peerKey is the peer ID in hex, as GetPeerUploaded and
+// GetPeerDownloadRates key peers.
+func (d *Downloader) SetPeerRateLimits(peerKey string, download, upload int64) error {
+	if id, err := hex.DecodeString(peerKey); err != nil || len(id) != 20 {
+		return fmt.Errorf("invalid peer ID %q", peerKey)
+	}
+	if download < 0 || upload < 0 {
+		return fmt.Errorf("rate limits can't be negative")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if download == 0 && upload == 0 {
+		delete(d.peerLimits, peerKey)
+		if conn, exists := d.connections[peerKey]; exists {
+			conn.SetPeerRateLimiters(nil, nil)
+		}
+		return nil
+	}
+
+	limits, exists := d.peerLimits[peerKey]
+	if !exists {
+		limits = &peerRateLimits{download: NewRateLimiter(download), upload: NewRateLimiter(upload)}
+		limits.download.SetClock(d.clock)
+		limits.upload.SetClock(d.clock)
+		d.peerLimits[peerKey] = limits
+	} else {
+		limits.download.SetRate(download)
+		limits.upload.SetRate(upload)
+	}
+	if conn, exists := d.connections[peerKey]; exists {
+		conn.SetPeerRateLimiters(limits.download, limits.upload)
+	}
+	return nil
+}
+
+// GetPeerRateLimits returns a peer's download and upload caps in bytes per
+// second (0 means unlimited), see SetPeerRateLimits
+func (d *Downloader) GetPeerRateLimits(peerKey string) (download, upload int64) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	limits, exists := d.peerLimits[peerKey]
+	if !exists {
+		return 0, 0
+	}
+	return limits.download.Rate(), limits.upload.Rate()
+}
+
+// applyPeerRateLimits caps a newly added connection if its peer has caps.
+// Caller must hold d.mu.
+func (d *Downloader) applyPeerRateLimits(peerKey string) {
+	limits, exists := d.peerLimits[peerKey]
+	if !exists {
+		return
+	}
+	if conn, ok := d.connections[peerKey]; ok {
+		conn.SetPeerRateLimiters(limits.download, limits.upload)
+	}
+}
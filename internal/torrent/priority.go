@@ -0,0 +1,41 @@
+package torrent
+
+import (
+	"bittorrentclient/internal/file"
+)
+
+// SetFilePriorityByPath sets the priority of the file at path, given
+// relative to the torrent root or including the torrent name. Skipped
+// files aren't allocated and pieces overlapping only skipped files aren't
+// downloaded; the rest are downloaded high priority first and low last.
+// Un-skipping a file once the download completed takes effect on the next
+// start.
+func (d *Downloader) SetFilePriorityByPath(path string, priority file.Priority) error {
+	index, err := findFile(d.torrent, createFileInfoFromTorrent(d.torrent), path)
+	if err != nil {
+		return err
+	}
+	return d.SetFilePriority(index, priority)
+}
+
+// GetFilePriorities returns every file's priority, in torrent order; pad
+// files are always skipped
+func (d *Downloader) GetFilePriorities() []file.Priority {
+	files := d.pieceManager.GetFiles()
+	priorities := make([]file.Priority, len(files))
+	for i, f := range files {
+		priorities[i] = f.Priority
+	}
+	return priorities
+}
+
+// hasFilePriorities returns true if a file that isn't padding has a
+// priority other than normal, so Session.SaveAll keeps them
+func (d *Downloader) hasFilePriorities() bool {
+	for _, f := range d.pieceManager.GetFiles() {
+		if !f.Padding && f.Priority != file.PriorityNormal {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,247 @@
+package torrent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"bittorrentclient/internal/bencode"
+)
+
+// queueFile is the file in SessionConfig.StateDir keeping the queue order
+const queueFile = "queue"
+
+// QueuePosition returns a torrent's position in the session queue, 0 being
+// the top, or -1 if it isn't in the session
+func (s *Session) QueuePosition(d *Downloader) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.indexOf(d)
+}
+
+// MoveUp moves a torrent one place towards the top of the queue
+func (s *Session) MoveUp(d *Downloader) error {
+	return s.moveTo(d, func(i int) int { return i - 1 })
+}
+
+// MoveDown moves a torrent one place towards the bottom of the queue
+func (s *Session) MoveDown(d *Downloader) error {
+	return s.moveTo(d, func(i int) int { return i + 1 })
+}
+
+// MoveTop moves a torrent to the top of the queue
+func (s *Session) MoveTop(d *Downloader) error {
+	return s.moveTo(d, func(int) int { return 0 })
+}
+
+// MoveBottom moves a torrent to the bottom of the queue
+func (s *Session) MoveBottom(d *Downloader) error {
+	return s.moveTo(d, func(int) int { return len(s.downloaders) - 1 })
+}
+
+// moveTo moves a torrent to the position target picks from its current
+// one, clamped to the queue, saves the new order and hands out the
+// download slots again
+func (s *Session) moveTo(d *Downloader, target func(int) int) error {
+	if err := s.reorder(d, target); err != nil {
+		return err
+	}
+	s.updateQueue()
+	return nil
+}
+
+// reorder does moveTo's moving and saving
+func (s *Session) reorder(d *Downloader, target func(int) int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from := s.indexOf(d)
+	if from < 0 {
+		return fmt.Errorf("torrent %s is not in the session", d.torrent.InfoHash)
+	}
+	to := max(0, min(target(from), len(s.downloaders)-1))
+	if to == from {
+		return nil
+	}
+
+	s.downloaders = append(s.downloaders[:from], s.downloaders[from+1:]...)
+	s.downloaders = append(s.downloaders[:to], append([]*Downloader{d}, s.downloaders[to:]...)...)
+
+	s.syncQueueOrder()
+	if err := s.saveQueue(); err != nil {
+		return fmt.Errorf("failed to save queue order: %w", err)
+	}
+	return nil
+}
+
+// SetMaxActive changes how many torrents download at once: the first n
+// incomplete ones in queue order, while the others wait. Seeding torrents
+// don't count. 0 means no limit.
+func (s *Session) SetMaxActive(n int) {
+	s.mu.Lock()
+	s.config.MaxActive = n
+	s.mu.Unlock()
+	s.updateQueue()
+}
+
+// updateQueue lets the first MaxActive torrents still downloading, in queue
+// order, go on and queues the rest
+func (s *Session) updateQueue() {
+	limit := s.GetConfig().MaxActive
+	active := 0
+	for _, d := range s.GetDownloaders() {
+		if d.IsComplete() || d.isStopped() || d.Err() != nil || d.GetTransferMode() == ModeSeedOnly {
+			continue // Not downloading, queued or not
+		}
+		queued := limit > 0 && active >= limit
+		d.setQueued(queued)
+		if !queued {
+			active++
+		}
+	}
+}
+
+// IsQueued returns true if the torrent waits for others ahead of it in the
+// queue to finish downloading, see Session.SetMaxActive. It still uploads
+// the pieces it has meanwhile.
+func (d *Downloader) IsQueued() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.queued
+}
+
+// setQueued holds back the torrent's download, giving up and cancelling
+// the blocks requested so far, or lets it go on
+func (d *Downloader) setQueued(queued bool) {
+	d.mu.Lock()
+	if queued == d.queued {
+		d.mu.Unlock()
+		return
+	}
+	d.queued = queued
+	if !queued {
+		d.logger.Printf("▶️  Its turn in the queue, downloading\n")
+		d.mu.Unlock()
+		return
+	}
+	d.logger.Printf("⏳ Queued behind other downloads\n")
+	released := d.releaseAllRequests()
+	d.mu.Unlock()
+	d.cancelRequests(released)
+}
+
+// indexOf returns a downloader's index in s.downloaders, or -1. Caller
+// must hold s.mu.
+func (s *Session) indexOf(d *Downloader) int {
+	for i, other := range s.downloaders {
+		if other == d {
+			return i
+		}
+	}
+	return -1
+}
+
+// enqueue inserts a downloader at its saved queue position, or at the
+// bottom if it has none. Caller must hold s.mu.
+func (s *Session) enqueue(d *Downloader) {
+	rank := make(map[string]int, len(s.queueOrder))
+	for i, hash := range s.queueOrder {
+		rank[hash] = i
+	}
+
+	hash := d.torrent.InfoHash.String()
+	r, known := rank[hash]
+	at := len(s.downloaders)
+	if known {
+		for i, other := range s.downloaders {
+			if otherRank, ok := rank[other.torrent.InfoHash.String()]; !ok || otherRank > r {
+				at = i
+				break
+			}
+		}
+	}
+	s.downloaders = append(s.downloaders[:at], append([]*Downloader{d}, s.downloaders[at:]...)...)
+
+	if !known {
+		s.syncQueueOrder()
+		if err := s.saveQueue(); err != nil {
+			fmt.Printf("⚠️  Failed to save queue order: %v\n", err)
+		}
+	}
+}
+
+// syncQueueOrder updates the saved order from the session's torrents,
+// keeping torrents that aren't loaded right now after them. Caller must
+// hold s.mu.
+func (s *Session) syncQueueOrder() {
+	loaded := make(map[string]bool, len(s.downloaders))
+	order := make([]string, 0, len(s.queueOrder)+len(s.downloaders))
+	for _, d := range s.downloaders {
+		hash := d.torrent.InfoHash.String()
+		loaded[hash] = true
+		order = append(order, hash)
+	}
+	for _, hash := range s.queueOrder {
+		if !loaded[hash] {
+			order = append(order, hash)
+		}
+	}
+	s.queueOrder = order
+}
+
+// loadQueue reads the saved queue order. A missing file just means nothing
+// was queued yet.
+func (s *Session) loadQueue() error {
+	if s.config.StateDir == "" {
+		return nil
+	}
+
+	raw, err := os.ReadFile(filepath.Join(s.config.StateDir, queueFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read queue order: %w", err)
+	}
+
+	decoded, err := bencode.Decode(raw)
+	if err != nil {
+		return fmt.Errorf("failed to decode queue order: %w", err)
+	}
+	list, ok := decoded.([]interface{})
+	if !ok {
+		return fmt.Errorf("queue order is not a list")
+	}
+	for _, item := range list {
+		if hash, ok := item.(string); ok {
+			s.queueOrder = append(s.queueOrder, hash)
+		}
+	}
+	return nil
+}
+
+// saveQueue writes the queue order, replacing the file atomically. Caller
+// must hold s.mu.
+func (s *Session) saveQueue() error {
+	if s.config.StateDir == "" {
+		return nil
+	}
+
+	list := make([]interface{}, len(s.queueOrder))
+	for i, hash := range s.queueOrder {
+		list[i] = hash
+	}
+	encoded, err := bencode.Encode(list)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.config.StateDir, 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(s.config.StateDir, queueFile)
+	if err := os.WriteFile(path+".tmp", encoded, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,146 @@
+package torrent
+
+import (
+	"sync"
+	"time"
+
+	"bittorrentclient/internal/clock"
+)
+
+// RateLimiterPollInterval is how often Wait checks for refilled tokens
+const RateLimiterPollInterval = 10 * time.Millisecond
+
+// RateLimiter is a token bucket measured in bytes. Callers check Ready
+// before starting a transfer and Take what they used, which may drive the
+// balance negative; Ready stays false until it refills. A nil limiter or a
+// rate of 0 means unlimited. A limiter may have a parent, e.g. a session-wide
+// cap, that its transfers also count against.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // Bytes per second
+	tokens float64
+	last   time.Time
+	clock  clock.Clock
+	parent *RateLimiter
+}
+
+// NewRateLimiter creates a limiter allowing bytesPerSecond on average,
+// with bursts of up to one second's worth
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	return &RateLimiter{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+		clock:  clock.Real,
+	}
+}
+
+// Within returns a limiter that is also held to parent: it is ready only
+// when parent is too, and transfers are taken from both. r may be nil, in
+// which case only parent limits.
+func (r *RateLimiter) Within(parent *RateLimiter) *RateLimiter {
+	if r == nil {
+		r = NewRateLimiter(0)
+	}
+	r.parent = parent
+	return r
+}
+
+// SetRate changes the limit in bytes per second (0 means unlimited). The
+// balance is capped at the new rate, so lowering it takes effect at once.
+func (r *RateLimiter) SetRate(bytesPerSecond int64) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refill()
+	r.rate = float64(bytesPerSecond)
+	if r.tokens > r.rate {
+		r.tokens = r.rate
+	}
+}
+
+// SetClock replaces the clock used to refill the bucket
+func (r *RateLimiter) SetClock(c clock.Clock) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clock = c
+	r.last = c.Now()
+}
+
+// Ready returns true if a transfer may start now
+func (r *RateLimiter) Ready() bool {
+	if r == nil {
+		return true
+	}
+	if !r.parent.Ready() {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rate <= 0 {
+		return true
+	}
+	r.refill()
+	return r.tokens > 0
+}
+
+// Take consumes n bytes from the bucket
+func (r *RateLimiter) Take(n int64) {
+	if r == nil {
+		return
+	}
+	r.parent.Take(n)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rate <= 0 {
+		return
+	}
+	r.refill()
+	r.tokens -= float64(n)
+}
+
+// Wait blocks until a transfer may start, then takes n bytes. It polls on
+// the limiter's clock, so a fake clock drives it too.
+func (r *RateLimiter) Wait(n int64) {
+	for !r.Ready() {
+		r.getClock().Sleep(RateLimiterPollInterval)
+	}
+	r.Take(n)
+}
+
+// getClock returns the clock the bucket refills by
+func (r *RateLimiter) getClock() clock.Clock {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.clock
+}
+
+// Rate returns the limit in bytes per second (0 means unlimited)
+func (r *RateLimiter) Rate() int64 {
+	if r == nil {
+		return 0
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return int64(r.rate)
+}
+
+// refill adds the tokens earned since the last call. Caller must hold r.mu.
+func (r *RateLimiter) refill() {
+	now := r.clock.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.rate {
+		r.tokens = r.rate
+	}
+	r.last = now
+}
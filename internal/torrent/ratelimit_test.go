@@ -0,0 +1,57 @@
+package torrent
+
+import (
+	"testing"
+	"time"
+
+	"bittorrentclient/internal/clock"
+)
+
+func TestRateLimiterWaitFakeClock(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1000, 0))
+	r := NewRateLimiter(1000)
+	r.SetClock(fake)
+
+	r.Take(1500) // Half a second in debt
+	done := make(chan struct{})
+	go func() {
+		r.Wait(100)
+		close(done)
+	}()
+
+	// Wait polls on the fake clock, so it stays blocked until the bucket
+	// refills on it, half a second later
+	var waited time.Duration
+	for waited < time.Second {
+		for fake.Waiters() == 0 {
+			select {
+			case <-done:
+				if waited < 400*time.Millisecond {
+					t.Fatalf("Wait returned after %v, still in debt", waited)
+				}
+				return
+			default:
+				time.Sleep(time.Millisecond)
+			}
+		}
+		fake.Advance(RateLimiterPollInterval)
+		waited += RateLimiterPollInterval
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait didn't return once the bucket refilled")
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	var nilLimiter *RateLimiter
+	nilLimiter.Wait(1 << 30) // Returns at once
+
+	r := NewRateLimiter(0)
+	r.SetClock(clock.NewFake(time.Unix(1000, 0)))
+	r.Wait(1 << 30)
+	if !r.Ready() {
+		t.Fatal("unlimited limiter not ready")
+	}
+}
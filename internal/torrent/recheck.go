@@ -0,0 +1,163 @@
+package torrent
+
+import (
+	"time"
+)
+
+// DefaultRecheckRate is how fast a recheck reads by default, in bytes per
+// second: slow enough to leave the disk to seeding
+const DefaultRecheckRate = 4 * 1024 * 1024
+
+// DefaultVerifyRate is how fast existing data is verified when a torrent
+// starts, in bytes per second; like a recheck, it leaves the disk to the
+// torrents already seeding
+const DefaultVerifyRate = DefaultRecheckRate
+
+// recheckPoll is how often the recheck loop looks whether a recheck is due
+const recheckPoll = time.Minute
+
+// RecheckPolicy says when a completed torrent's data is hashed again, so
+// pieces the disk silently corrupted are caught and downloaded again
+// instead of being served to the swarm
+type RecheckPolicy struct {
+	Interval   time.Duration // Recheck this long after completing and after each recheck; 0 never does
+	AfterCrash bool          // Recheck once complete if the last run didn't shut down cleanly
+	Rate       int64         // Read limit in bytes/second; 0 is unlimited
+}
+
+// Enabled returns true if the policy ever rechecks
+func (p RecheckPolicy) Enabled() bool {
+	return p.Interval > 0 || p.AfterCrash
+}
+
+// WithRecheck rechecks the torrent's data while it seeds, as policy says
+func WithRecheck(policy RecheckPolicy) Option {
+	return func(d *Downloader) {
+		d.recheck = policy
+	}
+}
+
+// GetRecheck returns the torrent's recheck policy
+func (d *Downloader) GetRecheck() RecheckPolicy {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.recheck
+}
+
+// GetLastRecheck returns when the data was last rechecked; zero if never
+func (d *Downloader) GetLastRecheck() time.Time {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.lastRecheck
+}
+
+// recheckLoop waits for the download to complete, then rechecks the data
+// whenever the policy says, until the torrent stops
+func (d *Downloader) recheckLoop() {
+	select {
+	case <-d.downloadDone:
+	case <-d.done:
+		return
+	}
+	if !d.downloadCompleted() {
+		return
+	}
+
+	ticker := d.clock.NewTicker(recheckPoll)
+	defer ticker.Stop()
+
+	for {
+		if d.recheckDue() {
+			d.runRecheck()
+		}
+
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// recheckDue returns true if the policy wants the data rechecked now
+func (d *Downloader) recheckDue() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.crashRecheck {
+		return true
+	}
+	if d.recheck.Interval <= 0 {
+		return false
+	}
+	last := d.lastRecheck
+	if last.IsZero() {
+		last = d.completedAt
+	}
+	return !last.IsZero() && d.clock.Now().Sub(last) >= d.recheck.Interval
+}
+
+// runRecheck hashes the data again and downloads whatever no longer
+// matches, carrying on seeding meanwhile
+func (d *Downloader) runRecheck() {
+	d.logger.Printf("🔍 Rechecking %s\n", d.torrent.Info.Name)
+	bad, err := d.pieceManager.Recheck(d.GetRecheck().Rate)
+
+	d.mu.Lock()
+	d.lastRecheck = d.clock.Now()
+	d.crashRecheck = false
+	d.mu.Unlock()
+
+	if err != nil {
+		d.logger.Printf("Recheck failed: %v\n", err)
+		return
+	}
+	if len(bad) == 0 {
+		d.logger.Printf("✅ Recheck passed, all %d pieces match\n", d.pieceManager.GetTotalPieces())
+		return
+	}
+	d.logger.Printf("⚠️  %d piece(s) no longer match on disk %v, downloading them again\n", len(bad), bad)
+	d.repair()
+}
+
+// repair downloads the pieces a recheck or a seed-mode read found corrupt,
+// the way downloadLoop would have, until the torrent is complete again or
+// stops. It returns at once if a repair is already running.
+func (d *Downloader) repair() {
+	d.mu.Lock()
+	if d.repairing {
+		d.mu.Unlock()
+		return
+	}
+	d.repairing = true
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		d.repairing = false
+		d.mu.Unlock()
+	}()
+
+	ticker := d.clock.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C():
+			if d.pieceManager.IsComplete() {
+				if err := d.pieceManager.Flush(); err != nil {
+					d.logger.Printf("Failed to flush write cache: %v\n", err)
+				}
+				d.updateAllInterest()
+				d.logger.Printf("✅ Repaired %s\n", d.torrent.Info.Name)
+				return
+			}
+			if err := d.pieceManager.Err(); err != nil {
+				d.logger.Printf("Repair stopped: %v\n", err)
+				return
+			}
+			d.downloadStep()
+		}
+	}
+}
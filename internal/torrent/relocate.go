@@ -0,0 +1,103 @@
+package torrent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"bittorrentclient/internal/file"
+)
+
+// SetLocation makes dir the torrent's output directory while it runs, e.g.
+// when its disk fills up. With move set, the data is moved there (copied
+// across file systems) and the download carries on where it was.
+// Otherwise dir is taken to hold the data already, say a copy made by
+// hand: nothing is moved, every piece is verified against what is in dir,
+// and whatever is missing or different there is downloaded again. A staged
+// download (WithIncompleteDir) moves to dir's staging directory, or stops
+// being staged when pointed at existing data. The session remembers dir
+// for the next time the torrent is added.
+func (d *Downloader) SetLocation(dir string, move bool) error {
+	d.locationMu.Lock()
+	defer d.locationMu.Unlock()
+
+	select {
+	case <-d.done:
+		return fmt.Errorf("the torrent is stopped")
+	default:
+	}
+	if d.lock == nil {
+		return fmt.Errorf("the torrent isn't started; add it with the new directory instead")
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	if current, err := filepath.Abs(d.outputDir); err == nil && current == dir {
+		return fmt.Errorf("the torrent is in %s already", dir)
+	}
+
+	oldDataDir := d.outputDir
+	if d.stagingDir != "" {
+		oldDataDir = d.stagingDir
+	}
+	dataDir, stagingDir := dir, ""
+	if move && d.stagingDir != "" {
+		stagingDir = IncompletePath(d.torrent, dir)
+		dataDir = stagingDir
+	}
+
+	// The data at dir is ours before we touch it
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	lock, err := file.AcquireLock(LockPath(d.torrent, dir))
+	if err != nil {
+		return err
+	}
+
+	var resumePath, journalPath string
+	if d.resume {
+		resumePath, journalPath = ResumePath(d.torrent, dataDir), JournalPath(d.torrent, dataDir)
+	}
+	complete, err := d.pieceManager.Relocate(dataDir, resumePath, journalPath, move)
+	if err != nil {
+		lock.Release()
+		return fmt.Errorf("failed to relocate into %s: %w", dataDir, err)
+	}
+
+	if err := d.lock.Release(); err != nil {
+		d.logger.Printf("Error releasing lock: %v\n", err)
+	}
+	d.lock = lock
+	if move {
+		// Nothing is left behind to describe
+		os.Remove(ResumePath(d.torrent, oldDataDir))
+		os.Remove(JournalPath(d.torrent, oldDataDir))
+		if d.stagingDir != "" {
+			os.RemoveAll(d.stagingDir)
+			os.Remove(filepath.Dir(d.stagingDir))
+		}
+	}
+
+	d.mu.Lock()
+	d.outputDir = dir
+	d.stagingDir = stagingDir
+	d.mu.Unlock()
+	if d.session != nil {
+		d.session.recordLocation(d.torrent, dir)
+	}
+
+	if move {
+		d.logger.Printf("Moved %s into %s\n", d.torrent.Info.Name, dataDir)
+	} else {
+		d.logger.Printf("Using the data in %s: %d/%d pieces verified\n", dir, complete, d.pieceManager.GetTotalPieces())
+		select {
+		case <-d.downloadDone:
+			if !d.pieceManager.IsComplete() {
+				d.logger.Printf("⚠️  The download had finished; restart the torrent to fetch the pieces missing in %s\n", dir)
+			}
+		default:
+		}
+	}
+	return nil
+}
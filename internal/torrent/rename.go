@@ -0,0 +1,95 @@
+package torrent
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Rename stores a file or a whole directory of the torrent under another
+// name, e.g. to tidy up a release name. from is the file's or directory's
+// current path relative to the output directory (multi-file torrents'
+// paths start with the torrent name) and to its new one, which may move it
+// into other directories. Data already downloaded is moved along, piece
+// verification is unaffected, and with resume enabled the names are kept
+// in the resume data, so later runs use them too. Call before Start.
+func (d *Downloader) Rename(from, to string) error {
+	from = strings.Trim(from, "/")
+	to, err := cleanRelativePath(to)
+	if err != nil {
+		return err
+	}
+
+	files := d.pieceManager.GetFiles()
+	stored := make([]string, len(files))
+	renamed := make(map[int]string)
+	for i, f := range files {
+		stored[i] = f.StoredPath()
+		if f.Padding {
+			continue
+		}
+		switch {
+		case stored[i] == from:
+			renamed[i] = to
+		case strings.HasPrefix(stored[i], from+"/"):
+			renamed[i] = to + strings.TrimPrefix(stored[i], from)
+		}
+	}
+	if len(renamed) == 0 {
+		return fmt.Errorf("no file or directory %q in torrent", from)
+	}
+
+	// Refuse names that would put two files, or a file and a directory, in
+	// the same place
+	taken := make(map[string]bool, len(files))
+	dirs := make(map[string]bool)
+	for i, f := range files {
+		if f.Padding {
+			continue
+		}
+		p := stored[i]
+		if name, ok := renamed[i]; ok {
+			p = name
+		}
+		key := strings.ToLower(p)
+		if taken[key] || dirs[key] {
+			return fmt.Errorf("%s would collide with another file", p)
+		}
+		taken[key] = true
+		for dir := path.Dir(key); dir != "."; dir = path.Dir(dir) {
+			if taken[dir] {
+				return fmt.Errorf("%s would be inside file %s", p, dir)
+			}
+			dirs[dir] = true
+		}
+	}
+
+	for i, name := range renamed {
+		if err := d.pieceManager.RenameFile(i, name); err != nil {
+			return err
+		}
+	}
+	d.logger.Printf("Renamed %s to %s (%d file(s))\n", from, to, len(renamed))
+	return nil
+}
+
+// GetFileNames returns the path, relative to the output directory, each
+// file of the torrent is stored under, in torrent order
+func (d *Downloader) GetFileNames() []string {
+	files := d.pieceManager.GetFiles()
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.StoredPath()
+	}
+	return names
+}
+
+// cleanRelativePath checks that p is a usable path inside the output
+// directory and returns it cleaned, with forward slashes
+func cleanRelativePath(p string) (string, error) {
+	cleaned := path.Clean(strings.ReplaceAll(p, "\\", "/"))
+	if p == "" || cleaned == "." || path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("invalid name %q: must be a relative path inside the output directory", p)
+	}
+	return cleaned, nil
+}
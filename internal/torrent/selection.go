@@ -0,0 +1,110 @@
+package torrent
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"bittorrentclient/internal/file"
+)
+
+// Selection is a part of a torrent to download: a whole file, or with
+// Length > 0, the byte range [Offset, Offset+Length) of a file
+type Selection struct {
+	Path   string // File path, with or without the torrent name in front
+	Offset int64
+	Length int64 // 0 means to the end of the file
+}
+
+// ParseRange parses a "path:offset-length" range selection
+func ParseRange(spec string) (Selection, error) {
+	sep := strings.LastIndex(spec, ":")
+	if sep <= 0 {
+		return Selection{}, fmt.Errorf("invalid range %q: expected path:offset-length", spec)
+	}
+
+	bounds := strings.SplitN(spec[sep+1:], "-", 2)
+	if len(bounds) != 2 {
+		return Selection{}, fmt.Errorf("invalid range %q: expected path:offset-length", spec)
+	}
+
+	offset, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil || offset < 0 {
+		return Selection{}, fmt.Errorf("invalid range offset %q", bounds[0])
+	}
+	length, err := strconv.ParseInt(bounds[1], 10, 64)
+	if err != nil || length <= 0 {
+		return Selection{}, fmt.Errorf("invalid range length %q", bounds[1])
+	}
+
+	return Selection{Path: spec[:sep], Offset: offset, Length: length}, nil
+}
+
+// Select restricts the download to the given files and ranges. Every other
+// file is skipped, and only pieces overlapping a selection are requested.
+// Must be called before Start.
+func (d *Downloader) Select(selections []Selection) error {
+	files := createFileInfoFromTorrent(d.torrent)
+	pieceLength := d.torrent.Info.PieceLength
+	wanted := make([]bool, d.torrent.Info.NumPieces())
+	selected := make([]bool, len(files))
+
+	for _, sel := range selections {
+		index, err := findFile(d.torrent, files, sel.Path)
+		if err != nil {
+			return err
+		}
+		f := files[index]
+
+		length := sel.Length
+		if length == 0 {
+			length = f.Length - sel.Offset
+		}
+		if sel.Offset < 0 || sel.Offset+length > f.Length {
+			return fmt.Errorf("range %d-%d is outside %s (%d bytes)", sel.Offset, sel.Offset+length, f.Path, f.Length)
+		}
+		selected[index] = true
+		if length == 0 {
+			continue // Empty file
+		}
+
+		start := f.Offset + sel.Offset
+		end := start + length
+		for p := start / pieceLength; p <= (end-1)/pieceLength; p++ {
+			wanted[p] = true
+		}
+	}
+
+	for i, sel := range selected {
+		// Selected files keep a priority set before, unless skipped
+		priority := file.PrioritySkip
+		if sel {
+			if priority = d.pieceManager.GetFilePriority(i); priority == file.PrioritySkip {
+				priority = file.PriorityNormal
+			}
+		}
+		if err := d.pieceManager.SetFilePriority(i, priority); err != nil {
+			return err
+		}
+	}
+
+	if err := d.pieceManager.SetWantedPieces(wanted); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.selections = append([]Selection(nil), selections...)
+	d.mu.Unlock()
+	return nil
+}
+
+// findFile returns the index of the file matching path, which may be given
+// relative to the torrent root or including the torrent name
+func findFile(t *Torrent, files []file.FileInfo, path string) (int, error) {
+	path = strings.Trim(path, "/")
+	for i, f := range files {
+		if f.Path == path || f.Path == t.Info.Name+"/"+path {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("no file %q in torrent", path)
+}
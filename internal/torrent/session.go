@@ -0,0 +1,360 @@
+package torrent
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"bittorrentclient/internal/fdbudget"
+	"bittorrentclient/internal/peer"
+	"bittorrentclient/internal/peerid"
+	"bittorrentclient/internal/tracker"
+)
+
+const (
+	// DefaultUploadSlots is the number of peers we keep unchoked
+	DefaultUploadSlots = 4
+	// MaxUploadSlots caps auto-scaled upload slots
+	MaxUploadSlots = 50
+)
+
+// UploadSlotConfig controls how many peers we unchoke for uploading
+type UploadSlotConfig struct {
+	Slots     int   // Base number of unchoked upload slots
+	AutoScale bool  // Add slots as upload bandwidth grows
+	SlotRate  int64 // Upload bytes/second each slot is expected to use when auto-scaling
+}
+
+// DefaultUploadSlotConfig returns the default upload slot configuration
+func DefaultUploadSlotConfig() UploadSlotConfig {
+	return UploadSlotConfig{
+		Slots:    DefaultUploadSlots,
+		SlotRate: 16 * 1024, // 16 KB/s per slot
+	}
+}
+
+// SlotsFor returns the number of upload slots for the given upload rate
+func (c UploadSlotConfig) SlotsFor(uploadRate float64) int {
+	slots := c.Slots
+	if slots <= 0 {
+		slots = DefaultUploadSlots
+	}
+
+	if c.AutoScale && c.SlotRate > 0 {
+		scaled := int(math.Ceil(uploadRate / float64(c.SlotRate)))
+		if scaled > slots {
+			slots = scaled
+		}
+	}
+
+	if slots > MaxUploadSlots {
+		slots = MaxUploadSlots
+	}
+	return slots
+}
+
+// SessionConfig holds settings shared by every torrent in a session
+type SessionConfig struct {
+	UploadSlots UploadSlotConfig
+	TrackerHTTP tracker.HTTPClientConfig // Pooled HTTP client shared by all tracker announces
+	Bind        string                   // Interface name or IP peer connections use; empty means any
+	AnnounceIP  string                   // "ip" sent to trackers, or tracker.AutoAnnounceIP; empty sends none
+	Resolver    peer.Resolver            // Looks up peer and tracker host names; nil uses the system resolver
+	HostsFile   string                   // Answer host names listed here before asking Resolver; empty disables it
+	PeerTimeout peer.Timeouts            // Dial, handshake and connect timeouts; zero fields use the defaults
+	Inbound     peer.InboundLimits       // Accept rate and pending handshake caps for Listen
+	FDBudget    int                      // File descriptors files and sockets may use; 0 derives it from the open file limit
+	PeerIDFile  string                   // Keep the peer ID here across restarts; empty rotates it every run
+	OutputDir   string                   // Where torrents added without an output directory go
+	StateDir    string                   // Where to remember each torrent's output directory; empty disables it
+	TraceFile   string                   // Append every peer wire message here as a JSON line; empty logs none
+	TraceBuffer int                      // Keep this many recent peer wire messages for ExportTrace; 0 keeps none
+	ExportDir   string                   // Write every added torrent's .torrent file here (see Torrent.Export); empty writes none
+	MaxActive   int                      // Torrents downloading at once, the first in queue order; 0 means no limit
+
+	RateLimits    RateLimits // Caps shared by all torrents
+	AltRateLimits RateLimits // Caps used instead while turtle mode is on
+	AltSpeed      bool       // Start in turtle mode
+
+	// Settings given explicitly, which LoadAll keeps rather than restoring
+	// the saved ones
+	KeepRateLimits bool // RateLimits and AltRateLimits
+	KeepAltSpeed   bool // AltSpeed
+}
+
+// DefaultSessionConfig returns the default session configuration
+func DefaultSessionConfig() SessionConfig {
+	return SessionConfig{
+		UploadSlots:   DefaultUploadSlotConfig(),
+		TrackerHTTP:   tracker.DefaultHTTPClientConfig(),
+		Inbound:       peer.DefaultInboundLimits(),
+		AltRateLimits: DefaultAltRateLimits,
+	}
+}
+
+// Session groups the downloaders of one client instance and the
+// defaults they are created with
+type Session struct {
+	mu          sync.RWMutex
+	config      SessionConfig
+	downloaders []*Downloader
+	httpClient  *http.Client      // Shared by the session's tracker clients
+	dialer      *peer.Dialer      // Bound to config.Bind, shared by all peer connections
+	listener    *peer.Listener    // Accepts inbound peers; nil until Listen
+	listenPort  int               // Port Listen was asked for; 0 until then
+	fdBudget    *fdbudget.Budget  // Shared by every torrent's file handles and the dialer's sockets
+	locations   map[string]string // Info hash -> output directory it was last added with
+	queueOrder  []string          // Saved queue order of info hashes, including torrents not loaded
+
+	downloadLimit *RateLimiter // Session-wide caps every torrent's limiters count against
+	uploadLimit   *RateLimiter
+	altSpeed      bool // Turtle mode: AltRateLimits are in effect
+
+	watchdogStop chan struct{} // Closed to stop the bind watchdog; nil if not running
+
+	trace     *peer.Trace // Set on every peer connection; nil unless TraceFile or TraceBuffer is set
+	traceFile *os.File    // Where trace writes each message; nil if no TraceFile
+}
+
+// NewSession creates a new session. It fails if config.Bind names an
+// interface or address that can't be used, config.AnnounceIP is invalid,
+// config.HostsFile can't be read, the peer ID can't be kept in
+// config.PeerIDFile, or config.TraceFile can't be opened.
+func NewSession(config SessionConfig) (*Session, error) {
+	if err := tracker.ValidateAnnounceIP(config.AnnounceIP); err != nil {
+		return nil, err
+	}
+
+	dialer, err := peer.NewDialer(config.Bind)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.HostsFile != "" {
+		resolver, err := peer.LoadHostsFile(config.HostsFile, config.Resolver)
+		if err != nil {
+			return nil, err
+		}
+		if config.Resolver == nil {
+			resolver.Fallback = net.DefaultResolver
+		}
+		config.Resolver = resolver
+	}
+	dialer.SetResolver(config.Resolver)
+	dialer.SetTimeouts(config.PeerTimeout)
+
+	fdBudget := fdbudget.Default()
+	if config.FDBudget > 0 {
+		fdBudget = fdbudget.New(config.FDBudget)
+	}
+	dialer.SetFDBudget(fdBudget)
+
+	if config.PeerIDFile != "" {
+		if err := peerid.Persist(config.PeerIDFile); err != nil {
+			return nil, err
+		}
+	}
+
+	// Announce over the bound interface too, so split routing treats
+	// tracker and peer traffic the same, and look tracker host names up
+	// with the same resolver as peers'
+	if (dialer.IsBound() || config.Resolver != nil) && config.TrackerHTTP.Dial == nil {
+		config.TrackerHTTP.Dial = dialer.DialNetwork
+	}
+
+	var trace *peer.Trace
+	var traceFile *os.File
+	if config.TraceFile != "" {
+		if traceFile, err = os.OpenFile(config.TraceFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			return nil, fmt.Errorf("failed to open trace file: %w", err)
+		}
+		trace = peer.NewTrace(config.TraceBuffer, traceFile)
+	} else if config.TraceBuffer > 0 {
+		trace = peer.NewTrace(config.TraceBuffer, nil)
+	}
+
+	s := &Session{
+		config:        config,
+		trace:         trace,
+		traceFile:     traceFile,
+		httpClient:    tracker.NewHTTPClient(config.TrackerHTTP),
+		dialer:        dialer,
+		fdBudget:      fdBudget,
+		downloadLimit: NewRateLimiter(0),
+		uploadLimit:   NewRateLimiter(0),
+		altSpeed:      config.AltSpeed,
+	}
+	s.applyRateLimits()
+	if err := s.loadLocations(); err != nil {
+		fmt.Printf("⚠️  Ignoring recorded download locations: %v\n", err)
+	}
+	if err := s.loadQueue(); err != nil {
+		fmt.Printf("⚠️  Ignoring saved queue order: %v\n", err)
+	}
+	return s, nil
+}
+
+// Dialer returns the dialer peer connections should be opened with, and
+// whose ListenAddrs peer listeners should bind
+func (s *Session) Dialer() *peer.Dialer {
+	return s.dialer
+}
+
+// NewTrackerClient creates a tracker client that shares the session's
+// pooled HTTP client and announces the session's announce IP
+func (s *Session) NewTrackerClient(port int) *tracker.TrackerClient {
+	client := tracker.NewTrackerClient(port)
+	client.SetHTTPClient(s.httpClient)
+	client.SetAnnounceIP(s.config.AnnounceIP) // Validated by NewSession
+	return client
+}
+
+// Close stops accepting peers and every downloader in the session, and
+// releases idle tracker connections
+func (s *Session) Close() {
+	s.mu.Lock()
+	if s.watchdogStop != nil {
+		close(s.watchdogStop)
+		s.watchdogStop = nil
+	}
+	if s.listener != nil {
+		s.listener.Close()
+		s.listener = nil
+	}
+	s.mu.Unlock()
+
+	for _, d := range s.GetDownloaders() {
+		d.Stop()
+	}
+	s.httpClient.CloseIdleConnections()
+	if s.traceFile != nil {
+		s.traceFile.Close()
+	}
+}
+
+// Trace returns the trace recording every peer wire message, or nil if
+// tracing is off (see SessionConfig.TraceFile and TraceBuffer)
+func (s *Session) Trace() *peer.Trace {
+	return s.trace
+}
+
+// ExportTrace writes the recent peer wire messages kept in the trace
+// buffer to path as JSON lines, oldest first
+func (s *Session) ExportTrace(path string) error {
+	if s.trace == nil {
+		return fmt.Errorf("tracing is off")
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := s.trace.Export(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// FDBudget returns the file descriptor budget shared by the session's file
+// handles and peer sockets
+func (s *Session) FDBudget() *fdbudget.Budget {
+	return s.fdBudget
+}
+
+// GetConfig returns the session configuration
+func (s *Session) GetConfig() SessionConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config
+}
+
+// SetUploadSlots changes the session-wide upload slot configuration. Torrents
+// without their own override pick up the new value.
+func (s *Session) SetUploadSlots(cfg UploadSlotConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config.UploadSlots = cfg
+}
+
+// AddTorrent creates a downloader for a torrent using the session defaults,
+// at its saved queue position.
+// An empty outputDir picks the directory with OutputDirFor; the one used is
+// recorded for next time. With config.ExportDir set, the torrent's .torrent
+// file is written there too.
+func (s *Session) AddTorrent(t *Torrent, outputDir string, opts ...Option) *Downloader {
+	outputDir = s.OutputDirFor(t, outputDir)
+	s.recordLocation(t, outputDir)
+
+	d := NewDownloader(t, outputDir, opts...)
+	d.session = s
+	d.pieceManager.SetFDBudget(s.fdBudget)
+	d.downloadLimit = d.downloadLimit.Within(s.downloadLimit)
+	d.uploadLimit = d.uploadLimit.Within(s.uploadLimit)
+	d.downloadLimit.SetClock(d.clock)
+	d.uploadLimit.SetClock(d.clock)
+
+	s.mu.Lock()
+	s.enqueue(d)
+	exportDir := s.config.ExportDir
+	s.mu.Unlock()
+	s.updateQueue()
+
+	// Keep a reusable copy, which for metadata fetched from peers is the
+	// only one
+	if exportDir != "" {
+		if path, err := t.Export(exportDir); err != nil {
+			fmt.Printf("⚠️  Failed to export %s: %v\n", t.Info.Name, err)
+		} else {
+			fmt.Printf("📄 Saved %s\n", path)
+		}
+	}
+	return d
+}
+
+// RemoveTorrent stops a downloader and drops it from the session
+func (s *Session) RemoveTorrent(d *Downloader) {
+	s.mu.Lock()
+	if i := s.indexOf(d); i >= 0 {
+		s.downloaders = append(s.downloaders[:i], s.downloaders[i+1:]...)
+	}
+	s.mu.Unlock()
+
+	d.Stop()
+	s.updateQueue()
+}
+
+// Find returns the downloader whose torrent has the given name or info hash
+// (hex, v1 or v2)
+func (s *Session) Find(query string) (*Downloader, error) {
+	var found *Downloader
+	for _, d := range s.GetDownloaders() {
+		t := d.GetTorrent()
+		if strings.EqualFold(query, t.InfoHash.String()) || (t.Info.IsV2() && strings.EqualFold(query, t.InfoHashV2.String())) {
+			return d, nil
+		}
+		if query == t.Info.Name {
+			if found != nil {
+				return nil, fmt.Errorf("more than one torrent is named %q, use its info hash", query)
+			}
+			found = d
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no torrent %q in the session", query)
+	}
+	return found, nil
+}
+
+// GetDownloaders returns all downloaders in the session, in queue order
+func (s *Session) GetDownloaders() []*Downloader {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	downloaders := make([]*Downloader, len(s.downloaders))
+	copy(downloaders, s.downloaders)
+	return downloaders
+}
@@ -0,0 +1,387 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"bittorrentclient/internal/bencode"
+	"bittorrentclient/internal/file"
+	"bittorrentclient/internal/peer"
+)
+
+const (
+	// sessionFile is the file in SessionConfig.StateDir SaveAll writes
+	sessionFile = "session"
+	// torrentsDir is the directory in SessionConfig.StateDir SaveAll keeps
+	// a copy of every torrent's metainfo in
+	torrentsDir = "torrents"
+)
+
+// SaveAll writes the whole session to SessionConfig.StateDir: every
+// torrent in queue order with its output directory, options, completion
+// and recheck policies, rate limits, peer caps, selections, file
+// priorities, upload total and last recheck, and the session-wide rate
+// limits, turtle mode and upload slots. LoadAll restores it after a
+// restart. Each torrent's metainfo is kept alongside, so the original
+// .torrent files aren't needed again.
+func (s *Session) SaveAll() error {
+	config := s.GetConfig()
+	if config.StateDir == "" {
+		return fmt.Errorf("the session has no state directory")
+	}
+	dir := filepath.Join(config.StateDir, torrentsDir)
+
+	downloaders := s.GetDownloaders()
+	torrents := make([]interface{}, 0, len(downloaders))
+	kept := make(map[string]bool, len(downloaders))
+	for _, d := range downloaders {
+		path, err := d.torrent.Export(dir)
+		if err != nil {
+			return fmt.Errorf("failed to save %s: %w", d.torrent.Info.Name, err)
+		}
+		kept[filepath.Base(path)] = true
+		entry := d.snapshot()
+		entry["torrent"] = path
+		torrents = append(torrents, entry)
+	}
+
+	encoded, err := bencode.Encode(map[string]interface{}{
+		"alt speed":       boolInt(s.AltSpeed()),
+		"rate limits":     encodeRateLimits(config.RateLimits),
+		"alt rate limits": encodeRateLimits(config.AltRateLimits),
+		"upload slots":    encodeUploadSlots(config.UploadSlots),
+		"torrents":        torrents,
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(config.StateDir, 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(config.StateDir, sessionFile)
+	if err := os.WriteFile(path+".tmp", encoded, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	// Drop the metainfo of torrents no longer in the session
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".torrent") && !kept[entry.Name()] {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+	return nil
+}
+
+// LoadAll restores the session SaveAll wrote: the session-wide settings
+// SessionConfig doesn't say to keep, then every torrent not already in
+// the session, in its saved queue order, created with opts followed by
+// its saved options. The torrents are returned without being started. A
+// torrent that can't be restored is skipped and reported in the error,
+// which is nil if every one was; with nothing saved, LoadAll restores
+// nothing.
+func (s *Session) LoadAll(opts ...Option) ([]*Downloader, error) {
+	config := s.GetConfig()
+	if config.StateDir == "" {
+		return nil, fmt.Errorf("the session has no state directory")
+	}
+
+	raw, err := os.ReadFile(filepath.Join(config.StateDir, sessionFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read saved session: %w", err)
+	}
+	decoded, err := bencode.Decode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode saved session: %w", err)
+	}
+	saved, ok := decoded.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("saved session is not a dictionary")
+	}
+
+	s.mu.Lock()
+	if !s.config.KeepRateLimits {
+		s.config.RateLimits = decodeRateLimits(dictOf(saved, "rate limits"))
+		s.config.AltRateLimits = decodeRateLimits(dictOf(saved, "alt rate limits"))
+	}
+	if slots := dictOf(saved, "upload slots"); slots != nil {
+		s.config.UploadSlots = decodeUploadSlots(slots)
+	}
+	if !s.config.KeepAltSpeed {
+		s.altSpeed = intOf(saved, "alt speed") != 0
+	}
+	s.applyRateLimits()
+	s.mu.Unlock()
+
+	var restored []*Downloader
+	var errs []error
+	list, _ := saved["torrents"].([]interface{})
+	for _, item := range list {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		d, err := s.restore(entry, opts)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if d != nil {
+			restored = append(restored, d)
+		}
+	}
+	return restored, errors.Join(errs...)
+}
+
+// snapshot returns what SaveAll keeps of the torrent, see restore
+func (d *Downloader) snapshot() map[string]interface{} {
+	download, upload := d.GetRateLimits()
+	policy := d.GetCompletion()
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	peerLimits := make(map[string]interface{}, len(d.peerLimits))
+	for key, limits := range d.peerLimits {
+		peerLimits[key] = encodeRateLimits(RateLimits{Download: limits.download.Rate(), Upload: limits.upload.Rate()})
+	}
+	selections := make([]interface{}, len(d.selections))
+	for i, sel := range d.selections {
+		selections[i] = map[string]interface{}{"path": sel.Path, "offset": sel.Offset, "length": sel.Length}
+	}
+	var completedAt, lastRecheck int64
+	if !d.completedAt.IsZero() {
+		completedAt = d.completedAt.UnixNano()
+	}
+	if !d.lastRecheck.IsZero() {
+		lastRecheck = d.lastRecheck.UnixNano()
+	}
+
+	entry := map[string]interface{}{
+		"info hash":  d.torrent.InfoHash.String(),
+		"output dir": d.outputDir,
+		"options": map[string]interface{}{
+			"max peers":      int64(d.maxPeers),
+			"resume":         boolInt(d.resume),
+			"verify rate":    d.verifyRate,
+			"write cache":    d.writeCache,
+			"seed mode":      boolInt(d.seedMode),
+			"verify on read": boolInt(d.verifyOnRead),
+			"allocation":     d.allocation.String(),
+			"incomplete":     boolInt(d.incomplete),
+			"stall timeout":  int64(d.stallTimeout),
+			"order log":      d.orderLog,
+			"capture dir":    d.captureDir,
+			"deterministic":  boolInt(d.deterministic),
+			"rand seed":      d.randSeed,
+			"mode":           d.mode.String(),
+			"peer allowlist": d.allowlist.String(),
+		},
+		"completion": map[string]interface{}{
+			"action":     policy.Action.String(),
+			"seed ratio": strconv.FormatFloat(policy.SeedRatio, 'g', -1, 64),
+			"seed time":  int64(policy.SeedTime),
+			"hook":       policy.Hook,
+		},
+		"recheck": map[string]interface{}{
+			"interval":    int64(d.recheck.Interval),
+			"after crash": boolInt(d.recheck.AfterCrash),
+			"rate":        d.recheck.Rate,
+		},
+		"rate limits":  encodeRateLimits(RateLimits{Download: download, Upload: upload}),
+		"peer limits":  peerLimits,
+		"selections":   selections,
+		"uploaded":     d.uploadedBefore + d.GetUploaded(),
+		"completed at": completedAt,
+		"last recheck": lastRecheck,
+	}
+	if d.uploadSlots != nil {
+		entry["upload slots"] = encodeUploadSlots(*d.uploadSlots)
+	}
+	if d.hasFilePriorities() {
+		filePriorities := d.GetFilePriorities()
+		priorities := make([]interface{}, len(filePriorities))
+		for i, priority := range filePriorities {
+			priorities[i] = priority.String()
+		}
+		entry["file priorities"] = priorities
+	}
+	return entry
+}
+
+// restore adds a torrent saved by snapshot to the session, or returns nil
+// if it is in the session already
+func (s *Session) restore(entry map[string]interface{}, opts []Option) (*Downloader, error) {
+	path := stringOf(entry, "torrent")
+	t, err := Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore %s: %w", path, err)
+	}
+	if _, err := s.Find(t.InfoHash.String()); err == nil {
+		return nil, nil
+	}
+
+	saved := dictOf(entry, "options")
+	allocation, err := file.ParseAllocationStrategy(stringOf(saved, "allocation"))
+	if err != nil {
+		allocation = file.AutoAllocation
+	}
+	limits := decodeRateLimits(dictOf(entry, "rate limits"))
+	opts = append(append([]Option(nil), opts...),
+		WithMaxPeers(int(intOf(saved, "max peers"))),
+		WithResume(intOf(saved, "resume") != 0),
+		WithVerifyRate(intOf(saved, "verify rate")),
+		WithWriteCache(intOf(saved, "write cache")),
+		WithAllocation(allocation),
+		WithStallTimeout(time.Duration(intOf(saved, "stall timeout"))),
+		WithOrderLog(stringOf(saved, "order log")),
+		WithCapture(stringOf(saved, "capture dir")),
+		WithRateLimits(limits.Download, limits.Upload),
+	)
+	if intOf(saved, "seed mode") != 0 {
+		opts = append(opts, WithSeedMode())
+	}
+	if intOf(saved, "verify on read") != 0 {
+		opts = append(opts, WithVerifyOnRead())
+	}
+	if intOf(saved, "incomplete") != 0 {
+		opts = append(opts, WithIncompleteDir())
+	}
+	if intOf(saved, "deterministic") != 0 {
+		opts = append(opts, WithDeterministic(intOf(saved, "rand seed")))
+	}
+	if mode, err := ParseTransferMode(stringOf(saved, "mode")); err == nil {
+		opts = append(opts, WithTransferMode(mode))
+	}
+	if specs := stringOf(saved, "peer allowlist"); specs != "" {
+		allowlist, err := peer.ParseAllowlist(strings.Split(specs, ","))
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %w", path, err)
+		}
+		opts = append(opts, WithPeerAllowlist(allowlist))
+	}
+	completion := dictOf(entry, "completion")
+	if action, err := ParseCompletionAction(stringOf(completion, "action")); err == nil {
+		ratio, _ := strconv.ParseFloat(stringOf(completion, "seed ratio"), 64)
+		opts = append(opts, WithCompletion(CompletionPolicy{
+			Action:    action,
+			SeedRatio: ratio,
+			SeedTime:  time.Duration(intOf(completion, "seed time")),
+			Hook:      stringOf(completion, "hook"),
+		}))
+	}
+	if recheck := dictOf(entry, "recheck"); recheck != nil {
+		opts = append(opts, WithRecheck(RecheckPolicy{
+			Interval:   time.Duration(intOf(recheck, "interval")),
+			AfterCrash: intOf(recheck, "after crash") != 0,
+			Rate:       intOf(recheck, "rate"),
+		}))
+	}
+
+	d := s.AddTorrent(t, stringOf(entry, "output dir"), opts...)
+
+	var selections []Selection
+	list, _ := entry["selections"].([]interface{})
+	for _, item := range list {
+		if sel, ok := item.(map[string]interface{}); ok {
+			selections = append(selections, Selection{
+				Path:   stringOf(sel, "path"),
+				Offset: intOf(sel, "offset"),
+				Length: intOf(sel, "length"),
+			})
+		}
+	}
+	if len(selections) > 0 {
+		if err := d.Select(selections); err != nil {
+			s.RemoveTorrent(d)
+			return nil, fmt.Errorf("failed to restore %s: %w", t.Info.Name, err)
+		}
+	}
+	priorities, _ := entry["file priorities"].([]interface{})
+	for i, item := range priorities {
+		name, _ := item.(string)
+		if priority, err := file.ParsePriority(name); err == nil {
+			d.SetFilePriority(i, priority)
+		}
+	}
+	for key, value := range dictOf(entry, "peer limits") {
+		if caps, ok := value.(map[string]interface{}); ok {
+			limits := decodeRateLimits(caps)
+			d.SetPeerRateLimits(key, limits.Download, limits.Upload)
+		}
+	}
+	if slots := dictOf(entry, "upload slots"); slots != nil {
+		d.SetUploadSlots(decodeUploadSlots(slots))
+	}
+
+	d.mu.Lock()
+	d.uploadedBefore = intOf(entry, "uploaded")
+	if at := intOf(entry, "completed at"); at != 0 {
+		d.completedAt = time.Unix(0, at)
+	}
+	if at := intOf(entry, "last recheck"); at != 0 {
+		d.lastRecheck = time.Unix(0, at)
+	}
+	d.mu.Unlock()
+	return d, nil
+}
+
+func encodeRateLimits(limits RateLimits) map[string]interface{} {
+	return map[string]interface{}{"download": limits.Download, "upload": limits.Upload}
+}
+
+func decodeRateLimits(dict map[string]interface{}) RateLimits {
+	return RateLimits{Download: intOf(dict, "download"), Upload: intOf(dict, "upload")}
+}
+
+func encodeUploadSlots(cfg UploadSlotConfig) map[string]interface{} {
+	return map[string]interface{}{
+		"slots":      int64(cfg.Slots),
+		"auto scale": boolInt(cfg.AutoScale),
+		"slot rate":  cfg.SlotRate,
+	}
+}
+
+func decodeUploadSlots(dict map[string]interface{}) UploadSlotConfig {
+	return UploadSlotConfig{
+		Slots:     int(intOf(dict, "slots")),
+		AutoScale: intOf(dict, "auto scale") != 0,
+		SlotRate:  intOf(dict, "slot rate"),
+	}
+}
+
+// boolInt encodes a bool for bencode, which has no booleans
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// intOf, stringOf and dictOf read a decoded bencode dictionary's values,
+// returning the zero value for missing or mistyped keys
+func intOf(dict map[string]interface{}, key string) int64 {
+	n, _ := dict[key].(int64)
+	return n
+}
+
+func stringOf(dict map[string]interface{}, key string) string {
+	s, _ := dict[key].(string)
+	return s
+}
+
+func dictOf(dict map[string]interface{}, key string) map[string]interface{} {
+	d, _ := dict[key].(map[string]interface{})
+	return d
+}
@@ -0,0 +1,134 @@
+package torrent
+
+import "time"
+
+// RateSmoothing is the weight given to the newest sample in the smoothed
+// download rate. Lower values give a steadier ETA at the cost of reacting
+// more slowly to real speed changes.
+const RateSmoothing = 0.2
+
+// Stats is a snapshot of a torrent's transfer statistics
+type Stats struct {
+	Progress        float64 // Percent of pieces verified
+	CompletedPieces int
+	TotalPieces     int
+
+	Downloaded int64 // Verified bytes
+	Uploaded   int64
+	Left       int64
+
+	DownloadRate float64       // Smoothed download rate in bytes/second
+	UploadRate   float64       // Upload rate in bytes/second
+	ETA          time.Duration // Based on the smoothed rate; 0 if unknown or complete
+
+	WastedBytes   int64 // Duplicate blocks and unrequested blocks we discarded
+	HashFailBytes int64 // Bytes of pieces that failed verification
+	HashFailures  int
+
+	// DistributedCopies is how many full copies connected peers hold
+	// between them: the rarest piece's count, plus the fraction of pieces
+	// held more often. Below 1 the torrent can't complete from them alone.
+	DistributedCopies float64
+
+	ConnectedSeeds    int // Connected peers that have every piece
+	ConnectedLeechers int // Connected peers that are missing pieces
+	TotalSeeds        int // Seeds reported by the tracker
+	TotalLeechers     int // Leechers reported by the tracker
+}
+
+// SetTrackerCounts records the swarm size reported by the last tracker response
+func (d *Downloader) SetTrackerCounts(seeds, leechers int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.trackerSeeds = seeds
+	d.trackerLeechers = leechers
+}
+
+// GetStats returns a snapshot of the torrent's statistics
+func (d *Downloader) GetStats() Stats {
+	uploaded, downloaded, left := d.AnnounceStats()
+
+	stats := Stats{
+		Progress:      d.pieceManager.GetProgress(),
+		TotalPieces:   d.pieceManager.GetTotalPieces(),
+		Downloaded:    downloaded,
+		Uploaded:      uploaded,
+		Left:          left,
+		HashFailBytes: d.pieceManager.GetHashFailBytes(),
+		HashFailures:  d.pieceManager.GetHashFailures(),
+		WastedBytes:   d.pieceManager.GetWastedBytes(),
+	}
+	if progress := d.pieceManager.GetFileProgress(); progress != nil {
+		stats.CompletedPieces = progress.GetCompletedPieceCount()
+		stats.UploadRate = progress.GetUploadSpeed()
+	}
+	stats.DistributedCopies = distributedCopies(d.pieceCounts(d.connectionList()))
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	stats.WastedBytes += d.discardedBytes
+	for _, conn := range d.connections {
+		stats.WastedBytes += conn.GetUnrequestedBytes()
+		if conn.IsSeed() {
+			stats.ConnectedSeeds++
+		} else {
+			stats.ConnectedLeechers++
+		}
+	}
+	stats.TotalSeeds = d.trackerSeeds
+	stats.TotalLeechers = d.trackerLeechers
+
+	stats.DownloadRate = d.smoothedRate
+	if left > 0 && d.smoothedRate > 0 {
+		stats.ETA = time.Duration(float64(left)/d.smoothedRate) * time.Second
+	}
+
+	return stats
+}
+
+// distributedCopies computes the distributed copies number from per-piece
+// peer counts, e.g. 3.7 when every piece has at least 3 copies and 70% of
+// them have more
+func distributedCopies(counts []int) float64 {
+	if len(counts) == 0 {
+		return 0
+	}
+
+	lowest := rarest(counts, nil)
+	more := 0
+	for _, n := range counts {
+		if n > lowest {
+			more++
+		}
+	}
+	return float64(lowest) + float64(more)/float64(len(counts))
+}
+
+// sampleRate folds the bytes verified since the last sample into the
+// smoothed download rate. Called once per download loop tick.
+func (d *Downloader) sampleRate() {
+	now := d.clock.Now()
+	downloaded := d.pieceManager.GetDownloadedBytes()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.lastSample.IsZero() {
+		elapsed := now.Sub(d.lastSample).Seconds()
+		if elapsed > 0 {
+			rate := float64(downloaded-d.lastSampleBytes) / elapsed
+			if d.smoothedRate == 0 {
+				d.smoothedRate = rate
+			} else {
+				d.smoothedRate = RateSmoothing*rate + (1-RateSmoothing)*d.smoothedRate
+			}
+		}
+	}
+	if downloaded > d.lastSampleBytes {
+		d.lastDataAt = now
+		d.stalled = false
+	}
+	d.lastSample = now
+	d.lastSampleBytes = downloaded
+}
@@ -1,6 +1,10 @@
 package torrent
 
-import "errors"
+import (
+	"errors"
+
+	"bittorrentclient/internal/tracker"
+)
 
 // Torrent represents a parsed torrent file
 type Torrent struct {
@@ -11,9 +15,23 @@ type Torrent struct {
 	CreatedBy    *string    `bencode:"created by,omitempty"`
 	CreationDate *int64     `bencode:"creation date,omitempty"`
 
+	PieceLayers map[[32]byte][]byte `bencode:"piece layers,omitempty"` // v2 pieces root -> the file's piece hashes
+
 	// Calculated fields (not from bencode)
-	InfoHash    InfoHash `bencode:"-"`
-	rawInfoDict []byte   `bencode:"-"` // Store for hash calculation
+	InfoHash    InfoHash   `bencode:"-"` // SHA-1 of the info dict; for v2-only torrents the truncated InfoHashV2, which is what their swarm uses
+	InfoHashV2  InfoHashV2 `bencode:"-"` // SHA-256 of the info dict, if it has v2 metadata
+	rawInfoDict []byte     `bencode:"-"` // Store for hash calculation
+	Warnings    []string   `bencode:"-"` // Unusual but accepted metadata, see ParseLimits
+}
+
+// AnnounceTiers returns the torrent's trackers as one deduplicated, tiered
+// list, merging the announce-list with the announce URL
+func (t *Torrent) AnnounceTiers() [][]string {
+	var announce [][]string
+	if t.Announce != "" {
+		announce = [][]string{{t.Announce}}
+	}
+	return tracker.MergeTiers(t.AnnounceList, announce)
 }
 
 // In torrent.go
@@ -0,0 +1,95 @@
+package torrent
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"bittorrentclient/internal/peer"
+)
+
+// UploadPollInterval is how long the uploader waits when no peer has a
+// request queued
+const UploadPollInterval = 50 * time.Millisecond
+
+// uploadLoop serves the block requests peers queued with us. Each round
+// takes at most one request from every peer, so a peer with a deep queue
+// can't starve the others the way a single global FIFO would.
+func (d *Downloader) uploadLoop() {
+	ticker := d.clock.NewTicker(UploadPollInterval)
+	defer ticker.Stop()
+
+	start := 0
+	for {
+		served := false
+		conns := d.uploadOrder(start)
+		for _, conn := range conns {
+			select {
+			case <-d.done:
+				return
+			default:
+			}
+
+			if d.serveNext(conn) {
+				served = true
+			}
+		}
+		start++
+
+		if served {
+			continue
+		}
+
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// uploadOrder returns the connected peers in a stable order rotated by
+// start, so no peer is always served first
+func (d *Downloader) uploadOrder(start int) []*peer.Connection {
+	d.mu.RLock()
+	keys := make([]string, 0, len(d.connections))
+	for key := range d.connections {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	conns := make([]*peer.Connection, 0, len(keys))
+	for i := range keys {
+		conns = append(conns, d.connections[keys[(start+i)%len(keys)]])
+	}
+	d.mu.RUnlock()
+	return conns
+}
+
+// serveNext serves the oldest request a peer queued, rejecting it if we
+// don't have the block or no longer upload. It returns false if the peer
+// had nothing queued.
+func (d *Downloader) serveNext(conn *peer.Connection) bool {
+	req, ok := conn.NextUploadRequest()
+	if !ok {
+		return false
+	}
+
+	var data []byte
+	err := fmt.Errorf("not seeding in %s mode", ModeNoSeed)
+	if d.uploading() {
+		data, err = d.pieceManager.ReadBlock(int(req.PieceIndex), req.Begin, req.Length)
+	}
+	if err != nil {
+		d.logger.Printf("Rejecting request from peer %x: %v\n", conn.ID[:8], err)
+		if err := conn.RejectRequest(req); err != nil {
+			d.logger.Printf("Failed to reject request from peer %x: %v\n", conn.ID[:8], err)
+		}
+		return true
+	}
+
+	if err := conn.SendPiece(uint32(req.PieceIndex), uint32(req.Begin), data); err != nil {
+		d.logger.Printf("Failed to send block to peer %x: %v\n", conn.ID[:8], err)
+	}
+	return true
+}
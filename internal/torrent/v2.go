@@ -0,0 +1,247 @@
+package torrent
+
+import (
+	"encoding/hex"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+
+	piece "bittorrentclient/internal/pieces"
+)
+
+// MetaVersion2 is the "meta version" of BitTorrent v2 info dictionaries
+// (BEP 52)
+const MetaVersion2 = 2
+
+// InfoHashV2 is the SHA-256 hash of a v2 info dictionary
+type InfoHashV2 [32]byte
+
+func (ih InfoHashV2) String() string {
+	return hex.EncodeToString(ih[:])
+}
+
+// FileV2 is a file from a v2 info dictionary's file tree
+type FileV2 struct {
+	Path       []string // Relative to the torrent's root directory
+	Length     int64
+	PiecesRoot [32]byte // Merkle root of the file's 16 KiB blocks; zero for empty files
+}
+
+// parseFileTree flattens a v2 file tree into its files, in path order
+func parseFileTree(tree map[string]interface{}, dir []string) ([]FileV2, error) {
+	names := make([]string, 0, len(tree))
+	for name := range tree {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var files []FileV2
+	for _, name := range names {
+		node, ok := tree[name].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("file tree entry %q is not a dictionary", name)
+		}
+		path := append(append([]string{}, dir...), name)
+
+		// A file is a dictionary whose only key is the empty string
+		entry, isFile := node[""].(map[string]interface{})
+		if !isFile {
+			children, err := parseFileTree(node, path)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, children...)
+			continue
+		}
+
+		f := FileV2{Path: path}
+		if err := (&File{Path: path}).ValidatePath(); err != nil {
+			return nil, fmt.Errorf("invalid file path: %w", err)
+		}
+		length, ok := entry["length"].(int64)
+		if !ok || length < 0 {
+			return nil, fmt.Errorf("missing or invalid length for file %v", path)
+		}
+		f.Length = length
+		if length > 0 {
+			root, ok := entry["pieces root"].(string)
+			if !ok || len(root) != 32 {
+				return nil, fmt.Errorf("missing or invalid pieces root for file %v", path)
+			}
+			copy(f.PiecesRoot[:], root)
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// layoutV2 gives a v2-only torrent the files the rest of the client works
+// with: laid back to back like v1 files, with pad files (BEP 47) so every
+// file starts a new piece, as v2 pieces never span files
+func layoutV2(info *Info) {
+	tree := info.FileTree
+	if len(tree) == 1 && len(tree[0].Path) == 1 && tree[0].Path[0] == info.Name {
+		length := tree[0].Length
+		info.Length = &length
+		return
+	}
+
+	padAttr := "p"
+	var offset int64
+	for _, f := range tree {
+		if rest := offset % info.PieceLength; rest != 0 && f.Length > 0 {
+			pad := info.PieceLength - rest
+			info.Files = append(info.Files, File{
+				Length: pad,
+				Path:   []string{".pad", strconv.Itoa(len(info.Files))},
+				Attr:   &padAttr,
+			})
+			offset += pad
+		}
+		info.Files = append(info.Files, File{Length: f.Length, Path: f.Path})
+		offset += f.Length
+	}
+}
+
+// parsePieceLayers reads the piece layers dictionary, which maps a file's
+// pieces root to the concatenated hashes of its pieces
+func parsePieceLayers(layers map[string]interface{}) (map[[32]byte][]byte, error) {
+	parsed := make(map[[32]byte][]byte, len(layers))
+	for root, value := range layers {
+		hashes, ok := value.(string)
+		if len(root) != 32 || !ok || len(hashes)%32 != 0 {
+			return nil, fmt.Errorf("invalid piece layer for root %x", root)
+		}
+		var key [32]byte
+		copy(key[:], root)
+		parsed[key] = []byte(hashes)
+	}
+	return parsed, nil
+}
+
+// checkPieceLayers verifies that every file spanning more than one piece
+// has a piece layer hashing up to its pieces root. The layers sit outside
+// the info dictionary, so nothing else vouches for them.
+func (t *Torrent) checkPieceLayers() error {
+	pieceLength := t.Info.PieceLength
+	zeroPiece := piece.MerkleRoot(nil, int(pieceLength/piece.BlockSize))
+
+	for _, f := range t.Info.FileTree {
+		if f.Length <= pieceLength {
+			continue // The pieces root covers the one piece
+		}
+		layer, ok := t.PieceLayers[f.PiecesRoot]
+		numPieces := (f.Length + pieceLength - 1) / pieceLength
+		if !ok || int64(len(layer)) != numPieces*32 {
+			return fmt.Errorf("missing or invalid piece layer for file %v", f.Path)
+		}
+
+		hashes := make([][32]byte, numPieces)
+		for i := range hashes {
+			copy(hashes[i][:], layer[i*32:])
+		}
+		if piece.MerkleLayerRoot(hashes, zeroPiece) != f.PiecesRoot {
+			return fmt.Errorf("piece layer of file %v does not match its pieces root", f.Path)
+		}
+	}
+	return nil
+}
+
+// merkleHashes returns how each piece of a v2 torrent is verified against
+// its v2 metadata, in piece order
+func (t *Torrent) merkleHashes() []piece.MerkleHash {
+	pieceLength := t.Info.PieceLength
+	leavesPerPiece := int(pieceLength / piece.BlockSize)
+
+	var hashes []piece.MerkleHash
+	for _, f := range t.Info.FileTree {
+		if f.Length == 0 {
+			continue
+		}
+		if f.Length <= pieceLength {
+			blocks := int((f.Length + piece.BlockSize - 1) / piece.BlockSize)
+			hashes = append(hashes, piece.MerkleHash{Root: f.PiecesRoot, Length: f.Length, Leaves: blocks})
+			continue
+		}
+
+		layer := t.PieceLayers[f.PiecesRoot]
+		for begin := int64(0); begin < f.Length; begin += pieceLength {
+			h := piece.MerkleHash{Length: min(pieceLength, f.Length-begin), Leaves: leavesPerPiece}
+			copy(h.Root[:], layer[begin/pieceLength*32:])
+			hashes = append(hashes, h)
+		}
+	}
+	return hashes
+}
+
+// fileSpan is where a file's bytes sit in the torrent's data
+type fileSpan struct {
+	path   string
+	offset int64
+	length int64
+}
+
+// dataFiles returns the spans of the non-empty files other than pad files
+func dataFiles(info *Info) []fileSpan {
+	if info.IsSingleFile() {
+		return []fileSpan{{path: info.Name, length: *info.Length}}
+	}
+
+	var spans []fileSpan
+	var offset int64
+	for _, f := range info.Files {
+		if !f.IsPadding() && f.Length > 0 {
+			spans = append(spans, fileSpan{path: path.Join(f.Path...), offset: offset, length: f.Length})
+		}
+		offset += f.Length
+	}
+	return spans
+}
+
+// checkHybrid verifies that a hybrid torrent's v1 files are its v2 file
+// tree laid out piece-aligned, as layoutV2 does, so that both hash schemes
+// cover the same bytes. Otherwise data could verify in one swarm and not
+// the other.
+func (t *Torrent) checkHybrid() error {
+	v2 := &Info{Name: t.Info.Name, PieceLength: t.Info.PieceLength, FileTree: t.Info.FileTree}
+	layoutV2(v2)
+
+	want, got := dataFiles(v2), dataFiles(t.Info)
+	if len(want) != len(got) {
+		return fmt.Errorf("hybrid torrent has %d v1 files but %d v2 files", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			return fmt.Errorf("hybrid torrent's v1 file %q (offset %d, length %d) does not match v2 file %q (offset %d, length %d)",
+				got[i].path, got[i].offset, got[i].length, want[i].path, want[i].offset, want[i].length)
+		}
+	}
+	if hashes := len(t.merkleHashes()); hashes != t.Info.NumPieces() {
+		return fmt.Errorf("hybrid torrent has %d v1 pieces but %d v2 pieces", t.Info.NumPieces(), hashes)
+	}
+	return nil
+}
+
+// SwarmHashes returns the info hashes of the swarms the torrent can join:
+// InfoHash, and for a hybrid also the v2 hash truncated to 20 bytes, which
+// v2-only clients use
+func (t *Torrent) SwarmHashes() []InfoHash {
+	hashes := []InfoHash{t.InfoHash}
+	if t.Info.IsHybrid() {
+		var v2 InfoHash
+		copy(v2[:], t.InfoHashV2[:])
+		hashes = append(hashes, v2)
+	}
+	return hashes
+}
+
+// InSwarm returns true if infoHash is one of the torrent's SwarmHashes
+func (t *Torrent) InSwarm(infoHash InfoHash) bool {
+	for _, hash := range t.SwarmHashes() {
+		if hash == infoHash {
+			return true
+		}
+	}
+	return false
+}
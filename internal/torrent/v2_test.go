@@ -0,0 +1,225 @@
+package torrent
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+
+	piece "bittorrentclient/internal/pieces"
+)
+
+const testPieceLength = 2 * piece.BlockSize
+
+// testData returns n bytes of the pattern merkle_test.go's vectors hash
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+func mustHash(t *testing.T, s string) [32]byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 32 {
+		t.Fatalf("bad test hash %q", s)
+	}
+	var h [32]byte
+	copy(h[:], b)
+	return h
+}
+
+// testV2Torrent returns a v2-only torrent of four files in 32 KiB pieces:
+// "a" of 70000 bytes (three pieces, with a piece layer), "b" of 10 bytes,
+// the empty "c" and "d" of 5 bytes
+func testV2Torrent(t *testing.T) *Torrent {
+	t.Helper()
+	// BEP 52 piece layer and pieces root of a, see TestMerkleLayerRoot
+	layer := ""
+	for _, h := range []string{
+		"d9e13d0b676ad681164ef0b7b5910d1328ea83a047cad57e619d76bbe3a08525",
+		"e28097eaaa55956702cf8195d1a551dbabb63e3d679b294cf33d506a6b5ef479",
+		"94cbfbca59afe74836d2e7c6f1e12f31e47ed0d068f78a4e78b4197c4709099f",
+	} {
+		root := mustHash(t, h)
+		layer += string(root[:])
+	}
+	rootA := mustHash(t, "83deabd1fe1301daff7f0f151ac57676bb0de039ffc91faa63b02a3e0105bcd8")
+
+	metaVersion := int64(MetaVersion2)
+	return &Torrent{
+		Info: &Info{
+			Name:        "test",
+			PieceLength: testPieceLength,
+			MetaVersion: &metaVersion,
+			FileTree: []FileV2{
+				{Path: []string{"a"}, Length: 70000, PiecesRoot: rootA},
+				{Path: []string{"b"}, Length: 10, PiecesRoot: piece.MerkleRoot(testData(10), 1)},
+				{Path: []string{"c"}, Length: 0},
+				{Path: []string{"d"}, Length: 5, PiecesRoot: piece.MerkleRoot(testData(5), 1)},
+			},
+		},
+		PieceLayers: map[[32]byte][]byte{rootA: []byte(layer)},
+	}
+}
+
+func TestLayoutV2Padding(t *testing.T) {
+	tr := testV2Torrent(t)
+	layoutV2(tr.Info)
+
+	type file struct {
+		path   string
+		length int64
+		pad    bool
+	}
+	want := []file{
+		{"a", 70000, false},
+		{".pad/1", 28304, true}, // b starts the third piece, at 98304
+		{"b", 10, false},
+		{"c", 0, false}, // Empty files need no alignment
+		{".pad/4", 32758, true},
+		{"d", 5, false},
+	}
+	var got []file
+	for _, f := range tr.Info.Files {
+		got = append(got, file{strings.Join(f.Path, "/"), f.Length, f.IsPadding()})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("files = %v, want %v", got, want)
+	}
+	if n := tr.Info.NumPieces(); n != 5 {
+		t.Fatalf("NumPieces = %d, want 5", n)
+	}
+}
+
+func TestLayoutV2SingleFile(t *testing.T) {
+	info := &Info{Name: "f", PieceLength: testPieceLength, FileTree: []FileV2{{Path: []string{"f"}, Length: 70000}}}
+	layoutV2(info)
+	if !info.IsSingleFile() || *info.Length != 70000 || len(info.Files) != 0 {
+		t.Fatalf("single-file v2 torrent laid out as %+v", info)
+	}
+}
+
+func TestCheckPieceLayers(t *testing.T) {
+	tr := testV2Torrent(t)
+	if err := tr.checkPieceLayers(); err != nil {
+		t.Fatalf("valid piece layers: %v", err)
+	}
+
+	rootA := tr.Info.FileTree[0].PiecesRoot
+	layer := tr.PieceLayers[rootA]
+
+	tampered := append([]byte(nil), layer...)
+	tampered[40] ^= 1
+	tr.PieceLayers[rootA] = tampered
+	if err := tr.checkPieceLayers(); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("tampered piece layer: err = %v", err)
+	}
+
+	tr.PieceLayers[rootA] = layer[:64]
+	if err := tr.checkPieceLayers(); err == nil || !strings.Contains(err.Error(), "missing or invalid") {
+		t.Fatalf("short piece layer: err = %v", err)
+	}
+
+	delete(tr.PieceLayers, rootA)
+	if err := tr.checkPieceLayers(); err == nil {
+		t.Fatal("missing piece layer accepted")
+	}
+}
+
+func TestMerkleHashes(t *testing.T) {
+	tr := testV2Torrent(t)
+	layer := tr.PieceLayers[tr.Info.FileTree[0].PiecesRoot]
+
+	hashes := tr.merkleHashes()
+	if len(hashes) != 5 {
+		t.Fatalf("%d piece hashes, want 5", len(hashes))
+	}
+	for i, want := range []struct {
+		length int64
+		leaves int
+	}{{testPieceLength, 2}, {testPieceLength, 2}, {70000 - 2*testPieceLength, 2}, {10, 1}, {5, 1}} {
+		if hashes[i].Length != want.length || hashes[i].Leaves != want.leaves {
+			t.Fatalf("piece %d: length %d over %d leaves, want %d over %d",
+				i, hashes[i].Length, hashes[i].Leaves, want.length, want.leaves)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if string(hashes[i].Root[:]) != string(layer[i*32:(i+1)*32]) {
+			t.Fatalf("piece %d root %x is not its piece layer entry", i, hashes[i].Root)
+		}
+	}
+	if hashes[3].Root != tr.Info.FileTree[1].PiecesRoot || hashes[4].Root != tr.Info.FileTree[3].PiecesRoot {
+		t.Fatal("single-piece files aren't verified against their pieces roots")
+	}
+
+	// Each piece of the file's data verifies against its hash
+	file := testData(70000)
+	for i := 0; i < 3; i++ {
+		begin := int64(i) * testPieceLength
+		if !hashes[i].Matches(file[begin:min(begin+testPieceLength, int64(len(file)))]) {
+			t.Fatalf("piece %d of a does not match", i)
+		}
+	}
+}
+
+// testHybridTorrent is testV2Torrent with v1 metadata laid out to match
+func testHybridTorrent(t *testing.T) *Torrent {
+	tr := testV2Torrent(t)
+	layoutV2(tr.Info)
+	tr.Info.Pieces = make([][20]byte, 5)
+	tr.InfoHash = InfoHash{1}
+	tr.InfoHashV2 = InfoHashV2{2, 3}
+	return tr
+}
+
+func TestCheckHybrid(t *testing.T) {
+	if err := testHybridTorrent(t).checkHybrid(); err != nil {
+		t.Fatalf("matching hybrid: %v", err)
+	}
+
+	// v1 files without the pad files put b and d at other offsets
+	tr := testHybridTorrent(t)
+	var unpadded []File
+	for _, f := range tr.Info.Files {
+		if !f.IsPadding() {
+			unpadded = append(unpadded, f)
+		}
+	}
+	tr.Info.Files = unpadded
+	if err := tr.checkHybrid(); err == nil || !strings.Contains(err.Error(), `"b"`) {
+		t.Fatalf("unpadded v1 files: err = %v", err)
+	}
+
+	tr = testHybridTorrent(t)
+	tr.Info.Files[2].Path = []string{"renamed"}
+	if err := tr.checkHybrid(); err == nil {
+		t.Fatal("v1 file named differently accepted")
+	}
+
+	tr = testHybridTorrent(t)
+	tr.Info.Files = tr.Info.Files[:3]
+	if err := tr.checkHybrid(); err == nil || !strings.Contains(err.Error(), "v1 files") {
+		t.Fatalf("missing v1 file: err = %v", err)
+	}
+}
+
+func TestSwarmHashes(t *testing.T) {
+	tr := testHybridTorrent(t)
+	var v2 InfoHash
+	copy(v2[:], tr.InfoHashV2[:])
+
+	if got := tr.SwarmHashes(); !reflect.DeepEqual(got, []InfoHash{tr.InfoHash, v2}) {
+		t.Fatalf("hybrid SwarmHashes = %x", got)
+	}
+	if !tr.InSwarm(v2) || !tr.InSwarm(tr.InfoHash) || tr.InSwarm(InfoHash{9}) {
+		t.Fatal("hybrid InSwarm is wrong")
+	}
+
+	tr.Info.Pieces = nil // v2 only: InfoHash is already the truncated v2 hash
+	if got := tr.SwarmHashes(); !reflect.DeepEqual(got, []InfoHash{tr.InfoHash}) {
+		t.Fatalf("v2-only SwarmHashes = %x", got)
+	}
+}
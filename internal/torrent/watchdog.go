@@ -0,0 +1,59 @@
+package torrent
+
+import (
+	"fmt"
+	"time"
+)
+
+// BindCheckInterval is how often the bind watchdog re-checks the bound
+// interface
+const BindCheckInterval = 5 * time.Second
+
+// StartBindWatchdog periodically checks that the interface or address the
+// session is bound to is still there. When it disappears (e.g. the VPN went
+// down) every peer is disconnected and new dials are refused until it comes
+// back, so peer traffic can't fall back to the real IP. It does nothing for
+// an unbound session, and runs until Close.
+func (s *Session) StartBindWatchdog(interval time.Duration) {
+	if !s.dialer.IsBound() {
+		return
+	}
+
+	s.mu.Lock()
+	if s.watchdogStop != nil {
+		s.mu.Unlock()
+		return // Already running
+	}
+	stop := make(chan struct{})
+	s.watchdogStop = stop
+	s.mu.Unlock()
+
+	go s.bindWatchdog(interval, stop)
+}
+
+// bindWatchdog runs the checks for StartBindWatchdog
+func (s *Session) bindWatchdog(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		err := s.dialer.Check()
+		switch {
+		case err != nil && !s.dialer.IsPaused():
+			fmt.Printf("Bound interface lost (%v), pausing peer traffic\n", err)
+			s.dialer.SetPaused(true)
+			for _, d := range s.GetDownloaders() {
+				d.DisconnectPeers()
+			}
+		case err == nil && s.dialer.IsPaused():
+			fmt.Printf("Bound interface is back, resuming peer traffic\n")
+			s.dialer.SetPaused(false)
+		}
+	}
+}
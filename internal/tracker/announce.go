@@ -9,11 +9,12 @@ import (
 	"strconv"
 )
 
-func (tc *TrackerClient) buildTrackerURL(announceURL string, req *TrackerRequest) (string, error) {
-	u, err := url.Parse(announceURL)
-	if err != nil {
-		return "", fmt.Errorf("invalid announce URL: %v", err)
-	}
+// NoPeers as TrackerRequest.NumWant asks the tracker for no peers at all,
+// e.g. when we already have as many connections as we can use
+const NoPeers = -1
+
+func (tc *TrackerClient) buildTrackerURL(announce *url.URL, req *TrackerRequest) (string, error) {
+	u := *announce
 
 	// Use url.Values for safe and idiomatic query parameter construction.
 	q := u.Query()
@@ -32,10 +33,15 @@ func (tc *TrackerClient) buildTrackerURL(announceURL string, req *TrackerRequest
 	}
 	if req.NumWant > 0 {
 		q.Set("numwant", strconv.Itoa(req.NumWant))
+	} else if req.NumWant == NoPeers {
+		q.Set("numwant", "0")
 	}
 	if req.TrackerID != "" {
 		q.Set("trackerid", req.TrackerID)
 	}
+	if req.IP != "" {
+		q.Set("ip", req.IP)
+	}
 
 	u.RawQuery = q.Encode()
 	return u.String(), nil
@@ -56,7 +62,32 @@ func (tc *TrackerClient) Announce(announceURL string, req *TrackerRequest) (*Tra
 		req.NumWant = 50
 	}
 
-	reqURL, err := tc.buildTrackerURL(announceURL, req)
+	if req.IP == "" {
+		req.IP = tc.announceIPParam()
+	}
+
+	u, err := NormalizeAnnounceURL(announceURL)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := transportFor(u.Scheme)
+	if err != nil {
+		tc.recordAnnounce(u.String(), nil, err)
+		return nil, err
+	}
+
+	resp, err := transport(tc, u, req)
+	tc.recordAnnounce(u.String(), resp, err)
+	if err == nil {
+		tc.recordExternalIP(resp.ExternalIP)
+	}
+	return resp, err
+}
+
+// announceHTTP announces to an HTTP(S) tracker
+func announceHTTP(tc *TrackerClient, u *url.URL, req *TrackerRequest) (*TrackerResponse, error) {
+	reqURL, err := tc.buildTrackerURL(u, req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build tracker URL: %v", err)
 	}
@@ -67,6 +98,13 @@ func (tc *TrackerClient) Announce(announceURL string, req *TrackerRequest) (*Tra
 	}
 	defer resp.Body.Close()
 
+	if isRetryStatus(resp.StatusCode) {
+		return nil, &RetryError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), tc.now()),
+		}
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("tracker returned status %d", resp.StatusCode)
 	}
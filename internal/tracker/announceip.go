@@ -0,0 +1,86 @@
+package tracker
+
+import (
+	"fmt"
+	"net"
+)
+
+// AutoAnnounceIP as the announce IP sends the external address trackers
+// report seeing us at (BEP 24 "external ip"), once one has
+const AutoAnnounceIP = "auto"
+
+// ValidateAnnounceIP checks an announce IP setting: an IP address, a DNS
+// name, AutoAnnounceIP, or empty to send none
+func ValidateAnnounceIP(ip string) error {
+	if ip == "" || ip == AutoAnnounceIP {
+		return nil
+	}
+	if err := validateHost(ip); err != nil {
+		return fmt.Errorf("invalid announce IP: %w", err)
+	}
+	return nil
+}
+
+// SetAnnounceIP sets the "ip" parameter sent with announces that don't set
+// TrackerRequest.IP themselves, for when the tracker would otherwise record
+// a gateway's internal address. See ValidateAnnounceIP for what it may be.
+func (tc *TrackerClient) SetAnnounceIP(ip string) error {
+	if err := ValidateAnnounceIP(ip); err != nil {
+		return err
+	}
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.announceIP = ip
+	return nil
+}
+
+// ExternalIP returns the address the last tracker to report one saw us at,
+// or nil
+func (tc *TrackerClient) ExternalIP() net.IP {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	return tc.externalIP
+}
+
+// AnnounceIP returns the "ip" parameter announces that don't set
+// TrackerRequest.IP are sent with, or "" for none: the announce IP, or for
+// AutoAnnounceIP the external IP once a tracker reported one
+func (tc *TrackerClient) AnnounceIP() string {
+	return tc.announceIPParam()
+}
+
+// announceIPParam returns the "ip" parameter to announce with, or "" for
+// none
+func (tc *TrackerClient) announceIPParam() string {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+
+	if tc.announceIP != AutoAnnounceIP {
+		return tc.announceIP
+	}
+	if tc.externalIP != nil {
+		return tc.externalIP.String()
+	}
+	return ""
+}
+
+// recordExternalIP remembers the external address a tracker reported
+func (tc *TrackerClient) recordExternalIP(ip net.IP) {
+	if ip == nil {
+		return
+	}
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.externalIP = ip
+}
+
+// parseExternalIP decodes a BEP 24 "external ip" value: 4 or 16 raw bytes
+func parseExternalIP(value interface{}) net.IP {
+	raw, ok := value.(string)
+	if !ok || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return nil
+	}
+	return net.IP(raw)
+}
@@ -1,35 +1,34 @@
 package tracker
 
 import (
-	"crypto/rand"
+	"bittorrentclient/internal/clock"
+	"bittorrentclient/internal/peerid"
 	"fmt"
 	"net/http"
 	"time"
-	// Adjust import path
 )
 
-// NewTrackerClient creates a new tracker client
+// NewTrackerClient creates a new tracker client announcing with the shared
+// peer ID, so the tracker sees the same identity as our peers
 func NewTrackerClient(port int) *TrackerClient {
+	peerID := peerid.Default()
+	return NewTrackerClientWithID(port, peerID)
+}
+
+// NewTrackerClientWithID creates a new tracker client with a specific peer ID
+func NewTrackerClientWithID(port int, peerID [20]byte) *TrackerClient {
 	return &TrackerClient{
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		peerID: generatePeerID(),
-		port:   port,
+		httpClient: SharedHTTPClient(),
+		peerID:     peerID[:],
+		port:       port,
+		stats:      make(map[string]*TrackerStats),
+		clock:      clock.Real,
 	}
 }
 
-// generatePeerID generates a random 20-byte peer ID
-// Format: -GT0001-<12 random bytes> (GT = Go Torrent, 0001 = version)
-func generatePeerID() []byte {
-	peerID := make([]byte, 20)
-	copy(peerID, "-GT0001-")
-
-	randomBytes := make([]byte, 12)
-	rand.Read(randomBytes)
-	copy(peerID[8:], randomBytes)
-
-	return peerID
+// SetHTTPClient replaces the HTTP client used for announces
+func (tc *TrackerClient) SetHTTPClient(client *http.Client) {
+	tc.httpClient = client
 }
 
 // parseTrackerResponse parses the bencode dictionary from the tracker
@@ -39,6 +38,16 @@ func (tc *TrackerClient) parseTrackerResponse(dict map[string]interface{}) (*Tra
 	// Check for failure reason
 	if failureReason, ok := dict["failure reason"].(string); ok {
 		resp.FailureReason = failureReason
+
+		// BEP 31: "retry in" is minutes until we may retry, or "never"
+		switch retryIn := dict["retry in"].(type) {
+		case int64:
+			if retryIn > 0 {
+				resp.RetryIn = time.Duration(retryIn) * time.Minute
+			}
+		case string:
+			resp.RetryNever = retryIn == "never"
+		}
 		return resp, nil // Don't parse other fields if there's a failure
 	}
 
@@ -64,6 +73,9 @@ func (tc *TrackerClient) parseTrackerResponse(dict map[string]interface{}) (*Tra
 		resp.TrackerID = trackerID
 	}
 
+	// External IP (optional, BEP 24)
+	resp.ExternalIP = parseExternalIP(dict["external ip"])
+
 	// Complete (seeders)
 	if complete, ok := dict["complete"].(int64); ok {
 		resp.Complete = int(complete)
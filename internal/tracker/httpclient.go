@@ -0,0 +1,98 @@
+package tracker
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DialFunc opens a connection to a tracker, e.g. from a bound local address
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// HTTPClientConfig tunes the HTTP client used for tracker requests
+type HTTPClientConfig struct {
+	Dial                DialFunc      // Opens tracker connections; nil uses a plain net.Dialer
+	Timeout             time.Duration // Whole-request timeout
+	MaxIdleConns        int           // Idle connections kept across all trackers
+	MaxIdleConnsPerHost int           // Idle connections kept per tracker
+	IdleConnTimeout     time.Duration // How long an idle connection is kept
+	HTTP2               bool          // Try HTTP/2 for https trackers
+}
+
+// DefaultHTTPClientConfig returns settings suited to announcing to many
+// trackers for many torrents
+func DefaultHTTPClientConfig() HTTPClientConfig {
+	return HTTPClientConfig{
+		Timeout:             30 * time.Second,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     90 * time.Second,
+		HTTP2:               true,
+	}
+}
+
+// NewHTTPClient creates an HTTP client with a pooled transport. Share one
+// client between tracker clients so connections to a tracker are reused.
+func NewHTTPClient(cfg HTTPClientConfig) *http.Client {
+	dial := defaultDial
+	if cfg.Dial != nil {
+		dial = withDialTimeout(cfg.Dial)
+	}
+
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dial,
+		MaxIdleConns:        cfg.MaxIdleConns,
+		MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:     cfg.IdleConnTimeout,
+		TLSHandshakeTimeout: 10 * time.Second,
+		ForceAttemptHTTP2:   cfg.HTTP2,
+	}
+
+	return &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: transport,
+	}
+}
+
+const (
+	dialTimeout   = 10 * time.Second // Opening a tracker connection
+	dialKeepAlive = 30 * time.Second // TCP keep-alive period of the default dialer
+)
+
+// defaultDial dials trackers without binding to a local address
+var defaultDial = (&net.Dialer{
+	Timeout:   dialTimeout,
+	KeepAlive: dialKeepAlive,
+}).DialContext
+
+// withDialTimeout bounds a custom dial by the default dialer's timeout, so
+// a tracker that doesn't answer can't hold up an announce any longer
+func withDialTimeout(dial DialFunc) DialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+		defer cancel()
+		return dial(ctx, network, address)
+	}
+}
+
+var (
+	sharedOnce   sync.Once
+	sharedClient *http.Client
+)
+
+// SharedHTTPClient returns the process-wide client used by tracker clients
+// that weren't given one explicitly
+func SharedHTTPClient() *http.Client {
+	sharedOnce.Do(func() {
+		sharedClient = NewHTTPClient(DefaultHTTPClientConfig())
+	})
+	return sharedClient
+}
+
+// CloseIdleConnections closes idle connections held by the shared client
+func CloseIdleConnections() {
+	SharedHTTPClient().CloseIdleConnections()
+}
@@ -0,0 +1,81 @@
+package tracker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultRetryDelay is used when a tracker asks us to back off without
+// saying for how long
+const DefaultRetryDelay = 60 * time.Second
+
+// RetryError is returned when the tracker is overloaded (HTTP 429/503).
+// It is temporary: the announce should be retried after RetryAfter.
+type RetryError struct {
+	StatusCode int
+	RetryAfter time.Duration // 0 if the tracker didn't send Retry-After
+}
+
+func (e *RetryError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("tracker returned status %d, retry in %s", e.StatusCode, e.RetryAfter)
+	}
+	return fmt.Sprintf("tracker returned status %d", e.StatusCode)
+}
+
+// isRetryStatus reports whether an HTTP status means "try again later"
+func isRetryStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable
+}
+
+// parseRetryAfter parses a Retry-After header, given either as seconds or
+// as an HTTP date
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(header); err == nil && when.After(now) {
+		return when.Sub(now)
+	}
+	return 0
+}
+
+// RetryDelay returns how long to wait before announcing again after an
+// announce returned resp and err, and whether retrying makes sense at all.
+// A successful response yields its interval.
+func RetryDelay(resp *TrackerResponse, err error) (time.Duration, bool) {
+	if err != nil {
+		if errors.Is(err, ErrUnsupportedProtocol) {
+			return 0, false
+		}
+
+		var retryErr *RetryError
+		if errors.As(err, &retryErr) && retryErr.RetryAfter > 0 {
+			return retryErr.RetryAfter, true
+		}
+		return DefaultRetryDelay, true
+	}
+
+	if resp.FailureReason != "" {
+		if resp.RetryNever {
+			return 0, false
+		}
+		if resp.RetryIn > 0 {
+			return resp.RetryIn, true
+		}
+		return DefaultRetryDelay, true
+	}
+
+	return time.Duration(resp.Interval) * time.Second, true
+}
@@ -0,0 +1,343 @@
+package tracker
+
+import (
+	"bittorrentclient/internal/bencode"
+	"bittorrentclient/internal/clock"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultServerInterval is the re-announce interval handed out by Server
+const DefaultServerInterval = 30
+
+// Server is a minimal in-memory HTTP tracker (announce + scrape).
+// It is intended for integration tests and local swarms, not production use.
+type Server struct {
+	mu       sync.RWMutex
+	swarms   map[string]*swarm // key: raw 20-byte info hash
+	interval int
+	peerTTL  time.Duration
+	clock    clock.Clock
+
+	httpServer *http.Server
+	listener   net.Listener
+}
+
+// swarm holds the peers announced for one info hash
+type swarm struct {
+	peers      map[string]*swarmPeer // key: peer ID
+	downloaded int                   // number of "completed" events seen
+}
+
+// swarmPeer is a single peer as seen by the tracker
+type swarmPeer struct {
+	ID       []byte
+	IP       net.IP
+	Port     int
+	Left     int64
+	LastSeen time.Time
+}
+
+// NewServer creates a new tracker server
+func NewServer() *Server {
+	return &Server{
+		swarms:   make(map[string]*swarm),
+		interval: DefaultServerInterval,
+		peerTTL:  2 * DefaultServerInterval * time.Second,
+		clock:    clock.Real,
+	}
+}
+
+// SetClock replaces the clock used to expire peers that stopped announcing
+func (s *Server) SetClock(c clock.Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = c
+}
+
+// SetInterval sets the announce interval (in seconds) returned to clients
+func (s *Server) SetInterval(seconds int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.interval = seconds
+	s.peerTTL = 2 * time.Duration(seconds) * time.Second
+}
+
+// Handler returns the HTTP handler serving /announce and /scrape
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/announce", s.handleAnnounce)
+	mux.HandleFunc("/scrape", s.handleScrape)
+	return mux
+}
+
+// Start listens on addr (e.g. "127.0.0.1:0") and serves in the background
+func (s *Server) Start(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	s.listener = listener
+	s.httpServer = &http.Server{Handler: s.Handler()}
+
+	go s.httpServer.Serve(listener)
+	return nil
+}
+
+// ListenAndServe listens on addr and blocks until the server stops
+func (s *Server) ListenAndServe(addr string) error {
+	s.httpServer = &http.Server{Addr: addr, Handler: s.Handler()}
+	return s.httpServer.ListenAndServe()
+}
+
+// AnnounceURL returns the announce URL for a server started with Start
+func (s *Server) AnnounceURL() string {
+	if s.listener == nil {
+		return ""
+	}
+	return fmt.Sprintf("http://%s/announce", s.listener.Addr().String())
+}
+
+// Close shuts down the server
+func (s *Server) Close() error {
+	if s.httpServer == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.httpServer.Shutdown(ctx)
+}
+
+// handleAnnounce handles an announce request
+func (s *Server) handleAnnounce(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	infoHash := q.Get("info_hash")
+	if len(infoHash) != 20 {
+		s.writeFailure(w, "invalid info_hash")
+		return
+	}
+
+	peerID := q.Get("peer_id")
+	if len(peerID) != 20 {
+		s.writeFailure(w, "invalid peer_id")
+		return
+	}
+
+	port, err := strconv.Atoi(q.Get("port"))
+	if err != nil || port <= 0 || port > 65535 {
+		s.writeFailure(w, "invalid port")
+		return
+	}
+
+	// Without a valid left we can't tell a seeder from a leecher
+	left, err := strconv.ParseInt(q.Get("left"), 10, 64)
+	if err != nil || left < 0 {
+		s.writeFailure(w, "invalid left")
+		return
+	}
+
+	remote := s.remoteIP(r)
+	ip := remote
+	if ipParam := q.Get("ip"); ipParam != "" {
+		if parsed := net.ParseIP(ipParam); parsed != nil {
+			ip = parsed
+		}
+	}
+	if ip == nil {
+		s.writeFailure(w, "could not determine peer IP")
+		return
+	}
+
+	numWant := 50
+	if n, err := strconv.Atoi(q.Get("numwant")); err == nil && n >= 0 {
+		numWant = n
+	}
+
+	event := q.Get("event")
+	compact := q.Get("compact") == "1"
+
+	s.mu.Lock()
+	sw, exists := s.swarms[infoHash]
+	if !exists {
+		// A peer stopping in a swarm we never saw leaves nothing to keep
+		sw = &swarm{peers: make(map[string]*swarmPeer)}
+		if event != "stopped" {
+			s.swarms[infoHash] = sw
+		}
+	}
+
+	s.expirePeers(sw)
+
+	if event == "stopped" {
+		delete(sw.peers, peerID)
+	} else {
+		sw.peers[peerID] = &swarmPeer{
+			ID:       []byte(peerID),
+			IP:       ip,
+			Port:     port,
+			Left:     left,
+			LastSeen: s.clock.Now(),
+		}
+		if event == "completed" {
+			sw.downloaded++
+		}
+	}
+
+	complete, incomplete := sw.counts()
+
+	// Collect peers other than the requester, only IPv4 ones if they go
+	// in the compact format so they all count towards numwant
+	var peers []*swarmPeer
+	for id, p := range sw.peers {
+		if id == peerID || (compact && p.IP.To4() == nil) {
+			continue
+		}
+		if len(peers) >= numWant {
+			break
+		}
+		peers = append(peers, p)
+	}
+	interval := s.interval
+	s.mu.Unlock()
+
+	resp := map[string]interface{}{
+		"interval":   interval,
+		"complete":   complete,
+		"incomplete": incomplete,
+	}
+	if remote != nil {
+		// BEP 24: tell the client the address we see it at
+		if v4 := remote.To4(); v4 != nil {
+			remote = v4
+		}
+		resp["external ip"] = string(remote)
+	}
+
+	if compact {
+		resp["peers"] = encodeCompactPeers(peers)
+	} else {
+		var list []interface{}
+		for _, p := range peers {
+			list = append(list, map[string]interface{}{
+				"peer id": string(p.ID),
+				"ip":      p.IP.String(),
+				"port":    p.Port,
+			})
+		}
+		if list == nil {
+			list = []interface{}{}
+		}
+		resp["peers"] = list
+	}
+
+	s.writeResponse(w, resp)
+}
+
+// handleScrape handles a scrape request
+func (s *Server) handleScrape(w http.ResponseWriter, r *http.Request) {
+	infoHashes := r.URL.Query()["info_hash"]
+
+	s.mu.Lock()
+	files := make(map[string]interface{})
+
+	// With no info_hash given, scrape every torrent we know about
+	if len(infoHashes) == 0 {
+		for infoHash := range s.swarms {
+			infoHashes = append(infoHashes, infoHash)
+		}
+	}
+
+	for _, infoHash := range infoHashes {
+		sw, exists := s.swarms[infoHash]
+		if !exists {
+			continue
+		}
+
+		s.expirePeers(sw)
+		complete, incomplete := sw.counts()
+		files[infoHash] = map[string]interface{}{
+			"complete":   complete,
+			"downloaded": sw.downloaded,
+			"incomplete": incomplete,
+		}
+	}
+	s.mu.Unlock()
+
+	s.writeResponse(w, map[string]interface{}{"files": files})
+}
+
+// expirePeers removes peers that have not announced within the TTL.
+// Caller must hold s.mu.
+func (s *Server) expirePeers(sw *swarm) {
+	cutoff := s.clock.Now().Add(-s.peerTTL)
+	for id, p := range sw.peers {
+		if p.LastSeen.Before(cutoff) {
+			delete(sw.peers, id)
+		}
+	}
+}
+
+// counts returns the number of seeders and leechers in the swarm
+func (sw *swarm) counts() (complete, incomplete int) {
+	for _, p := range sw.peers {
+		if p.Left == 0 {
+			complete++
+		} else {
+			incomplete++
+		}
+	}
+	return complete, incomplete
+}
+
+// remoteIP extracts the IP address of the requesting client
+func (s *Server) remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// writeFailure writes a bencoded failure response
+func (s *Server) writeFailure(w http.ResponseWriter, reason string) {
+	s.writeResponse(w, map[string]interface{}{"failure reason": reason})
+}
+
+// writeResponse bencodes and writes a response dictionary
+func (s *Server) writeResponse(w http.ResponseWriter, resp map[string]interface{}) {
+	body, err := bencode.Encode(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(body)
+}
+
+// encodeCompactPeers encodes IPv4 peers in the 6-byte compact format
+func encodeCompactPeers(peers []*swarmPeer) string {
+	buf := make([]byte, 0, len(peers)*6)
+	for _, p := range peers {
+		ip4 := p.IP.To4()
+		if ip4 == nil {
+			continue // Compact format only carries IPv4
+		}
+
+		entry := make([]byte, 6)
+		copy(entry[0:4], ip4)
+		binary.BigEndian.PutUint16(entry[4:6], uint16(p.Port))
+		buf = append(buf, entry...)
+	}
+	return string(buf)
+}
@@ -0,0 +1,161 @@
+package tracker
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"bittorrentclient/internal/bencode"
+	"bittorrentclient/internal/clock"
+)
+
+var testInfoHash = strings.Repeat("h", 20)
+
+// testServer starts a Server on a fake clock behind an httptest server
+func testServer(t *testing.T) (*httptest.Server, *clock.Fake) {
+	t.Helper()
+	fake := clock.NewFake(time.Unix(1000, 0))
+	s := NewServer()
+	s.SetClock(fake)
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+	return ts, fake
+}
+
+// get requests path with params and decodes the bencoded dictionary answer
+func get(t *testing.T, ts *httptest.Server, path string, params url.Values) map[string]interface{} {
+	t.Helper()
+	resp, err := http.Get(ts.URL + path + "?" + params.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := bencode.Decode(body)
+	if err != nil {
+		t.Fatalf("decoding %q: %v", body, err)
+	}
+	dict, ok := decoded.(map[string]interface{})
+	if !ok {
+		t.Fatalf("response %q is not a dictionary", body)
+	}
+	return dict
+}
+
+// announceParams returns the parameters of an announce by peer n from ip
+func announceParams(infoHash string, n int, ip string, left int64) url.Values {
+	return url.Values{
+		"info_hash": {infoHash},
+		"peer_id":   {fmt.Sprintf("peer-%015d", n)},
+		"port":      {"6881"},
+		"ip":        {ip},
+		"left":      {fmt.Sprint(left)},
+	}
+}
+
+func TestServerAnnounce(t *testing.T) {
+	ts, fake := testServer(t)
+
+	get(t, ts, "/announce", announceParams(testInfoHash, 1, "10.0.0.1", 0))
+	get(t, ts, "/announce", announceParams(testInfoHash, 2, "10.0.0.2", 100))
+	for n := 3; n <= 6; n++ {
+		get(t, ts, "/announce", announceParams(testInfoHash, n, fmt.Sprintf("2001:db8::%d", n), 100))
+	}
+
+	params := announceParams(testInfoHash, 7, "10.0.0.7", 100)
+	params.Set("numwant", "10")
+	resp := get(t, ts, "/announce", params)
+	if resp["complete"] != int64(1) || resp["incomplete"] != int64(6) {
+		t.Fatalf("complete/incomplete = %v/%v, want 1/6", resp["complete"], resp["incomplete"])
+	}
+	if peers := resp["peers"].([]interface{}); len(peers) != 6 {
+		t.Fatalf("got %d peers, want the 6 others", len(peers))
+	}
+
+	// The IPv6 peers can't be sent compact, so they mustn't use up numwant
+	params.Set("compact", "1")
+	params.Set("numwant", "2")
+	var peers string
+	for i := 0; i < 10; i++ {
+		peers = get(t, ts, "/announce", params)["peers"].(string)
+		if len(peers) != 12 {
+			t.Fatalf("compact peers are %d bytes, want the 2 IPv4 peers", len(peers))
+		}
+	}
+	for i := 0; i < len(peers); i += 6 {
+		ip, port := net.IP(peers[i:i+4]), binary.BigEndian.Uint16([]byte(peers[i+4:i+6]))
+		if (!ip.Equal(net.ParseIP("10.0.0.1")) && !ip.Equal(net.ParseIP("10.0.0.2"))) || port != 6881 {
+			t.Fatalf("unexpected compact peer %s:%d", ip, port)
+		}
+	}
+
+	// Peers that stop announcing expire, the one that keeps at it doesn't
+	fake.Advance(2*DefaultServerInterval*time.Second + time.Second)
+	resp = get(t, ts, "/announce", params)
+	if resp["complete"] != int64(0) || resp["incomplete"] != int64(1) || resp["peers"] != "" {
+		t.Fatalf("after expiry got %v seeders, %v leechers and peers %q, want only the announcer",
+			resp["complete"], resp["incomplete"], resp["peers"])
+	}
+}
+
+func TestServerRejectsInvalidLeft(t *testing.T) {
+	ts, _ := testServer(t)
+
+	for _, left := range []string{"", "many", "-1"} {
+		params := announceParams(testInfoHash, 1, "10.0.0.1", 0)
+		if left == "" {
+			params.Del("left")
+		} else {
+			params.Set("left", left)
+		}
+		if resp := get(t, ts, "/announce", params); resp["failure reason"] != "invalid left" {
+			t.Fatalf("left %q: got %v, want an invalid left failure", left, resp)
+		}
+	}
+
+	files := get(t, ts, "/scrape", nil)["files"].(map[string]interface{})
+	if len(files) != 0 {
+		t.Fatalf("scrape lists %d torrents, want none", len(files))
+	}
+}
+
+func TestServerScrape(t *testing.T) {
+	ts, _ := testServer(t)
+
+	get(t, ts, "/announce", announceParams(testInfoHash, 1, "10.0.0.1", 100))
+	completed := announceParams(testInfoHash, 2, "10.0.0.2", 0)
+	completed.Set("event", "completed")
+	get(t, ts, "/announce", completed)
+
+	// Stopping in an unknown swarm mustn't create it
+	stopped := announceParams(strings.Repeat("x", 20), 3, "10.0.0.3", 0)
+	stopped.Set("event", "stopped")
+	get(t, ts, "/announce", stopped)
+
+	files := get(t, ts, "/scrape", nil)["files"].(map[string]interface{})
+	if len(files) != 1 {
+		t.Fatalf("scrape lists %d torrents, want 1", len(files))
+	}
+	file, ok := files[testInfoHash].(map[string]interface{})
+	if !ok {
+		t.Fatalf("scrape is missing the announced torrent: %v", files)
+	}
+	if file["complete"] != int64(1) || file["incomplete"] != int64(1) || file["downloaded"] != int64(1) {
+		t.Fatalf("scrape = %v, want 1 seeder, 1 leecher and 1 download", file)
+	}
+
+	files = get(t, ts, "/scrape", url.Values{"info_hash": {strings.Repeat("x", 20)}})["files"].(map[string]interface{})
+	if len(files) != 0 {
+		t.Fatalf("scrape of an unknown torrent lists %v, want nothing", files)
+	}
+}
@@ -0,0 +1,105 @@
+package tracker
+
+import (
+	"sort"
+	"time"
+
+	"bittorrentclient/internal/clock"
+)
+
+// TrackerStats describes the announce history of one tracker
+type TrackerStats struct {
+	URL          string
+	LastAnnounce time.Time
+	NextAnnounce time.Time // Zero if the tracker asked us never to retry
+	LastError    string    // Error or failure reason of the last announce; empty on success
+	Peers        int       // Peers returned by the last successful announce
+	Seeders      int       // "complete" reported by the last successful announce
+	Leechers     int       // "incomplete" reported by the last successful announce
+	Announces    int       // Total announces attempted
+	Failures     int       // Announces that errored or returned a failure reason
+}
+
+// SetClock replaces the clock used to timestamp announces
+func (tc *TrackerClient) SetClock(c clock.Clock) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.clock = c
+}
+
+// now returns the current time on the client's clock
+func (tc *TrackerClient) now() time.Time {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	return tc.clock.Now()
+}
+
+// GetTrackerStats returns the stats of every tracker announced to, sorted by URL
+func (tc *TrackerClient) GetTrackerStats() []TrackerStats {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+
+	stats := make([]TrackerStats, 0, len(tc.stats))
+	for _, s := range tc.stats {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].URL < stats[j].URL })
+	return stats
+}
+
+// GetTrackerStatsFor returns the stats of one tracker
+func (tc *TrackerClient) GetTrackerStatsFor(announceURL string) (TrackerStats, bool) {
+	key := announceURL
+	if u, err := NormalizeAnnounceURL(announceURL); err == nil {
+		key = u.String()
+	}
+
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+
+	s, ok := tc.stats[key]
+	if !ok {
+		return TrackerStats{}, false
+	}
+	return *s, true
+}
+
+// recordAnnounce updates a tracker's stats with the outcome of an announce
+func (tc *TrackerClient) recordAnnounce(key string, resp *TrackerResponse, err error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if tc.stats == nil {
+		tc.stats = make(map[string]*TrackerStats)
+	}
+	s, ok := tc.stats[key]
+	if !ok {
+		s = &TrackerStats{URL: key}
+		tc.stats[key] = s
+	}
+
+	now := tc.clock.Now()
+	s.LastAnnounce = now
+	s.Announces++
+
+	delay, retry := RetryDelay(resp, err)
+	if retry {
+		s.NextAnnounce = now.Add(delay)
+	} else {
+		s.NextAnnounce = time.Time{}
+	}
+
+	switch {
+	case err != nil:
+		s.LastError = err.Error()
+		s.Failures++
+	case resp.FailureReason != "":
+		s.LastError = resp.FailureReason
+		s.Failures++
+	default:
+		s.LastError = ""
+		s.Peers = len(resp.Peers)
+		s.Seeders = resp.Complete
+		s.Leechers = resp.Incomplete
+	}
+}
@@ -0,0 +1,39 @@
+package tracker
+
+// MergeTiers merges tiered tracker lists from several sources, e.g. a
+// torrent's announce-list and its announce URL, into one. Tier i of every
+// source goes into tier i of the result, in source order. A tracker listed
+// more than once, compared by normalized URL, is kept only where it first
+// appears. URLs that can't be announced to are dropped, and so are tiers
+// left empty.
+func MergeTiers(sources ...[][]string) [][]string {
+	seen := make(map[string]bool)
+	var merged [][]string
+
+	for tier := 0; ; tier++ {
+		more := false
+		var urls []string
+		for _, source := range sources {
+			if tier >= len(source) {
+				continue
+			}
+			more = true
+			for _, raw := range source[tier] {
+				u, err := NormalizeAnnounceURL(raw)
+				if err != nil {
+					continue
+				}
+				if key := u.String(); !seen[key] {
+					seen[key] = true
+					urls = append(urls, key)
+				}
+			}
+		}
+		if !more {
+			return merged
+		}
+		if len(urls) > 0 {
+			merged = append(merged, urls)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package tracker
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrUnsupportedProtocol is returned when no transport handles an announce URL's scheme
+var ErrUnsupportedProtocol = errors.New("unsupported tracker protocol")
+
+// Transport announces to a tracker over one protocol. u has already been
+// validated and normalized by NormalizeAnnounceURL.
+type Transport func(tc *TrackerClient, u *url.URL, req *TrackerRequest) (*TrackerResponse, error)
+
+// knownSchemes are the tracker schemes we recognize, whether or not a
+// transport is registered for them yet
+var knownSchemes = map[string]bool{
+	"http":  true,
+	"https": true,
+	"udp":   true,
+	"wss":   true,
+}
+
+var (
+	transportsMu sync.RWMutex
+	transports   = map[string]Transport{
+		"http":  announceHTTP,
+		"https": announceHTTP,
+	}
+)
+
+// RegisterTransport installs the transport used for a URL scheme, replacing
+// any existing one
+func RegisterTransport(scheme string, t Transport) {
+	scheme = strings.ToLower(scheme)
+
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+
+	knownSchemes[scheme] = true
+	transports[scheme] = t
+}
+
+// transportFor returns the transport for a scheme
+func transportFor(scheme string) (Transport, error) {
+	transportsMu.RLock()
+	defer transportsMu.RUnlock()
+
+	t, ok := transports[scheme]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedProtocol, scheme)
+	}
+	return t, nil
+}
+
+// NormalizeAnnounceURL validates an announce URL and returns it in
+// canonical form: trimmed, with a lowercase scheme and host and without
+// a port that is the scheme's default
+func NormalizeAnnounceURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid announce URL: %v", err)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	transportsMu.RLock()
+	known := knownSchemes[u.Scheme]
+	transportsMu.RUnlock()
+	if !known {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedProtocol, u.Scheme)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if err := validateHost(host); err != nil {
+		return nil, fmt.Errorf("invalid announce URL %q: %v", raw, err)
+	}
+
+	port := u.Port()
+	if port != "" {
+		n, err := strconv.Atoi(port)
+		if err != nil || n <= 0 || n > 65535 {
+			return nil, fmt.Errorf("invalid announce URL %q: bad port %q", raw, port)
+		}
+		if (u.Scheme == "http" && n == 80) || (u.Scheme == "https" && n == 443) {
+			port = ""
+		}
+	}
+	if u.Scheme == "udp" && port == "" {
+		return nil, fmt.Errorf("invalid announce URL %q: udp trackers need a port", raw)
+	}
+
+	if port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	} else {
+		u.Host = host
+	}
+	u.Fragment = ""
+	return u, nil
+}
+
+// validateHost checks that host is an IP address or a syntactically valid
+// DNS name
+func validateHost(host string) error {
+	if host == "" {
+		return fmt.Errorf("missing host")
+	}
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+
+	name := strings.TrimSuffix(host, ".")
+	if len(name) > 253 {
+		return fmt.Errorf("host name too long")
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return fmt.Errorf("invalid host name %q", host)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("invalid host name %q", host)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return fmt.Errorf("invalid host name %q", host)
+			}
+		}
+	}
+	return nil
+}
@@ -1,9 +1,13 @@
 package tracker
 
 import (
-	"fmt"
 	"net"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"bittorrentclient/internal/clock"
 )
 
 // TrackerClient handles communication with BitTorrent trackers
@@ -11,6 +15,12 @@ type TrackerClient struct {
 	httpClient *http.Client
 	peerID     []byte
 	port       int
+
+	mu         sync.RWMutex
+	stats      map[string]*TrackerStats // key: normalized announce URL
+	clock      clock.Clock
+	announceIP string // Default "ip" parameter; AutoAnnounceIP uses externalIP
+	externalIP net.IP // Last address a tracker reported seeing us at
 }
 
 // TrackerRequest represents the parameters sent to the tracker
@@ -24,8 +34,8 @@ type TrackerRequest struct {
 	Compact    bool
 	NoPeerID   bool
 	Event      string // "started", "stopped", "completed", or empty
-	IP         string // Optional
-	NumWant    int    // Optional, defaults to 50
+	IP         string // Optional; the client's announce IP if empty
+	NumWant    int    // Optional, defaults to 50; NoPeers asks for none
 	Key        string // Optional
 	TrackerID  string // Optional
 }
@@ -41,6 +51,10 @@ type TrackerResponse struct {
 	Incomplete     int         `bencode:"incomplete"`
 	Peers          []Peer      `bencode:"-"`     // Parsed peers
 	RawPeers       interface{} `bencode:"peers"` // Raw peers data
+
+	RetryIn    time.Duration `bencode:"-"` // With a failure: when we may retry (BEP 31)
+	RetryNever bool          `bencode:"-"` // With a failure: the tracker will never accept us
+	ExternalIP net.IP        `bencode:"-"` // Address the tracker sees us at (BEP 24), if it says
 }
 
 // Peer represents a peer in the swarm
@@ -52,5 +66,5 @@ type Peer struct {
 
 // String returns a string representation of the peer
 func (p Peer) String() string {
-	return fmt.Sprintf("%s:%d", p.IP.String(), p.Port)
+	return net.JoinHostPort(p.IP.String(), strconv.Itoa(p.Port))
 }
@@ -2,81 +2,906 @@ package main
 
 import (
 	"context"
-	"crypto/rand"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"bittorrentclient/internal/file"
+	"bittorrentclient/internal/ipc"
 	"bittorrentclient/internal/peer"
+	"bittorrentclient/internal/peerid"
 	"bittorrentclient/internal/torrent"
 	"bittorrentclient/internal/tracker"
 )
 
-func generatePeerID() [20]byte {
-	var peerID [20]byte
-	copy(peerID[:8], []byte("-BC0100-"))
-	rand.Read(peerID[8:])
-	return peerID
-}
-
 func main() {
 	//if len(os.Args) < 2 {
 	//	fmt.Println("Usage: go run main.go <torrent-file> [output-directory]")
 	//	os.Exit(1)
 	//}
 
-	torrentFile := "debian.torrent"
-	outputDir := "./downloads/debian_1"
-	if len(os.Args) >= 3 {
-		outputDir = os.Args[2]
+	// "tracker" subcommand runs the embedded tracker for local swarms
+	if len(os.Args) >= 2 && os.Args[1] == "tracker" {
+		runTracker(os.Args[2:])
+		return
+	}
+
+	// "turtle" switches the running instance to or from its alternative
+	// rate limits
+	if len(os.Args) >= 2 && os.Args[1] == "turtle" {
+		runTurtle(os.Args[1:])
+		return
+	}
+
+	// "on-complete" changes what a running torrent does once it completes
+	if len(os.Args) >= 2 && os.Args[1] == "on-complete" {
+		runOnComplete(os.Args[1:])
+		return
+	}
+
+	// "peer-limit" caps one peer of a running torrent
+	if len(os.Args) >= 2 && os.Args[1] == "peer-limit" {
+		runPeerLimit(os.Args[1:])
+		return
+	}
+
+	// "trace-export" saves the running instance's recent wire messages
+	if len(os.Args) >= 2 && os.Args[1] == "trace-export" {
+		runTraceExport(os.Args[1:])
+		return
+	}
+
+	// "capture" records one peer of a running torrent; "replay" parses a
+	// recording offline
+	if len(os.Args) >= 2 && os.Args[1] == "capture" {
+		runCapture(os.Args[1:])
+		return
+	}
+	if len(os.Args) >= 2 && os.Args[1] == "replay" {
+		runReplay(os.Args[2:])
+		return
+	}
+
+	// "mode" switches a running torrent between downloading, uploading
+	// or both
+	if len(os.Args) >= 2 && os.Args[1] == "mode" {
+		runMode(os.Args[1:])
+		return
+	}
+
+	// "priority" changes the download priority of a running torrent's file
+	if len(os.Args) >= 2 && os.Args[1] == "priority" {
+		runPriority(os.Args[1:])
+		return
+	}
+
+	// "export-torrent" writes a running torrent's .torrent file
+	if len(os.Args) >= 2 && os.Args[1] == "export-torrent" {
+		runExportTorrent(os.Args[1:])
+		return
+	}
+
+	// "queue" shows or changes a running torrent's place in the queue
+	if len(os.Args) >= 2 && os.Args[1] == "queue" {
+		runQueue(os.Args[1:])
+		return
+	}
+
+	// "set-location" moves a running torrent's data, or points it at data
+	// that is elsewhere already
+	if len(os.Args) >= 2 && os.Args[1] == "set-location" {
+		runSetLocation(os.Args[1:])
+		return
+	}
+
+	opts, torrentFile, outputDir, err := parseArgs(os.Args[1:])
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	// Reproduce a run: the peer ID comes from the seed too
+	if opts.deterministic {
+		if err := peerid.SetSeed(opts.randSeed); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+	}
+
+	sessionConfig := torrent.DefaultSessionConfig()
+	sessionConfig.Bind = opts.bind
+	sessionConfig.AnnounceIP = opts.announceIP
+	sessionConfig.HostsFile = opts.hostsFile
+	sessionConfig.PeerTimeout = opts.peerTimeout
+	sessionConfig.Inbound = opts.inbound
+	sessionConfig.FDBudget = opts.fdBudget
+	sessionConfig.RateLimits = opts.rateLimits
+	sessionConfig.AltRateLimits = opts.altRateLimits
+	sessionConfig.AltSpeed = opts.turtle
+	sessionConfig.KeepRateLimits = opts.limitsSet
+	sessionConfig.KeepAltSpeed = opts.turtle
+	sessionConfig.StateDir = ipc.DefaultDir()
+	sessionConfig.TraceFile = opts.traceFile
+	sessionConfig.TraceBuffer = opts.traceBuffer
+	sessionConfig.MaxActive = opts.maxActive
+	if opts.exportDir != "" {
+		if sessionConfig.ExportDir, err = filepath.Abs(opts.exportDir); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+	}
+	if sessionConfig.OutputDir, err = filepath.Abs(defaultOutputDir); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	if opts.keepPeerID {
+		sessionConfig.PeerIDFile = filepath.Join(ipc.DefaultDir(), peerIDFileName)
+	}
+	session, err := torrent.NewSession(sessionConfig)
+	if err != nil {
+		log.Fatalf("❌ Failed to create session: %v", err)
+	}
+
+	fmt.Println("🔍 Generating peer ID...")
+	peerID := peerid.Default()
+	fmt.Printf("✅ Peer ID generated: %x\n", peerID[:8])
+
+	// Only one instance runs a session; later invocations hand their
+	// torrent to it rather than competing for the same directories
+	server, err := ipc.Listen(ipc.DefaultDir(), func(req ipc.Request) (string, error) {
+		return handleForwarded(session, peerID, req)
+	})
+	if errors.Is(err, ipc.ErrRunning) {
+		session.Close()
+		forwardToRunning(os.Args[1:])
+		return
+	}
+	if err != nil {
+		log.Fatalf("❌ Failed to start session: %v", err)
+	}
+	defer server.Close()
+
+	session.StartBindWatchdog(torrent.BindCheckInterval)
+	if err := session.Listen(torrent.DefaultListenPort, peerID); err != nil {
+		fmt.Printf("⚠️  Not accepting inbound peers: %v\n", err)
+	}
+	if opts.restore {
+		restoreSession(session, peerID)
+	}
+	if torrentFile != "" {
+		if _, err := addTorrent(session, peerID, torrentFile, outputDir, opts, maxAnnounceAttempts); err != nil && len(session.GetDownloaders()) > 0 {
+			fmt.Printf("⚠️  %v\n", err)
+		} else if err != nil {
+			session.Close()
+			server.Close()
+			log.Fatalf("❌ %v", err)
+		}
+	}
+	if len(session.GetDownloaders()) == 0 {
+		session.Close()
+		server.Close()
+		log.Fatalf("❌ Nothing to download: no torrent could be restored")
+	}
+
+	fmt.Println("\n🔍 STEP 6: Starting download monitoring...")
+	fmt.Println("   📊 Progress will be shown every 5 seconds")
+	fmt.Println("   ➕ Running this command again with another torrent adds it here")
+	fmt.Println("   🛑 Press Ctrl+C to stop")
+	fmt.Println()
+
+	monitor(session)
+}
+
+// forwardToRunning hands this invocation's arguments to the running
+// instance
+func forwardToRunning(args []string) {
+	dir, err := os.Getwd()
+	if err != nil {
+		log.Fatalf("❌ Failed to get working directory: %v", err)
+	}
+
+	fmt.Println("➡️  Another instance is running, handing the torrent to it...")
+	if _, err := ipc.Send(ipc.DefaultDir(), ipc.Request{Args: args, Dir: dir}); err != nil {
+		log.Fatalf("❌ The running instance could not add the torrent: %v", err)
+	}
+	fmt.Println("✅ Torrent added to the running instance")
+}
+
+// handleForwarded adds the torrent from another invocation's arguments to
+// the running session, or runs the session command it names, such as
+// "turtle" or "queue". Paths are resolved against that invocation's
+// working directory.
+func handleForwarded(session *torrent.Session, peerID [20]byte, req ipc.Request) (string, error) {
+	if len(req.Args) >= 1 && req.Args[0] == "turtle" {
+		return handleTurtle(session, req.Args)
+	}
+	if len(req.Args) >= 1 && req.Args[0] == "on-complete" {
+		return handleOnComplete(session, req.Args)
+	}
+	if len(req.Args) >= 1 && req.Args[0] == "peer-limit" {
+		return handlePeerLimit(session, req.Args)
+	}
+	if len(req.Args) >= 1 && req.Args[0] == "trace-export" {
+		return handleTraceExport(session, req)
+	}
+	if len(req.Args) >= 1 && req.Args[0] == "capture" {
+		return handleCapture(session, req)
+	}
+	if len(req.Args) >= 1 && req.Args[0] == "set-location" {
+		return handleSetLocation(session, req)
+	}
+	if len(req.Args) >= 1 && req.Args[0] == "mode" {
+		return handleMode(session, req.Args)
+	}
+	if len(req.Args) >= 1 && req.Args[0] == "priority" {
+		return handlePriority(session, req.Args)
+	}
+	if len(req.Args) >= 1 && req.Args[0] == "export-torrent" {
+		return handleExportTorrent(session, req)
+	}
+	if len(req.Args) >= 1 && req.Args[0] == "queue" {
+		return handleQueue(session, req.Args)
+	}
+
+	opts, torrentFile, outputDir, err := parseArgs(req.Args)
+	if err != nil {
+		return "", err
+	}
+	config := session.GetConfig()
+	if opts.bind != "" && opts.bind != config.Bind {
+		return "", fmt.Errorf("--bind %s differs from the running session's; stop it first to change it", opts.bind)
+	}
+	if opts.announceIP != "" && opts.announceIP != config.AnnounceIP {
+		return "", fmt.Errorf("--announce-ip %s differs from the running session's; stop it first to change it", opts.announceIP)
+	}
+	if opts.hostsFile != "" && opts.hostsFile != config.HostsFile {
+		return "", fmt.Errorf("--hosts-file %s differs from the running session's; stop it first to change it", opts.hostsFile)
+	}
+	if opts.exportDir != "" && !samePath(req.Dir, opts.exportDir, config.ExportDir) {
+		return "", fmt.Errorf("--export-dir %s differs from the running session's; stop it first to change it", opts.exportDir)
+	}
+	if opts.timeoutsSet && session.Dialer().Timeouts() != opts.peerTimeout {
+		return "", fmt.Errorf("peer timeouts differ from the running session's; stop it first to change them")
+	}
+	if opts.restore {
+		return "", fmt.Errorf("--restore only applies when starting a session, and this one is running")
+	}
+	if opts.keepPeerID && config.PeerIDFile == "" {
+		return "", fmt.Errorf("--keep-peer-id needs the running session restarted with it")
+	}
+	if (opts.traceFile != "" || opts.traceBuffer > 0) && session.Trace() == nil {
+		return "", fmt.Errorf("tracing needs the running session restarted with --trace or --trace-buffer")
+	}
+	if opts.limitsSet && (opts.rateLimits != config.RateLimits || opts.altRateLimits != config.AltRateLimits) {
+		return "", fmt.Errorf("rate limits differ from the running session's; stop it first to change them")
+	}
+	if opts.maxActiveSet && opts.maxActive != config.MaxActive {
+		session.SetMaxActive(opts.maxActive)
+		fmt.Printf("⏳ Downloading %s torrents at once\n", formatMaxActive(opts.maxActive))
+	}
+	if opts.turtle && !session.AltSpeed() {
+		session.SetAltSpeed(true)
+		fmt.Println("🐢 Turtle mode on")
+	}
+	if !filepath.IsAbs(torrentFile) {
+		torrentFile = filepath.Join(req.Dir, torrentFile)
+	}
+	if outputDir != "" && !filepath.IsAbs(outputDir) {
+		outputDir = filepath.Join(req.Dir, outputDir)
+	}
+	if opts.orderLog != "" && !filepath.IsAbs(opts.orderLog) {
+		opts.orderLog = filepath.Join(req.Dir, opts.orderLog)
+	}
+	if opts.captureDir != "" && !filepath.IsAbs(opts.captureDir) {
+		opts.captureDir = filepath.Join(req.Dir, opts.captureDir)
+	}
+
+	// The other invocation waits at most ipc.HandleTimeout for the answer,
+	// too short to back off from a busy tracker, so announce once and let
+	// it be run again
+	fmt.Printf("\n➕ Adding %s, forwarded by another invocation\n", torrentFile)
+	_, err = addTorrent(session, peerID, torrentFile, outputDir, opts, 1)
+	return "", err
+}
+
+// parseTurtle parses "turtle [on|off]" into the mode to switch to; without
+// an argument it toggles
+func parseTurtle(args []string) (mode string, err error) {
+	if len(args) < 2 {
+		return "toggle", nil
+	}
+	switch args[1] {
+	case "on", "off":
+		return args[1], nil
+	}
+	return "", fmt.Errorf("usage: turtle [on|off]")
+}
+
+// runTurtle asks the running instance to switch turtle mode
+// Usage: go run main.go turtle [on|off]
+func runTurtle(args []string) {
+	if _, err := parseTurtle(args); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	message, err := ipc.Send(ipc.DefaultDir(), ipc.Request{Args: args})
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	fmt.Println(message)
+}
+
+// handleTurtle switches the session's turtle mode as a forwarded "turtle"
+// command asks and describes the limits now in effect
+func handleTurtle(session *torrent.Session, args []string) (string, error) {
+	mode, err := parseTurtle(args)
+	if err != nil {
+		return "", err
+	}
+
+	var on bool
+	switch mode {
+	case "toggle":
+		on = session.ToggleAltSpeed()
+	default:
+		on = mode == "on"
+		session.SetAltSpeed(on)
+	}
+
+	state := "off"
+	if on {
+		state = "on"
+	}
+	limits := session.ActiveRateLimits()
+	message := fmt.Sprintf("🐢 Turtle mode %s (down: %s, up: %s)", state, formatLimit(limits.Download), formatLimit(limits.Upload))
+	fmt.Println(message)
+	return message, nil
+}
+
+// parseOnComplete parses
+// "on-complete [--seed-ratio R] [--seed-time D] [--hook CMD] <torrent> <action>"
+// into the torrent (its name or info hash) and its new completion policy
+func parseOnComplete(args []string) (query string, policy torrent.CompletionPolicy, err error) {
+	fs := flag.NewFlagSet("on-complete", flag.ContinueOnError)
+	fs.Float64Var(&policy.SeedRatio, "seed-ratio", 0, "with seed, stop at this upload ratio (0 means no limit)")
+	fs.DurationVar(&policy.SeedTime, "seed-time", 0, "with seed, stop after seeding this long (0 means no limit)")
+	fs.StringVar(&policy.Hook, "hook", "", "with hook, the shell command to run")
+	if len(args) >= 1 {
+		args = args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return "", policy, err
+	}
+	if fs.NArg() != 2 {
+		return "", policy, fmt.Errorf("usage: on-complete [--seed-ratio R] [--seed-time D] [--hook CMD] <torrent> <stop|seed|seed-forever|hook|remove|shutdown>")
+	}
+	if policy.Action, err = torrent.ParseCompletionAction(fs.Arg(1)); err != nil {
+		return "", policy, err
+	}
+	return fs.Arg(0), policy, policy.Validate()
+}
+
+// runOnComplete asks the running instance to change what a torrent does
+// once it completes
+// Usage: go run main.go on-complete [flags] <torrent> <action>
+func runOnComplete(args []string) {
+	if _, _, err := parseOnComplete(args); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	message, err := ipc.Send(ipc.DefaultDir(), ipc.Request{Args: args})
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	fmt.Println(message)
+}
+
+// handleOnComplete changes a torrent's completion policy as a forwarded
+// "on-complete" command asks
+func handleOnComplete(session *torrent.Session, args []string) (string, error) {
+	query, policy, err := parseOnComplete(args)
+	if err != nil {
+		return "", err
+	}
+	downloader, err := session.Find(query)
+	if err != nil {
+		return "", err
+	}
+	if err := downloader.SetCompletion(policy); err != nil {
+		return "", err
+	}
+
+	message := fmt.Sprintf("✅ %s will %s once complete", downloader.GetTorrent().Info.Name, policy.Action)
+	fmt.Println(message)
+	return message, nil
+}
+
+// parsePeerLimit parses "peer-limit <torrent> <peer-id> <down-KB/s> <up-KB/s>"
+// into the torrent (its name or info hash), the peer ID in hex and its caps
+// in bytes per second
+func parsePeerLimit(args []string) (query, peerKey string, download, upload int64, err error) {
+	if len(args) != 5 {
+		return "", "", 0, 0, fmt.Errorf("usage: peer-limit <torrent> <peer-id> <down-KB/s> <up-KB/s> (0 means unlimited)")
+	}
+	if download, err = strconv.ParseInt(args[3], 10, 64); err != nil {
+		return "", "", 0, 0, fmt.Errorf("invalid download limit %q", args[3])
+	}
+	if upload, err = strconv.ParseInt(args[4], 10, 64); err != nil {
+		return "", "", 0, 0, fmt.Errorf("invalid upload limit %q", args[4])
+	}
+	return args[1], strings.ToLower(args[2]), download * 1024, upload * 1024, nil
+}
+
+// runPeerLimit asks the running instance to cap one peer of a torrent
+// Usage: go run main.go peer-limit <torrent> <peer-id> <down-KB/s> <up-KB/s>
+func runPeerLimit(args []string) {
+	if _, _, _, _, err := parsePeerLimit(args); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	message, err := ipc.Send(ipc.DefaultDir(), ipc.Request{Args: args})
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	fmt.Println(message)
+}
+
+// handlePeerLimit caps a peer as a forwarded "peer-limit" command asks
+func handlePeerLimit(session *torrent.Session, args []string) (string, error) {
+	query, peerKey, download, upload, err := parsePeerLimit(args)
+	if err != nil {
+		return "", err
+	}
+	downloader, err := session.Find(query)
+	if err != nil {
+		return "", err
+	}
+	if err := downloader.SetPeerRateLimits(peerKey, download, upload); err != nil {
+		return "", err
+	}
+
+	message := fmt.Sprintf("🚦 Peer %s of %s capped (down: %s, up: %s)", peerKey, downloader.GetTorrent().Info.Name, formatLimit(download), formatLimit(upload))
+	fmt.Println(message)
+	return message, nil
+}
+
+// parseMode parses "mode <torrent> <normal|no-seed|seed-only>" into the
+// torrent (its name or info hash) and its new transfer mode
+func parseMode(args []string) (query string, mode torrent.TransferMode, err error) {
+	if len(args) != 3 {
+		return "", 0, fmt.Errorf("usage: mode <torrent> <normal|no-seed|seed-only>")
+	}
+	mode, err = torrent.ParseTransferMode(args[2])
+	return args[1], mode, err
+}
+
+// runMode asks the running instance to switch a torrent's transfer mode
+// Usage: go run main.go mode <torrent> <normal|no-seed|seed-only>
+func runMode(args []string) {
+	if _, _, err := parseMode(args); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	message, err := ipc.Send(ipc.DefaultDir(), ipc.Request{Args: args})
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	fmt.Println(message)
+}
+
+// handleMode switches a torrent's transfer mode as a forwarded "mode"
+// command asks
+func handleMode(session *torrent.Session, args []string) (string, error) {
+	query, mode, err := parseMode(args)
+	if err != nil {
+		return "", err
+	}
+	downloader, err := session.Find(query)
+	if err != nil {
+		return "", err
+	}
+	if err := downloader.SetTransferMode(mode); err != nil {
+		return "", err
+	}
+
+	message := fmt.Sprintf("🔀 %s is now in %s mode", downloader.GetTorrent().Info.Name, mode)
+	fmt.Println(message)
+	return message, nil
+}
+
+// parsePriority parses "priority <torrent> <file> <skip|low|normal|high>"
+// into the torrent (its name or info hash), the file's path and its new
+// priority
+func parsePriority(args []string) (query, path string, priority file.Priority, err error) {
+	if len(args) != 4 {
+		return "", "", 0, fmt.Errorf("usage: priority <torrent> <file> <skip|low|normal|high>")
+	}
+	priority, err = file.ParsePriority(args[3])
+	return args[1], args[2], priority, err
+}
+
+// runPriority asks the running instance to change a file's priority
+// Usage: go run main.go priority <torrent> <file> <skip|low|normal|high>
+func runPriority(args []string) {
+	if _, _, _, err := parsePriority(args); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	message, err := ipc.Send(ipc.DefaultDir(), ipc.Request{Args: args})
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	fmt.Println(message)
+}
+
+// handlePriority changes a file's priority as a forwarded "priority"
+// command asks
+func handlePriority(session *torrent.Session, args []string) (string, error) {
+	query, path, priority, err := parsePriority(args)
+	if err != nil {
+		return "", err
+	}
+	downloader, err := session.Find(query)
+	if err != nil {
+		return "", err
+	}
+	if err := downloader.SetFilePriorityByPath(path, priority); err != nil {
+		return "", err
+	}
+
+	message := fmt.Sprintf("📊 %s of %s now has %s priority", path, downloader.GetTorrent().Info.Name, priority)
+	fmt.Println(message)
+	return message, nil
+}
+
+// runTraceExport asks the running instance to save the wire messages in
+// its trace buffer
+// Usage: go run main.go trace-export <file>
+func runTraceExport(args []string) {
+	if len(args) != 2 {
+		log.Fatalf("❌ usage: trace-export <file>")
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		log.Fatalf("❌ Failed to get working directory: %v", err)
+	}
+	message, err := ipc.Send(ipc.DefaultDir(), ipc.Request{Args: args, Dir: dir})
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	fmt.Println(message)
+}
+
+// handleTraceExport writes the session's trace buffer as a forwarded
+// "trace-export" command asks
+func handleTraceExport(session *torrent.Session, req ipc.Request) (string, error) {
+	if len(req.Args) != 2 {
+		return "", fmt.Errorf("usage: trace-export <file>")
+	}
+	path := req.Args[1]
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(req.Dir, path)
+	}
+	if err := session.ExportTrace(path); err != nil {
+		return "", err
+	}
+
+	message := fmt.Sprintf("🔬 Wire trace written to %s", path)
+	fmt.Println(message)
+	return message, nil
+}
+
+// runCapture asks the running instance to record one peer's raw traffic
+// Usage: go run main.go capture <torrent> <peer-id> <file>
+func runCapture(args []string) {
+	if len(args) != 4 {
+		log.Fatalf("❌ usage: capture <torrent> <peer-id> <file>")
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		log.Fatalf("❌ Failed to get working directory: %v", err)
+	}
+	message, err := ipc.Send(ipc.DefaultDir(), ipc.Request{Args: args, Dir: dir})
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	fmt.Println(message)
+}
+
+// handleCapture starts recording a peer as a forwarded "capture" command
+// asks
+func handleCapture(session *torrent.Session, req ipc.Request) (string, error) {
+	if len(req.Args) != 4 {
+		return "", fmt.Errorf("usage: capture <torrent> <peer-id> <file>")
+	}
+	downloader, err := session.Find(req.Args[1])
+	if err != nil {
+		return "", err
+	}
+	peerKey, path := strings.ToLower(req.Args[2]), req.Args[3]
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(req.Dir, path)
+	}
+	if err := downloader.CapturePeer(peerKey, path); err != nil {
+		return "", err
+	}
+
+	message := fmt.Sprintf("📼 Capturing peer %s of %s to %s", peerKey, downloader.GetTorrent().Info.Name, path)
+	fmt.Println(message)
+	return message, nil
+}
+
+// parseQueue parses "queue <torrent> [up|down|top|bottom]" into the torrent
+// (its name or info hash) and where to move it, "" to only show its place
+func parseQueue(args []string) (query, move string, err error) {
+	if len(args) == 2 {
+		return args[1], "", nil
+	}
+	if len(args) == 3 {
+		switch args[2] {
+		case "up", "down", "top", "bottom":
+			return args[1], args[2], nil
+		}
+	}
+	return "", "", fmt.Errorf("usage: queue <torrent> [up|down|top|bottom]")
+}
+
+// runQueue asks the running instance to show or change a torrent's place
+// in the queue
+// Usage: go run main.go queue <torrent> [up|down|top|bottom]
+func runQueue(args []string) {
+	if _, _, err := parseQueue(args); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	message, err := ipc.Send(ipc.DefaultDir(), ipc.Request{Args: args})
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	fmt.Println(message)
+}
+
+// handleQueue moves a torrent in the queue as a forwarded "queue" command
+// asks, and reports where it is
+func handleQueue(session *torrent.Session, args []string) (string, error) {
+	query, move, err := parseQueue(args)
+	if err != nil {
+		return "", err
+	}
+	downloader, err := session.Find(query)
+	if err != nil {
+		return "", err
+	}
+	switch move {
+	case "up":
+		err = session.MoveUp(downloader)
+	case "down":
+		err = session.MoveDown(downloader)
+	case "top":
+		err = session.MoveTop(downloader)
+	case "bottom":
+		err = session.MoveBottom(downloader)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	message := fmt.Sprintf("📋 %s is number %d of %d in the queue", downloader.GetTorrent().Info.Name,
+		session.QueuePosition(downloader)+1, len(session.GetDownloaders()))
+	if downloader.IsQueued() {
+		message += ", waiting for a download slot"
+	}
+	fmt.Println(message)
+	return message, nil
+}
+
+// formatMaxActive formats an active torrent limit for display
+func formatMaxActive(n int) string {
+	if n <= 0 {
+		return "any number of"
+	}
+	return strconv.Itoa(n)
+}
+
+// runExportTorrent asks the running instance to write a torrent's .torrent
+// file, e.g. to keep metadata it fetched from peers
+// Usage: go run main.go export-torrent <torrent> <dir>
+func runExportTorrent(args []string) {
+	if len(args) != 3 {
+		log.Fatalf("❌ usage: export-torrent <torrent> <dir>")
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		log.Fatalf("❌ Failed to get working directory: %v", err)
+	}
+	message, err := ipc.Send(ipc.DefaultDir(), ipc.Request{Args: args, Dir: dir})
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	fmt.Println(message)
+}
+
+// handleExportTorrent writes a torrent's .torrent file as a forwarded
+// "export-torrent" command asks
+func handleExportTorrent(session *torrent.Session, req ipc.Request) (string, error) {
+	if len(req.Args) != 3 {
+		return "", fmt.Errorf("usage: export-torrent <torrent> <dir>")
+	}
+	downloader, err := session.Find(req.Args[1])
+	if err != nil {
+		return "", err
+	}
+	dir := req.Args[2]
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(req.Dir, dir)
+	}
+	path, err := downloader.GetTorrent().Export(dir)
+	if err != nil {
+		return "", err
+	}
+
+	message := fmt.Sprintf("📄 Saved %s", path)
+	fmt.Println(message)
+	return message, nil
+}
+
+// samePath returns true if path, relative to dir unless absolute, is
+// absPath
+func samePath(dir, path, absPath string) bool {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	return filepath.Clean(path) == filepath.Clean(absPath)
+}
+
+// runSetLocation asks the running instance to move a torrent's data into
+// another directory, or with --existing to use the data already there
+// Usage: go run main.go set-location <torrent> <dir> [--existing]
+func runSetLocation(args []string) {
+	if len(args) != 3 && (len(args) != 4 || args[3] != "--existing") {
+		log.Fatalf("❌ usage: set-location <torrent> <dir> [--existing]")
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		log.Fatalf("❌ Failed to get working directory: %v", err)
+	}
+	message, err := ipc.Send(ipc.DefaultDir(), ipc.Request{Args: args, Dir: dir})
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	fmt.Println(message)
+}
+
+// handleSetLocation relocates a torrent's data as a forwarded
+// "set-location" command asks
+func handleSetLocation(session *torrent.Session, req ipc.Request) (string, error) {
+	if len(req.Args) != 3 && (len(req.Args) != 4 || req.Args[3] != "--existing") {
+		return "", fmt.Errorf("usage: set-location <torrent> <dir> [--existing]")
+	}
+	downloader, err := session.Find(req.Args[1])
+	if err != nil {
+		return "", err
+	}
+	dir := req.Args[2]
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(req.Dir, dir)
+	}
+	move := len(req.Args) == 3
+	if err := downloader.SetLocation(dir, move); err != nil {
+		return "", err
+	}
+
+	message := fmt.Sprintf("📦 %s now uses the data in %s", downloader.GetTorrent().Info.Name, dir)
+	if move {
+		message = fmt.Sprintf("📦 Moved %s into %s", downloader.GetTorrent().Info.Name, dir)
+	}
+	fmt.Println(message)
+	return message, nil
+}
+
+// runReplay parses a capture file offline the way a connection would have,
+// printing its notes and each direction's messages, then replays what the
+// peer sent through a connection
+// Usage: go run main.go replay <file>
+func runReplay(args []string) {
+	if len(args) != 1 {
+		log.Fatalf("❌ usage: replay <capture-file>")
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	defer f.Close()
+
+	records, err := peer.ReadCapture(f)
+	if err != nil {
+		fmt.Printf("⚠️  %v\n", err)
+	}
+	for _, record := range records {
+		if record.Kind == peer.CaptureNote {
+			fmt.Printf("# %s %s\n", record.Time.Format(time.RFC3339Nano), record.Data)
+		}
+	}
+	for _, dir := range []struct {
+		kind byte
+		name string
+	}{{peer.CaptureReceived, "received"}, {peer.CaptureSent, "sent"}} {
+		messages, err := peer.ReplayMessages(records, dir.kind)
+		fmt.Printf("\n%s: %d messages\n", dir.name, len(messages))
+		for i, msg := range messages {
+			if msg == nil {
+				fmt.Printf("  %d keep_alive\n", i)
+				continue
+			}
+			fmt.Printf("  %d %s (%d bytes)\n", i, peer.MessageName(msg.ID), len(msg.Payload))
+		}
+		if err != nil {
+			fmt.Printf("  ❌ %v\n", err)
+		}
 	}
 
+	// Then feed what the peer sent through a connection, as it was handled live
+	fmt.Println("\nreplaying received messages through a connection...")
+	result, err := peer.ReplayCapture(records, nil)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	fmt.Printf("  delivered %d blocks, sent %d messages back, choked=%v, %d bytes unhandled\n",
+		len(result.Pieces), len(result.Sent), result.Conn.IsChoked(), result.Unread)
+	if result.Err != nil {
+		fmt.Printf("  ❌ %v\n", result.Err)
+	}
+}
+
+// formatLimit formats a rate limit for display
+func formatLimit(bytesPerSecond int64) string {
+	if bytesPerSecond <= 0 {
+		return "unlimited"
+	}
+	return formatBytes(bytesPerSecond) + "/s"
+}
+
+// addTorrent parses a torrent, announces it, trying up to announceAttempts
+// times, and starts downloading it in the session. Without an output
+// directory it goes where it went last time, or to the session default.
+func addTorrent(session *torrent.Session, peerID [20]byte, torrentFile, outputDir string, opts downloadOptions,
+	announceAttempts int) (*torrent.Downloader, error) {
 	fmt.Println("🔍 STEP 1: Parsing torrent file...")
 	t, err := torrent.Open(torrentFile)
 	if err != nil {
-		log.Fatalf("❌ Failed to parse torrent: %v", err)
+		return nil, fmt.Errorf("failed to parse torrent: %w", err)
 	}
 	fmt.Printf("✅ Torrent parsed successfully\n")
+	for _, warning := range t.Warnings {
+		fmt.Printf("⚠️  %s\n", warning)
+	}
 	fmt.Printf("   📁 Name: %s\n", t.Info.Name)
 	fmt.Printf("   💾 Size: %s\n", formatBytes(t.Info.GetTotalLength()))
-	fmt.Printf("   🧩 Pieces: %d\n", len(t.Info.Pieces))
+	fmt.Printf("   🧩 Pieces: %d\n", t.Info.NumPieces())
 	fmt.Printf("    Announce URL: %s\n", t.Announce)
+	if _, err := session.Find(t.InfoHash.String()); err == nil {
+		return nil, fmt.Errorf("%s is already in the session", t.Info.Name)
+	}
 
+	outputDir = session.OutputDirFor(t, outputDir)
 	fmt.Println("\n🔍 STEP 2: Creating output directory...")
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		log.Fatalf("❌ Failed to create output directory: %v", err)
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
 	}
 	fmt.Printf("✅ Output directory ready: %s\n", outputDir)
 
-	fmt.Println("\n🔍 STEP 3: Generating peer ID...")
-	peerID := generatePeerID()
-	fmt.Printf("✅ Peer ID generated: %x\n", peerID[:8])
-
-	fmt.Println("\n🔍 STEP 4: Contacting tracker...")
-	client := tracker.NewTrackerClient(6881)
+	fmt.Println("\n🔍 STEP 3: Contacting tracker...")
+	client := session.NewTrackerClient(session.ListenPort())
 
-	req := &tracker.TrackerRequest{
-		InfoHash:   t.InfoHash[:],
-		PeerID:     peerID[:],
-		Port:       6881,
-		Uploaded:   0,
-		Downloaded: 0,
-		Left:       t.Info.GetTotalLength(),
-		Compact:    true,
-		Event:      "started",
-		NumWant:    10, // Reduced for debugging
-	}
+	req := newAnnounce(session, client, t, peerID, "started")
+	req.NumWant = 10 // Reduced for debugging
 
-	resp, err := client.Announce(t.Announce, req)
+	resp, err := announceWithRetry(client, t.Announce, req, announceAttempts)
 	if err != nil {
-		log.Fatalf("❌ Failed to get peers from tracker: %v", err)
+		return nil, err
 	}
+	otherSwarms := announceOtherSwarms(client, t, req)
 
-	if len(resp.Peers) == 0 {
-		log.Fatalf("❌ No peers available from tracker")
+	if len(resp.Peers) == 0 && len(otherSwarms) == 0 {
+		return nil, fmt.Errorf("no peers available from tracker")
 	}
 
 	fmt.Printf("✅ Got %d peers from tracker\n", len(resp.Peers))
@@ -84,12 +909,283 @@ func main() {
 		fmt.Printf("   Peer %d: %s:%d\n", i+1, p.IP, p.Port)
 	}
 
-	fmt.Println("\n🔍 STEP 5: Creating downloader...")
-	downloader := torrent.NewDownloader(t, outputDir)
-	downloader.Start()
+	fmt.Println("\n🔍 STEP 4: Creating downloader...")
+	var torrentOpts []torrent.Option
+	if opts.seed {
+		torrentOpts = append(torrentOpts, torrent.WithSeedMode())
+	}
+	if opts.incomplete {
+		torrentOpts = append(torrentOpts, torrent.WithIncompleteDir())
+	}
+	if opts.verifyReads {
+		torrentOpts = append(torrentOpts, torrent.WithVerifyOnRead())
+	}
+	torrentOpts = append(torrentOpts, torrent.WithVerifyRate(opts.verifyRate))
+	if opts.orderLog != "" {
+		torrentOpts = append(torrentOpts, torrent.WithOrderLog(opts.orderLog))
+	}
+	if opts.captureDir != "" {
+		torrentOpts = append(torrentOpts, torrent.WithCapture(opts.captureDir))
+	}
+	if opts.allowlist != nil {
+		torrentOpts = append(torrentOpts, torrent.WithPeerAllowlist(opts.allowlist))
+		fmt.Printf("🔒 Only peers in %s\n", opts.allowlist)
+	}
+	if opts.deterministic {
+		torrentOpts = append(torrentOpts, torrent.WithDeterministic(opts.randSeed))
+	}
+	torrentOpts = append(torrentOpts, torrent.WithAllocation(opts.allocation), torrent.WithStallTimeout(opts.stallTimeout),
+		torrent.WithCompletion(opts.completion), torrent.WithRecheck(opts.recheck), torrent.WithTransferMode(opts.mode))
+	downloader := session.AddTorrent(t, outputDir, torrentOpts...)
+	collectOrphans(session, outputDir, opts.gcAfter)
+	for _, rename := range opts.renames {
+		if err := downloader.Rename(rename.from, rename.to); err != nil {
+			session.RemoveTorrent(downloader)
+			return nil, fmt.Errorf("invalid rename: %w", err)
+		}
+	}
+	if len(opts.selections) > 0 {
+		if err := downloader.Select(opts.selections); err != nil {
+			session.RemoveTorrent(downloader)
+			return nil, fmt.Errorf("invalid selection: %w", err)
+		}
+		fmt.Printf("✅ Downloading %d selected file(s)/range(s) only\n", len(opts.selections))
+	}
+	for _, fp := range opts.priorities {
+		if err := downloader.SetFilePriorityByPath(fp.path, fp.priority); err != nil {
+			session.RemoveTorrent(downloader)
+			return nil, fmt.Errorf("invalid priority: %w", err)
+		}
+	}
+	if err := joinSwarm(session, client, downloader, peerID, resp, otherSwarms); err != nil {
+		return nil, err
+	}
+	return downloader, nil
+}
+
+// restoreSession restores the torrents of the session saved when the last
+// run exited and starts them, each announcing afresh
+func restoreSession(session *torrent.Session, peerID [20]byte) {
+	downloaders, err := session.LoadAll()
+	if err != nil {
+		fmt.Printf("⚠️  %v\n", err)
+	}
+
+	client := session.NewTrackerClient(session.ListenPort())
+	for _, downloader := range downloaders {
+		t := downloader.GetTorrent()
+		fmt.Printf("\n♻️  Restoring %s into %s\n", t.Info.Name, downloader.GetOutputDir())
+
+		req := buildAnnounce(session, client, downloader, peerID, "started")
+		resp, err := announceWithRetry(client, t.Announce, req, maxAnnounceAttempts)
+		if err != nil {
+			session.RemoveTorrent(downloader)
+			fmt.Printf("⚠️  Could not restore %s: %v\n", t.Info.Name, err)
+			continue
+		}
+		if err := joinSwarm(session, client, downloader, peerID, resp, announceOtherSwarms(client, t, req)); err != nil {
+			fmt.Printf("⚠️  Could not restore %s: %v\n", t.Info.Name, err)
+		}
+	}
+}
+
+// joinSwarm starts a torrent the tracker answered for, keeps announcing
+// it and connects to its peers, removing it from the session if that
+// fails
+func joinSwarm(session *torrent.Session, client *tracker.TrackerClient, downloader *torrent.Downloader, peerID [20]byte,
+	resp *tracker.TrackerResponse, otherSwarms map[torrent.InfoHash][]tracker.Peer) error {
+	downloader.SetTrackerCounts(resp.Complete, resp.Incomplete)
+	if err := downloader.Start(); err != nil {
+		session.RemoveTorrent(downloader)
+		return fmt.Errorf("failed to start download: %w", err)
+	}
 	fmt.Printf("✅ Downloader created and started\n")
 
-	fmt.Println("\n🔍 STEP 6: Connecting to peers (PARALLEL)...")
+	// When no data arrives for a while despite peers, ask the tracker
+	// for fresh ones to replace those the downloader dropped
+	downloader.OnStall(func(event torrent.StallEvent) {
+		reannounce(session, client, downloader, peerID)
+	})
+	go announceLoop(session, client, downloader, peerID, resp)
+
+	fmt.Println("\n🔍 STEP 5: Connecting to peers (PARALLEL)...")
+
+	// Feed the tracker's peers into the pool, which dedupes them and
+	// hands them back best-first
+	pool := downloader.GetPeerPool()
+	for _, p := range resp.Peers {
+		pool.Add(p.String(), peer.SourceTracker)
+	}
+	addSwarmPeers(downloader, otherSwarms)
+
+	connectedPeers := connectPeers(session, downloader, peerID)
+	if connectedPeers == 0 {
+		session.RemoveTorrent(downloader)
+		return fmt.Errorf("could not connect to any peers. Try a different network or VPN")
+	}
+
+	fmt.Printf("✅ Connected to %d peers successfully\n", connectedPeers)
+	return nil
+}
+
+// announceLoop keeps the tracker up to date with what we have uploaded and
+// downloaded in each of the torrent's swarms: it announces again at the
+// interval the tracker asked for, sends "completed" when the download
+// completes and "stopped" once the torrent is finished or stopped. It gives
+// up if the tracker says retrying is pointless.
+func announceLoop(session *torrent.Session, client *tracker.TrackerClient, downloader *torrent.Downloader, peerID [20]byte,
+	resp *tracker.TrackerResponse) {
+	t := downloader.GetTorrent()
+	wasComplete := downloader.IsComplete()
+
+	announce := func(event string) (time.Duration, bool) {
+		req := buildAnnounce(session, client, downloader, peerID, event)
+		resp, err := client.Announce(t.Announce, req)
+		if err != nil {
+			fmt.Printf("⚠️  Announce failed for %s: %v\n", t.Info.Name, err)
+		} else if resp.FailureReason != "" {
+			fmt.Printf("⚠️  Announce failed for %s: %s\n", t.Info.Name, resp.FailureReason)
+		} else {
+			downloader.SetTrackerCounts(resp.Complete, resp.Incomplete)
+		}
+		swarms := announceOtherSwarms(client, t, req)
+		if event != "stopped" {
+			addSwarmPeers(downloader, swarms)
+		}
+		return tracker.RetryDelay(resp, err)
+	}
+
+	interval, _ := tracker.RetryDelay(resp, nil)
+	if interval < minAnnounceInterval {
+		interval = minAnnounceInterval
+	}
+	clk := downloader.Clock()
+	next := clk.After(interval)
+
+	downloaded := make(chan struct{})
+	go func() {
+		downloader.WaitForCompletion()
+		close(downloaded)
+	}()
+
+	for {
+		retry := true
+		select {
+		case <-next:
+			interval, retry = announce("")
+		case <-downloaded:
+			downloaded = nil
+			if !downloader.IsComplete() {
+				announce("stopped") // Stopped or errored before completing
+				return
+			}
+			if wasComplete {
+				continue // Seeding from the start; the tracker knows
+			}
+			interval, retry = announce("completed")
+		case <-downloader.Finished():
+			announce("stopped")
+			return
+		}
+		if !retry {
+			fmt.Printf("⚠️  Giving up announcing %s: the tracker won't take it\n", t.Info.Name)
+			return
+		}
+		if interval < minAnnounceInterval {
+			interval = minAnnounceInterval
+		}
+		next = clk.After(interval)
+	}
+}
+
+// newAnnounce builds t's announce for event ("" for a regular one) in its
+// first swarm, as if nothing were transferred yet. It tells the tracker
+// where we accept peers: the session's listen port and the client's
+// announce IP.
+func newAnnounce(session *torrent.Session, client *tracker.TrackerClient, t *torrent.Torrent, peerID [20]byte, event string) *tracker.TrackerRequest {
+	return &tracker.TrackerRequest{
+		InfoHash: t.InfoHash[:],
+		PeerID:   peerID[:],
+		Port:     session.ListenPort(),
+		IP:       client.AnnounceIP(),
+		Left:     t.Info.GetTotalLength(),
+		Compact:  true,
+		Event:    event,
+	}
+}
+
+// buildAnnounce builds the downloader's announce for event like
+// newAnnounce, with what it transferred and how many peers it wants.
+// announceOtherSwarms sends it to the other swarms of a hybrid.
+func buildAnnounce(session *torrent.Session, client *tracker.TrackerClient, downloader *torrent.Downloader, peerID [20]byte,
+	event string) *tracker.TrackerRequest {
+	req := newAnnounce(session, client, downloader.GetTorrent(), peerID, event)
+	req.Uploaded, req.Downloaded, req.Left = downloader.AnnounceStats()
+	req.NumWant = downloader.NumWant()
+	return req
+}
+
+// reannounce asks the tracker for more peers for a stalled download and
+// connects to the best known ones
+func reannounce(session *torrent.Session, client *tracker.TrackerClient, downloader *torrent.Downloader, peerID [20]byte) {
+	t := downloader.GetTorrent()
+	fmt.Printf("\n⚠️  %s stalled, re-announcing for fresh peers...\n", t.Info.Name)
+
+	req := buildAnnounce(session, client, downloader, peerID, "")
+	resp, err := client.Announce(t.Announce, req)
+	addSwarmPeers(downloader, announceOtherSwarms(client, t, req))
+	if err != nil {
+		fmt.Printf("⚠️  Re-announce failed: %v\n", err)
+	} else if resp.FailureReason != "" {
+		fmt.Printf("⚠️  Re-announce failed: %s\n", resp.FailureReason)
+	} else {
+		downloader.SetTrackerCounts(resp.Complete, resp.Incomplete)
+		for _, p := range resp.Peers {
+			downloader.GetPeerPool().Add(p.String(), peer.SourceTracker)
+		}
+	}
+
+	connected := connectPeers(session, downloader, peerID)
+	fmt.Printf("✅ Connected to %d new peers for %s\n", connected, t.Info.Name)
+}
+
+// announceOtherSwarms announces a hybrid torrent in its v2 swarm too, under
+// the truncated v2 info hash, with req's other parameters. It returns the
+// peers found, by swarm hash; failures are only reported.
+func announceOtherSwarms(client *tracker.TrackerClient, t *torrent.Torrent, req *tracker.TrackerRequest) map[torrent.InfoHash][]tracker.Peer {
+	found := make(map[torrent.InfoHash][]tracker.Peer)
+	for _, infoHash := range t.SwarmHashes()[1:] {
+		swarmReq := *req
+		swarmReq.InfoHash = infoHash[:]
+		resp, err := client.Announce(t.Announce, &swarmReq)
+		if err == nil && resp.FailureReason != "" {
+			err = errors.New(resp.FailureReason)
+		}
+		if err != nil {
+			fmt.Printf("⚠️  Announce in swarm %s failed: %v\n", infoHash, err)
+			continue
+		}
+		fmt.Printf("✅ Got %d peers from swarm %s\n", len(resp.Peers), infoHash)
+		found[infoHash] = resp.Peers
+	}
+	return found
+}
+
+// addSwarmPeers feeds peers from announceOtherSwarms into the downloader's
+// pool, remembering which swarm each came from
+func addSwarmPeers(downloader *torrent.Downloader, swarms map[torrent.InfoHash][]tracker.Peer) {
+	for infoHash, peers := range swarms {
+		for _, p := range peers {
+			downloader.AddSwarmPeer(p.String(), infoHash, peer.SourceTracker)
+		}
+	}
+}
+
+// connectPeers dials the torrent's best known peers in parallel and adds
+// those that connect to the downloader, returning how many it added
+func connectPeers(session *torrent.Session, downloader *torrent.Downloader, peerID [20]byte) int {
+	pool := downloader.GetPeerPool()
+	peersToTry := pool.Next(50)
 
 	// Connection result channel
 	type connResult struct {
@@ -98,36 +1194,27 @@ func main() {
 		err  error
 	}
 
-	resultChan := make(chan connResult, len(resp.Peers))
+	resultChan := make(chan connResult, len(peersToTry))
 	maxPeers := 5   // Max peers we want to connect to
 	batchSize := 15 // Try 15 peers at once
-	timeout := 10 * time.Second
-
-	// Try peers in batches
-	peersToTry := resp.Peers
-	if len(peersToTry) > 50 {
-		peersToTry = peersToTry[:50]
-	}
+	timeout := session.Dialer().Timeouts().Connect
 
 	fmt.Printf("   🚀 Attempting %d peers in parallel (timeout: %v)...\n", min(batchSize, len(peersToTry)), timeout)
 
 	// Launch parallel connection attempts
 	for i := 0; i < len(peersToTry) && i < batchSize; i++ {
-		p := peersToTry[i]
-		peerAddr := fmt.Sprintf("%s:%d", p.IP, p.Port)
+		peerAddr := peersToTry[i]
+		pool.RecordAttempt(peerAddr)
 
 		go func(addr string) {
-			ctx, cancel := context.WithTimeout(context.Background(), timeout)
-			defer cancel()
-
-			conn, err := peer.ConnectToPeer(ctx, addr, t.InfoHash, peerID)
+			conn, err := session.Dialer().Connect(context.Background(), addr, downloader.HandshakeHash(addr), peerID)
 			if err != nil {
+				pool.RecordFailure(addr)
 				resultChan <- connResult{nil, addr, err}
 				return
 			}
 
-			peerConn := peer.NewConnection(conn.Conn, t.InfoHash)
-			peerConn.ID = conn.ID
+			peerConn := downloader.NewConnection(conn)
 			peerConn.Start()
 			resultChan <- connResult{peerConn, addr, nil}
 		}(peerAddr)
@@ -146,8 +1233,12 @@ func main() {
 			if result.err != nil {
 				fmt.Printf("   ❌ %s: %v\n", result.addr, result.err)
 			} else {
+				if err := downloader.AddPeer(result.conn); err != nil {
+					fmt.Printf("   ⚠️  %s: %v\n", result.addr, err)
+					result.conn.Stop()
+					continue
+				}
 				fmt.Printf("   ✅ Connected to %s\n", result.addr)
-				downloader.AddPeer(result.conn)
 				connectedPeers++
 			}
 		case <-overallTimeout:
@@ -157,52 +1248,121 @@ func main() {
 	}
 
 doneConnecting:
-	if connectedPeers == 0 {
-		log.Fatalf("❌ Could not connect to any peers. Try a different network or VPN.")
-	}
-
-	fmt.Printf("✅ Connected to %d peers successfully\n", connectedPeers)
+	return connectedPeers
+}
 
-	fmt.Println("\n🔍 STEP 7: Starting download monitoring...")
-	fmt.Println("   📊 Progress will be shown every 5 seconds")
-	fmt.Println("   🛑 Press Ctrl+C to stop\n")
+// sessionSaveInterval is how often monitor saves the session, so a crash
+// loses little of it
+const sessionSaveInterval = 1 * time.Minute
 
+// monitor reports progress for every torrent in the session until they
+// have all finished (carried out their completion action) or failed, or the
+// process is interrupted, saving the session meanwhile. If a torrent
+// finished asking for it, the machine is shut down then.
+func monitor(session *torrent.Session) {
 	// Create a channel to listen for OS signals (like Ctrl+C)
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 
 	progressTicker := time.NewTicker(5 * time.Second)
 	defer progressTicker.Stop()
+	saveTicker := time.NewTicker(sessionSaveInterval)
+	defer saveTicker.Stop()
+
+	completed := make(map[*torrent.Downloader]bool)
+	shutdown := false
 
 	// Main monitoring loop
 	for {
 		select {
 		case <-progressTicker.C:
-			progress := downloader.GetProgress()
-			isComplete := downloader.IsComplete()
-
-			// Get some diagnostic info from the piece manager
-			var speed float64
-			var downloadedPieces, totalPieces int
-			if downloader.GetPieceMgr() != nil {
-				speed = downloader.GetPieceMgr().GetDownloadSpeed()
-				downloadedPieces = downloader.GetPieceMgr().GetDownloaded()
-				totalPieces = downloader.GetPieceMgr().GetTotalPieces()
+			downloaders := session.GetDownloaders()
+			finished := 0
+			for _, downloader := range downloaders {
+				select {
+				case <-downloader.Finished():
+					finished++
+					shutdown = shutdown || downloader.FinishedWith() == torrent.CompleteShutdown
+					continue
+				default:
+				}
+				if completed[downloader] {
+					if downloader.IsSeeding() {
+						stats := downloader.GetStats()
+						fmt.Printf("🌱 Seeding %s | Upload: %.2f KB/s | Uploaded: %s\n", downloader.GetTorrent().Info.Name,
+							stats.UploadRate/1024, formatBytes(stats.Uploaded))
+					}
+					continue
+				}
+				if downloader.IsQueued() {
+					fmt.Printf("⏳ Queued: %s (number %d in the queue)\n", downloader.GetTorrent().Info.Name,
+						session.QueuePosition(downloader)+1)
+					continue
+				}
+				stats := downloader.GetStats()
+				isComplete := downloader.IsComplete()
+
+				if len(downloaders) > 1 {
+					fmt.Printf("📁 %s\n", downloader.GetTorrent().Info.Name)
+				}
+				fmt.Printf("📊 Progress: %.2f%% (%d/%d pieces) | Speed: %.2f KB/s | ETA: %s | Uploaded: %s\n",
+					stats.Progress, stats.CompletedPieces, stats.TotalPieces, stats.DownloadRate/1024,
+					formatETA(stats.ETA), formatBytes(stats.Uploaded))
+				fmt.Printf("   Peers: %d seeds, %d leechers connected (swarm: %d seeds, %d leechers)\n",
+					stats.ConnectedSeeds, stats.ConnectedLeechers, stats.TotalSeeds, stats.TotalLeechers)
+				health := downloader.GetHealth()
+				fmt.Printf("   Health: %s (rarest piece: %d copies among connected peers, availability %.2f)\n",
+					health.Status, health.RarestAvailability, stats.DistributedCopies)
+				if !isComplete && health.ActivePeers > 0 && stats.DistributedCopies < 1 {
+					fmt.Printf("   ⚠️  Connected peers are missing some pieces; the torrent can't complete from them alone\n")
+				}
+
+				if stats.WastedBytes > 0 {
+					fmt.Printf("   Wasted: %s\n", formatBytes(stats.WastedBytes))
+				}
+				if stats.HashFailures > 0 {
+					fmt.Printf("   ⚠️  Hash failures: %d, %s discarded (failing pieces: %v)\n",
+						stats.HashFailures, formatBytes(stats.HashFailBytes), downloader.GetPieceMgr().GetFailingPieces())
+				}
+
+				if err := downloader.Err(); err != nil {
+					fmt.Printf("\n❌ Download errored: %v\n", err)
+					session.RemoveTorrent(downloader)
+					continue
+				}
+
+				if isComplete {
+					fmt.Printf("\n🎉 Download completed! Files saved to: %s (then: %s)\n",
+						downloader.GetOutputDir(), downloader.GetCompletion().Action)
+					completed[downloader] = true
+				}
 			}
 
-			fmt.Printf("📊 Progress: %.2f%% (%d/%d pieces) | Speed: %.2f KB/s\n",
-				progress, downloadedPieces, totalPieces, speed/1024)
+			if budget := session.FDBudget(); budget.Limit() > 0 && budget.InUse() >= budget.Limit() {
+				fmt.Printf("⚠️  File descriptor budget used up (%d), refusing new peers until some close\n", budget.Limit())
+			}
+			if dropped := session.InboundDropped(); dropped > 0 {
+				fmt.Printf("🛡️  Dropped %d inbound connections over the accept limits\n", dropped)
+			}
 
-			if isComplete {
-				fmt.Printf("\n🎉 Download completed! Files saved to: %s\n", outputDir)
-				downloader.Stop()
+			// Exit once nothing is left downloading or seeding
+			if finished == len(session.GetDownloaders()) {
+				saveSession(session)
+				session.Close()
+				if shutdown {
+					shutdownMachine()
+				}
 				return // Exit main
 			}
 
+		case <-saveTicker.C:
+			saveSession(session)
+
 		case <-signals:
 			// Signal received, start graceful shutdown.
 			fmt.Println("\n🛑 Shutdown signal received. Stopping downloader...")
-			downloader.Stop()
+			saveSession(session)
+			session.Close()
 			// You might want to wait for the downloader to finish stopping here.
 			// For now, we'll just exit.
 			fmt.Println("Downloader stopped. Exiting.")
@@ -211,6 +1371,323 @@ doneConnecting:
 	}
 }
 
+// saveSession saves the session so --restore can bring it back
+func saveSession(session *torrent.Session) {
+	if err := session.SaveAll(); err != nil {
+		fmt.Printf("⚠️  Failed to save the session: %v\n", err)
+	}
+}
+
+// shutdownMachine powers off the machine, for torrents whose completion
+// action asked for it
+func shutdownMachine() {
+	fmt.Println("⏻ Shutting down the machine, as a finished torrent asked")
+	if output, err := torrent.ShutdownCommand().CombinedOutput(); err != nil {
+		fmt.Printf("❌ Shutdown failed: %v %s\n", err, output)
+	}
+}
+
+// repeatedFlag collects the values of a flag that may be given more than
+// once, such as --range, --priority or --rename
+type repeatedFlag []string
+
+func (r *repeatedFlag) String() string { return strings.Join(*r, ",") }
+
+func (r *repeatedFlag) Set(value string) error {
+	*r = append(*r, value)
+	return nil
+}
+
+// fileRename is a --rename flag: store the torrent's file or directory
+// from under the name to
+type fileRename struct {
+	from, to string
+}
+
+// filePriority is a --priority flag: download the torrent's file at path
+// with priority
+type filePriority struct {
+	path     string
+	priority file.Priority
+}
+
+// downloadOptions holds the "download" subcommand's flags
+type downloadOptions struct {
+	selections    []torrent.Selection
+	renames       []fileRename
+	priorities    []filePriority
+	bind          string        // Interface name or IP for peer connections
+	announceIP    string        // IP or host name trackers should record for us, or "auto"
+	hostsFile     string        // Resolve peer and tracker host names from this file first
+	peerTimeout   peer.Timeouts // Dial, handshake and connect timeouts for peers
+	inbound       peer.InboundLimits
+	fdBudget      int           // File descriptors the session may use; 0 derives it from the open file limit
+	timeoutsSet   bool          // A peer timeout flag was given
+	seed          bool          // Data is known complete, skip the hash check
+	incomplete    bool          // Stage data under .incomplete/ until complete
+	gcAfter       time.Duration // Delete orphaned resume/partial data this old; 0 only reports it
+	allocation    file.AllocationStrategy
+	verifyReads   bool                     // Re-hash pieces whose files changed before uploading them
+	verifyRate    int64                    // Read limit for verifying existing data on start, in bytes/second; 0 is unlimited
+	keepPeerID    bool                     // Reuse the peer ID across restarts instead of a new one per run
+	stallTimeout  time.Duration            // Re-announce and replace peers after no data for this long; 0 never does
+	orderLog      string                   // Export piece completion order and timing here (.csv or JSON)
+	randSeed      int64                    // Seed for piece selection and the peer ID when deterministic
+	deterministic bool                     // --rand-seed was given
+	completion    torrent.CompletionPolicy // What to do once the download completes
+	mode          torrent.TransferMode     // Download, upload or both
+	allowlist     *peer.Allowlist          // Only connect to peers inside it; nil allows all
+	recheck       torrent.RecheckPolicy    // When to hash the data again while seeding
+	traceFile     string                   // Log every peer wire message here
+	traceBuffer   int                      // Keep this many recent wire messages for trace-export
+	captureDir    string                   // Capture every peer's raw traffic here
+	restore       bool                     // Restore the torrents of the session saved on the last exit
+	exportDir     string                   // Write each added torrent's .torrent file here
+	maxActive     int                      // Torrents downloading at once, the rest queued; 0 means no limit
+	maxActiveSet  bool                     // --max-active was given
+
+	rateLimits    torrent.RateLimits // Session-wide caps
+	altRateLimits torrent.RateLimits // Session-wide caps in turtle mode
+	limitsSet     bool               // A rate limit flag was given
+	turtle        bool               // Start in turtle mode
+}
+
+// peerIDFileName is where --keep-peer-id keeps the peer ID, inside
+// ipc.DefaultDir
+const peerIDFileName = "peer_id"
+
+// parseArgs parses the command line after the program name into the
+// download options, torrent file and output directory. "download" accepts
+// flags to fetch only some files or byte ranges, or to bind to an
+// interface; without it the arguments are just
+// <torrent-file> [output-directory]. The output directory is empty if not
+// given.
+func parseArgs(args []string) (opts downloadOptions, torrentFile, outputDir string, err error) {
+	opts = downloadOptions{
+		inbound:       peer.DefaultInboundLimits(),
+		allocation:    file.AutoAllocation,
+		altRateLimits: torrent.DefaultAltRateLimits,
+		stallTimeout:  torrent.DefaultStallTimeout,
+		completion:    torrent.DefaultCompletionPolicy(),
+	}
+	if len(args) >= 1 && args[0] == "download" {
+		opts, args, err = parseDownloadFlags(args[1:])
+		if err != nil {
+			return opts, "", "", err
+		}
+	}
+
+	torrentFile = "debian.torrent"
+	if opts.restore {
+		torrentFile = "" // Restoring is enough
+	}
+	if len(args) >= 1 {
+		torrentFile = args[0]
+	}
+	if len(args) >= 2 {
+		outputDir = args[1]
+	}
+	return opts, torrentFile, outputDir, nil
+}
+
+// defaultOutputDir is where torrents go when no output directory is given
+// and they weren't downloaded before
+const defaultOutputDir = "./downloads/debian_1"
+
+// parseDownloadFlags parses the "download" subcommand's flags, returning
+// them and the remaining positional arguments
+func parseDownloadFlags(args []string) (downloadOptions, []string, error) {
+	var opts downloadOptions
+	fs := flag.NewFlagSet("download", flag.ContinueOnError)
+	files := fs.String("files", "", "comma-separated list of files to download")
+	var ranges repeatedFlag
+	fs.Var(&ranges, "range", "byte range to download as file:offset-length (repeatable)")
+	var priorities repeatedFlag
+	fs.Var(&priorities, "priority", "download a file as path=skip|low|normal|high: skipped files aren't downloaded or allocated, high ones come first, low ones last (repeatable)")
+	var renames repeatedFlag
+	fs.Var(&renames, "rename", "store a file or directory under another name as old=new, paths relative to the output directory (repeatable)")
+	fs.StringVar(&opts.bind, "bind", "", "interface name or IP address to connect to peers from")
+	fs.StringVar(&opts.announceIP, "announce-ip", "", "IP or host name trackers should record for us instead of the one they see, or \"auto\" for the external IP a tracker reports")
+	fs.StringVar(&opts.hostsFile, "hosts-file", "", "hosts file (\"address name...\" per line) to resolve peer and tracker host names from before asking DNS")
+	fs.DurationVar(&opts.peerTimeout.Dial, "dial-timeout", peer.DefaultTimeouts().Dial, "give up opening a peer connection after this long")
+	fs.DurationVar(&opts.peerTimeout.Handshake, "handshake-timeout", peer.DefaultTimeouts().Handshake, "give up waiting for a peer's handshake after this long")
+	fs.DurationVar(&opts.peerTimeout.Connect, "connect-timeout", peer.DefaultTimeouts().Connect, "give up on a peer after this long, dial and handshake together")
+	opts.inbound = peer.DefaultInboundLimits()
+	fs.Float64Var(&opts.inbound.AcceptRate, "accept-rate", opts.inbound.AcceptRate, "inbound peer connections accepted per second, excess closed at once (0 means unlimited)")
+	fs.IntVar(&opts.inbound.MaxPending, "max-pending", opts.inbound.MaxPending, "inbound peer connections allowed to be handshaking at once (0 means unlimited)")
+	allowPeers := fs.String("allow-peers", "", "comma-separated peer IPs and subnets (e.g. 10.0.0.0/8,192.168.1.7) to restrict the torrent to, ignoring peers from trackers or anywhere else outside them")
+	fs.IntVar(&opts.fdBudget, "fd-budget", 0, "file descriptors open files and peer sockets may use together (0 derives it from the open file limit)")
+	fs.BoolVar(&opts.seed, "seed", false, "data is already complete: skip hashing and seed, verifying pieces on first request")
+	fs.BoolVar(&opts.incomplete, "incomplete", false, "keep in-progress data under .incomplete/<infohash>/ and move it into place when done")
+	fs.DurationVar(&opts.gcAfter, "gc-after", 0, "delete resume files and partial data of other torrents untouched for this long (e.g. 168h)")
+	fs.BoolVar(&opts.verifyReads, "verify-reads", false, "re-hash pieces whose files changed on disk before uploading them")
+	fs.DurationVar(&opts.stallTimeout, "stall-timeout", torrent.DefaultStallTimeout, "re-announce and replace peers after no data arrives for this long (0 disables)")
+	fs.StringVar(&opts.orderLog, "order-log", "", "export piece completion order and timing to this file when done or stopped (CSV if it ends in .csv, else JSON)")
+	fs.Int64Var(&opts.randSeed, "rand-seed", 0, "seed piece selection and the peer ID so a run can be reproduced (debugging only)")
+	fs.BoolVar(&opts.keepPeerID, "keep-peer-id", false, "reuse the same peer ID across restarts (some private trackers expect this)")
+	fs.StringVar(&opts.traceFile, "trace", "", "append every peer wire message (type, index, begin, length, peer) to this file as JSON lines")
+	fs.BoolVar(&opts.restore, "restore", false, "restore the torrents, settings and queue of the session saved when the last run exited; the torrent file is then optional")
+	fs.IntVar(&opts.maxActive, "max-active", 0, "torrents downloading at once, in queue order (see \"queue\"); the rest wait, seeding what they have (0 means no limit)")
+	fs.StringVar(&opts.exportDir, "export-dir", "", "write the .torrent file of every torrent added to the session into this directory, named <infohash>.torrent, with the trackers of every source merged")
+	fs.StringVar(&opts.captureDir, "capture", "", "write every peer connection's raw wire traffic into this directory, one capture file each (see \"replay\")")
+	fs.IntVar(&opts.traceBuffer, "trace-buffer", 0, "keep this many recent peer wire messages for \"trace-export\" (0 keeps none)")
+	alloc := fs.String("alloc", "auto", "file allocation: auto, sparse, full or compact")
+	mode := fs.String("mode", "normal", "normal, no-seed (upload while downloading, never seed once complete) or seed-only (never request pieces, only serve the data already there)")
+	onComplete := fs.String("on-complete", "stop", "once downloaded: stop, seed (until --seed-ratio or --seed-time), seed-forever, hook (run --on-complete-hook), remove (keeping the data) or shutdown (the machine)")
+	fs.Float64Var(&opts.completion.SeedRatio, "seed-ratio", 0, "with --on-complete seed, stop at this upload ratio (0 means no limit)")
+	fs.DurationVar(&opts.completion.SeedTime, "seed-time", 0, "with --on-complete seed, stop after seeding this long (0 means no limit)")
+	fs.StringVar(&opts.completion.Hook, "on-complete-hook", "", "with --on-complete hook, the shell command to run; TORRENT_NAME, TORRENT_INFOHASH and TORRENT_DIR say which torrent completed")
+	fs.DurationVar(&opts.recheck.Interval, "recheck-every", 0, "while seeding, hash the data again this often and download pieces that no longer match (e.g. 168h; 0 never does)")
+	fs.BoolVar(&opts.recheck.AfterCrash, "recheck-after-crash", false, "hash the data again once complete if the last run didn't shut down cleanly")
+	verifyRate := fs.Int64("verify-rate", torrent.DefaultVerifyRate/1024, "read limit for verifying existing data when a torrent starts in KB/s, leaving the disk to seeding (0 means unlimited)")
+	recheckRate := fs.Int64("recheck-rate", torrent.DefaultRecheckRate/1024, "read limit for rechecks in KB/s, leaving the disk to seeding (0 means unlimited)")
+	downLimit := fs.Int64("down-limit", 0, "session-wide download limit in KB/s (0 means unlimited)")
+	upLimit := fs.Int64("up-limit", 0, "session-wide upload limit in KB/s (0 means unlimited)")
+	altDownLimit := fs.Int64("alt-down-limit", torrent.DefaultAltRateLimits.Download/1024, "download limit in KB/s while in turtle mode")
+	altUpLimit := fs.Int64("alt-up-limit", torrent.DefaultAltRateLimits.Upload/1024, "upload limit in KB/s while in turtle mode")
+	fs.BoolVar(&opts.turtle, "turtle", false, "start with the alternative (turtle mode) rate limits")
+	if err := fs.Parse(args); err != nil {
+		return opts, nil, err
+	}
+	opts.rateLimits = torrent.RateLimits{Download: *downLimit * 1024, Upload: *upLimit * 1024}
+	opts.verifyRate = *verifyRate * 1024
+	opts.recheck.Rate = *recheckRate * 1024
+	opts.altRateLimits = torrent.RateLimits{Download: *altDownLimit * 1024, Upload: *altUpLimit * 1024}
+	fs.Visit(func(f *flag.Flag) {
+		if strings.HasSuffix(f.Name, "-limit") {
+			opts.limitsSet = true
+		}
+		if f.Name == "rand-seed" {
+			opts.deterministic = true
+		}
+		if f.Name == "max-active" {
+			opts.maxActiveSet = true
+		}
+		if strings.HasSuffix(f.Name, "-timeout") && f.Name != "stall-timeout" {
+			opts.timeoutsSet = true
+		}
+	})
+	allocation, err := file.ParseAllocationStrategy(*alloc)
+	if err != nil {
+		return opts, nil, err
+	}
+	opts.allocation = allocation
+	if opts.mode, err = torrent.ParseTransferMode(*mode); err != nil {
+		return opts, nil, err
+	}
+	if *allowPeers != "" {
+		if opts.allowlist, err = peer.ParseAllowlist(strings.Split(*allowPeers, ",")); err != nil {
+			return opts, nil, err
+		}
+	}
+	if opts.completion.Action, err = torrent.ParseCompletionAction(*onComplete); err != nil {
+		return opts, nil, err
+	}
+	if err := opts.completion.Validate(); err != nil {
+		return opts, nil, err
+	}
+
+	var selections []torrent.Selection
+	if *files != "" {
+		for _, path := range strings.Split(*files, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				selections = append(selections, torrent.Selection{Path: path})
+			}
+		}
+	}
+	for _, spec := range ranges {
+		sel, err := torrent.ParseRange(spec)
+		if err != nil {
+			return opts, nil, err
+		}
+		selections = append(selections, sel)
+	}
+
+	opts.selections = selections
+
+	for _, spec := range renames {
+		from, to, ok := strings.Cut(spec, "=")
+		if !ok || from == "" || to == "" {
+			return opts, nil, fmt.Errorf("invalid rename %q, expected old=new", spec)
+		}
+		opts.renames = append(opts.renames, fileRename{from: from, to: to})
+	}
+
+	for _, spec := range priorities {
+		path, level, ok := strings.Cut(spec, "=")
+		if !ok || path == "" {
+			return opts, nil, fmt.Errorf("invalid priority %q, expected path=skip|low|normal|high", spec)
+		}
+		priority, err := file.ParsePriority(level)
+		if err != nil {
+			return opts, nil, err
+		}
+		opts.priorities = append(opts.priorities, filePriority{path: path, priority: priority})
+	}
+	return opts, fs.Args(), nil
+}
+
+// runTracker runs the embedded HTTP tracker until interrupted
+// Usage: go run main.go tracker [listen-address]
+func runTracker(args []string) {
+	addr := ":6969"
+	if len(args) >= 1 {
+		addr = args[0]
+	}
+
+	server := tracker.NewServer()
+	if err := server.Start(addr); err != nil {
+		log.Fatalf("❌ Failed to start tracker: %v", err)
+	}
+	fmt.Printf("✅ Tracker listening, announce URL: %s\n", server.AnnounceURL())
+	fmt.Println("   🛑 Press Ctrl+C to stop")
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	fmt.Println("\n🛑 Shutdown signal received. Stopping tracker...")
+	if err := server.Close(); err != nil {
+		fmt.Printf("Error stopping tracker: %v\n", err)
+	}
+}
+
+// collectOrphans reports resume state and partial data in outputDir left by
+// torrents other than the ones in the session, deleting what has been
+// untouched for gcAfter if it is set
+func collectOrphans(session *torrent.Session, outputDir string, gcAfter time.Duration) {
+	orphans, err := session.FindOrphans(outputDir)
+	if err != nil {
+		fmt.Printf("⚠️  Failed to look for orphaned data: %v\n", err)
+		return
+	}
+	if len(orphans) == 0 {
+		return
+	}
+
+	var total int64
+	for _, orphan := range orphans {
+		total += orphan.Size
+	}
+	fmt.Printf("🧹 Found %d orphaned resume/partial data entries (%s)\n", len(orphans), formatBytes(total))
+	for _, orphan := range orphans {
+		fmt.Printf("   %s: %s, last written %s\n", orphan.Path, formatBytes(orphan.Size), orphan.ModTime.Format(time.DateTime))
+	}
+
+	if gcAfter <= 0 {
+		return
+	}
+	removed, err := session.CollectGarbage(outputDir, gcAfter)
+	if err != nil {
+		fmt.Printf("⚠️  Failed to delete orphaned data: %v\n", err)
+	}
+	total = 0
+	for _, orphan := range removed {
+		total += orphan.Size
+	}
+	fmt.Printf("🧹 Deleted %d entries untouched for %s (%s)\n", len(removed), gcAfter, formatBytes(total))
+}
+
 func formatBytes(bytes int64) string {
 	const unit = 1024
 	if bytes < unit {
@@ -223,3 +1700,49 @@ func formatBytes(bytes int64) string {
 	}
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
+
+// formatETA formats an ETA for display, or "unknown" if there is none yet
+func formatETA(eta time.Duration) string {
+	if eta <= 0 {
+		return "unknown"
+	}
+	return eta.Round(time.Second).String()
+}
+
+// minAnnounceInterval keeps a tracker asking for very short intervals, or
+// failing, from being announced to in a tight loop
+const minAnnounceInterval = 60 * time.Second
+
+// maxAnnounceAttempts bounds how often the initial announce is retried
+const maxAnnounceAttempts = 5
+
+// announceWithRetry announces up to attempts times. It only tries again
+// when the tracker is overloaded or rejects us temporarily, backing off for
+// as long as it asks; any other failure is returned right away.
+func announceWithRetry(client *tracker.TrackerClient, announceURL string, req *tracker.TrackerRequest,
+	attempts int) (*tracker.TrackerResponse, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := client.Announce(announceURL, req)
+		if err == nil && resp.FailureReason == "" {
+			return resp, nil
+		}
+
+		reason, temporary := "", false
+		var retryErr *tracker.RetryError
+		if err != nil {
+			reason = err.Error()
+			temporary = errors.As(err, &retryErr)
+		} else {
+			reason = resp.FailureReason
+			temporary = resp.RetryIn > 0
+		}
+
+		delay, retry := tracker.RetryDelay(resp, err)
+		if !temporary || !retry || attempt >= attempts {
+			return nil, fmt.Errorf("failed to get peers from tracker: %s", reason)
+		}
+
+		fmt.Printf("⚠️  Tracker announce failed (%s), retrying in %s\n", reason, delay)
+		time.Sleep(delay)
+	}
+}